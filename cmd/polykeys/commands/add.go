@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
@@ -73,19 +74,24 @@ func runAddDetect() error {
 		return fmt.Errorf("failed to get connected devices: %w", err)
 	}
 
-	// Start monitoring
-	if err := app.DeviceDetector.StartMonitoring(ctx); err != nil {
-		return fmt.Errorf("failed to start monitoring: %w", err)
-	}
-	defer app.DeviceDetector.StopMonitoring()
-
 	// Wait for a new device
 	deviceChan := make(chan *domain.Device, 1)
-	app.DeviceDetector.OnDeviceConnected(func(device *domain.Device) {
+	detectorFailed := make(chan error, 1)
+	failure := app.EventBus.Subscribe(func(event domain.Event) {
+		select {
+		case detectorFailed <- event.(domain.DetectorFailed).Err:
+		default:
+		}
+	}, domain.EventDetectorFailed)
+	defer failure.Unsubscribe()
+
+	subscription := app.EventBus.Subscribe(func(event domain.Event) {
+		device := event.(domain.DeviceConnected).Device
+
 		// Check if this is a new device
 		isNew := true
 		for _, existing := range currentDevices {
-			if existing.ID == device.ID {
+			if existing.InstanceID() == device.InstanceID() {
 				isNew = false
 				break
 			}
@@ -97,12 +103,24 @@ func runAddDetect() error {
 			default:
 			}
 		}
-	})
+	}, domain.EventDeviceConnected)
+	defer subscription.Unsubscribe()
+
+	// Start monitoring
+	if err := app.DeviceDetector.StartMonitoring(ctx); err != nil {
+		return fmt.Errorf("failed to start monitoring: %w", err)
+	}
+	defer app.DeviceDetector.StopMonitoring()
 
 	// Wait for device
-	device := <-deviceChan
+	var device *domain.Device
+	select {
+	case device = <-deviceChan:
+	case err := <-detectorFailed:
+		return fmt.Errorf("device monitoring failed: %w", err)
+	}
 
-	fmt.Printf("✓ Detected: %s (%s)\n", device.Name, device.ID)
+	fmt.Printf("✓ Detected: %s (%s)\n", device.Name, device.InstanceID())
 	fmt.Println()
 
 	// Ask for optional alias
@@ -116,44 +134,19 @@ func runAddDetect() error {
 	}
 
 	// Get available layouts from repository
-	layouts, err := app.LayoutRepo.FindAll(ctx)
+	layouts, err := app.LayoutRepo.FindByOS(ctx, currentOS())
 	if err != nil {
 		return fmt.Errorf("failed to get layouts: %w", err)
 	}
 
-	// Display available layouts
-	fmt.Println()
-	fmt.Println("Available layouts:")
-	for i, layout := range layouts {
-		fmt.Printf("  %d. %s\n", i+1, layout.Name)
-	}
-	fmt.Println()
-
 	// Ask user to choose
-	fmt.Print("Choose a layout (number): ")
-	var choice int
-	_, err = fmt.Scanf("%d", &choice)
-	if err != nil || choice < 1 || choice > len(layouts) {
-		return fmt.Errorf("invalid choice")
-	}
-
-	selectedLayout := layouts[choice-1]
-
-	// Get current OS
-	var os domain.OperatingSystem
-	switch runtime.GOOS {
-	case "linux":
-		os = domain.OSLinux
-	case "darwin":
-		os = domain.OSMacOS
-	case "windows":
-		os = domain.OSWindows
-	default:
-		os = domain.OSLinux
+	selectedLayout, err := chooseLayout(reader, layouts)
+	if err != nil {
+		return err
 	}
 
 	// Add mapping
-	if err := app.ManageMappingsUC.AddMapping(ctx, device, selectedLayout.Name, os); err != nil {
+	if _, err := app.ManageMappingsUC.AddMapping(ctx, device, currentDevices, selectedLayout.Name, selectedLayout.OS); err != nil {
 		return fmt.Errorf("failed to add mapping: %w", err)
 	}
 
@@ -168,6 +161,54 @@ func runAddDetect() error {
 	return nil
 }
 
+// maxListedLayouts is the number of search results offered at once
+const maxListedLayouts = 25
+
+// chooseLayout lets the user search layouts and pick one of the results
+func chooseLayout(reader *bufio.Reader, layouts []*domain.KeyboardLayout) (*domain.KeyboardLayout, error) {
+	for {
+		fmt.Println()
+		fmt.Print("Search a layout (name or XKB code, press Enter to list all): ")
+		query, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid choice")
+		}
+
+		found := domain.SearchLayouts(layouts, query, "")
+		if len(found) == 0 {
+			fmt.Printf("No layout matches %q.\n", strings.TrimSpace(query))
+			continue
+		}
+
+		fmt.Println()
+		more := len(found) - maxListedLayouts
+		if more > 0 {
+			found = found[:maxListedLayouts]
+		}
+		printLayouts(found, true)
+		if more > 0 {
+			fmt.Printf("  ... and %d more, refine the search to see them\n", more)
+		}
+		fmt.Println()
+
+		fmt.Print("Choose a layout (number, press Enter to search again): ")
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("invalid choice")
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			continue
+		}
+
+		choice, err := strconv.Atoi(answer)
+		if err != nil || choice < 1 || choice > len(found) {
+			return nil, fmt.Errorf("invalid choice")
+		}
+		return found[choice-1], nil
+	}
+}
+
 func runAddManual(deviceID, layoutName string) error {
 	// Initialize app
 	app, err := infrastructure.NewApp()
@@ -181,24 +222,15 @@ func runAddManual(deviceID, layoutName string) error {
 	_ = app.ManageMappingsUC.LoadFromConfig(ctx)
 
 	// Get current OS
-	var os domain.OperatingSystem
-	switch runtime.GOOS {
-	case "linux":
-		os = domain.OSLinux
-	case "darwin":
-		os = domain.OSMacOS
-	case "windows":
-		os = domain.OSWindows
-	default:
-		os = domain.OSLinux
-	}
+	os := currentOS()
 
 	// Create a device object
 	device := domain.NewDevice("unknown", "unknown", deviceID)
 	device.ID = deviceID
 
-	// Add mapping
-	if err := app.ManageMappingsUC.AddMapping(ctx, device, layoutName, os); err != nil {
+	// Add mapping, with the canonical name of the layout
+	mapping, err := app.ManageMappingsUC.AddMapping(ctx, device, nil, layoutName, os)
+	if err != nil {
 		return fmt.Errorf("failed to add mapping: %w", err)
 	}
 
@@ -207,7 +239,21 @@ func runAddManual(deviceID, layoutName string) error {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
-	fmt.Printf("✓ Mapping created: %s → %s\n", deviceID, layoutName)
+	fmt.Printf("✓ Mapping created: %s → %s\n", deviceID, mapping.LayoutName)
 
 	return nil
 }
+
+// currentOS returns the operating system polykeys runs on
+func currentOS() domain.OperatingSystem {
+	switch runtime.GOOS {
+	case "linux":
+		return domain.OSLinux
+	case "darwin":
+		return domain.OSMacOS
+	case "windows":
+		return domain.OSWindows
+	default:
+		return domain.OSLinux
+	}
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/control"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/infrastructure"
+	"github.com/spf13/cobra"
+)
+
+var backendCmd = &cobra.Command{
+	Use:   "backend",
+	Short: "Show which layout backend is in use and why",
+	Long: `Display the layout backend polykeysd switches layouts with, why it was
+chosen and whether the other backends could work in this session. When the
+daemon is not running, the session is probed directly.`,
+	RunE: runBackend,
+}
+
+func runBackend(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel := newDaemonClient()
+	defer cancel()
+
+	backend, err := client.Backend(ctx)
+	if errors.GetCode(err) == errors.ErrCodeDaemonUnreachable {
+		fmt.Println("polykeysd is not running, probing this session instead")
+		fmt.Println()
+		backend, err = probeBackend()
+	}
+	if err != nil {
+		return err
+	}
+
+	name := backend.Name
+	if name == "" {
+		name = "(none)"
+	}
+	fmt.Printf("Layout backend: %s\n", name)
+	fmt.Printf("Reason: %s\n", backend.Reason)
+	fmt.Println()
+
+	fmt.Println("Backends:")
+	for _, candidate := range backend.Candidates {
+		mark := "✗"
+		if candidate.Available {
+			mark = "✓"
+		}
+		fmt.Printf("  %s %s: %s\n", mark, candidate.Name, candidate.Reason)
+	}
+
+	return nil
+}
+
+// probeBackend selects a layout backend the way polykeysd would, honouring
+// the backend setting of the configuration
+func probeBackend() (*control.BackendStatus, error) {
+	app, err := infrastructure.NewApp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize: %w", err)
+	}
+
+	if config, err := app.ConfigLoader.Load(context.Background()); err == nil {
+		if err := app.LayoutBackends.Prefer(config.Backend); err != nil {
+			fmt.Printf("Warning: %v\n\n", err)
+		}
+	}
+
+	return infrastructure.BackendStatus(app.LayoutBackends.Info()), nil
+}
@@ -0,0 +1,18 @@
+package commands
+
+import (
+	"context"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/control"
+)
+
+// daemonTimeout bounds how long the CLI waits for polykeysd to answer
+const daemonTimeout = 10 * time.Second
+
+// newDaemonClient returns a client for the running polykeysd and a context
+// bounded by daemonTimeout
+func newDaemonClient() (*control.Client, context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), daemonTimeout)
+	return control.NewClient(control.SocketPath()), ctx, cancel
+}
@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var disableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Disable automatic layout switching",
+	Long:  `Pause automatic layout switching in the running polykeysd until it is enabled again.`,
+	RunE:  runDisable,
+}
+
+func runDisable(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel := newDaemonClient()
+	defer cancel()
+
+	if err := client.Disable(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Polykeys disabled")
+	return nil
+}
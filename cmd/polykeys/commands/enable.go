@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var enableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Enable automatic layout switching",
+	Long:  `Resume automatic layout switching in the running polykeysd.`,
+	RunE:  runEnable,
+}
+
+func runEnable(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel := newDaemonClient()
+	defer cancel()
+
+	if err := client.Enable(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Polykeys enabled")
+	return nil
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/infrastructure"
+	"github.com/spf13/cobra"
+)
+
+var (
+	searchFlag string
+	langFlag   string
+)
+
+var layoutsCmd = &cobra.Command{
+	Use:   "layouts",
+	Short: "List the keyboard layouts mappings can use",
+	Long: `List the built-in layouts, the ones declared in the config and, on Linux,
+every layout and variant installed with XKB.
+
+Use --search to filter on names and XKB codes, and --lang to filter on an
+ISO 639 language code such as "de" or "deu".`,
+	RunE: runLayouts,
+}
+
+func init() {
+	layoutsCmd.Flags().StringVar(&searchFlag, "search", "", "Only show layouts whose name or code contains this text")
+	layoutsCmd.Flags().StringVar(&langFlag, "lang", "", "Only show layouts for this language")
+}
+
+func runLayouts(cmd *cobra.Command, args []string) error {
+	// Initialize app
+	app, err := infrastructure.NewApp()
+	if err != nil {
+		return fmt.Errorf("failed to initialize: %w", err)
+	}
+
+	ctx := context.Background()
+
+	// Load current config for the layouts it declares
+	_ = app.ManageMappingsUC.LoadFromConfig(ctx)
+
+	layouts, err := app.LayoutRepo.FindByOS(ctx, currentOS())
+	if err != nil {
+		return fmt.Errorf("failed to get layouts: %w", err)
+	}
+
+	layouts = domain.SearchLayouts(layouts, searchFlag, langFlag)
+	if len(layouts) == 0 {
+		fmt.Println("No layout matches.")
+		return nil
+	}
+
+	printLayouts(layouts, false)
+	return nil
+}
+
+// printLayouts prints a table of layouts with their code, languages and
+// origin, numbered from 1 if asked
+func printLayouts(layouts []*domain.KeyboardLayout, numbered bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, layout := range layouts {
+		line := fmt.Sprintf("  %s\t%s\t%s\t%s", layout.Name, layoutCode(layout), strings.Join(layout.Languages, ","), layout.Origin)
+		if numbered {
+			line = fmt.Sprintf("  %d.%s", i+1, line)
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
+
+// layoutCode returns the XKB code of a Linux layout, such as "us(intl)",
+// or its system identifier
+func layoutCode(layout *domain.KeyboardLayout) string {
+	if code := layout.XKBCode(); code != "" {
+		return code
+	}
+	return layout.SystemIdentifier
+}
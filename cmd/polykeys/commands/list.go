@@ -3,7 +3,9 @@ package commands
 import (
 	"context"
 	"fmt"
+	"sort"
 
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
 	"github.com/0xJohnnyboy/polykeys/internal/infrastructure"
 	"github.com/spf13/cobra"
 )
@@ -46,12 +48,46 @@ func runList(cmd *cobra.Command, args []string) error {
 	fmt.Println("Current mappings:")
 	fmt.Println()
 	for _, mapping := range mappings {
+		origin := layoutOrigin(ctx, app.LayoutRepo, mapping)
 		if mapping.IsSystemDefault() {
-			fmt.Printf("  • System Default → %s\n", mapping.LayoutName)
+			fmt.Printf("  • System Default → %s (%s)\n", mapping.LayoutName, origin)
 		} else {
-			fmt.Printf("  • %s → %s\n", mapping.DeviceDisplayName, mapping.LayoutName)
+			fmt.Printf("  • %s → %s (%s)\n", mapping.DeviceDisplayName, mapping.LayoutName, origin)
 		}
 	}
 
+	// Layouts declared in the config, mapped or not
+	layouts, err := app.LayoutRepo.FindByOS(ctx, currentOS())
+	if err != nil {
+		return fmt.Errorf("failed to get layouts: %w", err)
+	}
+	sort.Slice(layouts, func(i, j int) bool { return layouts[i].Name < layouts[j].Name })
+
+	header := false
+	for _, layout := range layouts {
+		if layout.Origin != domain.LayoutOriginConfig {
+			continue
+		}
+		if !header {
+			fmt.Println()
+			fmt.Println("Layouts from config:")
+			fmt.Println()
+			header = true
+		}
+		fmt.Printf("  • %s: %s\n", layout.Name, layout.SystemIdentifier)
+	}
+
 	return nil
 }
+
+// layoutOrigin tells where the layout of a mapping is declared
+func layoutOrigin(ctx context.Context, layouts domain.LayoutRepository, mapping *domain.Mapping) string {
+	if mapping.Layout != nil {
+		return string(mapping.Layout.Origin)
+	}
+	layout, err := layouts.Resolve(ctx, mapping.LayoutName, mapping.LayoutOS)
+	if err != nil {
+		return "unknown layout"
+	}
+	return string(layout.Origin)
+}
@@ -47,7 +47,7 @@ func runLogs(cmd *cobra.Command, args []string) error {
 			fmt.Println("  (none)")
 		} else {
 			for _, device := range devices {
-				fmt.Printf("  • %s (%s)\n", device.Name, device.ID)
+				fmt.Printf("  • %s (%s)%s\n", device.Name, device.InstanceID(), deviceClassSuffix(device))
 			}
 		}
 		return nil
@@ -67,14 +67,22 @@ func runLogs(cmd *cobra.Command, args []string) error {
 		cancel()
 	}()
 
-	// Register callbacks
-	app.DeviceDetector.OnDeviceConnected(func(device *domain.Device) {
-		fmt.Printf("[CONNECTED] %s (%s)\n", device.Name, device.ID)
-	})
-
-	app.DeviceDetector.OnDeviceDisconnected(func(device *domain.Device) {
-		fmt.Printf("[DISCONNECTED] %s (%s)\n", device.Name, device.ID)
-	})
+	// Subscribe to device events
+	detectorFailed := make(chan error, 1)
+	subscription := app.EventBus.Subscribe(func(event domain.Event) {
+		switch e := event.(type) {
+		case domain.DeviceConnected:
+			fmt.Printf("[CONNECTED] %s (%s)%s\n", e.Device.Name, e.Device.InstanceID(), deviceClassSuffix(e.Device))
+		case domain.DeviceDisconnected:
+			fmt.Printf("[DISCONNECTED] %s (%s)%s\n", e.Device.Name, e.Device.InstanceID(), deviceClassSuffix(e.Device))
+		case domain.DetectorFailed:
+			select {
+			case detectorFailed <- e.Err:
+			default:
+			}
+		}
+	}, domain.EventDeviceConnected, domain.EventDeviceDisconnected, domain.EventDetectorFailed)
+	defer subscription.Unsubscribe()
 
 	// Start monitoring
 	if err := app.DeviceDetector.StartMonitoring(ctx); err != nil {
@@ -83,7 +91,18 @@ func runLogs(cmd *cobra.Command, args []string) error {
 	defer app.DeviceDetector.StopMonitoring()
 
 	// Wait for context cancellation
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-detectorFailed:
+		return fmt.Errorf("device monitoring failed: %w", err)
+	}
+}
 
-	return nil
+// deviceClassSuffix formats the device class for display, if the detector set one
+func deviceClassSuffix(device *domain.Device) string {
+	if device.Class == domain.DeviceClassUnknown {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", device.Class)
 }
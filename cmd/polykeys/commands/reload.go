@@ -0,0 +1,26 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the daemon configuration",
+	Long:  `Ask the running polykeysd to reload its configuration file.`,
+	RunE:  runReload,
+}
+
+func runReload(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel := newDaemonClient()
+	defer cancel()
+
+	if err := client.Reload(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Configuration reloaded")
+	return nil
+}
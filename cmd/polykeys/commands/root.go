@@ -26,6 +26,12 @@ func init() {
 
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(layoutsCmd)
 	rootCmd.AddCommand(removeCmd)
 	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(enableCmd)
+	rootCmd.AddCommand(disableCmd)
+	rootCmd.AddCommand(backendCmd)
 }
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the running daemon",
+	Long:  `Display whether polykeysd is enabled, the current layout and the connected keyboards.`,
+	RunE:  runStatus,
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel := newDaemonClient()
+	defer cancel()
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	state := "enabled"
+	if !status.Enabled {
+		state = "disabled"
+	}
+	fmt.Printf("Polykeys is %s\n", state)
+
+	layout := status.CurrentLayout
+	if layout == "" {
+		layout = "(unchanged since startup)"
+	}
+	fmt.Printf("Current layout: %s\n", layout)
+	fmt.Println()
+
+	fmt.Println("Connected devices:")
+	if len(status.Devices) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, device := range status.Devices {
+		name := device.Name
+		if device.Alias != "" {
+			name = device.Alias
+		}
+		if device.Class != "" {
+			fmt.Printf("  • %s (%s) [%s]\n", name, device.ID, device.Class)
+		} else {
+			fmt.Printf("  • %s (%s)\n", name, device.ID)
+		}
+		if len(device.Nodes) > 0 {
+			fmt.Printf("    %s\n", strings.Join(device.Nodes, ", "))
+		}
+	}
+
+	return nil
+}
@@ -8,6 +8,9 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/config"
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/control"
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
 	"github.com/0xJohnnyboy/polykeys/internal/infrastructure"
 	"github.com/0xJohnnyboy/polykeys/internal/logger"
 )
@@ -45,21 +48,130 @@ func main() {
 	}()
 
 	// Load configuration
-	if err := app.ManageMappingsUC.LoadFromConfig(ctx); err != nil {
+	if err := app.LoadConfig(ctx); err != nil {
 		log.Printf("Warning: Failed to load config: %v", err)
 		log.Println("Daemon will run without mappings. Use 'polykeys add' to configure.")
 	}
 
+	backend := app.LayoutBackends.Info()
+	log.Printf("Layout backend: %s (%s)", backend.Active, backend.Reason)
+
+	// Stop when the device detector fails, for the service manager to
+	// restart the daemon
+	detectorFailed := make(chan error, 1)
+	app.EventBus.Subscribe(func(event domain.Event) {
+		select {
+		case detectorFailed <- event.(domain.DetectorFailed).Err:
+		default:
+		}
+	}, domain.EventDetectorFailed)
+
 	// Start device monitoring
 	if err := app.MonitorDevicesUC.StartMonitoring(ctx); err != nil {
 		log.Fatalf("Failed to start monitoring: %v", err)
 	}
 	defer app.MonitorDevicesUC.StopMonitoring()
 
+	// Follow layout changes made outside polykeys, when the backend can
+	watchLayouts(ctx, app)
+
+	// Reload the configuration when the file changes or on SIGHUP
+	watchConfig(ctx, app)
+
+	// Serve the control socket used by the polykeys CLI
+	controlServer := control.NewServer(control.SocketPath(), infrastructure.NewControlHandler(app))
+	if err := controlServer.Start(ctx); err != nil {
+		log.Printf("Warning: Control socket unavailable: %v", err)
+	} else {
+		defer controlServer.Stop()
+		log.Printf("Control socket listening on %s", controlServer.Path())
+	}
+
 	log.Println("Polykeys daemon ready - monitoring for device changes")
 
 	// Wait for context cancellation
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+	case err := <-detectorFailed:
+		log.Printf("Device monitoring failed: %v", err)
+		app.MonitorDevicesUC.StopMonitoring()
+		controlServer.Stop()
+		log.Fatalln("Polykeys daemon stopped")
+	}
 
 	log.Println("Polykeys daemon stopped")
 }
+
+// watchConfig reloads the configuration when its file changes or when the
+// daemon receives SIGHUP
+func watchConfig(ctx context.Context, app *infrastructure.App) {
+	reload := func(reason string) {
+		if err := app.LoadConfig(ctx); err != nil {
+			log.Printf("Config reload (%s) failed, keeping the previous mappings: %v", reason, err)
+			return
+		}
+		log.Printf("Config reloaded (%s)", reason)
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case <-hupChan:
+				reload("SIGHUP")
+			case <-ctx.Done():
+				signal.Stop(hupChan)
+				return
+			}
+		}
+	}()
+
+	configPath, err := app.ConfigLoader.GetConfigPath()
+	if err != nil {
+		log.Printf("Warning: Not watching the config file: %v", err)
+		return
+	}
+
+	watcher, err := config.NewWatcher(configPath)
+	if err != nil {
+		log.Printf("Warning: Not watching the config file: %v", err)
+		return
+	}
+
+	if err := watcher.Start(ctx, func() { reload("file changed") }); err != nil {
+		log.Printf("Warning: Not watching the config file: %v", err)
+		watcher.Stop()
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		watcher.Stop()
+	}()
+
+	log.Printf("Watching %s for changes", configPath)
+}
+
+// watchLayouts logs the layout changes made outside polykeys, if the layout
+// backend reports them
+func watchLayouts(ctx context.Context, app *infrastructure.App) {
+	watcher, ok := app.LayoutSwitcher.(domain.LayoutWatcher)
+	if !ok {
+		return
+	}
+
+	app.EventBus.Subscribe(func(event domain.Event) {
+		changed := event.(domain.LayoutChanged)
+		if changed.Keyboard != "" {
+			log.Printf("Layout changed outside polykeys: %s → %s", changed.Keyboard, changed.Layout)
+		} else {
+			log.Printf("Layout changed outside polykeys: %s", changed.Layout)
+		}
+	}, domain.EventLayoutChanged)
+
+	if err := watcher.WatchLayouts(ctx); err != nil {
+		log.Printf("Warning: Not following layout changes: %v", err)
+	}
+}
@@ -7,6 +7,7 @@ toolchain go1.24.10
 require (
 	github.com/StackExchange/wmi v1.2.1
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/godbus/dbus/v5 v5.2.2
 	github.com/spf13/cobra v1.10.1
 	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/sys v0.38.0
@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 	lua "github.com/yuin/gopher-lua"
@@ -114,6 +117,18 @@ func (l *LuaConfigLoader) Load(ctx context.Context) (*domain.Config, error) {
 		return nil, fmt.Errorf("'mappings' is not defined or is not a table in config")
 	}
 
+	// Parse the global debounce settings, which mappings override field by field
+	debounce, err := parseDebounce(L.GetGlobal("debounce"), domain.Debounce{})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing debounce: %w", err)
+	}
+
+	// Parse the layouts declared by the user before the mappings using them
+	layouts, err := parseLayouts(L.GetGlobal("layouts"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing layouts: %w", err)
+	}
+
 	// Parse mappings
 	mappings, err := l.parseMappings(L, mappingsTable.(*lua.LTable))
 	if err != nil {
@@ -126,9 +141,29 @@ func (l *LuaConfigLoader) Load(ctx context.Context) (*domain.Config, error) {
 		enabled = bool(enabledValue.(lua.LBool))
 	}
 
+	// Switch every keyboard unless per-device layouts are asked for
+	perDevice := false
+	if perDeviceValue := L.GetGlobal("per_device"); perDeviceValue.Type() == lua.LTBool {
+		perDevice = bool(perDeviceValue.(lua.LBool))
+	}
+
+	// Pick the layout backend automatically unless one is named
+	backend := ""
+	switch backendValue := L.GetGlobal("backend"); backendValue.Type() {
+	case lua.LTString:
+		backend = string(backendValue.(lua.LString))
+	case lua.LTNil:
+	default:
+		return nil, fmt.Errorf("'backend' must be a string, got %s", backendValue.Type())
+	}
+
 	return &domain.Config{
-		Mappings: mappings,
-		Enabled:  enabled,
+		Mappings:  mappings,
+		Layouts:   layouts,
+		Enabled:   enabled,
+		Debounce:  debounce,
+		PerDevice: perDevice,
+		Backend:   backend,
 	}, nil
 }
 
@@ -145,6 +180,7 @@ func (l *LuaConfigLoader) findExistingConfig() (string, error) {
 // parseMappings parses the mappings table from Lua
 func (l *LuaConfigLoader) parseMappings(L *lua.LState, table *lua.LTable) ([]*domain.Mapping, error) {
 	mappings := make([]*domain.Mapping, 0)
+	var parseErr error
 
 	// Get current OS
 	currentOS := getCurrentOS()
@@ -160,17 +196,28 @@ func (l *LuaConfigLoader) parseMappings(L *lua.LState, table *lua.LTable) ([]*do
 		// Check number of elements to support both formats:
 		// New format: { "alias", "deviceID", "layoutName" }
 		// Old format: { "deviceID", "layoutName" }
+		// Table format: { alias = "alias", device = "deviceID", layout = "layoutName" }
 
 		var alias, deviceID, layoutName string
 
 		// Try to get third element
-		thirdElement := mappingTable.RawGetInt(3).String()
-
-		if thirdElement != "" {
+		thirdElement := mappingTable.RawGetInt(3)
+
+		if device, ok := mappingTable.RawGetString(deviceField).(lua.LString); ok {
+			// Table format, where the alias defaults to the device ID
+			deviceID = string(device)
+			alias = deviceID
+			if value, ok := mappingTable.RawGetString(aliasField).(lua.LString); ok {
+				alias = string(value)
+			}
+			if value, ok := mappingTable.RawGetString(layoutField).(lua.LString); ok {
+				layoutName = string(value)
+			}
+		} else if thirdElement.Type() == lua.LTString {
 			// New format with 3 elements
 			alias = mappingTable.RawGetInt(1).String()
 			deviceID = mappingTable.RawGetInt(2).String()
-			layoutName = thirdElement
+			layoutName = thirdElement.String()
 		} else {
 			// Old format with 2 elements
 			deviceID = mappingTable.RawGetInt(1).String()
@@ -178,18 +225,397 @@ func (l *LuaConfigLoader) parseMappings(L *lua.LState, table *lua.LTable) ([]*do
 			alias = deviceID // Use deviceID as display name
 		}
 
+		// An XKB specification replaces the layout name:
+		// { alias = "Corne", device = "4653:0004", xkb = { layout = "us", variant = "altgr-intl" } }
+		var layout *domain.KeyboardLayout
+		if xkb := mappingTable.RawGetString(xkbField); xkb != lua.LNil {
+			var err error
+			if layout, err = parseXKBLayout(xkb, ""); err != nil {
+				if parseErr == nil {
+					parseErr = fmt.Errorf("mapping %s: %s: %w", deviceID, xkbField, err)
+				}
+				return
+			}
+			layoutName = layout.Name
+		}
+
 		if deviceID == "" || layoutName == "" {
 			return
 		}
 
 		// Create mapping
 		mapping := domain.NewMapping(deviceID, alias, layoutName, currentOS)
+		if layout != nil {
+			mapping.Layout = layout
+			mapping.LayoutOS = layout.OS
+		}
+
+		// Named fields override the global debounce for this device:
+		// { "Keychron K3", "05ac:024f", "US", disconnect_grace = "10s" }
+		mappingDebounce, err := parseDebounceOverride(mappingTable)
+		if err != nil {
+			if parseErr == nil {
+				parseErr = fmt.Errorf("mapping %s: %w", deviceID, err)
+			}
+			return
+		}
+		mapping.Debounce = mappingDebounce
+
+		// Higher priority keyboards keep their layout while others connect:
+		// { "Corne", "4653:0004", "Colemak", priority = 10 }
+		if priority := mappingTable.RawGetString(priorityField); priority != lua.LNil {
+			number, ok := priority.(lua.LNumber)
+			if !ok {
+				if parseErr == nil {
+					parseErr = fmt.Errorf("mapping %s: %s: expected a number, got %s", deviceID, priorityField, priority.Type())
+				}
+				return
+			}
+			mapping.Priority = int(number)
+		}
+
+		// XKB options are kept unless a mapping adds or removes some:
+		// { "Corne", "4653:0004", "Colemak", options = { add = { "ctrl:nocaps" } } }
+		if options := mappingTable.RawGetString(optionsField); options != lua.LNil {
+			parsed, err := parseLayoutOptions(options)
+			if err != nil {
+				if parseErr == nil {
+					parseErr = fmt.Errorf("mapping %s: %s: %w", deviceID, optionsField, err)
+				}
+				return
+			}
+			mapping.Options = parsed
+		}
+
 		mappings = append(mappings, mapping)
 	})
 
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
 	return mappings, nil
 }
 
+// Named mapping fields; the debounce ones are also used globally
+const (
+	settleDelayField     = "settle_delay"
+	disconnectGraceField = "disconnect_grace"
+	priorityField        = "priority"
+	optionsField         = "options"
+	aliasField           = "alias"
+	deviceField          = "device"
+	layoutField          = "layout"
+	xkbField             = "xkb"
+)
+
+// Fields of an XKB specification
+const (
+	xkbLayoutField  = "layout"
+	xkbVariantField = "variant"
+	xkbModelField   = "model"
+	xkbOptionsField = "options"
+)
+
+// layoutOSFields are the fields of a layout declaration giving the system
+// identifier for each OS
+var layoutOSFields = []domain.OperatingSystem{domain.OSLinux, domain.OSMacOS, domain.OSWindows}
+
+// layoutAliasesField lists other names of a declared layout
+const layoutAliasesField = "aliases"
+
+// parseDebounce reads the debounce fields of a table, keeping the defaults
+// for the missing ones. A nil value returns the defaults unchanged.
+func parseDebounce(value lua.LValue, defaults domain.Debounce) (domain.Debounce, error) {
+	debounce := defaults
+
+	if value == lua.LNil {
+		return debounce, nil
+	}
+
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return debounce, fmt.Errorf("expected a table, got %s", value.Type())
+	}
+
+	var err error
+	if debounce.SettleDelay, err = parseDuration(table.RawGetString(settleDelayField), debounce.SettleDelay); err != nil {
+		return debounce, fmt.Errorf("%s: %w", settleDelayField, err)
+	}
+	if debounce.DisconnectGrace, err = parseDuration(table.RawGetString(disconnectGraceField), debounce.DisconnectGrace); err != nil {
+		return debounce, fmt.Errorf("%s: %w", disconnectGraceField, err)
+	}
+
+	return debounce, nil
+}
+
+// parseDebounceOverride reads the debounce fields a mapping table sets,
+// leaving the others unset
+func parseDebounceOverride(table *lua.LTable) (domain.DebounceOverride, error) {
+	var override domain.DebounceOverride
+
+	fields := []struct {
+		name  string
+		value **time.Duration
+	}{
+		{settleDelayField, &override.SettleDelay},
+		{disconnectGraceField, &override.DisconnectGrace},
+	}
+	for _, field := range fields {
+		value := table.RawGetString(field.name)
+		if value == lua.LNil {
+			continue
+		}
+
+		duration, err := parseDuration(value, 0)
+		if err != nil {
+			return override, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.value = &duration
+	}
+
+	return override, nil
+}
+
+// parseLayoutOptions reads the add and remove lists of an options table
+func parseLayoutOptions(value lua.LValue) (domain.LayoutOptions, error) {
+	var options domain.LayoutOptions
+
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return options, fmt.Errorf("expected a table, got %s", value.Type())
+	}
+
+	var err error
+	if options.Add, err = parseStringList(table.RawGetString("add")); err != nil {
+		return options, fmt.Errorf("add: %w", err)
+	}
+	if options.Remove, err = parseStringList(table.RawGetString("remove")); err != nil {
+		return options, fmt.Errorf("remove: %w", err)
+	}
+
+	return options, nil
+}
+
+// parseLayouts reads the layouts table, which maps layout names to their
+// system identifier on each OS and optional aliases:
+// layouts = { ["Colemak-DH"] = { aliases = { "cdh" }, linux = "us -variant colemak_dh" } }
+// A Linux identifier may also be an XKB specification table.
+func parseLayouts(value lua.LValue) ([]*domain.KeyboardLayout, error) {
+	if value == lua.LNil {
+		return nil, nil
+	}
+
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a table, got %s", value.Type())
+	}
+
+	layouts := make([]*domain.KeyboardLayout, 0)
+	var parseErr error
+	table.ForEach(func(key, value lua.LValue) {
+		if parseErr != nil {
+			return
+		}
+
+		name, ok := key.(lua.LString)
+		if !ok || strings.TrimSpace(string(name)) == "" {
+			parseErr = fmt.Errorf("expected layout names as keys, got %s", key.Type())
+			return
+		}
+
+		declared, err := parseLayout(strings.TrimSpace(string(name)), value)
+		if err != nil {
+			parseErr = fmt.Errorf("layout %s: %w", name, err)
+			return
+		}
+		layouts = append(layouts, declared...)
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	// Lua tables have no order; keep saved configs stable
+	sort.Slice(layouts, func(i, j int) bool {
+		if layouts[i].Name != layouts[j].Name {
+			return layouts[i].Name < layouts[j].Name
+		}
+		return layouts[i].OS < layouts[j].OS
+	})
+
+	return layouts, nil
+}
+
+// parseLayout reads the system identifiers of a layout declaration, giving
+// a layout for each OS
+func parseLayout(name string, value lua.LValue) ([]*domain.KeyboardLayout, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a table, got %s", value.Type())
+	}
+
+	var unknown string
+	table.ForEach(func(key, _ lua.LValue) {
+		if key.String() == layoutAliasesField {
+			return
+		}
+		for _, os := range layoutOSFields {
+			if key.String() == string(os) {
+				return
+			}
+		}
+		unknown = key.String()
+	})
+	if unknown != "" {
+		return nil, fmt.Errorf("unknown OS %q", unknown)
+	}
+
+	aliases, err := parseStringList(table.RawGetString(layoutAliasesField))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", layoutAliasesField, err)
+	}
+
+	layouts := make([]*domain.KeyboardLayout, 0, len(layoutOSFields))
+	for _, os := range layoutOSFields {
+		var layout *domain.KeyboardLayout
+		switch v := table.RawGetString(string(os)).(type) {
+		case *lua.LNilType:
+			continue
+		case lua.LString:
+			if strings.TrimSpace(string(v)) == "" {
+				return nil, fmt.Errorf("%s: empty identifier", os)
+			}
+			layout = domain.NewKeyboardLayout(name, os, strings.TrimSpace(string(v)))
+		case *lua.LTable:
+			if os != domain.OSLinux {
+				return nil, fmt.Errorf("%s: expected a string, got %s", os, v.Type())
+			}
+			var err error
+			if layout, err = parseXKBLayout(v, name); err != nil {
+				return nil, fmt.Errorf("%s: %w", os, err)
+			}
+		default:
+			return nil, fmt.Errorf("%s: expected a string, got %s", os, v.Type())
+		}
+		layout.Aliases = aliases
+		layout.Origin = domain.LayoutOriginConfig
+		layouts = append(layouts, layout)
+	}
+
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("no system identifier")
+	}
+
+	return layouts, nil
+}
+
+// parseXKBLayout reads an XKB specification, whose layout and variant are
+// comma-separated lists for several groups, into a Linux layout. Without a
+// name, the layout is named after its groups, such as "us(altgr-intl)".
+func parseXKBLayout(value lua.LValue, name string) (*domain.KeyboardLayout, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a table, got %s", value.Type())
+	}
+
+	fields := make(map[string]string)
+	for _, field := range []string{xkbLayoutField, xkbVariantField, xkbModelField} {
+		switch v := table.RawGetString(field).(type) {
+		case lua.LString:
+			fields[field] = strings.TrimSpace(string(v))
+		case *lua.LNilType:
+		default:
+			return nil, fmt.Errorf("%s: expected a string, got %s", field, v.Type())
+		}
+	}
+	if fields[xkbLayoutField] == "" {
+		return nil, fmt.Errorf("%s is required", xkbLayoutField)
+	}
+
+	options, err := parseStringList(table.RawGetString(xkbOptionsField))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", xkbOptionsField, err)
+	}
+
+	layouts := strings.Split(fields[xkbLayoutField], ",")
+	variants := strings.Split(fields[xkbVariantField], ",")
+	if len(variants) > len(layouts) {
+		return nil, fmt.Errorf("more variants than layouts")
+	}
+
+	groups := make([]domain.XKBGroup, 0, len(layouts))
+	names := make([]string, 0, len(layouts))
+	for i, layout := range layouts {
+		group := domain.XKBGroup{Layout: strings.TrimSpace(layout)}
+		if i < len(variants) {
+			group.Variant = strings.TrimSpace(variants[i])
+		}
+		if group.Layout == "" {
+			return nil, fmt.Errorf("empty layout in %q", fields[xkbLayoutField])
+		}
+		groups = append(groups, group)
+		names = append(names, group.String())
+	}
+
+	if name == "" {
+		name = strings.Join(names, ",")
+	}
+
+	layout := domain.NewXKBKeyboardLayout(name, groups, fields[xkbModelField], domain.LayoutOptions{Add: options})
+	layout.Origin = domain.LayoutOriginConfig
+	return layout, nil
+}
+
+// parseStringList reads a list of strings, nil if the value is nil
+func parseStringList(value lua.LValue) ([]string, error) {
+	if value == lua.LNil {
+		return nil, nil
+	}
+
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a list of strings, got %s", value.Type())
+	}
+
+	items := make([]string, 0, table.Len())
+	for i := 1; i <= table.Len(); i++ {
+		item, ok := table.RawGetInt(i).(lua.LString)
+		if !ok {
+			return nil, fmt.Errorf("expected a list of strings, got a %s item", table.RawGetInt(i).Type())
+		}
+		items = append(items, string(item))
+	}
+
+	return items, nil
+}
+
+// parseDuration reads a duration given either as a number of seconds (1.5)
+// or as a Go duration string ("1500ms")
+func parseDuration(value lua.LValue, fallback time.Duration) (time.Duration, error) {
+	var duration time.Duration
+
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return fallback, nil
+	case lua.LNumber:
+		duration = time.Duration(float64(v) * float64(time.Second))
+	case lua.LString:
+		parsed, err := time.ParseDuration(string(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", string(v))
+		}
+		duration = parsed
+	default:
+		return 0, fmt.Errorf("expected a number of seconds or a duration string, got %s", value.Type())
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("duration must not be negative")
+	}
+
+	return duration, nil
+}
+
 // Save saves the configuration to the Lua file
 func (l *LuaConfigLoader) Save(ctx context.Context, config *domain.Config) error {
 	configPath, err := l.GetConfigPath()
@@ -218,6 +644,11 @@ func (l *LuaConfigLoader) Save(ctx context.Context, config *domain.Config) error
 func (l *LuaConfigLoader) generateLuaConfig(config *domain.Config) string {
 	content := "-- Polykeys configuration\n"
 	content += "-- Format: { \"alias\", \"deviceID\", \"layout\" }\n\n"
+
+	if len(config.Layouts) > 0 {
+		content += formatLayouts(config.Layouts) + "\n"
+	}
+
 	content += "mappings = {\n"
 
 	for _, mapping := range config.Mappings {
@@ -227,16 +658,130 @@ func (l *LuaConfigLoader) generateLuaConfig(config *domain.Config) string {
 		}
 
 		// Write in new 3-element format
-		content += fmt.Sprintf("    { \"%s\", \"%s\", \"%s\" },\n",
-			alias, mapping.DeviceID, mapping.LayoutName)
+		fields := ""
+		if !mapping.Debounce.IsZero() {
+			fields += ", " + formatDebounceOverride(mapping.Debounce)
+		}
+		if mapping.Priority != 0 {
+			fields += fmt.Sprintf(", %s = %d", priorityField, mapping.Priority)
+		}
+		if !mapping.Options.IsZero() {
+			fields += fmt.Sprintf(", %s = %s", optionsField, formatLayoutOptions(mapping.Options))
+		}
+		if mapping.Layout != nil {
+			content += fmt.Sprintf("    { %s = %q, %s = %q, %s = %s%s },\n",
+				aliasField, alias, deviceField, mapping.DeviceID, xkbField, formatXKBLayout(mapping.Layout), fields)
+			continue
+		}
+		content += fmt.Sprintf("    { \"%s\", \"%s\", \"%s\"%s },\n",
+			alias, mapping.DeviceID, mapping.LayoutName, fields)
 	}
 
 	content += "}\n\n"
 	content += fmt.Sprintf("enabled = %v\n", config.Enabled)
 
+	if config.PerDevice {
+		content += "per_device = true\n"
+	}
+
+	if config.Backend != "" {
+		content += fmt.Sprintf("backend = %q\n", config.Backend)
+	}
+
+	if !config.Debounce.IsZero() {
+		content += fmt.Sprintf("\ndebounce = { %s }\n", formatDebounceFields(config.Debounce))
+	}
+
 	return content
 }
 
+// formatDebounceFields formats debounce settings as Lua table fields
+func formatDebounceFields(debounce domain.Debounce) string {
+	return fmt.Sprintf("%s = \"%s\", %s = \"%s\"",
+		settleDelayField, debounce.SettleDelay, disconnectGraceField, debounce.DisconnectGrace)
+}
+
+// formatDebounceOverride formats the debounce fields a mapping sets as Lua
+// table fields
+func formatDebounceOverride(override domain.DebounceOverride) string {
+	fields := make([]string, 0, 2)
+	if override.SettleDelay != nil {
+		fields = append(fields, fmt.Sprintf("%s = \"%s\"", settleDelayField, *override.SettleDelay))
+	}
+	if override.DisconnectGrace != nil {
+		fields = append(fields, fmt.Sprintf("%s = \"%s\"", disconnectGraceField, *override.DisconnectGrace))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// formatLayouts formats the declared layouts as a Lua table, one line per
+// name with the identifier of each OS
+func formatLayouts(layouts []*domain.KeyboardLayout) string {
+	names := make([]string, 0, len(layouts))
+	identifiers := make(map[string][]string)
+	for _, layout := range layouts {
+		if _, ok := identifiers[layout.Name]; !ok {
+			names = append(names, layout.Name)
+			if len(layout.Aliases) > 0 {
+				identifiers[layout.Name] = append(identifiers[layout.Name],
+					fmt.Sprintf("%s = %s", layoutAliasesField, formatStringList(layout.Aliases)))
+			}
+		}
+		identifiers[layout.Name] = append(identifiers[layout.Name],
+			fmt.Sprintf("%s = %q", layout.OS, layout.SystemIdentifier))
+	}
+
+	content := "layouts = {\n"
+	for _, name := range names {
+		content += fmt.Sprintf("    [%q] = { %s },\n", name, strings.Join(identifiers[name], ", "))
+	}
+	return content + "}\n"
+}
+
+// formatLayoutOptions formats option changes as a Lua table
+func formatLayoutOptions(options domain.LayoutOptions) string {
+	fields := make([]string, 0, 2)
+	if len(options.Add) > 0 {
+		fields = append(fields, "add = "+formatStringList(options.Add))
+	}
+	if len(options.Remove) > 0 {
+		fields = append(fields, "remove = "+formatStringList(options.Remove))
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+// formatXKBLayout formats the XKB fields of a layout as a Lua table
+func formatXKBLayout(layout *domain.KeyboardLayout) string {
+	groups := layout.XKBGroups()
+	layouts := make([]string, 0, len(groups))
+	variants := make([]string, 0, len(groups))
+	for _, group := range groups {
+		layouts = append(layouts, group.Layout)
+		variants = append(variants, group.Variant)
+	}
+
+	fields := []string{fmt.Sprintf("%s = %q", xkbLayoutField, strings.Join(layouts, ","))}
+	if variant := strings.Join(variants, ","); strings.Trim(variant, ",") != "" {
+		fields = append(fields, fmt.Sprintf("%s = %q", xkbVariantField, variant))
+	}
+	if layout.Model != "" {
+		fields = append(fields, fmt.Sprintf("%s = %q", xkbModelField, layout.Model))
+	}
+	if len(layout.Options.Add) > 0 {
+		fields = append(fields, fmt.Sprintf("%s = %s", xkbOptionsField, formatStringList(layout.Options.Add)))
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+// formatStringList formats strings as a Lua list
+func formatStringList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, fmt.Sprintf("%q", item))
+	}
+	return "{ " + strings.Join(quoted, ", ") + " }"
+}
+
 // getCurrentOS returns the current operating system as a domain.OperatingSystem
 func getCurrentOS() domain.OperatingSystem {
 	switch runtime.GOOS {
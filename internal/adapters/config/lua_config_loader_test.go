@@ -4,7 +4,10 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 )
@@ -98,6 +101,196 @@ func TestLuaConfigLoader_Save(t *testing.T) {
 	}
 }
 
+func TestLuaConfigLoader_LoadDebounce(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+debounce = { settle_delay = 0.5, disconnect_grace = "3s" }
+
+mappings = {
+    { "Corne", "4653:0004", "US International" },
+    { "K380", "046d:b342", "US", disconnect_grace = 10 },
+    { "System Default", "system_default", "French" },
+}
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expectedGlobal := domain.Debounce{SettleDelay: 500 * time.Millisecond, DisconnectGrace: 3 * time.Second}
+	if config.Debounce != expectedGlobal {
+		t.Errorf("Expected global debounce %+v, got %+v", expectedGlobal, config.Debounce)
+	}
+
+	if !config.Mappings[0].Debounce.IsZero() {
+		t.Errorf("Expected no debounce override for Corne, got %+v", config.Mappings[0].Debounce)
+	}
+
+	// Unset fields fall back to the global settings
+	expectedK380 := domain.Debounce{SettleDelay: 500 * time.Millisecond, DisconnectGrace: 10 * time.Second}
+	if got := config.Mappings[1].Debounce.Apply(config.Debounce); got != expectedK380 {
+		t.Errorf("Expected K380 debounce %+v, got %+v", expectedK380, got)
+	}
+	if config.Mappings[1].Debounce.SettleDelay != nil {
+		t.Errorf("Expected K380 to leave settle_delay unset, got %v", *config.Mappings[1].Debounce.SettleDelay)
+	}
+
+	// Saving keeps both levels, writing back only the fields the mapping sets
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to read saved config: %v", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.Contains(line, "046d:b342") && strings.Contains(line, "settle_delay") {
+			t.Errorf("Expected K380 to keep following the global settle_delay, got %s", line)
+		}
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+
+	if saved.Debounce != expectedGlobal {
+		t.Errorf("Expected saved global debounce %+v, got %+v", expectedGlobal, saved.Debounce)
+	}
+	if got := saved.Mappings[1].Debounce.Apply(saved.Debounce); got != expectedK380 {
+		t.Errorf("Expected saved K380 debounce %+v, got %+v", expectedK380, got)
+	}
+
+	// The mapping follows later changes to the global debounce
+	changed := domain.Debounce{SettleDelay: time.Second, DisconnectGrace: 3 * time.Second}
+	if got := saved.Mappings[1].Debounce.Apply(changed).SettleDelay; got != time.Second {
+		t.Errorf("Expected K380 settle delay to follow the global one, got %v", got)
+	}
+}
+
+func TestLuaConfigLoader_LoadInvalidDebounce(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+mappings = {
+    { "Corne", "4653:0004", "US International", settle_delay = "soon" },
+}
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	if _, err := loader.Load(context.Background()); err == nil {
+		t.Error("Expected an error for an invalid settle_delay")
+	}
+}
+
+func TestLuaConfigLoader_LoadPriority(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+mappings = {
+    { "Corne", "4653:0004", "Colemak", priority = 10 },
+    { "Lily58", "1209:bb58", "US" },
+}
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if config.Mappings[0].Priority != 10 {
+		t.Errorf("Expected Corne priority 10, got %d", config.Mappings[0].Priority)
+	}
+	if config.Mappings[1].Priority != 0 {
+		t.Errorf("Expected Lily58 default priority 0, got %d", config.Mappings[1].Priority)
+	}
+
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if saved.Mappings[0].Priority != 10 {
+		t.Errorf("Expected saved Corne priority 10, got %d", saved.Mappings[0].Priority)
+	}
+}
+
+func TestLuaConfigLoader_LoadPerDevice(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+mappings = {
+    { "Corne", "4653:0004", "Colemak" },
+}
+
+per_device = true
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !config.PerDevice {
+		t.Error("Expected per_device to be enabled")
+	}
+
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if !saved.PerDevice {
+		t.Error("Expected saved config to keep per_device")
+	}
+}
+
 func TestLuaConfigLoader_GetConfigPath(t *testing.T) {
 	loader := NewLuaConfigLoader()
 
@@ -142,3 +335,329 @@ func TestGetCurrentOS(t *testing.T) {
 		t.Errorf("getCurrentOS returned invalid OS: %s", os)
 	}
 }
+
+func TestLuaConfigLoader_LoadBackend(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+mappings = {
+    { "Corne", "4653:0004", "Colemak" },
+}
+
+backend = "sway"
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Backend != "sway" {
+		t.Errorf("Expected backend 'sway', got '%s'", config.Backend)
+	}
+
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if saved.Backend != "sway" {
+		t.Errorf("Expected saved config to keep the backend, got '%s'", saved.Backend)
+	}
+
+	if err := os.WriteFile(configPath, []byte("mappings = {}\nbackend = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	if _, err := loader.Load(ctx); err == nil {
+		t.Error("Expected an error for a backend that is not a string")
+	}
+}
+
+func TestLuaConfigLoader_LoadOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+mappings = {
+    { "Corne", "4653:0004", "Colemak", options = { add = { "ctrl:nocaps" }, remove = { "compose:ralt" } } },
+    { "Lily58", "1209:bb58", "US" },
+}
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := domain.LayoutOptions{Add: []string{"ctrl:nocaps"}, Remove: []string{"compose:ralt"}}
+	if !reflect.DeepEqual(config.Mappings[0].Options, expected) {
+		t.Errorf("Expected Corne options %+v, got %+v", expected, config.Mappings[0].Options)
+	}
+	if !config.Mappings[1].Options.IsZero() {
+		t.Errorf("Expected Lily58 to keep the options, got %+v", config.Mappings[1].Options)
+	}
+
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	if !reflect.DeepEqual(saved.Mappings[0].Options, expected) {
+		t.Errorf("Expected saved Corne options %+v, got %+v", expected, saved.Mappings[0].Options)
+	}
+}
+
+func TestLuaConfigLoader_LoadInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+	}{
+		{"not a table", `"ctrl:nocaps"`},
+		{"add not a list", `{ add = "ctrl:nocaps" }`},
+		{"remove item not a string", `{ remove = { 1 } }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "polykeys.lua")
+			configContent := `mappings = { { "Corne", "4653:0004", "Colemak", options = ` + tt.options + ` } }`
+			if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+
+			loader := &LuaConfigLoader{configPaths: []string{configPath}}
+			if _, err := loader.Load(context.Background()); err == nil {
+				t.Error("Expected an error for invalid options")
+			}
+		})
+	}
+}
+
+func TestLuaConfigLoader_LoadXKB(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+mappings = {
+    { alias = "Corne", device = "4653:0004", xkb = { layout = "us", variant = "altgr-intl", options = { "compose:ralt" } } },
+    { alias = "Lily58", device = "1209:bb58", xkb = { layout = "us,ru", variant = ",phonetic", model = "pc104" }, priority = 5 },
+    { device = "05ac:024f", layout = "Colemak" },
+}
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(config.Mappings) != 3 {
+		t.Fatalf("Expected 3 mappings, got %d", len(config.Mappings))
+	}
+
+	check := func(t *testing.T, mappings []*domain.Mapping) {
+		t.Helper()
+
+		corne := mappings[0]
+		if corne.DeviceDisplayName != "Corne" || corne.DeviceID != "4653:0004" || corne.LayoutName != "us(altgr-intl)" {
+			t.Errorf("Unexpected Corne mapping %+v", corne)
+		}
+		if corne.Layout == nil || corne.Layout.Layout != "us" || corne.Layout.Variant != "altgr-intl" || corne.Layout.OS != domain.OSLinux {
+			t.Fatalf("Unexpected Corne layout %+v", corne.Layout)
+		}
+		if !reflect.DeepEqual(corne.Layout.Options.Add, []string{"compose:ralt"}) {
+			t.Errorf("Expected Corne to add compose:ralt, got %+v", corne.Layout.Options)
+		}
+
+		lily58 := mappings[1]
+		if lily58.LayoutName != "us,ru(phonetic)" || lily58.Priority != 5 {
+			t.Errorf("Unexpected Lily58 mapping %+v", lily58)
+		}
+		expectedGroups := []domain.XKBGroup{{Layout: "us"}, {Layout: "ru", Variant: "phonetic"}}
+		if lily58.Layout == nil || !reflect.DeepEqual(lily58.Layout.XKBGroups(), expectedGroups) || lily58.Layout.Model != "pc104" {
+			t.Errorf("Unexpected Lily58 layout %+v", lily58.Layout)
+		}
+
+		keychron := mappings[2]
+		if keychron.DeviceDisplayName != "05ac:024f" || keychron.LayoutName != "Colemak" || keychron.Layout != nil {
+			t.Errorf("Unexpected Keychron mapping %+v", keychron)
+		}
+	}
+	check(t, config.Mappings)
+
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	check(t, saved.Mappings)
+}
+
+func TestLuaConfigLoader_LoadInvalidXKB(t *testing.T) {
+	tests := []struct {
+		name string
+		xkb  string
+	}{
+		{"not a table", `"us"`},
+		{"no layout", `{ variant = "intl" }`},
+		{"layout not a string", `{ layout = 1 }`},
+		{"too many variants", `{ layout = "us", variant = "intl,phonetic" }`},
+		{"empty group", `{ layout = "us,,ru" }`},
+		{"options not a list", `{ layout = "us", options = "ctrl:nocaps" }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "polykeys.lua")
+			configContent := `mappings = { { alias = "Corne", device = "4653:0004", xkb = ` + tt.xkb + ` } }`
+			if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+
+			loader := &LuaConfigLoader{configPaths: []string{configPath}}
+			if _, err := loader.Load(context.Background()); err == nil {
+				t.Error("Expected an error for an invalid XKB specification")
+			}
+		})
+	}
+}
+
+func TestLuaConfigLoader_LoadLayouts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "polykeys.lua")
+
+	configContent := `
+layouts = {
+    ["Colemak-DH"] = { linux = "us -variant colemak_dh", macos = "com.apple.keylayout.Colemak", windows = "00060409", aliases = { "CDH" } },
+    ["Neo"] = { linux = { layout = "de", variant = "neo" } },
+}
+
+mappings = {
+    { "Corne", "4653:0004", "Colemak-DH" },
+}
+`
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	loader := &LuaConfigLoader{
+		configPaths: []string{configPath},
+	}
+
+	ctx := context.Background()
+	config, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	check := func(t *testing.T, layouts []*domain.KeyboardLayout) {
+		t.Helper()
+
+		expected := []struct {
+			name       string
+			os         domain.OperatingSystem
+			identifier string
+		}{
+			{"Colemak-DH", domain.OSLinux, "us -variant colemak_dh"},
+			{"Colemak-DH", domain.OSMacOS, "com.apple.keylayout.Colemak"},
+			{"Colemak-DH", domain.OSWindows, "00060409"},
+			{"Neo", domain.OSLinux, "de -variant neo"},
+		}
+		if len(layouts) != len(expected) {
+			t.Fatalf("Expected %d layouts, got %d", len(expected), len(layouts))
+		}
+		for i, e := range expected {
+			layout := layouts[i]
+			if layout.Name != e.name || layout.OS != e.os || layout.SystemIdentifier != e.identifier {
+				t.Errorf("Expected %s for %s as %q, got %+v", e.name, e.os, e.identifier, layout)
+			}
+			if layout.ID != e.name+":"+string(e.os) || layout.Origin != domain.LayoutOriginConfig {
+				t.Errorf("Unexpected ID %q or origin %q", layout.ID, layout.Origin)
+			}
+		}
+		if layouts[0].Variant != "colemak_dh" || layouts[3].Layout != "de" || layouts[3].Variant != "neo" {
+			t.Errorf("Expected the XKB fields to be set, got %+v and %+v", layouts[0], layouts[3])
+		}
+		if !reflect.DeepEqual(layouts[2].Aliases, []string{"CDH"}) || layouts[3].Aliases != nil {
+			t.Errorf("Unexpected aliases %v and %v", layouts[2].Aliases, layouts[3].Aliases)
+		}
+	}
+	check(t, config.Layouts)
+
+	if err := loader.Save(ctx, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	saved, err := loader.Load(ctx)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %v", err)
+	}
+	check(t, saved.Layouts)
+}
+
+func TestLuaConfigLoader_LoadInvalidLayouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		layouts string
+	}{
+		{"not a table", `"Colemak-DH"`},
+		{"list instead of names", `{ { linux = "us" } }`},
+		{"identifiers not a table", `{ ["Colemak-DH"] = "us" }`},
+		{"no identifier", `{ ["Colemak-DH"] = {} }`},
+		{"unknown OS", `{ ["Colemak-DH"] = { linxu = "us" } }`},
+		{"empty identifier", `{ ["Colemak-DH"] = { linux = "" } }`},
+		{"table for windows", `{ ["Colemak-DH"] = { windows = { layout = "us" } } }`},
+		{"invalid XKB", `{ ["Colemak-DH"] = { linux = { variant = "colemak_dh" } } }`},
+		{"aliases not a list", `{ ["Colemak-DH"] = { linux = "us", aliases = "CDH" } }`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "polykeys.lua")
+			configContent := "layouts = " + tt.layouts + "\nmappings = {}"
+			if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+				t.Fatalf("Failed to create test config: %v", err)
+			}
+
+			loader := &LuaConfigLoader{configPaths: []string{configPath}}
+			if _, err := loader.Load(context.Background()); err == nil {
+				t.Error("Expected an error for invalid layouts")
+			}
+		})
+	}
+}
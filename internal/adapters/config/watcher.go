@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultWatchDelay groups the several events an editor produces when saving
+const defaultWatchDelay = 200 * time.Millisecond
+
+// Watcher calls a function when the configuration file changes. It watches
+// the file's directory as well, so that editors replacing the file by
+// renaming a new one over it are noticed.
+type Watcher struct {
+	path    string
+	delay   time.Duration
+	watcher *fsnotify.Watcher
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+// NewWatcher creates a watcher for the configuration file at path
+func NewWatcher(path string) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	return &Watcher{
+		path:    filepath.Clean(path),
+		delay:   defaultWatchDelay,
+		watcher: watcher,
+	}, nil
+}
+
+// Start begins watching, calling onChange once the file has stopped changing
+func (w *Watcher) Start(ctx context.Context, onChange func()) error {
+	if err := w.watcher.Add(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", filepath.Dir(w.path), err)
+	}
+
+	// Not every platform reports writes to a file through its directory
+	w.watchFile()
+
+	go w.watchEvents(ctx, onChange)
+
+	return nil
+}
+
+// Stop stops watching
+func (w *Watcher) Stop() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	return w.watcher.Close()
+}
+
+// watchFile adds the file itself to the watch list, if it exists
+func (w *Watcher) watchFile() {
+	if err := w.watcher.Add(w.path); err != nil {
+		logger.Debug("[Config] Not watching %s directly: %v\n", w.path, err)
+	}
+}
+
+// watchEvents waits for changes to the configuration file
+func (w *Watcher) watchEvents(ctx context.Context, onChange func()) {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if filepath.Clean(event.Name) != w.path {
+				continue
+			}
+
+			logger.Debug("[Config] %s\n", event)
+
+			// A replaced file is a new inode that must be watched again
+			if event.Has(fsnotify.Create) {
+				w.watchFile()
+			}
+
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+				w.schedule(onChange)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Debug("[Config] Watcher error: %v\n", err)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// schedule calls onChange after the delay, restarting the delay if the file
+// changes again in the meantime
+func (w *Watcher) schedule(onChange func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.delay, onChange)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestWatcher watches path, returning a channel receiving each change
+func startTestWatcher(t *testing.T, path string) <-chan struct{} {
+	t.Helper()
+
+	watcher, err := NewWatcher(path)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	watcher.delay = 20 * time.Millisecond
+
+	changes := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := watcher.Start(ctx, func() { changes <- struct{}{} }); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		watcher.Stop()
+	})
+
+	return changes
+}
+
+func expectChange(t *testing.T, changes <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a change to be reported")
+	}
+}
+
+func TestWatcher_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "polykeys.lua")
+	if err := os.WriteFile(path, []byte("mappings = {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	changes := startTestWatcher(t, path)
+
+	if err := os.WriteFile(path, []byte("mappings = { { \"Corne\", \"Colemak\" } }\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	expectChange(t, changes)
+}
+
+func TestWatcher_RenameReplace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "polykeys.lua")
+	if err := os.WriteFile(path, []byte("mappings = {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	changes := startTestWatcher(t, path)
+
+	// Save the way many editors do: write a temporary file, rename it over
+	for i := 0; i < 2; i++ {
+		tmp := filepath.Join(dir, ".polykeys.lua.swp")
+		if err := os.WriteFile(tmp, []byte("mappings = { { \"Corne\", \"Colemak\" } }\n"), 0644); err != nil {
+			t.Fatalf("Failed to write temporary file: %v", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatalf("Failed to replace config: %v", err)
+		}
+		expectChange(t, changes)
+	}
+}
+
+func TestWatcher_IgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "polykeys.lua")
+	if err := os.WriteFile(path, []byte("mappings = {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	changes := startTestWatcher(t, path)
+
+	if err := os.WriteFile(filepath.Join(dir, "other.lua"), []byte("x = 1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	select {
+	case <-changes:
+		t.Error("Expected changes to other files to be ignored")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,128 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+)
+
+// Client sends commands to a running daemon over the control socket
+type Client struct {
+	path string
+}
+
+// NewClient creates a client for the socket at path
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Status returns the current daemon state
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	resp, err := c.do(ctx, CommandStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Status == nil {
+		return nil, errors.New(errors.ErrCodeControlRequestFailed, "daemon returned no status")
+	}
+
+	return resp.Status, nil
+}
+
+// Reload asks the daemon to reload its configuration
+func (c *Client) Reload(ctx context.Context) error {
+	_, err := c.do(ctx, CommandReload)
+	return err
+}
+
+// Enable turns automatic layout switching on
+func (c *Client) Enable(ctx context.Context) error {
+	_, err := c.do(ctx, CommandEnable)
+	return err
+}
+
+// Disable turns automatic layout switching off
+func (c *Client) Disable(ctx context.Context) error {
+	_, err := c.do(ctx, CommandDisable)
+	return err
+}
+
+// Backend describes the layout backend the daemon uses
+func (c *Client) Backend(ctx context.Context) (*BackendStatus, error) {
+	resp, err := c.do(ctx, CommandBackend)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Backend == nil {
+		return nil, errors.New(errors.ErrCodeControlRequestFailed, "daemon returned no backend")
+	}
+
+	return resp.Backend, nil
+}
+
+// do sends a single command and waits for the response
+func (c *Client) do(ctx context.Context, command Command) (*Response, error) {
+	// Only talk to a daemon whose socket no other user controls
+	if err := checkSocketDir(filepath.Dir(c.path)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.WithDetails(
+				errors.Wrap(errors.ErrCodeDaemonUnreachable, "cannot reach polykeysd, is it running?", err),
+				map[string]interface{}{"socket": c.path},
+			)
+		}
+		return nil, errors.WithDetails(
+			errors.Wrap(errors.ErrCodeControlPermissionDenied, "refusing to use the control socket", err),
+			map[string]interface{}{"socket": c.path},
+		)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.path)
+	if err != nil {
+		return nil, errors.WithDetails(
+			errors.Wrap(errors.ErrCodeDaemonUnreachable, "cannot reach polykeysd, is it running?", err),
+			map[string]interface{}{"socket": c.path},
+		)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(requestTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	req := Request{Version: ProtocolVersion, Command: command}
+	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+		return nil, errors.Wrap(errors.ErrCodeControlRequestFailed, "failed to send request", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, errors.Wrap(errors.ErrCodeControlRequestFailed, "failed to read response", err)
+	}
+
+	if resp.Version != ProtocolVersion {
+		return nil, errors.WithDetails(
+			errors.New(errors.ErrCodeControlProtocolMismatch, "daemon speaks a different protocol version"),
+			map[string]interface{}{
+				"client": ProtocolVersion,
+				"daemon": resp.Version,
+			},
+		)
+	}
+
+	if !resp.OK {
+		return nil, errors.Wrap(errors.ErrCodeControlRequestFailed, fmt.Sprintf("daemon failed to %s", command), fmt.Errorf("%s", resp.Error))
+	}
+
+	return &resp, nil
+}
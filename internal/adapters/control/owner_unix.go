@@ -0,0 +1,24 @@
+//go:build unix
+
+package control
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkOwnership fails unless info is owned by the current user and only
+// accessible to them
+func checkOwnership(info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errUnsafeSocketDir("cannot read its owner")
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return errUnsafeSocketDir("owned by another user")
+	}
+	if info.Mode().Perm() != 0700 {
+		return errUnsafeSocketDir("mode is not 0700")
+	}
+	return nil
+}
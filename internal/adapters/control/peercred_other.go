@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package control
+
+import (
+	"net"
+	"os"
+)
+
+// peerUID is not available on this platform. Access to the socket is
+// restricted by the permissions of its parent directory instead.
+func peerUID(conn *net.UnixConn) (int, error) {
+	return os.Getuid(), nil
+}
@@ -0,0 +1,77 @@
+package control
+
+// ProtocolVersion is the version of the control socket protocol.
+// It is bumped whenever a request or response changes in an incompatible way.
+const ProtocolVersion = 1
+
+// Command identifies an operation requested from the daemon
+type Command string
+
+const (
+	CommandStatus  Command = "status"
+	CommandReload  Command = "reload"
+	CommandEnable  Command = "enable"
+	CommandDisable Command = "disable"
+	CommandBackend Command = "backend"
+)
+
+// Request is a single command sent by a client to the daemon
+type Request struct {
+	// Version is the protocol version spoken by the client
+	Version int `json:"version"`
+	// Command is the operation to perform
+	Command Command `json:"command"`
+}
+
+// Response is the daemon's answer to a Request
+type Response struct {
+	// Version is the protocol version spoken by the daemon
+	Version int `json:"version"`
+	// OK is true when the command succeeded
+	OK bool `json:"ok"`
+	// Error holds the failure reason when OK is false
+	Error string `json:"error,omitempty"`
+	// Status is set in response to a status command
+	Status *Status `json:"status,omitempty"`
+	// Backend is set in response to a backend command
+	Backend *BackendStatus `json:"backend,omitempty"`
+}
+
+// Status describes the current state of the daemon
+type Status struct {
+	// Enabled indicates if automatic layout switching is active
+	Enabled bool `json:"enabled"`
+	// CurrentLayout is the name of the last layout polykeys switched to
+	CurrentLayout string `json:"current_layout,omitempty"`
+	// Devices lists the currently connected keyboards
+	Devices []DeviceStatus `json:"devices"`
+}
+
+// DeviceStatus describes a connected keyboard
+type DeviceStatus struct {
+	// ID is the device instance ID, qualified when a serial or port is known
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Alias string `json:"alias,omitempty"`
+	// Class is the kind of keys the device reports, when known
+	Class string `json:"class,omitempty"`
+	// Nodes lists the OS input nodes grouped into the device, when known
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// BackendStatus describes the layout backend in use
+type BackendStatus struct {
+	// Name is the backend switching layouts, empty if none works
+	Name string `json:"name"`
+	// Reason explains why the backend was chosen
+	Reason string `json:"reason"`
+	// Candidates lists every backend with its probe result, best first
+	Candidates []BackendCandidate `json:"candidates"`
+}
+
+// BackendCandidate is a layout backend and whether it can work here
+type BackendCandidate struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason"`
+}
@@ -0,0 +1,232 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+)
+
+// requestTimeout bounds how long a single client exchange may take
+const requestTimeout = 5 * time.Second
+
+// Handler performs the commands received on the control socket
+type Handler interface {
+	// Status returns the current daemon state
+	Status(ctx context.Context) (*Status, error)
+	// Reload reloads the configuration file
+	Reload(ctx context.Context) error
+	// SetEnabled enables or disables automatic layout switching
+	SetEnabled(ctx context.Context, enabled bool) error
+	// Backend describes the layout backend in use
+	Backend(ctx context.Context) (*BackendStatus, error)
+}
+
+// Server serves the daemon control socket
+type Server struct {
+	path     string
+	handler  Handler
+	listener *net.UnixListener
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a new control socket server listening on path
+func NewServer(path string, handler Handler) *Server {
+	return &Server{
+		path:    path,
+		handler: handler,
+	}
+}
+
+// Start creates the socket and begins accepting connections
+func (s *Server) Start(ctx context.Context) error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := checkSocketDir(dir); err != nil {
+		return fmt.Errorf("refusing socket directory %s: %w", dir, err)
+	}
+
+	if err := removeStaleSocket(s.path); err != nil {
+		return err
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: s.path, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.path, err)
+	}
+
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.acceptLoop(ctx)
+
+	return nil
+}
+
+// Stop closes the socket and waits for in-flight requests to finish
+func (s *Server) Stop() error {
+	if s.listener == nil {
+		return nil
+	}
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Path returns the filesystem path of the socket
+func (s *Server) Path() string {
+	return s.path
+}
+
+// removeStaleSocket removes a socket file left behind by a previous daemon,
+// refusing to do so if another daemon is still answering on it
+func removeStaleSocket(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another polykeysd is already listening on %s", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	return nil
+}
+
+// acceptLoop accepts connections until the listener is closed
+func (s *Server) acceptLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.AcceptUnix()
+		if err != nil {
+			// The listener was closed by Stop
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(ctx, conn)
+		}()
+	}
+}
+
+// handleConn serves a single request/response exchange
+func (s *Server) handleConn(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	encoder := json.NewEncoder(conn)
+
+	if err := checkPeer(conn); err != nil {
+		log.Printf("[Control] Rejected connection: %v", err)
+		_ = encoder.Encode(errorResponse(err))
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logger.Debug("[Control] Invalid request: %v\n", err)
+		_ = encoder.Encode(errorResponse(errors.Wrap(errors.ErrCodeControlRequestFailed, "invalid request", err)))
+		return
+	}
+
+	logger.Debug("[Control] Received %s (protocol v%d)\n", req.Command, req.Version)
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	_ = encoder.Encode(s.dispatch(ctx, &req))
+}
+
+// dispatch runs the handler method matching the request command
+func (s *Server) dispatch(ctx context.Context, req *Request) *Response {
+	if req.Version != ProtocolVersion {
+		return errorResponse(errors.WithDetails(
+			errors.New(errors.ErrCodeControlProtocolMismatch, "unsupported protocol version"),
+			map[string]interface{}{
+				"client": req.Version,
+				"daemon": ProtocolVersion,
+			},
+		))
+	}
+
+	switch req.Command {
+	case CommandStatus:
+		status, err := s.handler.Status(ctx)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &Response{Version: ProtocolVersion, OK: true, Status: status}
+
+	case CommandReload:
+		if err := s.handler.Reload(ctx); err != nil {
+			return errorResponse(err)
+		}
+
+	case CommandEnable, CommandDisable:
+		if err := s.handler.SetEnabled(ctx, req.Command == CommandEnable); err != nil {
+			return errorResponse(err)
+		}
+
+	case CommandBackend:
+		backend, err := s.handler.Backend(ctx)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &Response{Version: ProtocolVersion, OK: true, Backend: backend}
+
+	default:
+		return errorResponse(errors.New(errors.ErrCodeControlRequestFailed, fmt.Sprintf("unknown command %q", req.Command)))
+	}
+
+	return &Response{Version: ProtocolVersion, OK: true}
+}
+
+// checkPeer only lets the daemon's own user (or root) talk to the daemon
+func checkPeer(conn *net.UnixConn) error {
+	uid, err := peerUID(conn)
+	if err != nil {
+		return errors.Wrap(errors.ErrCodeControlPermissionDenied, "failed to read peer credentials", err)
+	}
+
+	if uid != os.Getuid() && uid != 0 {
+		return errors.WithDetails(
+			errors.New(errors.ErrCodeControlPermissionDenied, "peer is not allowed to control this daemon"),
+			map[string]interface{}{"uid": uid},
+		)
+	}
+
+	return nil
+}
+
+// errorResponse builds a failed Response from an error
+func errorResponse(err error) *Response {
+	return &Response{
+		Version: ProtocolVersion,
+		OK:      false,
+		Error:   err.Error(),
+	}
+}
@@ -0,0 +1,207 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeHandler records the commands it receives
+type fakeHandler struct {
+	enabled  bool
+	reloaded int
+}
+
+func (h *fakeHandler) Status(ctx context.Context) (*Status, error) {
+	return &Status{
+		Enabled:       h.enabled,
+		CurrentLayout: "US International",
+		Devices: []DeviceStatus{
+			{ID: "4653:0004", Name: "foostan Corne", Alias: "Corne"},
+		},
+	}, nil
+}
+
+func (h *fakeHandler) Reload(ctx context.Context) error {
+	h.reloaded++
+	return nil
+}
+
+func (h *fakeHandler) SetEnabled(ctx context.Context, enabled bool) error {
+	h.enabled = enabled
+	return nil
+}
+
+func (h *fakeHandler) Backend(ctx context.Context) (*BackendStatus, error) {
+	return &BackendStatus{
+		Name:   "sway",
+		Reason: "$SWAYSOCK is set",
+		Candidates: []BackendCandidate{
+			{Name: "sway", Available: true, Reason: "$SWAYSOCK is set"},
+			{Name: "setxkbmap", Available: false, Reason: "$DISPLAY is not set"},
+		},
+	}, nil
+}
+
+func startTestServer(t *testing.T, handler Handler) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "polykeys", socketName)
+	server := NewServer(path, handler)
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	return path
+}
+
+func TestServer_Commands(t *testing.T) {
+	handler := &fakeHandler{enabled: true}
+	client := NewClient(startTestServer(t, handler))
+	ctx := context.Background()
+
+	if err := client.Disable(ctx); err != nil {
+		t.Fatalf("Disable failed: %v", err)
+	}
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	if status.Enabled {
+		t.Error("Expected daemon to be disabled")
+	}
+
+	if status.CurrentLayout != "US International" {
+		t.Errorf("Expected current layout 'US International', got '%s'", status.CurrentLayout)
+	}
+
+	if len(status.Devices) != 1 || status.Devices[0].Alias != "Corne" {
+		t.Errorf("Expected Corne in device list, got %+v", status.Devices)
+	}
+
+	if err := client.Enable(ctx); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+
+	if !handler.enabled {
+		t.Error("Expected daemon to be enabled")
+	}
+
+	if err := client.Reload(ctx); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if handler.reloaded != 1 {
+		t.Errorf("Expected 1 reload, got %d", handler.reloaded)
+	}
+
+	backend, err := client.Backend(ctx)
+	if err != nil {
+		t.Fatalf("Backend failed: %v", err)
+	}
+
+	if backend.Name != "sway" || len(backend.Candidates) != 2 {
+		t.Errorf("Expected the sway backend and 2 candidates, got %+v", backend)
+	}
+}
+
+func TestServer_RejectsOtherProtocolVersions(t *testing.T) {
+	path := startTestServer(t, &fakeHandler{})
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := Request{Version: ProtocolVersion + 1, Command: CommandStatus}
+	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	if resp.OK {
+		t.Fatal("Expected request with unknown version to fail")
+	}
+
+	if !strings.Contains(resp.Error, "PK_601") {
+		t.Errorf("Expected PK_601 error, got '%s'", resp.Error)
+	}
+}
+
+func TestServer_UnknownCommand(t *testing.T) {
+	client := NewClient(startTestServer(t, &fakeHandler{}))
+
+	if _, err := client.do(context.Background(), Command("explode")); err == nil {
+		t.Error("Expected unknown command to fail")
+	}
+}
+
+func TestClient_DaemonNotRunning(t *testing.T) {
+	client := NewClient(filepath.Join(t.TempDir(), "polykeys", socketName))
+
+	_, err := client.Status(context.Background())
+	if err == nil {
+		t.Fatal("Expected error when no daemon is listening")
+	}
+
+	if !strings.Contains(err.Error(), "PK_600") {
+		t.Errorf("Expected PK_600 error, got '%v'", err)
+	}
+}
+
+func TestServer_RefusesToReplaceLiveSocket(t *testing.T) {
+	path := startTestServer(t, &fakeHandler{})
+
+	second := NewServer(path, &fakeHandler{})
+	if err := second.Start(context.Background()); err == nil {
+		second.Stop()
+		t.Error("Expected second server on the same socket to fail")
+	}
+}
+
+func TestServer_RefusesUnsafeSocketDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket directory permissions are not checked on Windows")
+	}
+
+	// Another user could have created the directory with open permissions
+	open := filepath.Join(t.TempDir(), "open")
+	if err := os.Mkdir(open, 0700); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.Chmod(open, 0777); err != nil {
+		t.Fatalf("Failed to chmod dir: %v", err)
+	}
+
+	// Or pointed it somewhere else
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	for _, dir := range []string{open, link} {
+		server := NewServer(filepath.Join(dir, socketName), &fakeHandler{})
+		if err := server.Start(context.Background()); err == nil {
+			server.Stop()
+			t.Errorf("Expected server to refuse socket directory %s", dir)
+		}
+
+		_, err := NewClient(filepath.Join(dir, socketName)).Status(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "PK_602") {
+			t.Errorf("Expected PK_602 error for socket directory %s, got '%v'", dir, err)
+		}
+	}
+}
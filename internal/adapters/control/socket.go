@@ -0,0 +1,49 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// socketName is the file name of the daemon control socket
+const socketName = "polykeysd.sock"
+
+// SocketPath returns the path of the daemon control socket.
+// The socket lives under $XDG_RUNTIME_DIR/polykeys, falling back to a
+// per-user directory in the system temp dir when XDG_RUNTIME_DIR is not set.
+func SocketPath() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "polykeys", socketName)
+	}
+
+	dir := "polykeys"
+	if uid := os.Getuid(); uid >= 0 {
+		dir += "-" + strconv.Itoa(uid)
+	}
+	return filepath.Join(os.TempDir(), dir, socketName)
+}
+
+// errUnsafeSocketDir reports a socket directory that another user could
+// have created or could write to
+func errUnsafeSocketDir(reason string) error {
+	return fmt.Errorf("unsafe socket directory: %s", reason)
+}
+
+// checkSocketDir fails unless dir is a real directory owned by the current
+// user with mode 0700. The fallback directory has a predictable name in the
+// shared temp dir, where another user could have created it first.
+func checkSocketDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return errUnsafeSocketDir("is a symlink")
+	}
+	if !info.IsDir() {
+		return errUnsafeSocketDir("not a directory")
+	}
+	return checkOwnership(info)
+}
@@ -17,17 +17,17 @@ import (
 
 // DarwinDeviceDetector detects USB/HID devices on macOS
 type DarwinDeviceDetector struct {
-	onConnectedCallback    func(*domain.Device)
-	onDisconnectedCallback func(*domain.Device)
-	devices                map[string]*domain.Device
-	mu                     sync.RWMutex
-	stopChan               chan struct{}
-	polling                bool
+	events   *domain.EventBus
+	devices  map[string]*domain.Device
+	mu       sync.RWMutex
+	stopChan chan struct{}
+	polling  bool
 }
 
-// NewDarwinDeviceDetector creates a new macOS device detector
-func NewDarwinDeviceDetector() (*DarwinDeviceDetector, error) {
+// NewDarwinDeviceDetector creates a new macOS device detector publishing to events
+func NewDarwinDeviceDetector(events *domain.EventBus) (*DarwinDeviceDetector, error) {
 	return &DarwinDeviceDetector{
+		events:   events,
 		devices:  make(map[string]*domain.Device),
 		stopChan: make(chan struct{}),
 	}, nil
@@ -73,16 +73,6 @@ func (d *DarwinDeviceDetector) GetConnectedDevices(ctx context.Context) ([]*doma
 	return devices, nil
 }
 
-// OnDeviceConnected registers a callback for device connection events
-func (d *DarwinDeviceDetector) OnDeviceConnected(callback func(*domain.Device)) {
-	d.onConnectedCallback = callback
-}
-
-// OnDeviceDisconnected registers a callback for device disconnection events
-func (d *DarwinDeviceDetector) OnDeviceDisconnected(callback func(*domain.Device)) {
-	d.onDisconnectedCallback = callback
-}
-
 // pollDevices polls for device changes
 func (d *DarwinDeviceDetector) pollDevices(ctx context.Context) {
 	ticker := time.NewTicker(2 * time.Second)
@@ -126,17 +116,7 @@ func (d *DarwinDeviceDetector) pollDevices(ctx context.Context) {
 			// Check for new devices (connected)
 			for id, device := range currentDevices {
 				if _, existed := previousDevices[id]; !existed {
-					if d.onConnectedCallback != nil {
-						// Run callback in goroutine to avoid blocking polling
-						go func(dev *domain.Device) {
-							defer func() {
-								if r := recover(); r != nil {
-									logger.Debug("[Detector] Panic in connected callback: %v\n", r)
-								}
-							}()
-							d.onConnectedCallback(dev)
-						}(device)
-					}
+					d.events.Publish(domain.DeviceConnected{Device: device})
 				}
 			}
 
@@ -145,17 +125,7 @@ func (d *DarwinDeviceDetector) pollDevices(ctx context.Context) {
 				if _, exists := currentDevices[id]; !exists {
 					// Device was disconnected - use the stored device info
 					device := previousDevices[id]
-					if d.onDisconnectedCallback != nil {
-						// Run callback in goroutine to avoid blocking polling
-						go func(dev *domain.Device) {
-							defer func() {
-								if r := recover(); r != nil {
-									logger.Debug("[Detector] Panic in disconnected callback: %v\n", r)
-								}
-							}()
-							d.onDisconnectedCallback(dev)
-						}(device)
-					}
+					d.events.Publish(domain.DeviceDisconnected{Device: device})
 				}
 			}
 
@@ -176,11 +146,11 @@ func (d *DarwinDeviceDetector) pollDevices(ctx context.Context) {
 
 // SPUSBDevice represents a USB device from system_profiler
 type SPUSBDevice struct {
-	Name       string `json:"_name"`
-	VendorID   string `json:"vendor_id,omitempty"`
-	ProductID  string `json:"product_id,omitempty"`
-	SerialNum  string `json:"serial_num,omitempty"`
-	Items      []SPUSBDevice `json:"_items,omitempty"`
+	Name      string        `json:"_name"`
+	VendorID  string        `json:"vendor_id,omitempty"`
+	ProductID string        `json:"product_id,omitempty"`
+	SerialNum string        `json:"serial_num,omitempty"`
+	Items     []SPUSBDevice `json:"_items,omitempty"`
 }
 
 // SPUSBDataType represents the root of system_profiler output
@@ -235,10 +205,10 @@ func (d *DarwinDeviceDetector) processUSBDevice(usbDevice SPUSBDevice) {
 
 		// Skip some known non-keyboard devices
 		skipDevices := []string{
-			"hub",           // USB hubs
-			"camera",        // Cameras
-			"bluetooth",     // Bluetooth adapters
-			"card reader",   // Card readers
+			"hub",         // USB hubs
+			"camera",      // Cameras
+			"bluetooth",   // Bluetooth adapters
+			"card reader", // Card readers
 		}
 
 		deviceNameLower := strings.ToLower(usbDevice.Name)
@@ -257,9 +227,11 @@ func (d *DarwinDeviceDetector) processUSBDevice(usbDevice SPUSBDevice) {
 
 			device := domain.NewDevice(vendorID, productID, usbDevice.Name)
 			device.ID = deviceID
+			device.Serial = usbDevice.SerialNum
 			device.UpdateLastSeen()
 
-			d.devices[deviceID] = device
+			// Key by instance so identical keyboards with serials are kept apart
+			d.devices[device.InstanceID()] = device
 		}
 	}
 
@@ -0,0 +1,124 @@
+package devices
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// Event types and key codes from linux/input-event-codes.h
+const (
+	evKey = 0x01
+
+	keySpace = 57
+	btnMisc  = 0x100
+	keyOK    = 0x160
+	keyMax   = 0x2ff
+)
+
+var (
+	// letterKeys are KEY_Q..KEY_P, KEY_A..KEY_L and KEY_Z..KEY_M
+	letterKeys = keyRange([2]int{16, 25}, [2]int{30, 38}, [2]int{44, 50})
+	// digitKeys are KEY_1..KEY_0 on the main block
+	digitKeys = keyRange([2]int{2, 11})
+	// keypadDigitKeys are KEY_KP7..KEY_KP0 on the numeric keypad
+	keypadDigitKeys = keyRange([2]int{71, 73}, [2]int{75, 77}, [2]int{79, 82})
+)
+
+// keyRange expands inclusive key code ranges
+func keyRange(ranges ...[2]int) []int {
+	codes := make([]int, 0)
+	for _, r := range ranges {
+		for code := r[0]; code <= r[1]; code++ {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// bitmap is a kernel capability bitmask, least significant word first
+type bitmap []uint64
+
+// parseBitmap parses a capability bitmask as printed by the kernel in
+// /proc/bus/input/devices and uevents: hex words of the native long size,
+// most significant word first (e.g. "1000000000007 ff9f207ac14057ff")
+func parseBitmap(value string) bitmap {
+	words := strings.Fields(value)
+	b := make(bitmap, len(words))
+	for i, word := range words {
+		parsed, err := strconv.ParseUint(word, 16, 64)
+		if err != nil {
+			return nil
+		}
+		b[len(words)-1-i] = parsed
+	}
+	return b
+}
+
+// has reports whether a bit is set
+func (b bitmap) has(bit int) bool {
+	word := bit / bits.UintSize
+	if bit < 0 || word >= len(b) {
+		return false
+	}
+	return b[word]&(1<<(uint(bit)%bits.UintSize)) != 0
+}
+
+// hasAll reports whether every bit in codes is set
+func (b bitmap) hasAll(codes []int) bool {
+	for _, code := range codes {
+		if !b.has(code) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasAnyIn reports whether any bit in [from, to] is set
+func (b bitmap) hasAnyIn(from, to int) bool {
+	for code := from; code <= to; code++ {
+		if b.has(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyDevice classifies an input device from its EV and KEY bitmasks
+func classifyDevice(evBits, keyBits bitmap) domain.DeviceClass {
+	if !evBits.has(evKey) {
+		return domain.DeviceClassNonKeyboard
+	}
+
+	if keyBits.hasAll(letterKeys) && keyBits.has(keySpace) {
+		return domain.DeviceClassKeyboard
+	}
+
+	if keyBits.hasAll(keypadDigitKeys) || keyBits.hasAll(digitKeys) {
+		return domain.DeviceClassKeypad
+	}
+
+	// Mice, touchpads and joysticks only report buttons (BTN_*), which
+	// live between BTN_MISC and KEY_OK
+	if keyBits.hasAnyIn(1, btnMisc-1) || keyBits.hasAnyIn(keyOK, keyMax) {
+		return domain.DeviceClassConsumerKeys
+	}
+
+	return domain.DeviceClassNonKeyboard
+}
+
+// classRank orders device classes from least to most keyboard-like
+func classRank(class domain.DeviceClass) int {
+	switch class {
+	case domain.DeviceClassKeyboard:
+		return 3
+	case domain.DeviceClassKeypad:
+		return 2
+	case domain.DeviceClassConsumerKeys:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,96 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+func TestParseBitmap(t *testing.T) {
+	b := parseBitmap("1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe")
+
+	if len(b) != 4 {
+		t.Fatalf("Expected 4 words, got %d", len(b))
+	}
+
+	if b.has(0) {
+		t.Error("Expected KEY_RESERVED (0) to be unset")
+	}
+
+	for _, code := range []int{1, 16, 30, 44, keySpace} {
+		if !b.has(code) {
+			t.Errorf("Expected key %d to be set", code)
+		}
+	}
+
+	if b.has(4096) {
+		t.Error("Expected out of range bit to be unset")
+	}
+}
+
+func TestParseBitmap_Invalid(t *testing.T) {
+	if b := parseBitmap("not hex"); b.has(0) || len(b) != 0 {
+		t.Errorf("Expected empty bitmap for invalid input, got %v", b)
+	}
+}
+
+func TestClassifyDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		ev       string
+		key      string
+		expected domain.DeviceClass
+	}{
+		{
+			name:     "Full keyboard",
+			ev:       "120013",
+			key:      "1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe",
+			expected: domain.DeviceClassKeyboard,
+		},
+		{
+			name:     "Numeric keypad",
+			ev:       "120013",
+			key:      "5000fffa0 80000000004000",
+			expected: domain.DeviceClassKeypad,
+		},
+		{
+			name:     "Consumer Control",
+			ev:       "1f",
+			key:      "3f000303ff 0 0 483ffff17aff32d bfd4444600000000 1 130c730b17c000 267bfad9415fed 9e168000004400 10000002",
+			expected: domain.DeviceClassConsumerKeys,
+		},
+		{
+			name:     "Power Button",
+			ev:       "3",
+			key:      "10000000000000 0",
+			expected: domain.DeviceClassConsumerKeys,
+		},
+		{
+			name:     "Lid Switch",
+			ev:       "21",
+			key:      "",
+			expected: domain.DeviceClassNonKeyboard,
+		},
+		{
+			name:     "QMK mousekeys interface",
+			ev:       "17",
+			key:      "1f0000 0 0 0 0",
+			expected: domain.DeviceClassNonKeyboard,
+		},
+		{
+			name:     "Touchpad",
+			ev:       "1b",
+			key:      "e520 10000 0 0 0 0",
+			expected: domain.DeviceClassNonKeyboard,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := classifyDevice(parseBitmap(tt.ev), parseBitmap(tt.key))
+			if class != tt.expected {
+				t.Errorf("Expected class '%s', got '%s'", tt.expected, class)
+			}
+		})
+	}
+}
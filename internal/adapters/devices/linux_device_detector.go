@@ -1,29 +1,31 @@
 package devices
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
 	"github.com/fsnotify/fsnotify"
 )
 
-// LinuxDeviceDetector detects USB/HID devices on Linux
+// LinuxDeviceDetector detects USB/HID devices on Linux by watching /dev/input
 type LinuxDeviceDetector struct {
-	watcher              *fsnotify.Watcher
-	onConnectedCallback  func(*domain.Device)
-	onDisconnectedCallback func(*domain.Device)
-	devices              map[string]*domain.Device // deviceID -> device
-	mu                   sync.RWMutex
-	stopChan             chan struct{}
-}
-
-// NewLinuxDeviceDetector creates a new Linux device detector
-func NewLinuxDeviceDetector() (*LinuxDeviceDetector, error) {
+	watcher  *fsnotify.Watcher
+	tracker  *deviceTracker
+	stopChan chan struct{}
+	mu       sync.Mutex
+	running  bool
+	// scanMu is held from reading the devices to applying them, so that
+	// scans reach the tracker in the order they were taken
+	scanMu sync.Mutex
+}
+
+// NewLinuxDeviceDetector creates a new Linux device detector publishing to events
+func NewLinuxDeviceDetector(events *domain.EventBus) (*LinuxDeviceDetector, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create watcher: %w", err)
@@ -31,7 +33,7 @@ func NewLinuxDeviceDetector() (*LinuxDeviceDetector, error) {
 
 	return &LinuxDeviceDetector{
 		watcher:  watcher,
-		devices:  make(map[string]*domain.Device),
+		tracker:  newDeviceTracker(events),
 		stopChan: make(chan struct{}),
 	}, nil
 }
@@ -48,6 +50,10 @@ func (d *LinuxDeviceDetector) StartMonitoring(ctx context.Context) error {
 		return fmt.Errorf("failed to scan initial devices: %w", err)
 	}
 
+	d.mu.Lock()
+	d.running = true
+	d.mu.Unlock()
+
 	// Start watching for events
 	go d.watchEvents(ctx)
 
@@ -56,35 +62,29 @@ func (d *LinuxDeviceDetector) StartMonitoring(ctx context.Context) error {
 
 // StopMonitoring stops the monitoring process
 func (d *LinuxDeviceDetector) StopMonitoring() error {
+	d.mu.Lock()
+	d.running = false
+	d.mu.Unlock()
+
 	close(d.stopChan)
 	return d.watcher.Close()
 }
 
 // GetConnectedDevices returns all currently connected keyboard devices
 func (d *LinuxDeviceDetector) GetConnectedDevices(ctx context.Context) ([]*domain.Device, error) {
-	if err := d.scanDevices(); err != nil {
-		return nil, fmt.Errorf("failed to scan devices: %w", err)
-	}
-
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
-	devices := make([]*domain.Device, 0, len(d.devices))
-	for _, device := range d.devices {
-		devices = append(devices, device)
+	d.mu.Lock()
+	running := d.running
+	d.mu.Unlock()
+
+	// While monitoring, the watch loop keeps the tracker up to date. A
+	// rescan here would publish events, which status requests must not do.
+	if !running {
+		if err := d.scanDevices(); err != nil {
+			return nil, fmt.Errorf("failed to scan devices: %w", err)
+		}
 	}
 
-	return devices, nil
-}
-
-// OnDeviceConnected registers a callback for device connection events
-func (d *LinuxDeviceDetector) OnDeviceConnected(callback func(*domain.Device)) {
-	d.onConnectedCallback = callback
-}
-
-// OnDeviceDisconnected registers a callback for device disconnection events
-func (d *LinuxDeviceDetector) OnDeviceDisconnected(callback func(*domain.Device)) {
-	d.onDisconnectedCallback = callback
+	return d.tracker.connectedDevices(), nil
 }
 
 // watchEvents watches for filesystem events
@@ -128,150 +128,49 @@ func (d *LinuxDeviceDetector) watchEvents(ctx context.Context) {
 
 // scanDevices scans /proc/bus/input/devices for keyboard devices
 func (d *LinuxDeviceDetector) scanDevices() error {
-	file, err := os.Open("/proc/bus/input/devices")
-	if err != nil {
-		return fmt.Errorf("failed to open /proc/bus/input/devices: %w", err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	var currentDevice *deviceInfo
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// New device block
-		if strings.HasPrefix(line, "I:") {
-			if currentDevice != nil {
-				d.processDevice(currentDevice)
-			}
-			currentDevice = &deviceInfo{}
-			d.parseInputLine(line, currentDevice)
-		} else if currentDevice != nil {
-			if strings.HasPrefix(line, "N:") {
-				d.parseNameLine(line, currentDevice)
-			} else if strings.HasPrefix(line, "H:") {
-				d.parseHandlersLine(line, currentDevice)
-			} else if strings.HasPrefix(line, "B: EV=") {
-				d.parseEventsLine(line, currentDevice)
-			}
-		}
-	}
-
-	// Process last device
-	if currentDevice != nil {
-		d.processDevice(currentDevice)
-	}
-
-	return scanner.Err()
+	return d.scanDevicesExcluding("")
 }
 
-// deviceInfo holds temporary device information during parsing
-type deviceInfo struct {
-	vendorID  string
-	productID string
-	name      string
-	handlers  []string
-	hasKeys   bool
-}
-
-// parseInputLine parses the "I:" line containing vendor and product IDs
-func (d *LinuxDeviceDetector) parseInputLine(line string, info *deviceInfo) {
-	parts := strings.Fields(line)
-	for _, part := range parts {
-		if strings.HasPrefix(part, "Vendor=") {
-			info.vendorID = strings.TrimPrefix(part, "Vendor=")
-		} else if strings.HasPrefix(part, "Product=") {
-			info.productID = strings.TrimPrefix(part, "Product=")
-		}
-	}
-}
-
-// parseNameLine parses the "N:" line containing device name
-func (d *LinuxDeviceDetector) parseNameLine(line string, info *deviceInfo) {
-	if strings.HasPrefix(line, "N: Name=") {
-		name := strings.TrimPrefix(line, "N: Name=")
-		name = strings.Trim(name, "\"")
-		info.name = name
-	}
-}
+// scanDevicesExcluding rescans keyboard devices, ignoring any input device that
+// owns the given event node. This lets a removal event take effect even if the
+// kernel has not yet dropped the device from /proc/bus/input/devices.
+func (d *LinuxDeviceDetector) scanDevicesExcluding(removedNode string) error {
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
 
-// parseHandlersLine parses the "H:" line containing handlers
-func (d *LinuxDeviceDetector) parseHandlersLine(line string, info *deviceInfo) {
-	if strings.HasPrefix(line, "H: Handlers=") {
-		handlers := strings.TrimPrefix(line, "H: Handlers=")
-		info.handlers = strings.Fields(handlers)
+	infos, err := readProcDevices()
+	if err != nil {
+		return err
 	}
-}
 
-// parseEventsLine parses the "B: EV=" line to detect keyboard capability
-func (d *LinuxDeviceDetector) parseEventsLine(line string, info *deviceInfo) {
-	// EV_KEY (0x01) indicates the device can produce key events
-	// A keyboard typically has EV=120013 or similar
-	if strings.Contains(line, "B: EV=") {
-		evValue := strings.TrimPrefix(line, "B: EV=")
-		evValue = strings.TrimSpace(evValue)
-		// Check if bit 0 (EV_KEY) is set
-		// This is a simplified check - a proper implementation would parse the hex value
-		if len(evValue) > 0 {
-			info.hasKeys = true
+	if removedNode != "" {
+		kept := infos[:0]
+		for _, info := range infos {
+			if !info.hasEventNode(removedNode) {
+				kept = append(kept, info)
+			}
 		}
-	}
-}
-
-// processDevice processes a parsed device and adds it if it's a keyboard
-func (d *LinuxDeviceDetector) processDevice(info *deviceInfo) {
-	// Skip if not a keyboard (no key events or no event handler)
-	if !info.hasKeys || len(info.handlers) == 0 {
-		return
+		infos = kept
 	}
 
-	// Skip if it's a mouse or touchpad (simple heuristic)
-	nameLower := strings.ToLower(info.name)
-	if strings.Contains(nameLower, "mouse") ||
-	   strings.Contains(nameLower, "touchpad") ||
-	   strings.Contains(nameLower, "touchscreen") {
-		return
-	}
-
-	// Create device
-	device := domain.NewDevice(info.vendorID, info.productID, info.name)
-
-	d.mu.Lock()
-	existingDevice, exists := d.devices[device.ID]
-	if !exists {
-		d.devices[device.ID] = device
-		d.mu.Unlock()
-
-		// Trigger callback for new device
-		if d.onConnectedCallback != nil {
-			d.onConnectedCallback(device)
-		}
-	} else {
-		existingDevice.UpdateLastSeen()
-		d.mu.Unlock()
-	}
+	d.tracker.apply(infos)
+	return nil
 }
 
 // handleDeviceRemoval handles device removal events
 func (d *LinuxDeviceDetector) handleDeviceRemoval(eventPath string) {
-	// We can't easily map an event file back to a device ID
-	// So we rescan and compare with current devices
-	// This is a simplified approach - a production version would be more sophisticated
+	node := filepath.Base(eventPath)
+
+	if device, known := d.tracker.deviceForNode(node); known {
+		logger.Debug("[Detector] Event node %s removed (device %s, %d nodes)\n", node, device.ID, len(device.Nodes))
+	}
 
-	// For now, we'll just rescan
-	_ = d.scanDevices()
+	if err := d.scanDevicesExcluding(node); err != nil {
+		logger.Debug("[Detector] Error rescanning after removal of %s: %v\n", node, err)
+	}
 }
 
 // GetDeviceByID returns a device by its ID
 func (d *LinuxDeviceDetector) GetDeviceByID(deviceID string) (*domain.Device, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
-	device, exists := d.devices[deviceID]
-	if !exists {
-		return nil, fmt.Errorf("device %s not found", deviceID)
-	}
-
-	return device, nil
+	return d.tracker.deviceByID(deviceID)
 }
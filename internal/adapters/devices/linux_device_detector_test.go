@@ -0,0 +1,29 @@
+package devices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+func TestLinuxDeviceDetector_GetConnectedDevicesWhileMonitoring(t *testing.T) {
+	events := domain.NewEventBus()
+	detector := &LinuxDeviceDetector{tracker: newDeviceTracker(events), running: true}
+	detector.tracker.apply(parseProc(t, procCorne))
+
+	published := 0
+	events.Subscribe(func(domain.Event) { published++ })
+
+	// Status requests list the devices without rescanning them
+	devices, err := detector.GetConnectedDevices(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list devices: %v", err)
+	}
+	if len(devices) != 1 || devices[0].ID != "4653:0004" {
+		t.Errorf("Expected the tracked Corne, got %v", devices)
+	}
+	if published != 0 {
+		t.Errorf("Expected no events while listing devices, got %d", published)
+	}
+}
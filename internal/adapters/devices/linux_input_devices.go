@@ -0,0 +1,391 @@
+package devices
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// procInputDevices is the kernel's list of input devices
+const procInputDevices = "/proc/bus/input/devices"
+
+// sysfsRoot is where sysfs is mounted
+var sysfsRoot = "/sys"
+
+// deviceInfo holds temporary device information during parsing
+type deviceInfo struct {
+	vendorID  string
+	productID string
+	name      string
+	phys      string
+	uniq      string
+	sysfs     string
+	handlers  []string
+	evBits    bitmap
+	keyBits   bitmap
+}
+
+// eventNodes returns the evdev nodes (e.g. "event5") listed in the handlers
+func (info *deviceInfo) eventNodes() []string {
+	nodes := make([]string, 0, 1)
+	for _, handler := range info.handlers {
+		if strings.HasPrefix(handler, "event") {
+			nodes = append(nodes, handler)
+		}
+	}
+	return nodes
+}
+
+// hasEventNode reports whether the device owns the given event node
+func (info *deviceInfo) hasEventNode(node string) bool {
+	for _, n := range info.eventNodes() {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// readProcDevices reads and parses /proc/bus/input/devices
+func readProcDevices() ([]*deviceInfo, error) {
+	file, err := os.Open(procInputDevices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", procInputDevices, err)
+	}
+	defer file.Close()
+
+	return parseProcDevices(file)
+}
+
+// parseProcDevices parses the content of /proc/bus/input/devices
+func parseProcDevices(r io.Reader) ([]*deviceInfo, error) {
+	scanner := bufio.NewScanner(r)
+	infos := make([]*deviceInfo, 0)
+	var currentDevice *deviceInfo
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// New device block
+		if strings.HasPrefix(line, "I:") {
+			currentDevice = &deviceInfo{}
+			infos = append(infos, currentDevice)
+			parseInputLine(line, currentDevice)
+		} else if currentDevice != nil {
+			switch {
+			case strings.HasPrefix(line, "N: Name="):
+				currentDevice.name = strings.Trim(strings.TrimPrefix(line, "N: Name="), "\"")
+			case strings.HasPrefix(line, "P: Phys="):
+				currentDevice.phys = strings.TrimPrefix(line, "P: Phys=")
+			case strings.HasPrefix(line, "U: Uniq="):
+				currentDevice.uniq = strings.TrimPrefix(line, "U: Uniq=")
+			case strings.HasPrefix(line, "S: Sysfs="):
+				currentDevice.sysfs = strings.TrimPrefix(line, "S: Sysfs=")
+			case strings.HasPrefix(line, "H: Handlers="):
+				currentDevice.handlers = strings.Fields(strings.TrimPrefix(line, "H: Handlers="))
+			case strings.HasPrefix(line, "B: EV="):
+				currentDevice.evBits = parseBitmap(strings.TrimPrefix(line, "B: EV="))
+			case strings.HasPrefix(line, "B: KEY="):
+				currentDevice.keyBits = parseBitmap(strings.TrimPrefix(line, "B: KEY="))
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input devices: %w", err)
+	}
+
+	return infos, nil
+}
+
+// parseInputLine parses the "I:" line containing vendor and product IDs
+func parseInputLine(line string, info *deviceInfo) {
+	parts := strings.Fields(line)
+	for _, part := range parts {
+		if strings.HasPrefix(part, "Vendor=") {
+			info.vendorID = strings.TrimPrefix(part, "Vendor=")
+		} else if strings.HasPrefix(part, "Product=") {
+			info.productID = strings.TrimPrefix(part, "Product=")
+		}
+	}
+}
+
+// class classifies the device from its capabilities
+func (info *deviceInfo) class() domain.DeviceClass {
+	return classifyDevice(info.evBits, info.keyBits)
+}
+
+// isTrackedDevice reports whether a parsed device should be tracked. Keypads and
+// consumer control nodes are tracked too, but only full keyboards drive
+// layout switching (see domain.Device.IsFullKeyboard).
+func isTrackedDevice(info *deviceInfo) bool {
+	if len(info.eventNodes()) == 0 {
+		return false
+	}
+
+	return info.class() != domain.DeviceClassNonKeyboard
+}
+
+// hidDeviceDir matches sysfs HID device directories (e.g. "0003:4653:0004.0001")
+var hidDeviceDir = regexp.MustCompile(`^[0-9A-Fa-f]{4}:[0-9A-Fa-f]{4}:[0-9A-Fa-f]{4}\.[0-9A-Fa-f]+$`)
+
+// usbInterfaceDir matches sysfs USB interface directories (e.g. "1-3:1.0")
+var usbInterfaceDir = regexp.MustCompile(`^\d+-[\d.]+:\d+\.\d+$`)
+
+// usbDeviceDir matches sysfs USB device directories (e.g. "1-3" or "1-2.4")
+var usbDeviceDir = regexp.MustCompile(`^\d+-[\d.]+$`)
+
+// physInputSuffix matches the per-interface suffix of a Phys value
+var physInputSuffix = regexp.MustCompile(`/input\d+$`)
+
+// parentKey identifies the physical device an input node belongs to, so that
+// the "Keyboard", "Consumer Control" and "System Control" interfaces of a
+// single keyboard are grouped together
+func (info *deviceInfo) parentKey() string {
+	if dir := info.parentSysfsDir(); dir != "" {
+		return "sysfs:" + dir
+	}
+
+	// Otherwise interfaces share the Phys prefix (e.g. "usb-0000:00:14.0-3")
+	if port := info.port(); port != "" {
+		return "phys:" + port
+	}
+
+	// Last resort: one logical device per vendor, product and name
+	return "id:" + info.vendorID + ":" + info.productID + ":" + info.name
+}
+
+// parentSysfsDir returns the sysfs directory of the physical device: the
+// parent of the HID device, skipping the USB interface to reach the USB
+// device, or the Bluetooth connection. It is empty for non-HID devices.
+func (info *deviceInfo) parentSysfsDir() string {
+	if info.sysfs == "" {
+		return ""
+	}
+
+	segments := strings.Split(info.sysfs, "/")
+	for i := len(segments) - 1; i > 0; i-- {
+		if !hidDeviceDir.MatchString(segments[i]) {
+			continue
+		}
+		parent := segments[:i]
+		switch last := parent[len(parent)-1]; {
+		case usbInterfaceDir.MatchString(last):
+			parent = parent[:len(parent)-1]
+		case last == "uhid":
+			// Bluetooth LE keyboards all hang off the uhid misc device,
+			// each with its own HID device
+			parent = segments[:i+1]
+		}
+		return strings.Join(parent, "/")
+	}
+
+	return ""
+}
+
+// port returns the physical port path shared by all interfaces of the device
+func (info *deviceInfo) port() string {
+	return physInputSuffix.ReplaceAllString(info.phys, "")
+}
+
+// serial returns the unique ID the device reports (a Bluetooth MAC address or
+// a USB serial number), falling back to the serial attribute of the USB device
+func (info *deviceInfo) serial() string {
+	if info.uniq != "" {
+		return info.uniq
+	}
+
+	dir := info.parentSysfsDir()
+	if !usbDeviceDir.MatchString(path.Base(dir)) {
+		return ""
+	}
+
+	data, err := os.ReadFile(filepath.Join(sysfsRoot, dir, "serial"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// deviceTracker turns successive snapshots of the kernel input devices into
+// connect/disconnect events. Input nodes are coalesced into one logical
+// device per physical keyboard. It is shared by the Linux detectors.
+type deviceTracker struct {
+	events  *domain.EventBus
+	devices map[string]*domain.Device // parent key -> device
+	nodes   map[string]string         // event node (e.g. "event5") -> parent key
+	mu      sync.RWMutex
+}
+
+// newDeviceTracker creates an empty device tracker publishing to events
+func newDeviceTracker(events *domain.EventBus) *deviceTracker {
+	return &deviceTracker{
+		events:  events,
+		devices: make(map[string]*domain.Device),
+		nodes:   make(map[string]string),
+	}
+}
+
+// apply replaces the tracked devices with a fresh snapshot and publishes
+// connect/disconnect events for the differences, the same way the
+// Windows and macOS detectors diff consecutive polls. A device connects
+// when its first node appears and disconnects when its last node is gone.
+func (t *deviceTracker) apply(infos []*deviceInfo) {
+	currentDevices := make(map[string]*domain.Device)
+	currentNodes := make(map[string]string)
+
+	for _, info := range infos {
+		if !isTrackedDevice(info) {
+			continue
+		}
+
+		key := info.parentKey()
+		class := info.class()
+
+		device, seen := currentDevices[key]
+		if !seen {
+			device = domain.NewDevice(info.vendorID, info.productID, info.name)
+			device.Class = class
+			device.Serial = info.serial()
+			device.Phys = info.port()
+			currentDevices[key] = device
+		} else if classRank(class) > classRank(device.Class) {
+			// Name the device after its most keyboard-like interface
+			upgraded := domain.NewDevice(info.vendorID, info.productID, info.name)
+			upgraded.Class = class
+			upgraded.Serial = device.Serial
+			upgraded.Phys = device.Phys
+			upgraded.Nodes = device.Nodes
+			device = upgraded
+			currentDevices[key] = device
+		}
+
+		for _, node := range info.eventNodes() {
+			device.Nodes = append(device.Nodes, domain.DeviceNode{
+				Name:  info.name,
+				Path:  "/dev/input/" + node,
+				Class: class,
+			})
+			currentNodes[node] = key
+		}
+	}
+
+	for _, device := range currentDevices {
+		sort.Slice(device.Nodes, func(i, j int) bool {
+			return device.Nodes[i].Path < device.Nodes[j].Path
+		})
+	}
+
+	t.mu.Lock()
+	previousDevices := t.devices
+
+	// Devices handed out are never changed, as other goroutines use them:
+	// a rescanned device replaces the known one, keeping its alias. A device
+	// whose class changed, such as its keyboard interface showing up after
+	// its consumer control one, connects again for listeners to reconsider it.
+	// A keyboard whose keyboard interface went away disconnects instead,
+	// even though its other nodes remain.
+	// Events go out in key order, so that rescans stack devices the same way.
+	connected := make([]*domain.Device, 0)
+	disconnected := make([]*domain.Device, 0)
+	for _, key := range sortedKeys(currentDevices) {
+		device := currentDevices[key]
+		existing, existed := previousDevices[key]
+		if existed && existing.InstanceID() == device.InstanceID() {
+			device.Alias = existing.Alias
+			if existing.Class == device.Class {
+				continue
+			}
+			if existing.IsFullKeyboard() && !device.IsFullKeyboard() {
+				disconnected = append(disconnected, existing)
+				continue
+			}
+		}
+		connected = append(connected, device)
+	}
+
+	// A device is gone once its last event node has disappeared
+	for _, key := range sortedKeys(previousDevices) {
+		device := previousDevices[key]
+		if current, exists := currentDevices[key]; !exists || current.InstanceID() != device.InstanceID() {
+			disconnected = append(disconnected, device)
+		}
+	}
+
+	t.devices = currentDevices
+	t.nodes = currentNodes
+	t.mu.Unlock()
+
+	// Each event gets its own copy, which its handlers may change
+	for _, device := range disconnected {
+		t.events.Publish(domain.DeviceDisconnected{Device: copyDevice(device)})
+	}
+
+	for _, device := range connected {
+		t.events.Publish(domain.DeviceConnected{Device: copyDevice(device)})
+	}
+}
+
+// sortedKeys returns the keys of devices in order
+func sortedKeys(devices map[string]*domain.Device) []string {
+	keys := make([]string, 0, len(devices))
+	for key := range devices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// connectedDevices returns the currently tracked devices
+func (t *deviceTracker) connectedDevices() []*domain.Device {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	devices := make([]*domain.Device, 0, len(t.devices))
+	for _, device := range t.devices {
+		devices = append(devices, copyDevice(device))
+	}
+	return devices
+}
+
+// deviceForNode returns the device owning an event node
+func (t *deviceTracker) deviceForNode(node string) (*domain.Device, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	device, ok := t.devices[t.nodes[node]]
+	if !ok {
+		return nil, false
+	}
+	return copyDevice(device), true
+}
+
+// deviceByID returns a tracked device by its ID
+func (t *deviceTracker) deviceByID(deviceID string) (*domain.Device, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, device := range t.devices {
+		if device.ID == deviceID {
+			return copyDevice(device), nil
+		}
+	}
+
+	return nil, fmt.Errorf("device %s not found", deviceID)
+}
+
+// copyDevice returns a copy of a tracked device for callers to keep
+func copyDevice(device *domain.Device) *domain.Device {
+	copied := *device
+	copied.Nodes = slices.Clone(device.Nodes)
+	return &copied
+}
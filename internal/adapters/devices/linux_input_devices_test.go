@@ -0,0 +1,450 @@
+package devices
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// procCorne is a /proc/bus/input/devices excerpt for a Corne exposing
+// a keyboard and a consumer control interface, plus a laptop touchpad
+const procCorne = `I: Bus=0003 Vendor=4653 Product=0004 Version=0111
+N: Name="foostan Corne"
+P: Phys=usb-0000:00:14.0-3/input0
+S: Sysfs=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23
+U: Uniq=
+H: Handlers=sysrq kbd leds event5
+B: PROP=0
+B: EV=120013
+B: KEY=1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe
+B: MSC=10
+B: LED=1f
+
+I: Bus=0003 Vendor=4653 Product=0004 Version=0111
+N: Name="foostan Corne Consumer Control"
+P: Phys=usb-0000:00:14.0-3/input1
+S: Sysfs=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.1/0003:4653:0004.0002/input/input24
+U: Uniq=
+H: Handlers=kbd event6
+B: PROP=0
+B: EV=1f
+B: KEY=3f000303ff 0 0 483ffff17aff32d bfd4444600000000 1 130c730b17c000 267bfad9415fed 9e168000004400 10000002
+
+I: Bus=0018 Vendor=06cb Product=ce44 Version=0100
+N: Name="SYNA8004:00 06CB:CE44 Touchpad"
+P: Phys=i2c-SYNA8004:00
+S: Sysfs=/devices/platform/AMDI0010:00/i2c-0/i2c-SYNA8004:00/0018:06CB:CE44.0003/input/input12
+U: Uniq=
+H: Handlers=mouse0 event9
+B: PROP=5
+B: EV=1b
+B: KEY=e520 10000 0 0 0 0
+`
+
+// procLily58 is a /proc/bus/input/devices excerpt for a single Lily58
+const procLily58 = `I: Bus=0003 Vendor=1209 Product=bb58 Version=0001
+N: Name="Lily58"
+P: Phys=usb-0000:00:14.0-4/input0
+S: Sysfs=/devices/pci0000:00/0000:00:14.0/usb1/1-4/1-4:1.0/0003:1209:BB58.0004/input/input30
+U: Uniq=
+H: Handlers=sysrq kbd leds event7
+B: PROP=0
+B: EV=120013
+B: KEY=1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe
+`
+
+// recordingEvents collects the devices reported by a detector
+type recordingEvents struct {
+	connected    []string
+	disconnected []string
+}
+
+func newRecordingTracker(t *testing.T) (*deviceTracker, *recordingEvents) {
+	t.Helper()
+
+	events := domain.NewEventBus()
+	rec := &recordingEvents{}
+	events.Subscribe(func(event domain.Event) {
+		switch e := event.(type) {
+		case domain.DeviceConnected:
+			rec.connected = append(rec.connected, e.Device.ID)
+		case domain.DeviceDisconnected:
+			rec.disconnected = append(rec.disconnected, e.Device.ID)
+		}
+	})
+
+	return newDeviceTracker(events), rec
+}
+
+func parseProc(t *testing.T, content string) []*deviceInfo {
+	t.Helper()
+
+	infos, err := parseProcDevices(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to parse devices: %v", err)
+	}
+	return infos
+}
+
+func TestParseProcDevices(t *testing.T) {
+	infos := parseProc(t, procCorne)
+
+	if len(infos) != 3 {
+		t.Fatalf("Expected 3 input devices, got %d", len(infos))
+	}
+
+	corne := infos[0]
+	if corne.vendorID != "4653" || corne.productID != "0004" {
+		t.Errorf("Expected 4653:0004, got %s:%s", corne.vendorID, corne.productID)
+	}
+
+	if corne.name != "foostan Corne" {
+		t.Errorf("Expected name 'foostan Corne', got '%s'", corne.name)
+	}
+
+	if corne.phys != "usb-0000:00:14.0-3/input0" {
+		t.Errorf("Expected phys 'usb-0000:00:14.0-3/input0', got '%s'", corne.phys)
+	}
+
+	expectedSysfs := "/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23"
+	if corne.sysfs != expectedSysfs {
+		t.Errorf("Expected sysfs '%s', got '%s'", expectedSysfs, corne.sysfs)
+	}
+
+	nodes := corne.eventNodes()
+	if len(nodes) != 1 || nodes[0] != "event5" {
+		t.Errorf("Expected event nodes [event5], got %v", nodes)
+	}
+}
+
+func TestDeviceTracker_NodeIndex(t *testing.T) {
+	tracker, _ := newRecordingTracker(t)
+	tracker.apply(parseProc(t, procCorne))
+
+	for _, node := range []string{"event5", "event6"} {
+		device, ok := tracker.deviceForNode(node)
+		if !ok || device.ID != "4653:0004" {
+			t.Errorf("Expected %s to belong to 4653:0004, got %v", node, device)
+		}
+	}
+
+	if _, indexed := tracker.deviceForNode("event9"); indexed {
+		t.Error("Expected touchpad node event9 not to be indexed")
+	}
+}
+
+func TestDeviceTracker_ConnectAndDisconnect(t *testing.T) {
+	tracker, rec := newRecordingTracker(t)
+
+	tracker.apply(parseProc(t, procCorne))
+	if len(rec.connected) != 1 || rec.connected[0] != "4653:0004" {
+		t.Fatalf("Expected a single connect for the Corne, got %v", rec.connected)
+	}
+
+	// Rescanning the same devices must not fire anything
+	tracker.apply(parseProc(t, procCorne))
+	if len(rec.connected) != 1 || len(rec.disconnected) != 0 {
+		t.Fatalf("Expected no events on identical rescan, got connected=%v disconnected=%v",
+			rec.connected, rec.disconnected)
+	}
+
+	// Swap the Corne for the Lily58
+	tracker.apply(parseProc(t, procLily58))
+	if len(rec.disconnected) != 1 || rec.disconnected[0] != "4653:0004" {
+		t.Errorf("Expected Corne disconnect, got %v", rec.disconnected)
+	}
+	if len(rec.connected) != 2 || rec.connected[1] != "1209:bb58" {
+		t.Errorf("Expected Lily58 connect, got %v", rec.connected)
+	}
+}
+
+func TestDeviceTracker_DisconnectOnLastNode(t *testing.T) {
+	tracker, rec := newRecordingTracker(t)
+	infos := parseProc(t, procCorne)
+	tracker.apply(infos)
+
+	// Only the keyboard interface is left
+	tracker.apply(infos[:1])
+	if len(rec.disconnected) != 0 {
+		t.Fatalf("Expected no disconnect while event5 remains, got %v", rec.disconnected)
+	}
+
+	tracker.apply(nil)
+	if len(rec.disconnected) != 1 || rec.disconnected[0] != "4653:0004" {
+		t.Errorf("Expected Corne disconnect once its last node is gone, got %v", rec.disconnected)
+	}
+}
+
+func TestDeviceTracker_RescanLeavesPublishedDevices(t *testing.T) {
+	events := domain.NewEventBus()
+	published := make(chan *domain.Device, 1)
+	events.Subscribe(func(event domain.Event) {
+		// Only the first one matters, the others connect it again as its
+		// class changes
+		select {
+		case published <- event.(domain.DeviceConnected).Device:
+		default:
+		}
+	}, domain.EventDeviceConnected)
+
+	tracker := newDeviceTracker(events)
+	infos := parseProc(t, procCorne)
+	tracker.apply(infos)
+	corne := <-published
+
+	// Event handlers and status requests use the devices while rescans happen
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 100 {
+			corne.UpdateLastSeen()
+			_ = len(corne.Nodes)
+			for _, device := range tracker.connectedDevices() {
+				device.UpdateLastSeen()
+			}
+		}
+	}()
+	for i := range 100 {
+		tracker.apply(infos[i%2:])
+	}
+	<-done
+
+	if len(corne.Nodes) != 2 {
+		t.Errorf("Expected the published device to keep its nodes, got %v", corne.Nodes)
+	}
+}
+
+func TestDeviceTracker_ReconnectsOnClassChange(t *testing.T) {
+	events := domain.NewEventBus()
+	var classes []domain.DeviceClass
+	events.Subscribe(func(event domain.Event) {
+		classes = append(classes, event.(domain.DeviceConnected).Device.Class)
+	}, domain.EventDeviceConnected)
+
+	tracker := newDeviceTracker(events)
+	infos := parseProc(t, procCorne)
+
+	// The consumer control node is scanned before the keyboard one
+	tracker.apply(infos[1:2])
+	tracker.apply(infos[:2])
+
+	expected := []domain.DeviceClass{domain.DeviceClassConsumerKeys, domain.DeviceClassKeyboard}
+	if !slices.Equal(classes, expected) {
+		t.Errorf("Expected the Corne to connect again as a keyboard, got %v", classes)
+	}
+
+	// Nothing changed since
+	tracker.apply(infos[:2])
+	if len(classes) != 2 {
+		t.Errorf("Expected no event on identical rescan, got %v", classes)
+	}
+}
+
+func TestDeviceTracker_DisconnectsOnKeyboardInterfaceLoss(t *testing.T) {
+	events := domain.NewEventBus()
+	var got []string
+	events.Subscribe(func(event domain.Event) {
+		switch e := event.(type) {
+		case domain.DeviceConnected:
+			got = append(got, "connect "+string(e.Device.Class))
+		case domain.DeviceDisconnected:
+			got = append(got, "disconnect "+string(e.Device.Class))
+		}
+	})
+
+	tracker := newDeviceTracker(events)
+	infos := parseProc(t, procCorne)
+
+	// The keyboard interface goes away while the consumer control one stays
+	tracker.apply(infos[:2])
+	tracker.apply(infos[1:2])
+
+	expected := []string{"connect " + string(domain.DeviceClassKeyboard), "disconnect " + string(domain.DeviceClassKeyboard)}
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected the Corne to disconnect as a keyboard, got %v", got)
+	}
+
+	devices := tracker.connectedDevices()
+	if len(devices) != 1 || devices[0].Class != domain.DeviceClassConsumerKeys {
+		t.Errorf("Expected the consumer control interface to stay tracked, got %v", devices)
+	}
+}
+
+func TestDeviceTracker_EventOrder(t *testing.T) {
+	for range 20 {
+		tracker, rec := newRecordingTracker(t)
+
+		tracker.apply(parseProc(t, procCorne+"\n"+procLily58))
+		tracker.apply(nil)
+
+		if strings.Join(rec.connected, ",") != "4653:0004,1209:bb58" {
+			t.Fatalf("Expected connects in device order, got %v", rec.connected)
+		}
+		if strings.Join(rec.disconnected, ",") != "4653:0004,1209:bb58" {
+			t.Fatalf("Expected disconnects in device order, got %v", rec.disconnected)
+		}
+	}
+}
+
+func TestDeviceTracker_CoalescesInterfaces(t *testing.T) {
+	tracker, rec := newRecordingTracker(t)
+	tracker.apply(parseProc(t, procCorne))
+
+	devices := tracker.connectedDevices()
+	if len(devices) != 1 {
+		t.Fatalf("Expected the Corne interfaces to form 1 device, got %d", len(devices))
+	}
+
+	corne := devices[0]
+	if corne.Name != "foostan Corne" || corne.Class != domain.DeviceClassKeyboard {
+		t.Errorf("Expected device named after its keyboard interface, got '%s' (%s)", corne.Name, corne.Class)
+	}
+
+	paths := make([]string, 0, len(corne.Nodes))
+	for _, node := range corne.Nodes {
+		paths = append(paths, node.Path)
+	}
+	if strings.Join(paths, ",") != "/dev/input/event5,/dev/input/event6" {
+		t.Errorf("Expected nodes event5 and event6, got %v", paths)
+	}
+
+	if len(rec.connected) != 1 {
+		t.Errorf("Expected a single connect, got %v", rec.connected)
+	}
+}
+
+func TestDeviceTracker_NamesAfterKeyboardInterface(t *testing.T) {
+	tracker, _ := newRecordingTracker(t)
+	infos := parseProc(t, procCorne)
+
+	// The consumer control interface is listed first
+	tracker.apply([]*deviceInfo{infos[1], infos[0]})
+
+	devices := tracker.connectedDevices()
+	if len(devices) != 1 {
+		t.Fatalf("Expected the Corne interfaces to form 1 device, got %d", len(devices))
+	}
+
+	corne := devices[0]
+	if corne.ID != "4653:0004" || corne.Name != "foostan Corne" || corne.Class != domain.DeviceClassKeyboard {
+		t.Errorf("Expected device named after its keyboard interface, got %s '%s' (%s)", corne.ID, corne.Name, corne.Class)
+	}
+	if len(corne.Nodes) != 2 || corne.LastSeen.IsZero() {
+		t.Errorf("Expected both nodes and a last seen time, got %v at %v", corne.Nodes, corne.LastSeen)
+	}
+}
+
+func TestDeviceInfo_ParentKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     deviceInfo
+		expected string
+	}{
+		{
+			name: "USB interface",
+			info: deviceInfo{
+				sysfs: "/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.1/0003:4653:0004.0002/input/input24",
+				phys:  "usb-0000:00:14.0-3/input1",
+			},
+			expected: "sysfs:/devices/pci0000:00/0000:00:14.0/usb1/1-3",
+		},
+		{
+			name: "USB hub port",
+			info: deviceInfo{
+				sysfs: "/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2.4/1-2.4:1.0/0003:1209:BB58.0007/input/input31",
+			},
+			expected: "sysfs:/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2.4",
+		},
+		{
+			name: "Bluetooth",
+			info: deviceInfo{
+				sysfs: "/devices/virtual/misc/uhid/0005:046D:B35B.0009/input/input40",
+				phys:  "c8:e2:65:a1:2b:3c",
+			},
+			expected: "sysfs:/devices/virtual/misc/uhid/0005:046D:B35B.0009",
+		},
+		{
+			name: "Phys prefix",
+			info: deviceInfo{
+				sysfs: "/devices/platform/i8042/serio0/input/input3",
+				phys:  "isa0060/serio0/input0",
+			},
+			expected: "phys:isa0060/serio0",
+		},
+		{
+			name:     "Vendor and product",
+			info:     deviceInfo{vendorID: "0000", productID: "0000", name: "ydotoold virtual device"},
+			expected: "id:0000:0000:ydotoold virtual device",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.parentKey(); got != tt.expected {
+				t.Errorf("Expected parent key '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDeviceInfo_Serial(t *testing.T) {
+	root := t.TempDir()
+	usbDevice := filepath.Join(root, "devices/pci0000:00/0000:00:14.0/usb1/1-3")
+	if err := os.MkdirAll(usbDevice, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(usbDevice, "serial"), []byte("ABC123\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previousRoot := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = previousRoot })
+
+	tracker, _ := newRecordingTracker(t)
+	tracker.apply(parseProc(t, procCorne))
+
+	devices := tracker.connectedDevices()
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+
+	corne := devices[0]
+	if corne.Serial != "ABC123" {
+		t.Errorf("Expected serial 'ABC123' from sysfs, got '%s'", corne.Serial)
+	}
+	if corne.Phys != "usb-0000:00:14.0-3" {
+		t.Errorf("Expected port 'usb-0000:00:14.0-3', got '%s'", corne.Phys)
+	}
+	if corne.InstanceID() != "4653:0004#serial=ABC123" {
+		t.Errorf("Expected instance ID '4653:0004#serial=ABC123', got '%s'", corne.InstanceID())
+	}
+}
+
+func TestDeviceTracker_IdenticalKeyboards(t *testing.T) {
+	second := strings.NewReplacer(
+		"usb-0000:00:14.0-3", "usb-0000:00:14.0-4",
+		"/1-3/1-3:", "/1-4/1-4:",
+		"event5", "event10",
+		"event6", "event11",
+	).Replace(procCorne)
+
+	tracker, rec := newRecordingTracker(t)
+	tracker.apply(parseProc(t, procCorne+"\n"+second))
+
+	if len(rec.connected) != 2 {
+		t.Fatalf("Expected both Cornes to connect, got %v", rec.connected)
+	}
+
+	ports := map[string]bool{}
+	for _, device := range tracker.connectedDevices() {
+		ports[device.InstanceID()] = true
+	}
+	if !ports["4653:0004@usb-0000:00:14.0-3"] || !ports["4653:0004@usb-0000:00:14.0-4"] {
+		t.Errorf("Expected one device per port, got %v", ports)
+	}
+}
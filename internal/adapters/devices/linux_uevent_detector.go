@@ -0,0 +1,381 @@
+//go:build linux
+
+package devices
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+	"golang.org/x/sys/unix"
+)
+
+// ueventBufferSize is large enough for any single kernel uevent datagram
+const ueventBufferSize = 64 * 1024
+
+// ueventKernelGroup is the netlink multicast group of kernel uevents
+// (group 2 carries the udev-processed copies)
+const ueventKernelGroup = 1
+
+// UeventDeviceDetector detects USB/HID devices on Linux by listening to
+// kernel uevents on a NETLINK_KOBJECT_UEVENT socket
+type UeventDeviceDetector struct {
+	socket  io.ReadCloser
+	tracker *deviceTracker
+	inputs  map[string]*deviceInfo // input device sysfs path -> device
+	hids    map[string]*uevent     // hid device sysfs path -> hid uevent
+	mu      sync.Mutex
+	// scanMu is held from taking a snapshot of the inputs to applying it,
+	// so that snapshots reach the tracker in the order they were taken
+	scanMu   sync.Mutex
+	stopChan chan struct{}
+	running  bool
+}
+
+// NewUeventDeviceDetector creates a detector bound to the kernel uevent socket,
+// publishing to events
+func NewUeventDeviceDetector(events *domain.EventBus) (*UeventDeviceDetector, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+
+	addr := &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: ueventKernelGroup}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+
+	return newUeventDeviceDetector(os.NewFile(uintptr(fd), "uevent"), events), nil
+}
+
+// newUeventDeviceDetector creates a detector reading uevent datagrams from socket
+func newUeventDeviceDetector(socket io.ReadCloser, events *domain.EventBus) *UeventDeviceDetector {
+	return &UeventDeviceDetector{
+		socket:   socket,
+		tracker:  newDeviceTracker(events),
+		inputs:   make(map[string]*deviceInfo),
+		hids:     make(map[string]*uevent),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// StartMonitoring begins monitoring for device connection/disconnection events
+func (d *UeventDeviceDetector) StartMonitoring(ctx context.Context) error {
+	// The socket is already bound, so no event is lost between the
+	// initial scan and the start of the read loop
+	if err := d.scanDevices(); err != nil {
+		return fmt.Errorf("failed to scan initial devices: %w", err)
+	}
+
+	d.mu.Lock()
+	d.running = true
+	d.mu.Unlock()
+
+	go d.readEvents(ctx)
+
+	return nil
+}
+
+// StopMonitoring stops the monitoring process
+func (d *UeventDeviceDetector) StopMonitoring() error {
+	close(d.stopChan)
+	return d.socket.Close()
+}
+
+// GetConnectedDevices returns all currently connected keyboard devices
+func (d *UeventDeviceDetector) GetConnectedDevices(ctx context.Context) ([]*domain.Device, error) {
+	d.mu.Lock()
+	running := d.running
+	d.mu.Unlock()
+
+	// While monitoring, uevents keep the tracker up to date
+	if !running {
+		if err := d.scanDevices(); err != nil {
+			return nil, fmt.Errorf("failed to scan devices: %w", err)
+		}
+	}
+
+	return d.tracker.connectedDevices(), nil
+}
+
+// scanDevices seeds the known input devices from /proc/bus/input/devices
+func (d *UeventDeviceDetector) scanDevices() error {
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+
+	infos, err := readProcDevices()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.inputs = make(map[string]*deviceInfo)
+	for _, info := range infos {
+		if info.sysfs != "" {
+			d.inputs[info.sysfs] = info
+		}
+	}
+	d.mu.Unlock()
+
+	d.applyInputs()
+	return nil
+}
+
+// readEvents reads uevent datagrams until the socket is closed. Uevents
+// dropped because the socket buffer overflowed are made up for by a rescan;
+// other read errors end the monitoring and are published as DetectorFailed.
+func (d *UeventDeviceDetector) readEvents(ctx context.Context) {
+	buf := make([]byte, ueventBufferSize)
+
+	for {
+		n, err := d.socket.Read(buf)
+		if errors.Is(err, unix.ENOBUFS) {
+			log.Printf("Uevents were lost in a burst, rescanning input devices")
+			if err := d.scanDevices(); err != nil {
+				log.Printf("Failed to rescan input devices: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			select {
+			case <-d.stopChan:
+			case <-ctx.Done():
+			default:
+				d.fail(fmt.Errorf("failed to read uevents: %w", err))
+			}
+			return
+		}
+
+		select {
+		case <-d.stopChan:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		event, ok := parseUevent(buf[:n])
+		if !ok {
+			continue
+		}
+
+		d.handleUevent(event)
+	}
+}
+
+// fail stops relying on uevents, GetConnectedDevices scanning again, and
+// publishes the failure
+func (d *UeventDeviceDetector) fail(err error) {
+	d.mu.Lock()
+	d.running = false
+	d.mu.Unlock()
+
+	d.tracker.events.Publish(domain.DetectorFailed{Err: err})
+}
+
+// uevent is a parsed kernel uevent message
+type uevent struct {
+	action    string
+	devPath   string
+	subsystem string
+	env       map[string]string
+}
+
+// parseUevent parses a kernel uevent datagram of the form
+// "add@/devices/...\0ACTION=add\0DEVPATH=/devices/...\0SUBSYSTEM=input\0..."
+func parseUevent(data []byte) (*uevent, bool) {
+	fields := bytes.Split(data, []byte{0})
+	if len(fields) < 2 {
+		return nil, false
+	}
+
+	// Messages rebroadcast by udev start with "libudev" and use a binary header
+	header := string(fields[0])
+	if !strings.Contains(header, "@") {
+		return nil, false
+	}
+
+	event := &uevent{env: make(map[string]string)}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(string(field), "=")
+		if !found {
+			continue
+		}
+		event.env[key] = value
+	}
+
+	event.action = event.env["ACTION"]
+	event.devPath = event.env["DEVPATH"]
+	event.subsystem = event.env["SUBSYSTEM"]
+
+	if event.action == "" || event.devPath == "" {
+		return nil, false
+	}
+
+	return event, true
+}
+
+// handleUevent updates the known input devices from a single uevent
+func (d *UeventDeviceDetector) handleUevent(event *uevent) {
+	d.scanMu.Lock()
+	defer d.scanMu.Unlock()
+
+	switch event.subsystem {
+	case "input":
+		d.handleInputUevent(event)
+	case "hid":
+		d.handleHIDUevent(event)
+	default:
+		return
+	}
+
+	d.applyInputs()
+}
+
+// handleInputUevent tracks input devices (inputN) and their event nodes (eventN)
+func (d *UeventDeviceDetector) handleInputUevent(event *uevent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// inputN devices carry the identification of the physical device
+	if _, isInput := event.env["PRODUCT"]; isInput {
+		switch event.action {
+		case "add", "change":
+			info := deviceInfoFromUevent(event)
+			if existing, ok := d.inputs[event.devPath]; ok {
+				info.handlers = existing.handlers
+			}
+			d.inheritHIDUniq(info)
+			d.inputs[event.devPath] = info
+			logger.Debug("[Detector] Input device added: %s (%s:%s) at %s\n",
+				info.name, info.vendorID, info.productID, event.devPath)
+		case "remove":
+			delete(d.inputs, event.devPath)
+			logger.Debug("[Detector] Input device removed: %s\n", event.devPath)
+		}
+		return
+	}
+
+	// eventN nodes are children of their inputN device
+	devName := event.env["DEVNAME"]
+	if !strings.HasPrefix(devName, "input/event") {
+		return
+	}
+
+	info, ok := d.inputs[path.Dir(event.devPath)]
+	if !ok {
+		return
+	}
+
+	node := path.Base(devName)
+	switch event.action {
+	case "add":
+		if !info.hasEventNode(node) {
+			info.handlers = append(info.handlers, node)
+		}
+	case "remove":
+		handlers := info.handlers[:0]
+		for _, handler := range info.handlers {
+			if handler != node {
+				handlers = append(handlers, handler)
+			}
+		}
+		info.handlers = handlers
+	}
+}
+
+// handleHIDUevent tracks HID devices, whose removal takes every input device
+// below them along
+func (d *UeventDeviceDetector) handleHIDUevent(event *uevent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch event.action {
+	case "add", "change", "bind":
+		d.hids[event.devPath] = event
+	case "remove":
+		delete(d.hids, event.devPath)
+		for devPath := range d.inputs {
+			if strings.HasPrefix(devPath, event.devPath+"/") {
+				delete(d.inputs, devPath)
+			}
+		}
+	}
+}
+
+// inheritHIDUniq fills in the unique ID of an input device from its parent
+// HID device, which is where Bluetooth keyboards report their MAC address
+func (d *UeventDeviceDetector) inheritHIDUniq(info *deviceInfo) {
+	if info.uniq != "" {
+		return
+	}
+
+	for devPath, hid := range d.hids {
+		if strings.HasPrefix(info.sysfs, devPath+"/") {
+			info.uniq = hid.env["HID_UNIQ"]
+			return
+		}
+	}
+}
+
+// applyInputs hands the current set of input devices to the tracker.
+// d.scanMu must be held.
+func (d *UeventDeviceDetector) applyInputs() {
+	d.mu.Lock()
+	infos := make([]*deviceInfo, 0, len(d.inputs))
+	for _, info := range d.inputs {
+		copied := *info
+		copied.handlers = append([]string(nil), info.handlers...)
+		infos = append(infos, &copied)
+	}
+	d.mu.Unlock()
+
+	d.tracker.apply(infos)
+}
+
+// deviceInfoFromUevent builds device information from an inputN uevent.
+// PRODUCT has the form "bus/vendor/product/version" in unpadded hex.
+func deviceInfoFromUevent(event *uevent) *deviceInfo {
+	info := &deviceInfo{
+		name:  strings.Trim(event.env["NAME"], "\""),
+		phys:  strings.Trim(event.env["PHYS"], "\""),
+		uniq:  strings.Trim(event.env["UNIQ"], "\""),
+		sysfs: event.devPath,
+	}
+
+	product := strings.Split(event.env["PRODUCT"], "/")
+	if len(product) >= 3 {
+		info.vendorID = padHexID(product[1])
+		info.productID = padHexID(product[2])
+	}
+
+	info.evBits = parseBitmap(event.env["EV"])
+	info.keyBits = parseBitmap(event.env["KEY"])
+
+	return info
+}
+
+// padHexID formats an unpadded hex ID the way /proc/bus/input/devices does
+func padHexID(value string) string {
+	id, err := strconv.ParseUint(value, 16, 16)
+	if err != nil {
+		return strings.ToLower(value)
+	}
+	return fmt.Sprintf("%04x", id)
+}
+
+// GetDeviceByID returns a device by its ID
+func (d *UeventDeviceDetector) GetDeviceByID(deviceID string) (*domain.Device, error) {
+	return d.tracker.deviceByID(deviceID)
+}
@@ -0,0 +1,294 @@
+//go:build linux
+
+package devices
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"golang.org/x/sys/unix"
+)
+
+// Recorded kernel uevents for plugging and unplugging a Corne
+var (
+	ueventCorneHIDAdd = []string{
+		"add@/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001",
+		"ACTION=add",
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001",
+		"SUBSYSTEM=hid",
+		"HID_ID=0003:00004653:00000004",
+		"HID_NAME=foostan Corne",
+		"HID_PHYS=usb-0000:00:14.0-3/input0",
+		"HID_UNIQ=",
+		"MODALIAS=hid:b0003g0001v00004653p00000004",
+		"SEQNUM=4242",
+	}
+	ueventCorneInputAdd = []string{
+		"add@/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23",
+		"ACTION=add",
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23",
+		"SUBSYSTEM=input",
+		"PRODUCT=3/4653/4/111",
+		`NAME="foostan Corne"`,
+		`PHYS="usb-0000:00:14.0-3/input0"`,
+		`UNIQ=""`,
+		"PROP=0",
+		"EV=120013",
+		"KEY=1000000000007 ff9f207ac14057ff febeffdfffefffff fffffffffffffffe",
+		"MSC=10",
+		"LED=1f",
+		"MODALIAS=input:b0003v4653p0004e0111-e0,1,4,11,14,k77,ramlsfw",
+		"SEQNUM=4243",
+	}
+	ueventCorneEventAdd = []string{
+		"add@/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23/event5",
+		"ACTION=add",
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23/event5",
+		"SUBSYSTEM=input",
+		"MAJOR=13",
+		"MINOR=69",
+		"DEVNAME=input/event5",
+		"SEQNUM=4244",
+	}
+	ueventCorneEventRemove = []string{
+		"remove@/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23/event5",
+		"ACTION=remove",
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001/input/input23/event5",
+		"SUBSYSTEM=input",
+		"MAJOR=13",
+		"MINOR=69",
+		"DEVNAME=input/event5",
+		"SEQNUM=4250",
+	}
+	ueventCorneHIDRemove = []string{
+		"remove@/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001",
+		"ACTION=remove",
+		"DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-3/1-3:1.0/0003:4653:0004.0001",
+		"SUBSYSTEM=hid",
+		"SEQNUM=4252",
+	}
+)
+
+// encodeUevent builds a kernel uevent datagram from its fields
+func encodeUevent(fields []string) []byte {
+	return []byte(strings.Join(fields, "\x00") + "\x00")
+}
+
+// newSocketpairDetector returns a detector reading from one end of a
+// socketpair, the file descriptor of the other end and channels receiving
+// the connected and disconnected devices
+func newSocketpairDetector(t *testing.T) (*UeventDeviceDetector, int, <-chan *domain.Device, <-chan *domain.Device) {
+	t.Helper()
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("Failed to create socketpair: %v", err)
+	}
+
+	if err := unix.SetNonblock(fds[0], true); err != nil {
+		t.Fatalf("Failed to set non-blocking mode: %v", err)
+	}
+
+	connected := make(chan *domain.Device, 4)
+	disconnected := make(chan *domain.Device, 4)
+	events := domain.NewEventBus()
+	events.Subscribe(func(event domain.Event) {
+		switch e := event.(type) {
+		case domain.DeviceConnected:
+			connected <- e.Device
+		case domain.DeviceDisconnected:
+			disconnected <- e.Device
+		}
+	})
+
+	d := newUeventDeviceDetector(os.NewFile(uintptr(fds[0]), "uevent-test"), events)
+	t.Cleanup(func() {
+		d.StopMonitoring()
+		unix.Close(fds[1])
+	})
+
+	return d, fds[1], connected, disconnected
+}
+
+func sendUevent(t *testing.T, fd int, fields []string) {
+	t.Helper()
+
+	if _, err := unix.Write(fd, encodeUevent(fields)); err != nil {
+		t.Fatalf("Failed to send uevent: %v", err)
+	}
+}
+
+func waitForDevice(t *testing.T, ch <-chan *domain.Device) *domain.Device {
+	t.Helper()
+
+	select {
+	case device := <-ch:
+		return device
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for device event")
+		return nil
+	}
+}
+
+func TestParseUevent(t *testing.T) {
+	event, ok := parseUevent(encodeUevent(ueventCorneInputAdd))
+	if !ok {
+		t.Fatal("Expected uevent to parse")
+	}
+
+	if event.action != "add" || event.subsystem != "input" {
+		t.Errorf("Expected add/input, got %s/%s", event.action, event.subsystem)
+	}
+
+	info := deviceInfoFromUevent(event)
+	if info.vendorID != "4653" || info.productID != "0004" {
+		t.Errorf("Expected 4653:0004, got %s:%s", info.vendorID, info.productID)
+	}
+
+	if info.name != "foostan Corne" {
+		t.Errorf("Expected name 'foostan Corne', got '%s'", info.name)
+	}
+
+	if info.phys != "usb-0000:00:14.0-3/input0" {
+		t.Errorf("Expected phys 'usb-0000:00:14.0-3/input0', got '%s'", info.phys)
+	}
+}
+
+func TestParseUevent_IgnoresUdevMessages(t *testing.T) {
+	if _, ok := parseUevent([]byte("libudev\x00\xfe\xed\xca\xfe")); ok {
+		t.Error("Expected udev monitor messages to be ignored")
+	}
+}
+
+func TestUeventDeviceDetector_HotplugThroughSocketpair(t *testing.T) {
+	d, peer, connected, disconnected := newSocketpairDetector(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.readEvents(ctx)
+
+	sendUevent(t, peer, ueventCorneHIDAdd)
+	sendUevent(t, peer, ueventCorneInputAdd)
+	sendUevent(t, peer, ueventCorneEventAdd)
+
+	device := waitForDevice(t, connected)
+	if device.ID != "4653:0004" || device.Name != "foostan Corne" {
+		t.Errorf("Expected foostan Corne (4653:0004), got %s (%s)", device.Name, device.ID)
+	}
+
+	sendUevent(t, peer, ueventCorneEventRemove)
+	device = waitForDevice(t, disconnected)
+	if device.ID != "4653:0004" {
+		t.Errorf("Expected Corne disconnect, got %s", device.ID)
+	}
+
+	// The trailing HID removal must not report the device twice
+	sendUevent(t, peer, ueventCorneHIDRemove)
+	select {
+	case device := <-disconnected:
+		t.Errorf("Unexpected second disconnect for %s", device.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUeventDeviceDetector_HIDRemovalDropsInputs(t *testing.T) {
+	d, peer, _, disconnected := newSocketpairDetector(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.readEvents(ctx)
+
+	sendUevent(t, peer, ueventCorneHIDAdd)
+	sendUevent(t, peer, ueventCorneInputAdd)
+	sendUevent(t, peer, ueventCorneEventAdd)
+
+	// Input and event removals were missed, only the HID removal arrives
+	sendUevent(t, peer, ueventCorneHIDRemove)
+
+	device := waitForDevice(t, disconnected)
+	if device.ID != "4653:0004" {
+		t.Errorf("Expected Corne disconnect, got %s", device.ID)
+	}
+}
+
+// scriptedSocket returns its reads in order, then blocks until closed
+type scriptedSocket struct {
+	reads  chan scriptedRead
+	closed chan struct{}
+}
+
+type scriptedRead struct {
+	data []byte
+	err  error
+}
+
+func (s *scriptedSocket) Read(p []byte) (int, error) {
+	select {
+	case read := <-s.reads:
+		return copy(p, read.data), read.err
+	case <-s.closed:
+		return 0, os.ErrClosed
+	}
+}
+
+func (s *scriptedSocket) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestUeventDeviceDetector_ReadErrors(t *testing.T) {
+	socket := &scriptedSocket{reads: make(chan scriptedRead, 8), closed: make(chan struct{})}
+	for _, fields := range [][]string{ueventCorneHIDAdd, ueventCorneInputAdd} {
+		socket.reads <- scriptedRead{data: encodeUevent(fields)}
+	}
+	// The overflow triggers a rescan, and uevents keep being read
+	socket.reads <- scriptedRead{err: &os.PathError{Op: "read", Path: "uevent", Err: unix.ENOBUFS}}
+	for _, fields := range [][]string{ueventCorneHIDAdd, ueventCorneInputAdd, ueventCorneEventAdd} {
+		socket.reads <- scriptedRead{data: encodeUevent(fields)}
+	}
+	socket.reads <- scriptedRead{err: &os.PathError{Op: "read", Path: "uevent", Err: unix.EIO}}
+
+	connected := make(chan *domain.Device, 1)
+	failed := make(chan error, 1)
+	events := domain.NewEventBus()
+	events.Subscribe(func(event domain.Event) {
+		switch e := event.(type) {
+		case domain.DeviceConnected:
+			// The rescan also finds the keyboards of the machine running the test
+			if e.Device.ID == "4653:0004" {
+				connected <- e.Device
+			}
+		case domain.DetectorFailed:
+			failed <- e.Err
+		}
+	})
+
+	d := newUeventDeviceDetector(socket, events)
+	d.running = true
+	t.Cleanup(func() { d.StopMonitoring() })
+	go d.readEvents(context.Background())
+
+	if device := waitForDevice(t, connected); device.ID != "4653:0004" {
+		t.Errorf("Expected the Corne to connect after the overflow, got %s", device.ID)
+	}
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, unix.EIO) {
+			t.Errorf("Expected the read error to be reported, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the detector failure")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		t.Error("Expected the detector to stop relying on uevents")
+	}
+}
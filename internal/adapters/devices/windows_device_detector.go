@@ -18,17 +18,17 @@ import (
 
 // WindowsDeviceDetector detects USB/HID devices on Windows
 type WindowsDeviceDetector struct {
-	onConnectedCallback    func(*domain.Device)
-	onDisconnectedCallback func(*domain.Device)
-	devices                map[string]*domain.Device
-	mu                     sync.RWMutex
-	stopChan               chan struct{}
-	polling                bool
+	events   *domain.EventBus
+	devices  map[string]*domain.Device
+	mu       sync.RWMutex
+	stopChan chan struct{}
+	polling  bool
 }
 
-// NewWindowsDeviceDetector creates a new Windows device detector
-func NewWindowsDeviceDetector() (*WindowsDeviceDetector, error) {
+// NewWindowsDeviceDetector creates a new Windows device detector publishing to events
+func NewWindowsDeviceDetector(events *domain.EventBus) (*WindowsDeviceDetector, error) {
 	return &WindowsDeviceDetector{
+		events:   events,
 		devices:  make(map[string]*domain.Device),
 		stopChan: make(chan struct{}),
 	}, nil
@@ -74,16 +74,6 @@ func (d *WindowsDeviceDetector) GetConnectedDevices(ctx context.Context) ([]*dom
 	return devices, nil
 }
 
-// OnDeviceConnected registers a callback for device connection events
-func (d *WindowsDeviceDetector) OnDeviceConnected(callback func(*domain.Device)) {
-	d.onConnectedCallback = callback
-}
-
-// OnDeviceDisconnected registers a callback for device disconnection events
-func (d *WindowsDeviceDetector) OnDeviceDisconnected(callback func(*domain.Device)) {
-	d.onDisconnectedCallback = callback
-}
-
 // pollDevices polls for device changes
 func (d *WindowsDeviceDetector) pollDevices(ctx context.Context) {
 	ticker := time.NewTicker(2 * time.Second)
@@ -127,17 +117,7 @@ func (d *WindowsDeviceDetector) pollDevices(ctx context.Context) {
 			// Check for new devices (connected)
 			for id, device := range currentDevices {
 				if _, existed := previousDevices[id]; !existed {
-					if d.onConnectedCallback != nil {
-						// Run callback in goroutine to avoid blocking polling
-						go func(dev *domain.Device) {
-							defer func() {
-								if r := recover(); r != nil {
-									log.Printf("[Detector] Panic in connected callback: %v", r)
-								}
-							}()
-							d.onConnectedCallback(dev)
-						}(device)
-					}
+					d.events.Publish(domain.DeviceConnected{Device: device})
 				}
 			}
 
@@ -146,17 +126,7 @@ func (d *WindowsDeviceDetector) pollDevices(ctx context.Context) {
 				if _, exists := currentDevices[id]; !exists {
 					// Device was disconnected - use the stored device info
 					device := previousDevices[id]
-					if d.onDisconnectedCallback != nil {
-						// Run callback in goroutine to avoid blocking polling
-						go func(dev *domain.Device) {
-							defer func() {
-								if r := recover(); r != nil {
-									log.Printf("[Detector] Panic in disconnected callback: %v", r)
-								}
-							}()
-							d.onDisconnectedCallback(dev)
-						}(device)
-					}
+					d.events.Publish(domain.DeviceDisconnected{Device: device})
 				}
 			}
 
@@ -0,0 +1,430 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+	"github.com/godbus/dbus/v5"
+)
+
+// Backend is a way of switching layouts that may or may not work in the
+// running session
+type Backend struct {
+	// Name identifies the backend in the config and in diagnostics
+	Name string
+	// Probe tells whether the backend should work in env, and why
+	Probe func(env *Environment) (bool, string)
+	// New creates the layout switcher of the backend
+	New func(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error)
+}
+
+// Environment is the session backends are probed in
+type Environment struct {
+	Getenv   func(key string) string
+	LookPath func(file string) (string, error)
+	// SessionBus connects to the D-Bus session bus
+	SessionBus func() (*dbus.Conn, error)
+}
+
+// SystemEnvironment returns the environment of the running process. The
+// backends share a single session bus connection, opened when first needed.
+func SystemEnvironment() *Environment {
+	var once sync.Once
+	var conn *dbus.Conn
+	var connErr error
+
+	return &Environment{
+		Getenv:   os.Getenv,
+		LookPath: exec.LookPath,
+		SessionBus: func() (*dbus.Conn, error) {
+			once.Do(func() {
+				conn, connErr = dbus.ConnectSessionBus()
+			})
+			return conn, connErr
+		},
+	}
+}
+
+// desktop returns true if $XDG_CURRENT_DESKTOP, a colon-separated list such
+// as "ubuntu:GNOME", names desktop
+func (e *Environment) desktop(desktop string) bool {
+	for _, name := range strings.Split(e.Getenv("XDG_CURRENT_DESKTOP"), ":") {
+		if strings.EqualFold(name, desktop) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBusName returns true if a name is owned on the session bus, or can be
+// activated on it
+func (e *Environment) hasBusName(name string) (bool, error) {
+	conn, err := e.SessionBus()
+	if err != nil {
+		return false, err
+	}
+
+	var owned bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&owned); err != nil {
+		return false, err
+	}
+	if owned {
+		return true, nil
+	}
+
+	var activatable []string
+	if err := conn.BusObject().Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&activatable); err != nil {
+		return false, err
+	}
+	for _, candidate := range activatable {
+		if candidate == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// BackendInfo describes the backend in use and the other candidates
+type BackendInfo struct {
+	// Active is the name of the backend in use, empty if none could be
+	// created
+	Active string
+	// Reason explains why the active backend was chosen
+	Reason string
+	// Candidates lists every backend with its probe result, best first
+	Candidates []BackendCandidate
+}
+
+// BackendCandidate is the probe result of a backend
+type BackendCandidate struct {
+	Name      string
+	Available bool
+	Reason    string
+}
+
+// BackendChain switches layouts with the best backend available in the
+// session, or the one named in the config, falling back to the next backend
+// when one fails. Backends are probed when the chain is first used, so that
+// commands never switching layouts do not reach the X server or D-Bus.
+type BackendChain struct {
+	env        *Environment
+	events     *domain.EventBus
+	backends   []Backend
+	probed     bool
+	candidates []BackendCandidate
+	switchers  map[string]domain.LayoutSwitcher
+	preferred  string
+	active     string
+	reason     string
+	watchCtx   context.Context
+	stopWatch  context.CancelFunc
+	mu         sync.Mutex
+}
+
+// NewBackendChain creates a chain of backends, given best first. The first
+// available one that can be created is selected when the chain is first
+// used.
+func NewBackendChain(env *Environment, events *domain.EventBus, backends ...Backend) *BackendChain {
+	return &BackendChain{
+		env:       env,
+		events:    events,
+		backends:  backends,
+		switchers: make(map[string]domain.LayoutSwitcher),
+	}
+}
+
+// probe probes the backends and selects one, the first time it is called
+func (c *BackendChain) probe() {
+	if c.probed {
+		return
+	}
+	c.probed = true
+
+	c.candidates = make([]BackendCandidate, 0, len(c.backends))
+	for _, backend := range c.backends {
+		available, reason := backend.Probe(c.env)
+		logger.Debug("[Backend] %s: available=%v (%s)\n", backend.Name, available, reason)
+		c.candidates = append(c.candidates, BackendCandidate{
+			Name:      backend.Name,
+			Available: available,
+			Reason:    reason,
+		})
+	}
+
+	c.selectBackend()
+}
+
+// Prefer uses the named backend, or picks one automatically when name is
+// empty. Unknown names are rejected and the backend is picked automatically.
+func (c *BackendChain) Prefer(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	if name != "" && c.backend(name) == nil {
+		err = errors.WithDetails(
+			errors.New(errors.ErrCodeConfigParseFailed, fmt.Sprintf("unknown layout backend %q", name)),
+			map[string]any{"backends": strings.Join(c.names(), ", ")},
+		)
+		name = ""
+	}
+
+	if name != c.preferred {
+		c.preferred = name
+		if c.probed {
+			c.selectBackend()
+			c.watchActive()
+		}
+	}
+
+	return err
+}
+
+// Info describes the backend in use and why
+func (c *BackendChain) Info() BackendInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probe()
+
+	return BackendInfo{
+		Active:     c.active,
+		Reason:     c.reason,
+		Candidates: append([]BackendCandidate(nil), c.candidates...),
+	}
+}
+
+// SwitchLayout changes the layout with the active backend, or the next one
+// that works
+func (c *BackendChain) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probe()
+
+	return c.run(false, func(switcher domain.LayoutSwitcher) error {
+		return switcher.SwitchLayout(ctx, layout)
+	})
+}
+
+// SwitchDeviceLayout changes the layout of a single keyboard with the active
+// backend, or the next one supporting per-device layouts that works
+func (c *BackendChain) SwitchDeviceLayout(ctx context.Context, layout *domain.KeyboardLayout, device *domain.Device) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probe()
+
+	return c.run(true, func(switcher domain.LayoutSwitcher) error {
+		return switcher.(domain.DeviceLayoutSwitcher).SwitchDeviceLayout(ctx, layout, device)
+	})
+}
+
+// SupportsDeviceLayouts returns true if the active backend can give each
+// keyboard its own layout
+func (c *BackendChain) SupportsDeviceLayouts() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probe()
+
+	_, ok := c.switchers[c.active].(domain.DeviceLayoutSwitcher)
+	return ok
+}
+
+// WatchLayouts watches layout changes with the active backend, and with the
+// backends it falls back to, until ctx is done
+func (c *BackendChain) WatchLayouts(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probe()
+
+	c.watchCtx = ctx
+	return c.watchActive()
+}
+
+// selectBackend makes the first backend of the chain that can be created the
+// active one
+func (c *BackendChain) selectBackend() {
+	c.active, c.reason = "", "no layout backend could be created"
+
+	failures := make([]string, 0)
+	for _, name := range c.chain() {
+		if _, err := c.switcher(name); err != nil {
+			failures = append(failures, fmt.Sprintf("%s failed: %v", name, err))
+			continue
+		}
+
+		c.active, c.reason = name, c.selectionReason(name)
+		if len(failures) > 0 {
+			c.reason += " (" + strings.Join(failures, "; ") + ")"
+		}
+		break
+	}
+
+	logger.Debug("[Backend] Using %s: %s\n", c.active, c.reason)
+}
+
+// selectionReason explains why a backend comes first in the chain
+func (c *BackendChain) selectionReason(name string) string {
+	if name == c.preferred {
+		return fmt.Sprintf("configured with backend = %q", name)
+	}
+
+	for _, candidate := range c.candidates {
+		if candidate.Name == name && candidate.Available {
+			return candidate.Reason
+		}
+	}
+
+	return "no other backend is available"
+}
+
+// chain returns the backends to try in order: the preferred one, then the
+// available ones, best first. The last backend is tried when none is
+// available.
+func (c *BackendChain) chain() []string {
+	names := make([]string, 0, len(c.candidates))
+	if c.preferred != "" {
+		names = append(names, c.preferred)
+	}
+
+	for _, candidate := range c.candidates {
+		if candidate.Available && candidate.Name != c.preferred {
+			names = append(names, candidate.Name)
+		}
+	}
+
+	if len(names) == 0 && len(c.candidates) > 0 {
+		names = append(names, c.candidates[len(c.candidates)-1].Name)
+	}
+
+	return names
+}
+
+// run calls switch with the active backend, then with the rest of the chain
+// until one succeeds, which becomes the active backend. When every backend
+// fails the active one is kept, as the layout itself is likely at fault.
+func (c *BackendChain) run(perDevice bool, switchLayout func(domain.LayoutSwitcher) error) error {
+	order := []string{}
+	if c.active != "" {
+		order = append(order, c.active)
+	}
+	for _, name := range c.chain() {
+		if name != c.active {
+			order = append(order, name)
+		}
+	}
+
+	var firstErr error
+	for _, name := range order {
+		switcher, err := c.switcher(name)
+		if err == nil {
+			if _, ok := switcher.(domain.DeviceLayoutSwitcher); perDevice && !ok {
+				continue
+			}
+			if err = switchLayout(switcher); err == nil {
+				if name != c.active {
+					c.fallBack(name, firstErr)
+				}
+				return nil
+			}
+		}
+
+		logger.Debug("[Backend] %s failed: %v\n", name, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr == nil {
+		return errors.New(errors.ErrCodeLayoutSelectFailed, "no layout backend supports per-device layouts")
+	}
+	return firstErr
+}
+
+// fallBack makes name the active backend after the previous one failed
+func (c *BackendChain) fallBack(name string, cause error) {
+	previous := c.active
+	if previous == "" {
+		previous = "no backend"
+	}
+
+	log.Printf("Layout backend %s failed (%v), falling back to %s", previous, cause, name)
+
+	c.active = name
+	c.reason = fmt.Sprintf("fell back from %s, which failed: %v", previous, cause)
+	c.watchActive()
+}
+
+// watchActive watches layout changes with the active backend when asked to,
+// stopping the previous watch
+func (c *BackendChain) watchActive() error {
+	if c.stopWatch != nil {
+		c.stopWatch()
+		c.stopWatch = nil
+	}
+	if c.watchCtx == nil {
+		return nil
+	}
+
+	watcher, ok := c.switchers[c.active].(domain.LayoutWatcher)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(c.watchCtx)
+	if err := watcher.WatchLayouts(ctx); err != nil {
+		cancel()
+		return err
+	}
+	c.stopWatch = cancel
+	return nil
+}
+
+// switcher returns the layout switcher of a backend, creating it on first use
+func (c *BackendChain) switcher(name string) (domain.LayoutSwitcher, error) {
+	if switcher, ok := c.switchers[name]; ok {
+		return switcher, nil
+	}
+
+	backend := c.backend(name)
+	if backend == nil {
+		return nil, fmt.Errorf("unknown layout backend %q", name)
+	}
+
+	switcher, err := backend.New(c.env, c.events)
+	if err != nil {
+		return nil, err
+	}
+
+	c.switchers[name] = switcher
+	return switcher, nil
+}
+
+func (c *BackendChain) backend(name string) *Backend {
+	for i := range c.backends {
+		if c.backends[i].Name == name {
+			return &c.backends[i]
+		}
+	}
+	return nil
+}
+
+func (c *BackendChain) names() []string {
+	names := make([]string, 0, len(c.backends))
+	for _, backend := range c.backends {
+		names = append(names, backend.Name)
+	}
+	return names
+}
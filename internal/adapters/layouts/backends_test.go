@@ -0,0 +1,318 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeBackend is a layout switcher that records layouts, or fails
+type fakeBackend struct {
+	fail     error
+	switched []string
+	watching context.Context
+}
+
+func (b *fakeBackend) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if b.fail != nil {
+		return b.fail
+	}
+	b.switched = append(b.switched, layout.Name)
+	return nil
+}
+
+func (b *fakeBackend) WatchLayouts(ctx context.Context) error {
+	b.watching = ctx
+	return nil
+}
+
+// fakeDeviceBackend also switches the layout of single keyboards
+type fakeDeviceBackend struct {
+	fakeBackend
+}
+
+func (b *fakeDeviceBackend) SwitchDeviceLayout(ctx context.Context, layout *domain.KeyboardLayout, device *domain.Device) error {
+	if b.fail != nil {
+		return b.fail
+	}
+	b.switched = append(b.switched, device.Name+":"+layout.Name)
+	return nil
+}
+
+// testBackend returns a backend probing as available or not, creating
+// switcher, or failing to be created when switcher is nil
+func testBackend(name string, available bool, switcher domain.LayoutSwitcher) Backend {
+	return Backend{
+		Name: name,
+		Probe: func(*Environment) (bool, string) {
+			return available, fmt.Sprintf("%s probed %v", name, available)
+		},
+		New: func(*Environment, *domain.EventBus) (domain.LayoutSwitcher, error) {
+			if switcher == nil {
+				return nil, fmt.Errorf("cannot create %s", name)
+			}
+			return switcher, nil
+		},
+	}
+}
+
+func testEnvironment(vars map[string]string) *Environment {
+	return &Environment{
+		Getenv: func(key string) string { return vars[key] },
+		LookPath: func(file string) (string, error) {
+			if path, ok := vars["PATH:"+file]; ok {
+				return path, nil
+			}
+			return "", fmt.Errorf("%s not found", file)
+		},
+		SessionBus: func() (*dbus.Conn, error) {
+			return nil, fmt.Errorf("no session bus")
+		},
+	}
+}
+
+var testLayout = domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+
+func TestBackendChain_SelectsFirstAvailable(t *testing.T) {
+	sway, setxkbmap := &fakeBackend{}, &fakeBackend{}
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("hyprland", false, &fakeBackend{}),
+		testBackend("sway", true, sway),
+		testBackend("setxkbmap", true, setxkbmap),
+	)
+
+	info := chain.Info()
+	if info.Active != "sway" || info.Reason != "sway probed true" {
+		t.Errorf("Expected sway to be selected for its probe, got %+v", info)
+	}
+	if len(info.Candidates) != 3 || info.Candidates[0].Available {
+		t.Errorf("Expected the 3 probe results, got %+v", info.Candidates)
+	}
+
+	if err := chain.SwitchLayout(context.Background(), testLayout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+	if len(sway.switched) != 1 || len(setxkbmap.switched) != 0 {
+		t.Errorf("Expected sway to switch the layout, got sway %v, setxkbmap %v", sway.switched, setxkbmap.switched)
+	}
+}
+
+func TestBackendChain_SkipsBackendsFailingToStart(t *testing.T) {
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("kde", true, nil),
+		testBackend("setxkbmap", true, &fakeBackend{}),
+	)
+
+	info := chain.Info()
+	if info.Active != "setxkbmap" {
+		t.Errorf("Expected setxkbmap, got %s", info.Active)
+	}
+	if !strings.Contains(info.Reason, "kde failed: cannot create kde") {
+		t.Errorf("Expected the kde failure in the reason, got %q", info.Reason)
+	}
+}
+
+func TestBackendChain_LastResort(t *testing.T) {
+	setxkbmap := &fakeBackend{}
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("sway", false, &fakeBackend{}),
+		testBackend("setxkbmap", false, setxkbmap),
+	)
+
+	if info := chain.Info(); info.Active != "setxkbmap" || info.Reason != "no other backend is available" {
+		t.Errorf("Expected setxkbmap as a last resort, got %+v", info)
+	}
+}
+
+func TestBackendChain_FallsBack(t *testing.T) {
+	sway, setxkbmap := &fakeBackend{fail: fmt.Errorf("sway is gone")}, &fakeBackend{}
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("sway", true, sway),
+		testBackend("setxkbmap", true, setxkbmap),
+	)
+
+	if err := chain.SwitchLayout(context.Background(), testLayout); err != nil {
+		t.Fatalf("Expected the fallback to switch the layout: %v", err)
+	}
+
+	info := chain.Info()
+	if info.Active != "setxkbmap" || !strings.Contains(info.Reason, "fell back from sway") {
+		t.Errorf("Expected setxkbmap after the fallback, got %+v", info)
+	}
+	if len(setxkbmap.switched) != 1 {
+		t.Errorf("Expected setxkbmap to switch the layout, got %v", setxkbmap.switched)
+	}
+}
+
+func TestBackendChain_KeepsBackendWhenAllFail(t *testing.T) {
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("sway", true, &fakeBackend{fail: fmt.Errorf("unknown layout")}),
+		testBackend("setxkbmap", true, &fakeBackend{fail: fmt.Errorf("unknown layout too")}),
+	)
+
+	err := chain.SwitchLayout(context.Background(), testLayout)
+	if err == nil || err.Error() != "unknown layout" {
+		t.Errorf("Expected the active backend's error, got %v", err)
+	}
+	if info := chain.Info(); info.Active != "sway" {
+		t.Errorf("Expected sway to stay active, got %s", info.Active)
+	}
+}
+
+func TestBackendChain_Prefer(t *testing.T) {
+	sway, setxkbmap := &fakeBackend{}, &fakeBackend{}
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("sway", true, sway),
+		testBackend("setxkbmap", false, setxkbmap),
+	)
+
+	if err := chain.Prefer("setxkbmap"); err != nil {
+		t.Fatalf("Failed to prefer setxkbmap: %v", err)
+	}
+	if info := chain.Info(); info.Active != "setxkbmap" || info.Reason != `configured with backend = "setxkbmap"` {
+		t.Errorf("Expected the configured backend, got %+v", info)
+	}
+
+	if err := chain.Prefer("wayfire"); err == nil {
+		t.Error("Expected an unknown backend to be rejected")
+	}
+	if info := chain.Info(); info.Active != "sway" {
+		t.Errorf("Expected automatic selection after an unknown backend, got %s", info.Active)
+	}
+}
+
+func TestBackendChain_ProbesOnFirstUse(t *testing.T) {
+	probes := 0
+	sway := testBackend("sway", true, &fakeBackend{})
+	probe := sway.Probe
+	sway.Probe = func(env *Environment) (bool, string) {
+		probes++
+		return probe(env)
+	}
+
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(), sway)
+	if err := chain.Prefer("sway"); err != nil {
+		t.Fatalf("Failed to prefer sway: %v", err)
+	}
+	if probes != 0 {
+		t.Fatalf("Expected no probe before the chain is used, got %d", probes)
+	}
+
+	if err := chain.SwitchLayout(context.Background(), testLayout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+	if info := chain.Info(); probes != 1 || info.Reason != `configured with backend = "sway"` {
+		t.Errorf("Expected a single probe keeping the preference, got %d probes and %+v", probes, info)
+	}
+}
+
+func TestBackendChain_PerDevice(t *testing.T) {
+	setxkbmap := &fakeBackend{}
+	sway := &fakeDeviceBackend{}
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("setxkbmap", true, setxkbmap),
+		testBackend("sway", true, sway),
+	)
+
+	if chain.SupportsDeviceLayouts() {
+		t.Error("Expected setxkbmap not to support per-device layouts")
+	}
+
+	corne := domain.NewDevice("4653", "0004", "Corne")
+	if err := chain.SwitchDeviceLayout(context.Background(), testLayout, corne); err != nil {
+		t.Fatalf("Failed to switch the device layout: %v", err)
+	}
+	if len(sway.switched) != 1 || !chain.SupportsDeviceLayouts() {
+		t.Errorf("Expected sway to take over per-device layouts, got %v", sway.switched)
+	}
+}
+
+func TestBackendChain_WatchFollowsFallback(t *testing.T) {
+	hyprland := &fakeBackend{fail: fmt.Errorf("socket closed")}
+	sway := &fakeBackend{}
+	chain := NewBackendChain(testEnvironment(nil), domain.NewEventBus(),
+		testBackend("hyprland", true, hyprland),
+		testBackend("sway", true, sway),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := chain.WatchLayouts(ctx); err != nil {
+		t.Fatalf("Failed to watch layouts: %v", err)
+	}
+	if hyprland.watching == nil {
+		t.Fatal("Expected the active backend to be watched")
+	}
+
+	chain.SwitchLayout(context.Background(), testLayout)
+
+	if hyprland.watching.Err() == nil {
+		t.Error("Expected the previous backend's watch to be stopped")
+	}
+	if sway.watching == nil || sway.watching.Err() != nil {
+		t.Error("Expected the new backend to be watched")
+	}
+}
+
+func TestLinuxBackendProbes(t *testing.T) {
+	swaySocket := filepath.Join(t.TempDir(), "sway-ipc.sock")
+	if err := os.WriteFile(swaySocket, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create socket file: %v", err)
+	}
+	x := newFakeX(t, xkbNames{})
+
+	tests := []struct {
+		name      string
+		probe     func(*Environment) (bool, string)
+		vars      map[string]string
+		available bool
+	}{
+		{"sway", probeSway, map[string]string{"SWAYSOCK": swaySocket}, true},
+		{"sway without socket", probeSway, map[string]string{"SWAYSOCK": "/nonexistent"}, false},
+		{"hyprland", probeHyprland, map[string]string{"HYPRLAND_INSTANCE_SIGNATURE": "abc"}, true},
+		{"kde outside Plasma", probeKDE, map[string]string{"XDG_CURRENT_DESKTOP": "GNOME"}, false},
+		{"kde without bus", probeKDE, map[string]string{"XDG_CURRENT_DESKTOP": "KDE"}, false},
+		{"gnome without bus", probeGNOME, map[string]string{"XDG_CURRENT_DESKTOP": "ubuntu:GNOME"}, false},
+		{"x11", probeX11, map[string]string{"DISPLAY": x.display}, true},
+		{"x11 without server", probeX11, map[string]string{"DISPLAY": filepath.Join(t.TempDir(), "X") + ":0"}, false},
+		{"x11 on Wayland", probeX11, map[string]string{"DISPLAY": x.display, "WAYLAND_DISPLAY": "wayland-0"}, false},
+		{"setxkbmap on X11", probeSetxkbmap, map[string]string{"PATH:setxkbmap": "/usr/bin/setxkbmap", "DISPLAY": ":0"}, true},
+		{"setxkbmap on Wayland", probeSetxkbmap, map[string]string{"PATH:setxkbmap": "/usr/bin/setxkbmap", "DISPLAY": ":0", "WAYLAND_DISPLAY": "wayland-0"}, false},
+		{"setxkbmap missing", probeSetxkbmap, map[string]string{"DISPLAY": ":0"}, false},
+	}
+
+	for _, tt := range tests {
+		available, reason := tt.probe(testEnvironment(tt.vars))
+		if available != tt.available {
+			t.Errorf("%s: expected available=%v, got %v (%s)", tt.name, tt.available, available, reason)
+		}
+		if reason == "" {
+			t.Errorf("%s: expected a reason", tt.name)
+		}
+	}
+}
+
+func TestProbeGNOME_BusNames(t *testing.T) {
+	address := startPrivateBus(t)
+	newFakeGSettings(t, address)
+
+	env := testEnvironment(map[string]string{"XDG_CURRENT_DESKTOP": "GNOME"})
+	conn := connectBus(t, address)
+	env.SessionBus = func() (*dbus.Conn, error) { return conn, nil }
+
+	if available, reason := probeGNOME(env); !available {
+		t.Errorf("Expected GNOME to be available with dconf and the portal on the bus: %s", reason)
+	}
+
+	env.Getenv = func(key string) string { return map[string]string{"XDG_CURRENT_DESKTOP": "KDE"}[key] }
+	if available, reason := probeKDE(env); available || !strings.Contains(reason, kdeBusName) {
+		t.Errorf("Expected KDE to be unavailable without %s, got %v (%s)", kdeBusName, available, reason)
+	}
+}
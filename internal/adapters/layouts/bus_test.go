@@ -0,0 +1,81 @@
+package layouts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const privateBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startPrivateBus runs a dbus-daemon for the test and returns its address
+func startPrivateBus(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	dir, err := os.MkdirTemp("", "bus")
+	if err != nil {
+		t.Fatalf("Failed to create bus dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(privateBusConfig, filepath.Join(dir, "bus"))), 0o600); err != nil {
+		t.Fatalf("Failed to write bus config: %v", err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to start dbus-daemon: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read the bus address: %v", err)
+	}
+
+	return strings.TrimSpace(address)
+}
+
+// connectBus opens a connection to the private bus
+func connectBus(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("Failed to connect to the bus: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
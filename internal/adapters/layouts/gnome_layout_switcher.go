@@ -0,0 +1,206 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+	"github.com/godbus/dbus/v5"
+)
+
+// GSettings are read through the settings portal, which exposes the
+// org.gnome.desktop schemas, and written through the dconf writer
+const (
+	portalBusName      = "org.freedesktop.portal.Desktop"
+	portalObjectPath   = "/org/freedesktop/portal/desktop"
+	portalSettingsRead = "org.freedesktop.portal.Settings.Read"
+
+	dconfBusName      = "ca.desrt.dconf"
+	dconfWriterPath   = "/ca/desrt/dconf/Writer/user"
+	dconfWriterChange = "ca.desrt.dconf.Writer.Change"
+)
+
+// gnomeInputSourcesSchema is the GSettings schema of the input sources,
+// stored by dconf under gnomeInputSourcesPath
+const (
+	gnomeInputSourcesSchema = "org.gnome.desktop.input-sources"
+	gnomeInputSourcesPath   = "/org/gnome/desktop/input-sources/"
+)
+
+// gnomeTimeout bounds the D-Bus calls of a layout switch
+const gnomeTimeout = 5 * time.Second
+
+// GNOMELayoutSwitcher switches keyboard layouts on GNOME by selecting one of
+// its input sources. Layouts set with setxkbmap would be overwritten by
+// gnome-settings-daemon.
+type GNOMELayoutSwitcher struct {
+	conn    *dbus.Conn
+	options xkbOptionBaseline
+}
+
+// gnomeInputSource is an entry of the sources and mru-sources keys, e.g.
+// ('xkb', 'us+intl')
+type gnomeInputSource struct {
+	Type string
+	ID   string
+}
+
+// gnomeSetting is a key of the input sources schema and its new value
+type gnomeSetting struct {
+	key   string
+	value gvariant
+}
+
+// NewGNOMELayoutSwitcher creates a layout switcher using the session bus
+// connection conn
+func NewGNOMELayoutSwitcher(conn *dbus.Conn) *GNOMELayoutSwitcher {
+	return &GNOMELayoutSwitcher{conn: conn}
+}
+
+// SwitchLayout makes the input source of a layout the active one, adding it
+// to the input sources if needed
+func (s *GNOMELayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gnomeTimeout)
+	defer cancel()
+
+	groups := xkbGroups(layout)
+	target := gnomeInputSource{Type: "xkb", ID: gnomeSourceID(groups[0].Layout, groups[0].Variant)}
+
+	var sources, mru []gnomeInputSource
+	if err := s.read(ctx, "sources", &sources); err != nil {
+		return errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read GNOME input sources", err)
+	}
+	if err := s.read(ctx, "mru-sources", &mru); err != nil {
+		return errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read GNOME input sources", err)
+	}
+
+	// The other groups of the layout become input sources too
+	for _, group := range groups {
+		source := gnomeInputSource{Type: "xkb", ID: gnomeSourceID(group.Layout, group.Variant)}
+		if !slices.Contains(sources, source) {
+			logger.Debug("[GNOME] Adding input source %s\n", source.ID)
+			sources = append(sources, source)
+		}
+	}
+	current := slices.Index(sources, target)
+
+	// The most recently used source comes first
+	mru = slices.DeleteFunc(mru, func(source gnomeInputSource) bool { return source == target })
+	mru = slices.Insert(mru, 0, target)
+
+	logger.Debug("[GNOME] Selecting input source %s (%d)\n", target.ID, current)
+
+	// Sources is written even when unchanged so that GNOME reloads them
+	settings := []gnomeSetting{
+		{key: "sources", value: gvInputSources(sources)},
+		{key: "mru-sources", value: gvInputSources(mru)},
+		{key: "current", value: gvUint32(uint32(current))},
+	}
+
+	// The options in use before the first switch get the layout's changes
+	var inUse []string
+	if err := s.read(ctx, "xkb-options", &inUse); err != nil {
+		return errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read GNOME XKB options", err)
+	}
+	if options := s.options.apply(inUse, layout.Options); !slices.Equal(options, inUse) {
+		settings = append(settings, gnomeSetting{key: "xkb-options", value: gvStrings(options)})
+	}
+	if layout.Model != "" {
+		logger.Debug("[GNOME] Ignoring model %s, which GNOME does not set\n", layout.Model)
+	}
+
+	if err := s.write(ctx, settings); err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+			map[string]any{
+				"layout": layout.Name,
+				"source": target.ID,
+			},
+		)
+	}
+
+	return nil
+}
+
+// read reads a key of the input sources schema into value
+func (s *GNOMELayoutSwitcher) read(ctx context.Context, key string, value any) error {
+	var variant dbus.Variant
+	err := s.conn.Object(portalBusName, portalObjectPath).
+		CallWithContext(ctx, portalSettingsRead, 0, gnomeInputSourcesSchema, key).
+		Store(&variant)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	// Read wraps the value in a second variant
+	for {
+		inner, ok := variant.Value().(dbus.Variant)
+		if !ok {
+			break
+		}
+		variant = inner
+	}
+
+	if err := dbus.Store([]any{variant.Value()}, value); err != nil {
+		return fmt.Errorf("invalid %s value %s: %w", key, variant, err)
+	}
+
+	return nil
+}
+
+// write applies changes to the input sources schema atomically, as a single
+// dconf change set
+func (s *GNOMELayoutSwitcher) write(ctx context.Context, settings []gnomeSetting) error {
+	entries := make([]gvariant, 0, len(settings))
+	for _, setting := range settings {
+		entries = append(entries, gvTuple(true,
+			gvString(gnomeInputSourcesPath+setting.key),
+			gvJust(gvVariant(setting.value)),
+		))
+	}
+	changeset := gvArray("{smv}", 8, false, entries...)
+
+	var tag string
+	err := s.conn.Object(dconfBusName, dconfWriterPath).
+		CallWithContext(ctx, dconfWriterChange, 0, changeset.data).
+		Store(&tag)
+	if err != nil {
+		return fmt.Errorf("dconf change failed: %w", err)
+	}
+
+	return nil
+}
+
+// gvInputSources serializes a list of input sources ("a(ss)")
+func gvInputSources(sources []gnomeInputSource) gvariant {
+	elements := make([]gvariant, 0, len(sources))
+	for _, source := range sources {
+		elements = append(elements, gvTuple(false, gvString(source.Type), gvString(source.ID)))
+	}
+	return gvArray("(ss)", 1, false, elements...)
+}
+
+// gvStrings serializes a list of strings ("as")
+func gvStrings(values []string) gvariant {
+	elements := make([]gvariant, 0, len(values))
+	for _, value := range values {
+		elements = append(elements, gvString(value))
+	}
+	return gvArray("s", 1, false, elements...)
+}
+
+// gnomeSourceID returns the ID GNOME gives to an XKB layout, e.g. "us+intl"
+func gnomeSourceID(name, variant string) string {
+	if variant == "" {
+		return name
+	}
+	return name + "+" + variant
+}
@@ -0,0 +1,255 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeGSettings stands in for the settings portal and the dconf writer,
+// keeping the input sources keys in memory
+type fakeGSettings struct {
+	sources []gnomeInputSource
+	mru     []gnomeInputSource
+	current uint32
+	options []string
+	changes int
+	mu      sync.Mutex
+}
+
+func newFakeGSettings(t *testing.T, address string, sources ...gnomeInputSource) *fakeGSettings {
+	t.Helper()
+
+	settings := &fakeGSettings{sources: sources, mru: sources}
+	conn := connectBus(t, address)
+
+	if err := conn.Export(fakePortal{settings}, portalObjectPath, "org.freedesktop.portal.Settings"); err != nil {
+		t.Fatalf("Failed to export the portal: %v", err)
+	}
+	if err := conn.Export(fakeDconfWriter{settings}, dconfWriterPath, "ca.desrt.dconf.Writer"); err != nil {
+		t.Fatalf("Failed to export the dconf writer: %v", err)
+	}
+
+	for _, name := range []string{portalBusName, dconfBusName} {
+		reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+		if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+			t.Fatalf("Failed to own %s: %v", name, err)
+		}
+	}
+
+	return settings
+}
+
+type fakePortal struct {
+	settings *fakeGSettings
+}
+
+// Read answers like xdg-desktop-portal, with the value in two variants
+func (p fakePortal) Read(namespace, key string) (dbus.Variant, *dbus.Error) {
+	p.settings.mu.Lock()
+	defer p.settings.mu.Unlock()
+
+	if namespace != gnomeInputSourcesSchema {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.portal.Error.NotFound", []any{"Requested setting not found"})
+	}
+
+	var value any
+	switch key {
+	case "sources":
+		value = p.settings.sources
+	case "mru-sources":
+		value = p.settings.mru
+	case "current":
+		value = p.settings.current
+	case "xkb-options":
+		value = append([]string{}, p.settings.options...)
+	default:
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.portal.Error.NotFound", []any{"Requested setting not found"})
+	}
+
+	return dbus.MakeVariant(dbus.MakeVariant(value)), nil
+}
+
+type fakeDconfWriter struct {
+	settings *fakeGSettings
+}
+
+// Change applies a serialized a{smv} change set
+func (w fakeDconfWriter) Change(blob []byte) (string, *dbus.Error) {
+	changeset, err := gvDecode("a{smv}", blob)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+
+	w.settings.mu.Lock()
+	defer w.settings.mu.Unlock()
+
+	for path, value := range changeset.(map[string]any) {
+		switch strings.TrimPrefix(path, gnomeInputSourcesPath) {
+		case "sources":
+			w.settings.sources = decodedSources(value)
+		case "mru-sources":
+			w.settings.mru = decodedSources(value)
+		case "current":
+			w.settings.current = value.(uint32)
+		case "xkb-options":
+			w.settings.options = make([]string, 0)
+			for _, option := range value.([]any) {
+				w.settings.options = append(w.settings.options, option.(string))
+			}
+		default:
+			return "", dbus.MakeFailedError(fmt.Errorf("unexpected key %s", path))
+		}
+	}
+	w.settings.changes++
+
+	return fmt.Sprintf("tag-%d", w.settings.changes), nil
+}
+
+func decodedSources(value any) []gnomeInputSource {
+	sources := make([]gnomeInputSource, 0)
+	for _, item := range value.([]any) {
+		fields := item.([]any)
+		sources = append(sources, gnomeInputSource{Type: fields[0].(string), ID: fields[1].(string)})
+	}
+	return sources
+}
+
+func TestGNOMELayoutSwitcher_SelectsExistingSource(t *testing.T) {
+	address := startPrivateBus(t)
+	settings := newFakeGSettings(t, address,
+		gnomeInputSource{"xkb", "fr"},
+		gnomeInputSource{"xkb", "us+intl"},
+	)
+	switcher := NewGNOMELayoutSwitcher(connectBus(t, address))
+
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+
+	if len(settings.sources) != 2 {
+		t.Errorf("Expected the sources to be unchanged, got %v", settings.sources)
+	}
+	if settings.current != 1 {
+		t.Errorf("Expected current to be 1, got %d", settings.current)
+	}
+	expectedMRU := []gnomeInputSource{{"xkb", "us+intl"}, {"xkb", "fr"}}
+	if fmt.Sprint(settings.mru) != fmt.Sprint(expectedMRU) {
+		t.Errorf("Expected mru-sources %v, got %v", expectedMRU, settings.mru)
+	}
+	if settings.changes != 1 {
+		t.Errorf("Expected a single change set, got %d", settings.changes)
+	}
+}
+
+func TestGNOMELayoutSwitcher_AddsMissingSource(t *testing.T) {
+	address := startPrivateBus(t)
+	settings := newFakeGSettings(t, address, gnomeInputSource{"xkb", "us"})
+	switcher := NewGNOMELayoutSwitcher(connectBus(t, address))
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+
+	expectedSources := []gnomeInputSource{{"xkb", "us"}, {"xkb", "fr"}}
+	if fmt.Sprint(settings.sources) != fmt.Sprint(expectedSources) {
+		t.Errorf("Expected sources %v, got %v", expectedSources, settings.sources)
+	}
+	if settings.current != 1 {
+		t.Errorf("Expected current to be 1, got %d", settings.current)
+	}
+	if len(settings.mru) == 0 || settings.mru[0] != (gnomeInputSource{"xkb", "fr"}) {
+		t.Errorf("Expected fr to be the most recent source, got %v", settings.mru)
+	}
+}
+
+func TestGNOMELayoutSwitcher_GroupsAndOptions(t *testing.T) {
+	address := startPrivateBus(t)
+	settings := newFakeGSettings(t, address, gnomeInputSource{"xkb", "fr"})
+	settings.mu.Lock()
+	settings.options = []string{"ctrl:nocaps", "compose:ralt"}
+	settings.mu.Unlock()
+	switcher := NewGNOMELayoutSwitcher(connectBus(t, address))
+
+	layout := domain.NewXKBKeyboardLayout("us,ru(phonetic)",
+		[]domain.XKBGroup{{Layout: "us"}, {Layout: "ru", Variant: "phonetic"}},
+		"", domain.LayoutOptions{Add: []string{"grp:win_space_toggle"}, Remove: []string{"ctrl:nocaps"}})
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+
+	expectedSources := []gnomeInputSource{{"xkb", "fr"}, {"xkb", "us"}, {"xkb", "ru+phonetic"}}
+	if fmt.Sprint(settings.sources) != fmt.Sprint(expectedSources) {
+		t.Errorf("Expected sources %v, got %v", expectedSources, settings.sources)
+	}
+	if settings.current != 1 {
+		t.Errorf("Expected current to be 1, got %d", settings.current)
+	}
+	expectedOptions := []string{"compose:ralt", "grp:win_space_toggle"}
+	if fmt.Sprint(settings.options) != fmt.Sprint(expectedOptions) {
+		t.Errorf("Expected options %v, got %v", expectedOptions, settings.options)
+	}
+	if settings.changes != 1 {
+		t.Errorf("Expected a single change set, got %d", settings.changes)
+	}
+}
+
+func TestGNOMELayoutSwitcher_OutsideOptionChange(t *testing.T) {
+	address := startPrivateBus(t)
+	settings := newFakeGSettings(t, address, gnomeInputSource{"xkb", "fr"}, gnomeInputSource{"xkb", "us"})
+	settings.mu.Lock()
+	settings.options = []string{"ctrl:nocaps"}
+	settings.mu.Unlock()
+	switcher := NewGNOMELayoutSwitcher(connectBus(t, address))
+
+	withOptions := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us").WithOptions(domain.LayoutOptions{
+		Add: []string{"compose:ralt"},
+	})
+	withoutOptions := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+
+	if err := switcher.SwitchLayout(context.Background(), withOptions); err != nil {
+		t.Fatalf("Failed to switch to %s: %v", withOptions.Name, err)
+	}
+
+	// The user changes the options in GNOME Tweaks
+	settings.mu.Lock()
+	settings.options = []string{"caps:swapescape"}
+	settings.mu.Unlock()
+
+	if err := switcher.SwitchLayout(context.Background(), withoutOptions); err != nil {
+		t.Fatalf("Failed to switch to %s: %v", withoutOptions.Name, err)
+	}
+
+	settings.mu.Lock()
+	defer settings.mu.Unlock()
+	expectedOptions := []string{"caps:swapescape"}
+	if fmt.Sprint(settings.options) != fmt.Sprint(expectedOptions) {
+		t.Errorf("Expected the options set outside polykeys to be kept, got %v", settings.options)
+	}
+}
+
+func TestGNOMELayoutSwitcher_NoPortal(t *testing.T) {
+	address := startPrivateBus(t)
+	switcher := NewGNOMELayoutSwitcher(connectBus(t, address))
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err == nil {
+		t.Error("Expected an error without the settings services")
+	}
+}
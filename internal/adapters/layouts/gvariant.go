@@ -0,0 +1,130 @@
+package layouts
+
+// gvariant is a value in the GVariant serialization format, which dconf uses
+// for change sets. Only the types needed by the GNOME backend are supported.
+type gvariant struct {
+	signature string
+	data      []byte
+	alignment int
+	fixed     bool
+}
+
+// gvString serializes a string ("s")
+func gvString(value string) gvariant {
+	return gvariant{signature: "s", data: append([]byte(value), 0), alignment: 1}
+}
+
+// gvUint32 serializes a uint32 ("u") in little-endian order
+func gvUint32(value uint32) gvariant {
+	return gvariant{
+		signature: "u",
+		data:      []byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)},
+		alignment: 4,
+		fixed:     true,
+	}
+}
+
+// gvVariant wraps a value in a variant ("v"): the value, a zero byte, then
+// its type signature
+func gvVariant(value gvariant) gvariant {
+	data := append(append([]byte{}, value.data...), 0)
+	return gvariant{signature: "v", data: append(data, value.signature...), alignment: 8}
+}
+
+// gvJust serializes a maybe holding a variable-size value ("mX")
+func gvJust(value gvariant) gvariant {
+	return gvariant{
+		signature: "m" + value.signature,
+		data:      append(append([]byte{}, value.data...), 0),
+		alignment: value.alignment,
+	}
+}
+
+// gvTuple serializes a struct ("(...)"), or a dict entry ("{...}") when
+// dictEntry is set. The end of every variable-size member but the last is
+// stored in reverse order after the members.
+func gvTuple(dictEntry bool, members ...gvariant) gvariant {
+	open, close := "(", ")"
+	if dictEntry {
+		open, close = "{", "}"
+	}
+
+	result := gvariant{signature: open, alignment: 1, fixed: true}
+	ends := make([]int, 0)
+	for i, member := range members {
+		result.signature += member.signature
+		result.alignment = max(result.alignment, member.alignment)
+		result.fixed = result.fixed && member.fixed
+
+		result.data = gvPad(result.data, member.alignment)
+		result.data = append(result.data, member.data...)
+		if !member.fixed && i < len(members)-1 {
+			ends = append(ends, len(result.data))
+		}
+	}
+	result.signature += close
+
+	if result.fixed {
+		result.data = gvPad(result.data, result.alignment)
+		return result
+	}
+
+	size := gvOffsetSize(len(result.data), len(ends))
+	for i := len(ends) - 1; i >= 0; i-- {
+		result.data = gvAppendOffset(result.data, ends[i], size)
+	}
+	return result
+}
+
+// gvArray serializes an array ("aX") of elements of the given signature.
+// Variable-size elements are followed by a table of their ends.
+func gvArray(signature string, alignment int, fixed bool, elements ...gvariant) gvariant {
+	result := gvariant{signature: "a" + signature, alignment: alignment}
+
+	ends := make([]int, 0, len(elements))
+	for _, element := range elements {
+		result.data = gvPad(result.data, alignment)
+		result.data = append(result.data, element.data...)
+		ends = append(ends, len(result.data))
+	}
+
+	if fixed || len(elements) == 0 {
+		return result
+	}
+
+	size := gvOffsetSize(len(result.data), len(ends))
+	for _, end := range ends {
+		result.data = gvAppendOffset(result.data, end, size)
+	}
+	return result
+}
+
+// gvOffsetSize returns the size of the framing offsets of a container whose
+// body is bodySize bytes long, which depends on the size of the container
+// including its offsets
+func gvOffsetSize(bodySize, offsets int) int {
+	switch {
+	case bodySize+offsets <= 0xff:
+		return 1
+	case bodySize+2*offsets <= 0xffff:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// gvAppendOffset appends a little-endian framing offset
+func gvAppendOffset(data []byte, offset, size int) []byte {
+	for i := 0; i < size; i++ {
+		data = append(data, byte(offset>>(8*i)))
+	}
+	return data
+}
+
+// gvPad appends zero bytes up to the next multiple of alignment
+func gvPad(data []byte, alignment int) []byte {
+	for len(data)%alignment != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
@@ -0,0 +1,245 @@
+package layouts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// The expected encodings are the examples of the GVariant specification
+func TestGVariantSerialization(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    gvariant
+		expected []byte
+	}{
+		{
+			name:     "string",
+			value:    gvString("hello world"),
+			expected: []byte("hello world\x00"),
+		},
+		{
+			name:     "maybe string",
+			value:    gvJust(gvString("hello world")),
+			expected: []byte("hello world\x00\x00"),
+		},
+		{
+			name:     "array of strings",
+			value:    gvArray("s", 1, false, gvString("i"), gvString("can"), gvString("has"), gvString("strings?")),
+			expected: []byte("i\x00can\x00has\x00strings?\x00\x02\x06\x0a\x13"),
+		},
+		{
+			name:     "struct",
+			value:    gvTuple(false, gvString("foo"), gvUint32(0xffffffff)),
+			expected: []byte("foo\x00\xff\xff\xff\xff\x04"),
+		},
+		{
+			name: "dictionary",
+			value: gvArray("{su}", 4, false,
+				gvTuple(true, gvString("hi"), gvUint32(0xfffffffe)),
+				gvTuple(true, gvString("bye"), gvUint32(0xffffffff)),
+			),
+			expected: []byte("hi\x00\x00\xfe\xff\xff\xff\x03\x00\x00\x00bye\x00\xff\xff\xff\xff\x04\x09\x15"),
+		},
+		{
+			name:     "variant",
+			value:    gvVariant(gvUint32(7)),
+			expected: []byte("\x07\x00\x00\x00\x00u"),
+		},
+		{
+			name:     "empty array",
+			value:    gvArray("(ss)", 1, false),
+			expected: []byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		if !bytes.Equal(tt.value.data, tt.expected) {
+			t.Errorf("%s: expected % x, got % x", tt.name, tt.expected, tt.value.data)
+		}
+	}
+}
+
+func TestGVariantSignatures(t *testing.T) {
+	entry := gvTuple(true, gvString("/a"), gvJust(gvVariant(gvInputSources(nil))))
+	changeset := gvArray("{smv}", 8, false, entry)
+
+	if changeset.signature != "a{smv}" {
+		t.Errorf("Expected a{smv}, got %s", changeset.signature)
+	}
+	if entry.alignment != 8 || entry.fixed {
+		t.Errorf("Expected a variable-size entry aligned to 8, got %d (fixed %v)", entry.alignment, entry.fixed)
+	}
+}
+
+// gvDecode decodes serialized data of a signature into strings, uint32s,
+// nil for nothing, slices for arrays and tuples, and maps for arrays of dict
+// entries with string keys
+func gvDecode(signature string, data []byte) (any, error) {
+	switch signature[0] {
+	case 's':
+		if len(data) == 0 || data[len(data)-1] != 0 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		return string(data[:len(data)-1]), nil
+	case 'u':
+		if len(data) != 4 {
+			return nil, fmt.Errorf("invalid uint32 size %d", len(data))
+		}
+		return binary.LittleEndian.Uint32(data), nil
+	case 'v':
+		end := bytes.LastIndexByte(data, 0)
+		if end < 0 {
+			return nil, fmt.Errorf("variant without signature")
+		}
+		return gvDecode(string(data[end+1:]), data[:end])
+	case 'm':
+		if len(data) == 0 {
+			return nil, nil
+		}
+		return gvDecode(signature[1:], data[:len(data)-1])
+	case 'a':
+		return gvDecodeArray(signature[1:], data)
+	case '(', '{':
+		return gvDecodeTuple(gvMembers(signature[1:len(signature)-1]), data)
+	}
+	return nil, fmt.Errorf("unsupported signature %s", signature)
+}
+
+func gvDecodeArray(element string, data []byte) (any, error) {
+	values := make([]any, 0)
+	if len(data) > 0 {
+		size := gvDecodedOffsetSize(len(data))
+		table := gvReadOffset(data[len(data)-size:])
+		if table > len(data) {
+			return nil, fmt.Errorf("invalid offset table")
+		}
+
+		start := 0
+		for offset := table; offset < len(data); offset += size {
+			end := gvReadOffset(data[offset : offset+size])
+			for start%gvAlignment(element) != 0 {
+				start++
+			}
+			value, err := gvDecode(element, data[start:end])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			start = end
+		}
+	}
+
+	if element[0] != '{' {
+		return values, nil
+	}
+
+	entries := make(map[string]any)
+	for _, value := range values {
+		entry := value.([]any)
+		entries[entry[0].(string)] = entry[1]
+	}
+	return entries, nil
+}
+
+func gvDecodeTuple(members []string, data []byte) (any, error) {
+	size := gvDecodedOffsetSize(len(data))
+	offsets := len(data)
+
+	values := make([]any, 0, len(members))
+	start := 0
+	for i, member := range members {
+		for start%gvAlignment(member) != 0 {
+			start++
+		}
+
+		var end int
+		switch {
+		case member == "u":
+			end = start + 4
+		case i == len(members)-1:
+			end = offsets
+		default:
+			offsets -= size
+			end = gvReadOffset(data[offsets : offsets+size])
+		}
+
+		value, err := gvDecode(member, data[start:end])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		start = end
+	}
+
+	return values, nil
+}
+
+// gvMembers splits the member signatures of a tuple
+func gvMembers(signature string) []string {
+	members := make([]string, 0)
+	for len(signature) > 0 {
+		end := 0
+		for signature[end] == 'a' || signature[end] == 'm' {
+			end++
+		}
+		if signature[end] == '(' || signature[end] == '{' {
+			depth := 0
+			for {
+				switch signature[end] {
+				case '(', '{':
+					depth++
+				case ')', '}':
+					depth--
+				}
+				end++
+				if depth == 0 {
+					break
+				}
+			}
+		} else {
+			end++
+		}
+		members = append(members, signature[:end])
+		signature = signature[end:]
+	}
+	return members
+}
+
+func gvAlignment(signature string) int {
+	switch signature[0] {
+	case 'u':
+		return 4
+	case 'v':
+		return 8
+	case 'a', 'm':
+		return gvAlignment(signature[1:])
+	case '(', '{':
+		alignment := 1
+		for _, member := range gvMembers(signature[1 : len(signature)-1]) {
+			alignment = max(alignment, gvAlignment(member))
+		}
+		return alignment
+	}
+	return 1
+}
+
+func gvDecodedOffsetSize(size int) int {
+	switch {
+	case size <= 0xff:
+		return 1
+	case size <= 0xffff:
+		return 2
+	default:
+		return 4
+	}
+}
+
+func gvReadOffset(data []byte) int {
+	offset := 0
+	for i := len(data) - 1; i >= 0; i-- {
+		offset = offset<<8 | int(data[i])
+	}
+	return offset
+}
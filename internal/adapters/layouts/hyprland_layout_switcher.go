@@ -0,0 +1,435 @@
+package layouts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+)
+
+// Hyprland sockets, inside the instance directory
+const (
+	hyprlandCommandSocket = ".socket.sock"
+	hyprlandEventSocket   = ".socket2.sock"
+)
+
+// hyprlandTimeout bounds a request when the context has no deadline
+const hyprlandTimeout = 5 * time.Second
+
+// HyprlandLayoutSwitcher switches keyboard layouts on Hyprland through its
+// control socket
+type HyprlandLayoutSwitcher struct {
+	socketDir string
+	events    *domain.EventBus
+	own       ownSwitches
+	options   xkbOptionBaseline
+}
+
+// hyprlandKeyboard is a keyboard as reported by j/devices
+type hyprlandKeyboard struct {
+	Name         string `json:"name"`
+	Layout       string `json:"layout"`
+	Variant      string `json:"variant"`
+	ActiveKeymap string `json:"active_keymap"`
+	Main         bool   `json:"main"`
+}
+
+// hyprlandDevices is the reply of j/devices
+type hyprlandDevices struct {
+	Keyboards []hyprlandKeyboard `json:"keyboards"`
+}
+
+// hyprlandEmpty is what j/getoption reports for an empty string option
+const hyprlandEmpty = "[[EMPTY]]"
+
+// hyprlandOption is the reply of j/getoption
+type hyprlandOption struct {
+	Str string `json:"str"`
+}
+
+// value returns the string value of the option, empty when unset
+func (o hyprlandOption) value() string {
+	if o.Str == hyprlandEmpty {
+		return ""
+	}
+	return o.Str
+}
+
+// hyprlandSocketDir returns the socket directory of the Hyprland instance
+// polykeys runs in, or false outside Hyprland
+func hyprlandSocketDir(env *Environment) (string, bool) {
+	signature := env.Getenv("HYPRLAND_INSTANCE_SIGNATURE")
+	if signature == "" {
+		return "", false
+	}
+
+	candidates := make([]string, 0, 2)
+	if runtimeDir := env.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates, filepath.Join(runtimeDir, "hypr", signature))
+	}
+	// Used by Hyprland before 0.40
+	candidates = append(candidates, filepath.Join("/tmp", "hypr", signature))
+
+	for _, dir := range candidates {
+		if _, err := os.Stat(filepath.Join(dir, hyprlandCommandSocket)); err == nil {
+			return dir, true
+		}
+	}
+
+	return candidates[0], true
+}
+
+// NewHyprlandLayoutSwitcher creates a layout switcher for the Hyprland
+// instance whose sockets are in socketDir, publishing manual layout changes
+// to events
+func NewHyprlandLayoutSwitcher(socketDir string, events *domain.EventBus) *HyprlandLayoutSwitcher {
+	return &HyprlandLayoutSwitcher{
+		socketDir: socketDir,
+		events:    events,
+	}
+}
+
+// SwitchLayout changes the layout of every keyboard
+func (s *HyprlandLayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	layouts, variants := xkbGroupLists(xkbGroups(layout))
+
+	commands, err := s.settingCommands(ctx, layout)
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read the XKB options", err),
+			map[string]any{"layout": layout.Name},
+		)
+	}
+
+	// The variant is cleared first so that the intermediate keymap compiles
+	commands = append(commands,
+		"keyword input:kb_variant ",
+		"keyword input:kb_layout "+layouts,
+	)
+	if variants != "" {
+		commands = append(commands, "keyword input:kb_variant "+variants)
+	}
+
+	s.own.mark()
+	if err := s.batch(ctx, commands); err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+			map[string]any{"layout": layout.Name},
+		)
+	}
+
+	return nil
+}
+
+// SwitchDeviceLayout makes a single keyboard use a layout, adding it to the
+// configured layouts if needed
+func (s *HyprlandLayoutSwitcher) SwitchDeviceLayout(ctx context.Context, layout *domain.KeyboardLayout, device *domain.Device) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	name, variant := xkbLayout(layout)
+
+	keyboards, err := s.keyboardsForDevice(ctx, device)
+	if err != nil {
+		return err
+	}
+
+	// Keyboards share the input:kb_layout list, so the layout is added once
+	if hyprlandLayoutIndex(keyboards[0], name, variant) < 0 {
+		if err := s.addLayout(ctx, name, variant); err != nil {
+			return errors.WithDetails(
+				errors.Wrap(errors.ErrCodeLayoutEnableFailed, "failed to add layout", err),
+				map[string]any{"layout": layout.Name},
+			)
+		}
+
+		if keyboards, err = s.keyboardsForDevice(ctx, device); err != nil {
+			return err
+		}
+	}
+
+	// The model and options are shared by every keyboard
+	commands, err := s.settingCommands(ctx, layout)
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read the XKB options", err),
+			map[string]any{"layout": layout.Name},
+		)
+	}
+
+	for _, keyboard := range keyboards {
+		index := hyprlandLayoutIndex(keyboard, name, variant)
+		if index < 0 {
+			return errors.WithDetails(
+				errors.New(errors.ErrCodeLayoutEnableFailed, "layout not available to keyboard"),
+				map[string]any{
+					"layout":   layout.Name,
+					"keyboard": keyboard.Name,
+				},
+			)
+		}
+		commands = append(commands, fmt.Sprintf("switchxkblayout %s %d", keyboard.Name, index))
+	}
+
+	s.own.mark()
+	if err := s.batch(ctx, commands); err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+			map[string]any{
+				"layout": layout.Name,
+				"device": device.InstanceID(),
+			},
+		)
+	}
+
+	return nil
+}
+
+// WatchLayouts publishes the layout changes reported by Hyprland's event
+// socket that polykeys did not cause
+func (s *HyprlandLayoutSwitcher) WatchLayouts(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", filepath.Join(s.socketDir, hyprlandEventSocket))
+	if err != nil {
+		return fmt.Errorf("failed to connect to Hyprland events: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go func() {
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			s.handleEvent(scanner.Text())
+		}
+
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("Hyprland event socket closed: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// handleEvent publishes activelayout events, formatted as
+// "activelayout>>KEYBOARD,LAYOUT"
+func (s *HyprlandLayoutSwitcher) handleEvent(line string) {
+	data, ok := strings.CutPrefix(line, "activelayout>>")
+	if !ok {
+		return
+	}
+
+	keyboard, layout, ok := strings.Cut(data, ",")
+	if !ok {
+		return
+	}
+
+	if s.own.recent() {
+		logger.Debug("[Hyprland] %s switched to %s by polykeys\n", keyboard, layout)
+		return
+	}
+
+	s.events.Publish(domain.LayoutChanged{Keyboard: keyboard, Layout: layout})
+}
+
+// keyboardsForDevice returns the Hyprland keyboards of a device, matched on
+// its name: Hyprland lowercases names and replaces spaces with dashes
+func (s *HyprlandLayoutSwitcher) keyboardsForDevice(ctx context.Context, device *domain.Device) ([]hyprlandKeyboard, error) {
+	var devices hyprlandDevices
+	if err := s.requestJSON(ctx, "devices", &devices); err != nil {
+		return nil, errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to list Hyprland keyboards", err)
+	}
+
+	name := hyprlandDeviceName(device.Name)
+	keyboards := make([]hyprlandKeyboard, 0)
+	for _, keyboard := range devices.Keyboards {
+		// Extra interfaces are named after the device, e.g. "-consumer-control"
+		if keyboard.Name == name || strings.HasPrefix(keyboard.Name, name+"-") {
+			keyboards = append(keyboards, keyboard)
+		}
+	}
+
+	if len(keyboards) == 0 {
+		return nil, errors.WithDetails(
+			errors.New(errors.ErrCodeDeviceNotFound, "keyboard not known to Hyprland"),
+			map[string]any{
+				"device": device.InstanceID(),
+				"name":   name,
+			},
+		)
+	}
+
+	return keyboards, nil
+}
+
+// addLayout appends a layout to the input:kb_layout and kb_variant lists
+func (s *HyprlandLayoutSwitcher) addLayout(ctx context.Context, name, variant string) error {
+	var layouts, variants hyprlandOption
+	if err := s.requestJSON(ctx, "getoption input:kb_layout", &layouts); err != nil {
+		return err
+	}
+	if err := s.requestJSON(ctx, "getoption input:kb_variant", &variants); err != nil {
+		return err
+	}
+
+	layoutList := splitList(layouts.value())
+	variantList := padList(splitList(variants.value()), len(layoutList))
+
+	layoutList = append(layoutList, name)
+	variantList = append(variantList, variant)
+
+	logger.Debug("[Hyprland] Adding layout %s(%s)\n", name, variant)
+
+	// The layout list is set first; extra variants would not compile
+	return s.batch(ctx, []string{
+		"keyword input:kb_layout " + strings.Join(layoutList, ","),
+		"keyword input:kb_variant " + strings.Join(variantList, ","),
+	})
+}
+
+// settingCommands returns the commands setting the model of a layout and
+// its options in input:kb_options, which are the ones in use before the
+// first switch with the changes of the layout made
+func (s *HyprlandLayoutSwitcher) settingCommands(ctx context.Context, layout *domain.KeyboardLayout) ([]string, error) {
+	commands := make([]string, 0, 2)
+	if layout.Model != "" {
+		commands = append(commands, "keyword input:kb_model "+layout.Model)
+	}
+
+	var current hyprlandOption
+	if err := s.requestJSON(ctx, "getoption input:kb_options", &current); err != nil {
+		return nil, err
+	}
+
+	currentOptions := splitList(current.value())
+	options := s.options.apply(currentOptions, layout.Options)
+	if slices.Equal(options, currentOptions) {
+		return commands, nil
+	}
+	return append(commands, "keyword input:kb_options "+strings.Join(options, ",")), nil
+}
+
+// batch runs commands in one request, failing unless each answered "ok"
+func (s *HyprlandLayoutSwitcher) batch(ctx context.Context, commands []string) error {
+	logger.Debug("[Hyprland] %s\n", strings.Join(commands, "; "))
+
+	reply, err := s.request(ctx, "[[BATCH]]"+strings.Join(commands, ";"))
+	if err != nil {
+		return err
+	}
+
+	answers := strings.Fields(reply)
+	if len(answers) == 0 {
+		return fmt.Errorf("empty reply from Hyprland")
+	}
+	for _, answer := range answers {
+		if answer != "ok" {
+			return fmt.Errorf("hyprland: %s", strings.TrimSpace(reply))
+		}
+	}
+
+	return nil
+}
+
+// requestJSON runs a command with JSON output and decodes its reply
+func (s *HyprlandLayoutSwitcher) requestJSON(ctx context.Context, command string, value any) error {
+	reply, err := s.request(ctx, "j/"+command)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(reply), value); err != nil {
+		return fmt.Errorf("invalid reply to %s: %w", command, err)
+	}
+
+	return nil
+}
+
+// request sends a command to the control socket, which answers and closes
+// the connection
+func (s *HyprlandLayoutSwitcher) request(ctx context.Context, command string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", filepath.Join(s.socketDir, hyprlandCommandSocket))
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to Hyprland: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(hyprlandTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := io.WriteString(conn, command); err != nil {
+		return "", fmt.Errorf("failed to send Hyprland request: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Hyprland reply: %w", err)
+	}
+
+	return string(reply), nil
+}
+
+// hyprlandDeviceName returns the name Hyprland gives to a device
+func hyprlandDeviceName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "-")
+}
+
+// hyprlandLayoutIndex returns the index of a layout in a keyboard's layout
+// list, or -1 if it is not there
+func hyprlandLayoutIndex(keyboard hyprlandKeyboard, name, variant string) int {
+	layouts := splitList(keyboard.Layout)
+	variants := padList(splitList(keyboard.Variant), len(layouts))
+
+	for i := range layouts {
+		if layouts[i] == name && variants[i] == variant {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitList splits a comma-separated XKB list, trimming its items
+func splitList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// padList extends a list with empty items up to length
+func padList(items []string, length int) []string {
+	for len(items) < length {
+		items = append(items, "")
+	}
+	return items
+}
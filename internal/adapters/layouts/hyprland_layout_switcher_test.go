@@ -0,0 +1,401 @@
+package layouts
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// fakeHyprland answers control socket requests the way Hyprland does: one
+// request per connection, closed after the reply. It keeps the layout lists
+// so that added layouts show up in the devices.
+type fakeHyprland struct {
+	dir       string
+	listener  net.Listener
+	events    net.Listener
+	keyboards []string
+	layout    string
+	variant   string
+	options   string
+	model     string
+	requests  []string
+	mu        sync.Mutex
+}
+
+func newFakeHyprland(t *testing.T, layout, variant string, keyboards ...string) *fakeHyprland {
+	t.Helper()
+
+	// Unix socket paths are limited to about 100 bytes
+	dir, err := os.MkdirTemp("", "hypr")
+	if err != nil {
+		t.Fatalf("Failed to create socket dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	listener, err := net.Listen("unix", filepath.Join(dir, hyprlandCommandSocket))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	events, err := net.Listen("unix", filepath.Join(dir, hyprlandEventSocket))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		events.Close()
+	})
+
+	hypr := &fakeHyprland{
+		dir:       dir,
+		listener:  listener,
+		events:    events,
+		keyboards: keyboards,
+		layout:    layout,
+		variant:   variant,
+	}
+	go hypr.serve()
+
+	return hypr
+}
+
+func (f *fakeHyprland) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		// Like Hyprland, a request is a single read
+		buf := make([]byte, 8192)
+		n, _ := conn.Read(buf)
+		conn.Write([]byte(f.handle(string(buf[:n]))))
+		conn.Close()
+	}
+}
+
+// handle answers a request and applies the keywords it sets
+func (f *fakeHyprland) handle(request string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, request)
+
+	switch {
+	case request == "j/devices":
+		devices := hyprlandDevices{}
+		for _, name := range f.keyboards {
+			devices.Keyboards = append(devices.Keyboards, hyprlandKeyboard{Name: name, Layout: f.layout, Variant: f.variant})
+		}
+		data, _ := json.Marshal(devices)
+		return string(data)
+	case request == "j/getoption input:kb_layout":
+		return hyprlandOptionReply(f.layout)
+	case request == "j/getoption input:kb_variant":
+		return hyprlandOptionReply(f.variant)
+	case request == "j/getoption input:kb_options":
+		return hyprlandOptionReply(f.options)
+	case strings.HasPrefix(request, "[[BATCH]]"):
+		replies := make([]string, 0)
+		for _, command := range strings.Split(strings.TrimPrefix(request, "[[BATCH]]"), ";") {
+			if value, ok := strings.CutPrefix(command, "keyword input:kb_layout "); ok {
+				f.layout = value
+			} else if value, ok := strings.CutPrefix(command, "keyword input:kb_variant "); ok {
+				f.variant = value
+			} else if value, ok := strings.CutPrefix(command, "keyword input:kb_options "); ok {
+				f.options = value
+			} else if value, ok := strings.CutPrefix(command, "keyword input:kb_model "); ok {
+				f.model = value
+			} else if !strings.HasPrefix(command, "switchxkblayout ") {
+				replies = append(replies, "invalid command")
+				continue
+			}
+			replies = append(replies, "ok")
+		}
+		return strings.Join(replies, "\n\n")
+	}
+
+	return "unknown request"
+}
+
+// hyprlandOptionReply answers j/getoption like Hyprland, which reports
+// empty strings as [[EMPTY]]
+func hyprlandOptionReply(value string) string {
+	if value == "" {
+		value = hyprlandEmpty
+	}
+	data, _ := json.Marshal(hyprlandOption{Str: value})
+	return string(data)
+}
+
+func (f *fakeHyprland) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// sendEvent writes an event line to the first client of the event socket
+func (f *fakeHyprland) sendEvent(t *testing.T, lines ...string) {
+	t.Helper()
+
+	conn, err := f.events.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept event client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for _, line := range lines {
+		conn.Write([]byte(line + "\n"))
+	}
+}
+
+func TestHyprlandLayoutSwitcher_SwitchLayout(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	// The options are read, and left alone when they need no change
+	expected := []string{"j/getoption input:kb_options", "[[BATCH]]keyword input:kb_variant ;keyword input:kb_layout us;keyword input:kb_variant intl"}
+	if got := hypr.received(); !slices.Equal(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_SwitchLayoutOptions(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "")
+	hypr.options = "ctrl:nocaps,compose:ralt"
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us").WithOptions(domain.LayoutOptions{
+		Remove: []string{"ctrl:nocaps"},
+	})
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	hypr.mu.Lock()
+	defer hypr.mu.Unlock()
+	if hypr.options != "compose:ralt" {
+		t.Errorf("Expected options %q, got %q", "compose:ralt", hypr.options)
+	}
+	if hypr.layout != "us" {
+		t.Errorf("Expected layout us, got %q", hypr.layout)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_RestoresOptions(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "")
+	hypr.options = "ctrl:nocaps"
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	withOptions := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us").WithOptions(domain.LayoutOptions{
+		Add:    []string{"compose:ralt"},
+		Remove: []string{"ctrl:nocaps"},
+	})
+	withoutOptions := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+
+	for _, layout := range []*domain.KeyboardLayout{withOptions, withoutOptions} {
+		if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+			t.Fatalf("Failed to switch to %s: %v", layout.Name, err)
+		}
+	}
+
+	hypr.mu.Lock()
+	defer hypr.mu.Unlock()
+	if hypr.options != "ctrl:nocaps" {
+		t.Errorf("Expected the options in use before to be restored, got %q", hypr.options)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_SwitchLayoutGroups(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout("Greek", domain.OSLinux, "gr,us -variant polytonic, -model pc104")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	hypr.mu.Lock()
+	defer hypr.mu.Unlock()
+	if hypr.layout != "gr,us" || hypr.variant != "polytonic," || hypr.model != "pc104" {
+		t.Errorf("Expected gr,us with polytonic, on pc104, got %q with %q on %q", hypr.layout, hypr.variant, hypr.model)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_SwitchDeviceLayout(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr,us", ",intl", "at-translated-set-2-keyboard", "foostan-corne", "foostan-corne-consumer-control")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	got := hypr.received()
+	expected := "[[BATCH]]switchxkblayout foostan-corne 1;switchxkblayout foostan-corne-consumer-control 1"
+	if got[len(got)-1] != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_SwitchDeviceLayoutAddsLayout(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "", "at-translated-set-2-keyboard", "foostan-corne")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutColemak, domain.OSLinux, "us -variant colemak")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	if hypr.layout != "fr,us" || hypr.variant != ",colemak" {
+		t.Errorf("Expected Colemak to be added to the layouts, got %q / %q", hypr.layout, hypr.variant)
+	}
+
+	got := hypr.received()
+	if expected := "[[BATCH]]switchxkblayout foostan-corne 1"; got[len(got)-1] != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_SwitchDeviceLayoutUnsetOptions(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "", "at-translated-set-2-keyboard", "foostan-corne")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl").WithOptions(domain.LayoutOptions{
+		Add: []string{"ctrl:nocaps"},
+	})
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	hypr.mu.Lock()
+	defer hypr.mu.Unlock()
+	if hypr.layout != "fr,us" || hypr.variant != ",intl" {
+		t.Errorf("Expected US International to be added to the layouts, got %q / %q", hypr.layout, hypr.variant)
+	}
+	if hypr.options != "ctrl:nocaps" {
+		t.Errorf("Expected options %q, got %q", "ctrl:nocaps", hypr.options)
+	}
+}
+
+func TestHyprlandLayoutSwitcher_UnknownDevice(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "", "at-translated-set-2-keyboard")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err == nil {
+		t.Error("Expected an error for a keyboard Hyprland does not know")
+	}
+}
+
+func TestHyprlandLayoutSwitcher_CommandFailure(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr", "", "foostan-corne")
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, domain.NewEventBus())
+
+	if err := switcher.batch(context.Background(), []string{"keyword input:kb_layout us", "bogus"}); err == nil {
+		t.Error("Expected a failed command in the batch to be reported")
+	}
+}
+
+func TestHyprlandLayoutSwitcher_WatchLayouts(t *testing.T) {
+	hypr := newFakeHyprland(t, "fr,us", "")
+	events := domain.NewEventBus()
+	switcher := NewHyprlandLayoutSwitcher(hypr.dir, events)
+
+	changes := make(chan domain.LayoutChanged, 10)
+	events.Subscribe(func(event domain.Event) {
+		changes <- event.(domain.LayoutChanged)
+	}, domain.EventLayoutChanged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := switcher.WatchLayouts(ctx); err != nil {
+		t.Fatalf("Failed to watch layouts: %v", err)
+	}
+
+	hypr.sendEvent(t,
+		"workspace>>2",
+		"activelayout>>at-translated-set-2-keyboard,English (US)",
+	)
+
+	select {
+	case changed := <-changes:
+		if changed.Keyboard != "at-translated-set-2-keyboard" || changed.Layout != "English (US)" {
+			t.Errorf("Unexpected change %+v", changed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the layout change to be published")
+	}
+}
+
+func TestHyprlandLayoutSwitcher_IgnoresOwnSwitches(t *testing.T) {
+	events := domain.NewEventBus()
+	switcher := NewHyprlandLayoutSwitcher(t.TempDir(), events)
+
+	published := 0
+	events.Subscribe(func(domain.Event) { published++ }, domain.EventLayoutChanged)
+
+	switcher.own.mark()
+	switcher.handleEvent("activelayout>>foostan-corne,English (US, intl., with dead keys)")
+
+	if published != 0 {
+		t.Errorf("Expected the change made by polykeys not to be published, got %d events", published)
+	}
+}
+
+func TestHyprlandLayoutIndex(t *testing.T) {
+	keyboard := hyprlandKeyboard{Layout: "fr,us,us", Variant: ",,colemak"}
+
+	tests := []struct {
+		name     string
+		variant  string
+		expected int
+	}{
+		{"fr", "", 0},
+		{"us", "", 1},
+		{"us", "colemak", 2},
+		{"de", "", -1},
+	}
+
+	for _, tt := range tests {
+		if got := hyprlandLayoutIndex(keyboard, tt.name, tt.variant); got != tt.expected {
+			t.Errorf("%s(%s): expected %d, got %d", tt.name, tt.variant, tt.expected, got)
+		}
+	}
+}
+
+func TestHyprlandSocketDir(t *testing.T) {
+	vars := map[string]string{"XDG_RUNTIME_DIR": t.TempDir()}
+	env := &Environment{Getenv: func(key string) string { return vars[key] }}
+
+	if _, ok := hyprlandSocketDir(env); ok {
+		t.Error("Expected no socket directory outside Hyprland")
+	}
+
+	vars["HYPRLAND_INSTANCE_SIGNATURE"] = "abc_123"
+	dir, ok := hyprlandSocketDir(env)
+	if !ok {
+		t.Fatal("Expected a socket directory inside Hyprland")
+	}
+	if expected := filepath.Join(vars["XDG_RUNTIME_DIR"], "hypr", "abc_123"); dir != expected {
+		t.Errorf("Expected %s, got %s", expected, dir)
+	}
+}
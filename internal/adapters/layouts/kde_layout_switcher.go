@@ -0,0 +1,382 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+	"github.com/godbus/dbus/v5"
+)
+
+// KWin's keyboard layout service
+const (
+	kdeBusName        = "org.kde.keyboard"
+	kdeLayoutsPath    = "/Layouts"
+	kdeLayoutsIface   = "org.kde.KeyboardLayouts"
+	kdeLayoutChanged  = "layoutChanged"
+	kdeReloadConfig   = "org.kde.keyboard.reloadConfig"
+	kdeGetLayoutsList = kdeLayoutsIface + ".getLayoutsList"
+	kdeSetLayout      = kdeLayoutsIface + ".setLayout"
+)
+
+// kxkbrcGroup is the kxkbrc group holding the layouts
+const kxkbrcGroup = "[Layout]"
+
+// kdeTimeout bounds the D-Bus calls of a layout switch
+const kdeTimeout = 5 * time.Second
+
+// kdeReloadPoll is how often the layouts are listed while waiting for KWin to
+// apply a new kxkbrc
+const kdeReloadPoll = 100 * time.Millisecond
+
+// KDELayoutSwitcher switches keyboard layouts on Plasma through KWin's
+// org.kde.keyboard service. Layouts must be configured to be selected, so
+// missing ones are added to kxkbrc.
+type KDELayoutSwitcher struct {
+	conn       *dbus.Conn
+	configPath string
+	events     *domain.EventBus
+	own        ownSwitches
+	options    xkbOptionBaseline
+}
+
+// kdeLayout is a configured layout as listed by getLayoutsList
+type kdeLayout struct {
+	ShortName   string
+	VariantName string
+	LongName    string
+}
+
+// kdeConfigPath returns the path of kxkbrc, where Plasma stores the keyboard
+// layouts
+func kdeConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kxkbrc"), nil
+}
+
+// NewKDELayoutSwitcher creates a layout switcher using the session bus
+// connection conn and the kxkbrc file at configPath, publishing manual layout
+// changes to events
+func NewKDELayoutSwitcher(conn *dbus.Conn, configPath string, events *domain.EventBus) *KDELayoutSwitcher {
+	return &KDELayoutSwitcher{
+		conn:       conn,
+		configPath: configPath,
+		events:     events,
+	}
+}
+
+// SwitchLayout selects a layout, configuring it first if needed
+func (s *KDELayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, kdeTimeout)
+	defer cancel()
+
+	configured, err := s.layouts(ctx)
+	if err != nil {
+		return errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to list KDE layouts", err)
+	}
+
+	index, err := s.configure(ctx, configured, layout)
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutEnableFailed, "failed to configure layout", err),
+			map[string]any{
+				"layout": layout.Name,
+				"config": s.configPath,
+			},
+		)
+	}
+
+	logger.Debug("[KDE] Selecting layout %d (%s)\n", index, layout.Name)
+
+	s.own.mark()
+	var switched bool
+	err = s.object().CallWithContext(ctx, kdeSetLayout, 0, uint32(index)).Store(&switched)
+	if err == nil && !switched {
+		err = fmt.Errorf("KWin refused layout %d", index)
+	}
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+			map[string]any{"layout": layout.Name},
+		)
+	}
+
+	return nil
+}
+
+// WatchLayouts publishes the layout changes signalled by KWin that polykeys
+// did not cause
+func (s *KDELayoutSwitcher) WatchLayouts(ctx context.Context) error {
+	err := s.conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(kdeLayoutsPath),
+		dbus.WithMatchInterface(kdeLayoutsIface),
+		dbus.WithMatchMember(kdeLayoutChanged),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to watch KDE layouts: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 16)
+	s.conn.Signal(signals)
+
+	go func() {
+		defer s.conn.RemoveSignal(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case signal := <-signals:
+				s.handleSignal(ctx, signal)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// handleSignal publishes a layoutChanged signal, whose argument is the index
+// of the new layout
+func (s *KDELayoutSwitcher) handleSignal(ctx context.Context, signal *dbus.Signal) {
+	if signal.Path != kdeLayoutsPath || signal.Name != kdeLayoutsIface+"."+kdeLayoutChanged || len(signal.Body) != 1 {
+		return
+	}
+
+	index, ok := signal.Body[0].(uint32)
+	if !ok {
+		return
+	}
+
+	if s.own.recent() {
+		logger.Debug("[KDE] Layout %d selected by polykeys\n", index)
+		return
+	}
+
+	name := fmt.Sprint(index)
+	ctx, cancel := context.WithTimeout(ctx, kdeTimeout)
+	defer cancel()
+	if configured, err := s.layouts(ctx); err == nil && int(index) < len(configured) {
+		name = configured[index].LongName
+	}
+
+	s.events.Publish(domain.LayoutChanged{Layout: name})
+}
+
+// layouts returns the configured layouts, in switching order
+func (s *KDELayoutSwitcher) layouts(ctx context.Context) ([]kdeLayout, error) {
+	var layouts []kdeLayout
+	if err := s.object().CallWithContext(ctx, kdeGetLayoutsList, 0).Store(&layouts); err != nil {
+		return nil, err
+	}
+	return layouts, nil
+}
+
+// configure returns the index of a layout, first appending its missing
+// groups to kxkbrc and setting its model and options there. KWin is then
+// asked to reload kxkbrc, and the index is returned once KWin lists the
+// layout.
+func (s *KDELayoutSwitcher) configure(ctx context.Context, configured []kdeLayout, layout *domain.KeyboardLayout) (int, error) {
+	groups := xkbGroups(layout)
+	index := kdeLayoutIndex(configured, groups[0].Layout, groups[0].Variant)
+
+	data, err := os.ReadFile(s.configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return -1, err
+	}
+
+	keys := kdeConfigKeys(string(data), configured, layout, &s.options)
+	if len(keys) == 0 {
+		return index, nil
+	}
+
+	logger.Debug("[KDE] Setting %v in %s\n", keys, s.configPath)
+
+	if err := os.WriteFile(s.configPath, []byte(setKxkbrcKeys(string(data), keys)), 0o600); err != nil {
+		return -1, err
+	}
+
+	if err := s.conn.Emit(kdeLayoutsPath, kdeReloadConfig); err != nil {
+		return -1, fmt.Errorf("failed to ask KWin to reload the layouts: %w", err)
+	}
+
+	ticker := time.NewTicker(kdeReloadPoll)
+	defer ticker.Stop()
+
+	for {
+		configured, err := s.layouts(ctx)
+		if err != nil {
+			return -1, err
+		}
+		if index := kdeLayoutIndex(configured, groups[0].Layout, groups[0].Variant); index >= 0 {
+			return index, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return -1, fmt.Errorf("KWin did not load the new layout: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// kxkbrcKey is a key of the [Layout] group of kxkbrc and its new value
+type kxkbrcKey struct {
+	Name  string
+	Value string
+}
+
+// kdeConfigKeys returns the kxkbrc keys to change for a layout: the layout
+// lists when some of its groups are not configured, and the model and
+// options when they differ, the options being the baseline ones with the
+// changes of the layout made
+func kdeConfigKeys(data string, configured []kdeLayout, layout *domain.KeyboardLayout, baseline *xkbOptionBaseline) []kxkbrcKey {
+	keys := make([]kxkbrcKey, 0, 5)
+
+	names := make([]string, 0, len(configured)+1)
+	variants := make([]string, 0, len(configured)+1)
+	for _, layout := range configured {
+		names = append(names, layout.ShortName)
+		variants = append(variants, layout.VariantName)
+	}
+	added := false
+	for _, group := range xkbGroups(layout) {
+		if kdeLayoutIndex(configured, group.Layout, group.Variant) < 0 {
+			names = append(names, group.Layout)
+			variants = append(variants, group.Variant)
+			added = true
+		}
+	}
+	if added {
+		keys = append(keys,
+			kxkbrcKey{"LayoutList", strings.Join(names, ",")},
+			kxkbrcKey{"VariantList", strings.Join(variants, ",")},
+			kxkbrcKey{"Use", "true"},
+		)
+	}
+
+	if layout.Model != "" && kxkbrcValue(data, "Model") != layout.Model {
+		keys = append(keys, kxkbrcKey{"Model", layout.Model})
+	}
+
+	current := splitList(kxkbrcValue(data, "Options"))
+	if options := baseline.apply(current, layout.Options); !slices.Equal(options, current) {
+		// Without ResetOldOptions, KWin keeps the options set before
+		keys = append(keys, kxkbrcKey{"Options", strings.Join(options, ",")}, kxkbrcKey{"ResetOldOptions", "true"})
+	}
+
+	return keys
+}
+
+func (s *KDELayoutSwitcher) object() dbus.BusObject {
+	return s.conn.Object(kdeBusName, kdeLayoutsPath)
+}
+
+// kdeLayoutIndex returns the index of a layout in the configured layouts, or
+// -1 if it is not there
+func kdeLayoutIndex(layouts []kdeLayout, name, variant string) int {
+	for i, layout := range layouts {
+		if layout.ShortName == name && layout.VariantName == variant {
+			return i
+		}
+	}
+	return -1
+}
+
+// kxkbrcValue returns the value of a key of the [Layout] group of a kxkbrc
+// file, empty if it is not set
+func kxkbrcValue(data, name string) string {
+	inGroup := false
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inGroup = trimmed == kxkbrcGroup
+			continue
+		}
+		if key, value, ok := strings.Cut(trimmed, "="); inGroup && ok && strings.TrimSpace(key) == name {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// setKxkbrcKeys sets keys of the [Layout] group of a kxkbrc file, adding the
+// group if needed. Other keys are kept.
+func setKxkbrcKeys(data string, keys []kxkbrcKey) string {
+	values := make(map[string]string, len(keys))
+	order := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values[key.Name] = key.Value
+		order = append(order, key.Name)
+	}
+
+	lines := strings.Split(strings.TrimRight(data, "\n"), "\n")
+	if data == "" {
+		lines = nil
+	}
+
+	result := make([]string, 0, len(lines)+len(values)+2)
+	inGroup, found := false, false
+	flush := func() {
+		for _, key := range order {
+			if value, ok := values[key]; ok {
+				result = append(result, key+"="+value)
+				delete(values, key)
+			}
+		}
+	}
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if inGroup {
+				// Keys missing from the group go at its end, before blank lines
+				end := len(result)
+				for end > 0 && strings.TrimSpace(result[end-1]) == "" {
+					end--
+				}
+				tail := append([]string(nil), result[end:]...)
+				result = result[:end]
+				flush()
+				result = append(result, tail...)
+			}
+			inGroup = trimmed == kxkbrcGroup
+			found = found || inGroup
+			result = append(result, line)
+			continue
+		}
+
+		if inGroup {
+			key, _, ok := strings.Cut(trimmed, "=")
+			if value, replace := values[strings.TrimSpace(key)]; ok && replace {
+				result = append(result, strings.TrimSpace(key)+"="+value)
+				delete(values, strings.TrimSpace(key))
+				continue
+			}
+		}
+		result = append(result, line)
+	}
+
+	if !found {
+		if len(result) > 0 {
+			result = append(result, "")
+		}
+		result = append(result, kxkbrcGroup)
+	}
+	flush()
+
+	return strings.Join(result, "\n") + "\n"
+}
@@ -0,0 +1,305 @@
+package layouts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeKWin stands in for KWin's org.kde.keyboard service, reloading its
+// layouts from kxkbrc when asked to
+type fakeKWin struct {
+	conn       *dbus.Conn
+	configPath string
+	layouts    []kdeLayout
+	current    uint32
+	reloads    int
+	mu         sync.Mutex
+}
+
+var kdeLongNames = map[string]string{
+	"us":         "English (US)",
+	"fr":         "French",
+	"us+colemak": "English (Colemak)",
+}
+
+func newFakeKWin(t *testing.T, address string, layouts ...string) *fakeKWin {
+	t.Helper()
+
+	kwin := &fakeKWin{
+		conn:       connectBus(t, address),
+		configPath: filepath.Join(t.TempDir(), "kxkbrc"),
+	}
+	kwin.setLayouts(layouts, nil)
+
+	methods := map[string]any{
+		"getLayoutsList": kwin.getLayoutsList,
+		"setLayout":      kwin.setLayout,
+	}
+	if err := kwin.conn.ExportMethodTable(methods, kdeLayoutsPath, kdeLayoutsIface); err != nil {
+		t.Fatalf("Failed to export the layouts: %v", err)
+	}
+
+	reply, err := kwin.conn.RequestName(kdeBusName, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("Failed to own %s: %v", kdeBusName, err)
+	}
+
+	err = kwin.conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.kde.keyboard"),
+		dbus.WithMatchMember("reloadConfig"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to watch reloadConfig: %v", err)
+	}
+	signals := make(chan *dbus.Signal, 4)
+	kwin.conn.Signal(signals)
+	go func() {
+		for signal := range signals {
+			if signal.Name == kdeReloadConfig {
+				kwin.reload()
+			}
+		}
+	}()
+
+	return kwin
+}
+
+func (k *fakeKWin) setLayouts(names, variants []string) {
+	k.layouts = nil
+	for i, name := range names {
+		layout := kdeLayout{ShortName: name}
+		if i < len(variants) {
+			layout.VariantName = variants[i]
+		}
+		layout.LongName = kdeLongNames[gnomeSourceID(layout.ShortName, layout.VariantName)]
+		k.layouts = append(k.layouts, layout)
+	}
+}
+
+// reload reads the layout lists of kxkbrc
+func (k *fakeKWin) reload() {
+	data, err := os.ReadFile(k.configPath)
+	if err != nil {
+		return
+	}
+
+	var names, variants []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "LayoutList="); ok {
+			names = strings.Split(value, ",")
+		}
+		if value, ok := strings.CutPrefix(line, "VariantList="); ok {
+			variants = strings.Split(value, ",")
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.setLayouts(names, variants)
+	k.reloads++
+}
+
+func (k *fakeKWin) getLayoutsList() ([]kdeLayout, *dbus.Error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.layouts, nil
+}
+
+func (k *fakeKWin) setLayout(index uint32) (bool, *dbus.Error) {
+	k.mu.Lock()
+	if int(index) >= len(k.layouts) {
+		k.mu.Unlock()
+		return false, nil
+	}
+	k.current = index
+	k.mu.Unlock()
+
+	k.conn.Emit(kdeLayoutsPath, kdeLayoutsIface+"."+kdeLayoutChanged, index)
+	return true, nil
+}
+
+func (k *fakeKWin) state() (uint32, int, []kdeLayout) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.current, k.reloads, append([]kdeLayout(nil), k.layouts...)
+}
+
+func TestKDELayoutSwitcher_SelectsConfiguredLayout(t *testing.T) {
+	address := startPrivateBus(t)
+	kwin := newFakeKWin(t, address, "us", "fr")
+	switcher := NewKDELayoutSwitcher(connectBus(t, address), kwin.configPath, domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	current, reloads, _ := kwin.state()
+	if current != 1 {
+		t.Errorf("Expected layout 1, got %d", current)
+	}
+	if reloads != 0 {
+		t.Errorf("Expected no reload, got %d", reloads)
+	}
+	if _, err := os.Stat(kwin.configPath); !os.IsNotExist(err) {
+		t.Error("Expected kxkbrc to be left alone")
+	}
+}
+
+func TestKDELayoutSwitcher_AddsLayout(t *testing.T) {
+	address := startPrivateBus(t)
+	kwin := newFakeKWin(t, address, "us", "fr")
+	switcher := NewKDELayoutSwitcher(connectBus(t, address), kwin.configPath, domain.NewEventBus())
+
+	config := "[$Version]\nupdate_info=kxkb.upd:remove-empty-lists\n\n[Layout]\nLayoutList=us,fr\nOptions=caps:escape\n"
+	if err := os.WriteFile(kwin.configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write kxkbrc: %v", err)
+	}
+
+	layout := domain.NewKeyboardLayout(domain.LayoutColemak, domain.OSLinux, "us -variant colemak")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	current, reloads, layouts := kwin.state()
+	if reloads != 1 {
+		t.Errorf("Expected one reload, got %d", reloads)
+	}
+	if len(layouts) != 3 || current != 2 {
+		t.Errorf("Expected Colemak to be added and selected, got layout %d of %v", current, layouts)
+	}
+
+	data, _ := os.ReadFile(kwin.configPath)
+	if !strings.Contains(string(data), "Options=caps:escape") {
+		t.Errorf("Expected the other keys to be kept:\n%s", data)
+	}
+}
+
+func TestKDELayoutSwitcher_ConfiguresGroupsAndOptions(t *testing.T) {
+	address := startPrivateBus(t)
+	kwin := newFakeKWin(t, address, "fr", "us")
+	switcher := NewKDELayoutSwitcher(connectBus(t, address), kwin.configPath, domain.NewEventBus())
+
+	config := "[Layout]\nLayoutList=fr,us\nOptions=caps:escape\n"
+	if err := os.WriteFile(kwin.configPath, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write kxkbrc: %v", err)
+	}
+
+	layout := domain.NewKeyboardLayout("English (US) and Russian", domain.OSLinux, "us,ru -model pc104 -option ctrl:nocaps")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	current, reloads, layouts := kwin.state()
+	if reloads != 1 {
+		t.Errorf("Expected one reload, got %d", reloads)
+	}
+	if len(layouts) != 3 || current != 1 {
+		t.Errorf("Expected ru to be added and us selected, got layout %d of %v", current, layouts)
+	}
+
+	data, _ := os.ReadFile(kwin.configPath)
+	for _, line := range []string{"LayoutList=fr,us,ru", "Model=pc104", "Options=caps:escape,ctrl:nocaps", "ResetOldOptions=true"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("Expected %s in kxkbrc:\n%s", line, data)
+		}
+	}
+
+	// Nothing to change the second time
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout again: %v", err)
+	}
+	if _, reloads, _ := kwin.state(); reloads != 1 {
+		t.Errorf("Expected no other reload, got %d", reloads)
+	}
+}
+
+func TestKDELayoutSwitcher_WatchLayouts(t *testing.T) {
+	address := startPrivateBus(t)
+	kwin := newFakeKWin(t, address, "us", "fr")
+	events := domain.NewEventBus()
+	switcher := NewKDELayoutSwitcher(connectBus(t, address), kwin.configPath, events)
+
+	changes := make(chan domain.LayoutChanged, 10)
+	events.Subscribe(func(event domain.Event) {
+		changes <- event.(domain.LayoutChanged)
+	}, domain.EventLayoutChanged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := switcher.WatchLayouts(ctx); err != nil {
+		t.Fatalf("Failed to watch layouts: %v", err)
+	}
+
+	// A switch with the Plasma shortcut
+	kwin.setLayout(1)
+
+	select {
+	case changed := <-changes:
+		if changed.Layout != "French" {
+			t.Errorf("Expected French, got %+v", changed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the layout change to be published")
+	}
+}
+
+func TestKDELayoutSwitcher_IgnoresOwnSwitches(t *testing.T) {
+	events := domain.NewEventBus()
+	switcher := NewKDELayoutSwitcher(nil, "", events)
+
+	published := 0
+	events.Subscribe(func(domain.Event) { published++ }, domain.EventLayoutChanged)
+
+	switcher.own.mark()
+	switcher.handleSignal(context.Background(), &dbus.Signal{
+		Path: kdeLayoutsPath,
+		Name: kdeLayoutsIface + "." + kdeLayoutChanged,
+		Body: []any{uint32(1)},
+	})
+
+	if published != 0 {
+		t.Errorf("Expected the change made by polykeys not to be published, got %d events", published)
+	}
+}
+
+func TestSetKxkbrcKeys(t *testing.T) {
+	keys := []kxkbrcKey{{"LayoutList", "us,fr"}, {"VariantList", ",oss"}, {"Use", "true"}}
+
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name:     "no file",
+			data:     "",
+			expected: "[Layout]\nLayoutList=us,fr\nVariantList=,oss\nUse=true\n",
+		},
+		{
+			name:     "no layout group",
+			data:     "[$Version]\nupdate_info=kxkb.upd:remove-empty-lists\n",
+			expected: "[$Version]\nupdate_info=kxkb.upd:remove-empty-lists\n\n[Layout]\nLayoutList=us,fr\nVariantList=,oss\nUse=true\n",
+		},
+		{
+			name:     "replaces keys",
+			data:     "[Layout]\nLayoutList=us\nUse=false\n\n[Other]\nKey=value\n",
+			expected: "[Layout]\nLayoutList=us,fr\nUse=true\nVariantList=,oss\n\n[Other]\nKey=value\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := setKxkbrcKeys(tt.data, keys); got != tt.expected {
+			t.Errorf("%s: expected\n%q\ngot\n%q", tt.name, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// LinuxBackends returns the Linux layout backends, best first: the
+// compositor and desktop specific ones, then X11 through XKB and setxkbmap
+func LinuxBackends() []Backend {
+	return []Backend{
+		{Name: "sway", Probe: probeSway, New: newSwayBackend},
+		{Name: "hyprland", Probe: probeHyprland, New: newHyprlandBackend},
+		{Name: "kde", Probe: probeKDE, New: newKDEBackend},
+		{Name: "gnome", Probe: probeGNOME, New: newGNOMEBackend},
+		{Name: "x11", Probe: probeX11, New: newX11Backend},
+		{Name: "setxkbmap", Probe: probeSetxkbmap, New: newSetxkbmapBackend},
+	}
+}
+
+func probeSway(env *Environment) (bool, string) {
+	socket := env.Getenv("SWAYSOCK")
+	if socket == "" {
+		return false, "$SWAYSOCK is not set"
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return false, fmt.Sprintf("$SWAYSOCK is set but %s is missing", socket)
+	}
+	return true, "$SWAYSOCK is set"
+}
+
+func newSwayBackend(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error) {
+	socket := env.Getenv("SWAYSOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("$SWAYSOCK is not set")
+	}
+	return NewSwayLayoutSwitcher(socket), nil
+}
+
+func probeHyprland(env *Environment) (bool, string) {
+	if _, ok := hyprlandSocketDir(env); !ok {
+		return false, "$HYPRLAND_INSTANCE_SIGNATURE is not set"
+	}
+	return true, "$HYPRLAND_INSTANCE_SIGNATURE is set"
+}
+
+func newHyprlandBackend(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error) {
+	dir, ok := hyprlandSocketDir(env)
+	if !ok {
+		return nil, fmt.Errorf("$HYPRLAND_INSTANCE_SIGNATURE is not set")
+	}
+	return NewHyprlandLayoutSwitcher(dir, events), nil
+}
+
+func probeKDE(env *Environment) (bool, string) {
+	if !env.desktop("KDE") {
+		return false, "$XDG_CURRENT_DESKTOP is not KDE"
+	}
+	return probeBusNames(env, "Plasma session", kdeBusName)
+}
+
+func newKDEBackend(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error) {
+	conn, err := env.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	configPath, err := kdeConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKDELayoutSwitcher(conn, configPath, events), nil
+}
+
+func probeGNOME(env *Environment) (bool, string) {
+	if !env.desktop("GNOME") {
+		return false, "$XDG_CURRENT_DESKTOP is not GNOME"
+	}
+	return probeBusNames(env, "GNOME session", dconfBusName, portalBusName)
+}
+
+func newGNOMEBackend(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error) {
+	conn, err := env.SessionBus()
+	if err != nil {
+		return nil, err
+	}
+	return NewGNOMELayoutSwitcher(conn), nil
+}
+
+// probeBusNames checks that the services a desktop backend talks to are on
+// the session bus
+func probeBusNames(env *Environment, session string, names ...string) (bool, string) {
+	for _, name := range names {
+		found, err := env.hasBusName(name)
+		if err != nil {
+			return false, fmt.Sprintf("%s, but the session bus is unavailable: %v", session, err)
+		}
+		if !found {
+			return false, fmt.Sprintf("%s, but %s is not on the session bus", session, name)
+		}
+	}
+	return true, session
+}
+
+// x11ProbeTimeout bounds connecting to the X server while probing
+const x11ProbeTimeout = time.Second
+
+func probeX11(env *Environment) (bool, string) {
+	if available, reason := probeX11Session(env, "the X server"); !available {
+		return false, reason
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), x11ProbeTimeout)
+	defer cancel()
+
+	conn, err := dialX11(ctx, env.Getenv("DISPLAY"))
+	if err != nil {
+		return false, err.Error()
+	}
+	defer conn.Close()
+
+	if _, err := useXKB(ctx, conn); err != nil {
+		return false, err.Error()
+	}
+	return true, "X11 session with the XKB extension"
+}
+
+func newX11Backend(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error) {
+	display := env.Getenv("DISPLAY")
+	if display == "" {
+		return nil, fmt.Errorf("$DISPLAY is not set")
+	}
+	return NewX11LayoutSwitcher(display, xkbConfigRoot(env), events), nil
+}
+
+func probeSetxkbmap(env *Environment) (bool, string) {
+	if _, err := env.LookPath("setxkbmap"); err != nil {
+		return false, "setxkbmap is not installed"
+	}
+	if available, reason := probeX11Session(env, "setxkbmap"); !available {
+		return false, reason
+	}
+	return true, "X11 session with setxkbmap installed"
+}
+
+// probeX11Session checks that polykeys runs in an X11 session, as changing
+// the X keymap under Wayland only affects X11 applications
+func probeX11Session(env *Environment, what string) (bool, string) {
+	if env.Getenv("DISPLAY") == "" {
+		return false, "$DISPLAY is not set"
+	}
+	if env.Getenv("WAYLAND_DISPLAY") != "" || env.Getenv("XDG_SESSION_TYPE") == "wayland" {
+		return false, fmt.Sprintf("under Wayland %s only affects X11 applications", what)
+	}
+	return true, "X11 session"
+}
+
+func newSetxkbmapBackend(env *Environment, events *domain.EventBus) (domain.LayoutSwitcher, error) {
+	return NewLinuxLayoutSwitcher(), nil
+}
@@ -10,46 +10,43 @@ import (
 )
 
 // LinuxLayoutSwitcher switches keyboard layouts on Linux using setxkbmap
-type LinuxLayoutSwitcher struct{}
+type LinuxLayoutSwitcher struct {
+	options xkbOptionBaseline
+}
 
 // NewLinuxLayoutSwitcher creates a new Linux layout switcher
 func NewLinuxLayoutSwitcher() *LinuxLayoutSwitcher {
 	return &LinuxLayoutSwitcher{}
 }
 
-// SwitchLayout changes the system keyboard layout
+// SwitchLayout changes the system keyboard layout. Only the layout of the
+// first group changes unless the layout has several groups: the rules, the
+// model and the other groups reported by setxkbmap -query are kept unless
+// the layout sets them, and the options in use before the first switch are
+// changed as the layout asks.
 func (s *LinuxLayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
-	if layout.OS != domain.OSLinux {
-		return errors.WithDetails(
-			errors.New(errors.ErrCodeLayoutInvalidOS, "layout is not for Linux"),
-			map[string]interface{}{
-				"layout": layout.Name,
-				"os":     layout.OS,
-			},
-		)
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
 	}
 
-	// Get the setxkbmap identifier
-	identifier := s.getSetxkbmapIdentifier(layout)
-
-	// Split identifier into command parts (e.g., "us -variant intl")
-	parts := strings.Fields(identifier)
-	if len(parts) == 0 {
+	output, err := exec.CommandContext(ctx, "setxkbmap", "-query").CombinedOutput()
+	if err != nil {
 		return errors.WithDetails(
-			errors.New(errors.ErrCodeLayoutInvalidIdentifier, "invalid layout identifier"),
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to query the keyboard layout", err),
 			map[string]interface{}{
-				"layout":     layout.Name,
-				"identifier": identifier,
+				"layout": layout.Name,
+				"output": string(output),
 			},
 		)
 	}
 
+	names := setxkbmapNames(parseSetxkbmapQuery(string(output)), layout, &s.options)
+
 	// Build setxkbmap command
-	args := append([]string{parts[0]}, parts[1:]...)
-	cmd := exec.CommandContext(ctx, "setxkbmap", args...)
+	cmd := exec.CommandContext(ctx, "setxkbmap", setxkbmapArgs(names)...)
 
 	// Execute command
-	output, err := cmd.CombinedOutput()
+	output, err = cmd.CombinedOutput()
 	if err != nil {
 		return errors.WithDetails(
 			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
@@ -63,13 +60,63 @@ func (s *LinuxLayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.K
 	return nil
 }
 
-// getSetxkbmapIdentifier returns the setxkbmap identifier for a layout
-func (s *LinuxLayoutSwitcher) getSetxkbmapIdentifier(layout *domain.KeyboardLayout) string {
-	// Use the system identifier from the layout (set by the repository)
-	if layout.SystemIdentifier != "" {
-		return layout.SystemIdentifier
+// parseSetxkbmapQuery reads the names printed by setxkbmap -query, such as
+// "layout:     us,fr"
+func parseSetxkbmapQuery(output string) xkbNames {
+	var names xkbNames
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "rules":
+			names.Rules = value
+		case "model":
+			names.Model = value
+		case "layout":
+			names.Layout = value
+		case "variant":
+			names.Variant = value
+		case "options":
+			names.Options = value
+		}
+	}
+	return names
+}
+
+// setxkbmapNames returns names with a layout in the first group, which is
+// the active one after setxkbmap reloads the keymap. A group already having
+// the layout trades places with the first one.
+func setxkbmapNames(names xkbNames, layout *domain.KeyboardLayout, baseline *xkbOptionBaseline) xkbNames {
+	name, variant := xkbLayout(layout)
+	names = xkbLayoutNames(names, layout, baseline)
+
+	if group := xkbGroupIndex(names, name, variant); group > 0 {
+		layouts := splitList(names.Layout)
+		variants := padList(splitList(names.Variant), len(layouts))
+		names, _ = xkbSetGroup(names, group, layouts[0], variants[0])
 	}
 
-	// Fallback to "us" if no identifier is set
-	return "us"
+	names, _ = xkbSetGroup(names, 0, name, variant)
+	return names
+}
+
+// setxkbmapArgs returns the arguments making setxkbmap load names. The
+// options are cleared first, as setxkbmap adds to the current ones.
+func setxkbmapArgs(names xkbNames) []string {
+	args := make([]string, 0, 12)
+	if names.Rules != "" {
+		args = append(args, "-rules", names.Rules)
+	}
+	if names.Model != "" {
+		args = append(args, "-model", names.Model)
+	}
+	args = append(args, "-layout", names.Layout, "-variant", names.Variant, "-option", "")
+	if names.Options != "" {
+		args = append(args, "-option", names.Options)
+	}
+	return args
 }
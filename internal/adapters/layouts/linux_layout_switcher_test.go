@@ -0,0 +1,112 @@
+package layouts
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+func TestParseSetxkbmapQuery(t *testing.T) {
+	output := `rules:      evdev
+model:      pc105
+layout:     us,ru
+variant:    ,phonetic
+options:    ctrl:nocaps,compose:ralt
+`
+
+	names := parseSetxkbmapQuery(output)
+
+	expected := xkbNames{"evdev", "pc105", "us,ru", ",phonetic", "ctrl:nocaps,compose:ralt"}
+	if names != expected {
+		t.Errorf("Expected %+v, got %+v", expected, names)
+	}
+}
+
+func TestSetxkbmapNames(t *testing.T) {
+	current := xkbNames{"evdev", "pc105", "us,ru", ",phonetic", "ctrl:nocaps,compose:ralt"}
+
+	tests := []struct {
+		name     string
+		layout   *domain.KeyboardLayout
+		expected xkbNames
+	}{
+		{
+			name:     "replaces the first group",
+			layout:   domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr"),
+			expected: xkbNames{"evdev", "pc105", "fr,ru", ",phonetic", "ctrl:nocaps,compose:ralt"},
+		},
+		{
+			name:     "moves an existing group first",
+			layout:   domain.NewKeyboardLayout(domain.LayoutRussian, domain.OSLinux, "ru -variant phonetic"),
+			expected: xkbNames{"evdev", "pc105", "ru,us", "phonetic,", "ctrl:nocaps,compose:ralt"},
+		},
+		{
+			name: "changes options",
+			layout: domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us").WithOptions(domain.LayoutOptions{
+				Add:    []string{"ctrl:swapcaps"},
+				Remove: []string{"ctrl:nocaps"},
+			}),
+			expected: xkbNames{"evdev", "pc105", "us,ru", ",phonetic", "compose:ralt,ctrl:swapcaps"},
+		},
+		{
+			name:     "sets the groups and model",
+			layout:   domain.NewKeyboardLayout("Greek", domain.OSLinux, "gr,us -variant polytonic, -model pc104"),
+			expected: xkbNames{"evdev", "pc104", "gr,us", "polytonic,", "ctrl:nocaps,compose:ralt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := setxkbmapNames(current, tt.layout, &xkbOptionBaseline{})
+			if names != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestSetxkbmapNames_NoLayout(t *testing.T) {
+	names := setxkbmapNames(xkbNames{}, domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl"), &xkbOptionBaseline{})
+
+	expected := xkbNames{Layout: "us", Variant: "intl"}
+	if names != expected {
+		t.Errorf("Expected %+v, got %+v", expected, names)
+	}
+}
+
+func TestSetxkbmapNames_OutsideOptionChange(t *testing.T) {
+	baseline := &xkbOptionBaseline{}
+	withOptions := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us").WithOptions(domain.LayoutOptions{
+		Add: []string{"compose:ralt"},
+	})
+	withoutOptions := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+
+	names := setxkbmapNames(xkbNames{Layout: "fr", Options: "ctrl:nocaps"}, withOptions, baseline)
+	if names.Options != "ctrl:nocaps,compose:ralt" {
+		t.Fatalf("Expected the layout options to be added, got %q", names.Options)
+	}
+
+	// The user replaces the options with setxkbmap -option
+	names.Options = "caps:swapescape"
+
+	names = setxkbmapNames(names, withOptions, baseline)
+	if names.Options != "caps:swapescape,compose:ralt" {
+		t.Errorf("Expected the options set outside polykeys to be the baseline, got %q", names.Options)
+	}
+
+	// The options polykeys set are not taken for outside changes
+	names = setxkbmapNames(names, withoutOptions, baseline)
+	if names.Options != "caps:swapescape" {
+		t.Errorf("Expected the new baseline to be restored, got %q", names.Options)
+	}
+}
+
+func TestSetxkbmapArgs(t *testing.T) {
+	args := setxkbmapArgs(xkbNames{"evdev", "pc105", "fr,ru", "", "ctrl:nocaps"})
+
+	expected := []string{"-rules", "evdev", "-model", "pc105", "-layout", "fr,ru", "-variant", "", "-option", "", "-option", "ctrl:nocaps"}
+	if !slices.Equal(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+}
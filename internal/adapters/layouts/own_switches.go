@@ -0,0 +1,31 @@
+package layouts
+
+import (
+	"sync"
+	"time"
+)
+
+// ownSwitchWindow is how long layout change notifications are attributed to
+// a switch made by polykeys rather than by the user
+const ownSwitchWindow = time.Second
+
+// ownSwitches tells the layout changes polykeys causes apart from manual
+// ones, for backends that notify both alike
+type ownSwitches struct {
+	last time.Time
+	mu   sync.Mutex
+}
+
+// mark records that polykeys is switching the layout
+func (o *ownSwitches) mark() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.last = time.Now()
+}
+
+// recent returns true if polykeys switched the layout moments ago
+func (o *ownSwitches) recent() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return time.Since(o.last) < ownSwitchWindow
+}
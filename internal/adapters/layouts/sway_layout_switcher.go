@@ -0,0 +1,421 @@
+package layouts
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+)
+
+// Sway IPC message types
+const (
+	swayRunCommand uint32 = 0
+	swayGetConfig  uint32 = 9
+	swayGetInputs  uint32 = 100
+)
+
+// swayMagic starts every sway IPC message
+const swayMagic = "i3-ipc"
+
+// swayTimeout bounds a request when the context has no deadline
+const swayTimeout = 5 * time.Second
+
+// SwayLayoutSwitcher switches keyboard layouts on sway and other compositors
+// speaking the sway IPC protocol, through the socket named by $SWAYSOCK
+type SwayLayoutSwitcher struct {
+	socketPath string
+	// changedOptions are the input identifiers whose options polykeys
+	// changed, to be set back to the configured ones
+	changedOptions map[string]bool
+	mu             sync.Mutex
+}
+
+// swayInput is an input device as reported by get_inputs
+type swayInput struct {
+	Identifier          string   `json:"identifier"`
+	Name                string   `json:"name"`
+	Vendor              int      `json:"vendor"`
+	Product             int      `json:"product"`
+	Type                string   `json:"type"`
+	XkbActiveLayoutName string   `json:"xkb_active_layout_name"`
+	XkbLayoutNames      []string `json:"xkb_layout_names"`
+}
+
+// swayConfig is the reply of get_config, with the text of the loaded config
+// and, since sway 1.9, of the files it includes
+type swayConfig struct {
+	Config          string `json:"config"`
+	IncludedConfigs []struct {
+		RawContents string `json:"raw_contents"`
+	} `json:"included_configs"`
+}
+
+// swayCommandResult is the outcome of one command of a run_command request
+type swayCommandResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// NewSwayLayoutSwitcher creates a layout switcher talking to the sway IPC
+// socket at socketPath
+func NewSwayLayoutSwitcher(socketPath string) *SwayLayoutSwitcher {
+	return &SwayLayoutSwitcher{
+		socketPath:     socketPath,
+		changedOptions: make(map[string]bool),
+	}
+}
+
+// SwitchLayout changes the layout of every keyboard
+func (s *SwayLayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	if err := s.setLayout(ctx, "type:keyboard", layout); err != nil {
+		return err
+	}
+
+	s.logActiveLayouts(ctx)
+	return nil
+}
+
+// SwitchDeviceLayout changes the layout of the inputs of a single keyboard.
+// Sway identifies inputs by vendor, product and name, so identical keyboards
+// share their layout.
+func (s *SwayLayoutSwitcher) SwitchDeviceLayout(ctx context.Context, layout *domain.KeyboardLayout, device *domain.Device) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	inputs, err := s.getInputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	identifiers := swayIdentifiersForDevice(inputs, device)
+	if len(identifiers) == 0 {
+		return errors.WithDetails(
+			errors.New(errors.ErrCodeDeviceNotFound, "keyboard not known to sway"),
+			map[string]any{
+				"device": device.InstanceID(),
+			},
+		)
+	}
+
+	for _, identifier := range identifiers {
+		if err := s.setLayout(ctx, identifier, layout); err != nil {
+			return err
+		}
+	}
+
+	s.logActiveLayouts(ctx)
+	return nil
+}
+
+// setLayout sets the layout of the inputs matching identifier. The variant
+// is cleared first so that the intermediate keymap always compiles. Sway
+// does not report the options in use, so the option changes of a layout are
+// made to the options of the sway config.
+func (s *SwayLayoutSwitcher) setLayout(ctx context.Context, identifier string, layout *domain.KeyboardLayout) error {
+	layouts, variants := xkbGroupLists(xkbGroups(layout))
+	target := swayQuote(identifier)
+
+	options, setOptions, err := s.layoutOptions(ctx, identifier, layout)
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read the XKB options of the sway config", err),
+			map[string]any{
+				"layout": layout.Name,
+				"input":  identifier,
+			},
+		)
+	}
+
+	commands := []string{
+		fmt.Sprintf("input %s xkb_variant %s", target, swayQuote("")),
+		fmt.Sprintf("input %s xkb_layout %s", target, swayQuote(layouts)),
+	}
+	if variants != "" {
+		commands = append(commands, fmt.Sprintf("input %s xkb_variant %s", target, swayQuote(variants)))
+	}
+	if layout.Model != "" {
+		commands = append(commands, fmt.Sprintf("input %s xkb_model %s", target, swayQuote(layout.Model)))
+	}
+	if setOptions {
+		commands = append(commands, fmt.Sprintf("input %s xkb_options %s", target, swayQuote(strings.Join(options, ","))))
+	}
+
+	if err := s.runCommand(ctx, strings.Join(commands, "; ")); err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+			map[string]any{
+				"layout": layout.Name,
+				"input":  identifier,
+			},
+		)
+	}
+
+	if setOptions {
+		s.mu.Lock()
+		s.changedOptions[identifier] = !layout.Options.IsZero()
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// layoutOptions returns the options of the sway config for identifier with
+// the changes of a layout made, and false when the options need not be set:
+// the layout changes none, and polykeys has not changed them before
+func (s *SwayLayoutSwitcher) layoutOptions(ctx context.Context, identifier string, layout *domain.KeyboardLayout) ([]string, bool, error) {
+	s.mu.Lock()
+	changed := s.changedOptions[identifier]
+	s.mu.Unlock()
+
+	if layout.Options.IsZero() && !changed {
+		return nil, false, nil
+	}
+
+	reply, err := s.request(ctx, swayGetConfig, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var config swayConfig
+	if err := json.Unmarshal(reply, &config); err != nil {
+		return nil, false, fmt.Errorf("invalid get_config reply: %w", err)
+	}
+
+	configs := []string{config.Config}
+	for _, included := range config.IncludedConfigs {
+		configs = append(configs, included.RawContents)
+	}
+
+	return layout.Options.Apply(swayConfigOptions(configs, identifier)), true, nil
+}
+
+// runCommand runs sway commands, failing if any of them failed
+func (s *SwayLayoutSwitcher) runCommand(ctx context.Context, command string) error {
+	logger.Debug("[Sway] run_command: %s\n", command)
+
+	reply, err := s.request(ctx, swayRunCommand, []byte(command))
+	if err != nil {
+		return err
+	}
+
+	var results []swayCommandResult
+	if err := json.Unmarshal(reply, &results); err != nil {
+		// Sway answers a command it could not parse with a single object
+		var result swayCommandResult
+		if err := json.Unmarshal(reply, &result); err != nil {
+			return fmt.Errorf("invalid run_command reply: %w", err)
+		}
+		results = []swayCommandResult{result}
+	}
+
+	for _, result := range results {
+		if !result.Success {
+			return fmt.Errorf("sway command failed: %s", result.Error)
+		}
+	}
+
+	return nil
+}
+
+// getInputs returns the input devices known to sway
+func (s *SwayLayoutSwitcher) getInputs(ctx context.Context) ([]swayInput, error) {
+	reply, err := s.request(ctx, swayGetInputs, nil)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to list sway inputs", err)
+	}
+
+	var inputs []swayInput
+	if err := json.Unmarshal(reply, &inputs); err != nil {
+		return nil, errors.Wrap(errors.ErrCodeLayoutSelectFailed, "invalid get_inputs reply", err)
+	}
+
+	return inputs, nil
+}
+
+// logActiveLayouts reads back the layout of each keyboard for debugging
+func (s *SwayLayoutSwitcher) logActiveLayouts(ctx context.Context) {
+	if !logger.IsDebug() {
+		return
+	}
+
+	inputs, err := s.getInputs(ctx)
+	if err != nil {
+		logger.Debug("[Sway] Could not read back layouts: %v\n", err)
+		return
+	}
+
+	for _, input := range inputs {
+		if input.Type == "keyboard" {
+			logger.Debug("[Sway] %s: %s\n", input.Identifier, input.XkbActiveLayoutName)
+		}
+	}
+}
+
+// request sends an IPC message and returns the payload of the reply
+func (s *SwayLayoutSwitcher) request(ctx context.Context, messageType uint32, payload []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to sway: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(swayTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if err := writeSwayMessage(conn, messageType, payload); err != nil {
+		return nil, fmt.Errorf("failed to send sway request: %w", err)
+	}
+
+	replyType, reply, err := readSwayMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sway reply: %w", err)
+	}
+	if replyType != messageType {
+		return nil, fmt.Errorf("unexpected sway reply type %d to request %d", replyType, messageType)
+	}
+
+	return reply, nil
+}
+
+// writeSwayMessage writes the magic string, the payload length and type in
+// native byte order, then the payload
+func writeSwayMessage(w io.Writer, messageType uint32, payload []byte) error {
+	header := make([]byte, len(swayMagic)+8)
+	copy(header, swayMagic)
+	binary.NativeEndian.PutUint32(header[len(swayMagic):], uint32(len(payload)))
+	binary.NativeEndian.PutUint32(header[len(swayMagic)+4:], messageType)
+
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readSwayMessage reads a message written by writeSwayMessage
+func readSwayMessage(r io.Reader) (uint32, []byte, error) {
+	header := make([]byte, len(swayMagic)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	if string(header[:len(swayMagic)]) != swayMagic {
+		return 0, nil, fmt.Errorf("invalid magic %q", header[:len(swayMagic)])
+	}
+
+	length := binary.NativeEndian.Uint32(header[len(swayMagic):])
+	messageType := binary.NativeEndian.Uint32(header[len(swayMagic)+4:])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return messageType, payload, nil
+}
+
+// swayIdentifiersForDevice returns the identifiers of the keyboard inputs
+// belonging to a device, matched on vendor and product IDs
+func swayIdentifiersForDevice(inputs []swayInput, device *domain.Device) []string {
+	vendor, product, ok := usbIDs(device)
+	if !ok {
+		return nil
+	}
+
+	identifiers := make([]string, 0)
+	for _, input := range inputs {
+		if input.Type != "keyboard" {
+			continue
+		}
+		if input.Vendor == int(vendor) && input.Product == int(product) {
+			identifiers = append(identifiers, input.Identifier)
+		}
+	}
+
+	return identifiers
+}
+
+// swayConfigOptions returns the xkb_options a sway config gives the inputs
+// matching identifier. Settings for the identifier win over those for
+// type:keyboard, which win over those for every input, and among the same
+// ones the last wins.
+func swayConfigOptions(configs []string, identifier string) []string {
+	rank := map[string]int{"*": 1, "type:keyboard": 2}
+	rank[identifier] = 3
+
+	var options []string
+	best := 0
+	for _, config := range configs {
+		block := ""
+		for _, line := range strings.Split(config, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+
+			// Either "input ID xkb_options ..." or a line of an "input ID {" block
+			input, directive := block, fields
+			switch {
+			case block != "" && fields[0] == "}":
+				block = ""
+				continue
+			case block == "" && fields[0] == "input" && len(fields) == 3 && fields[2] == "{":
+				block = swayUnquote(fields[1])
+				continue
+			case block == "" && fields[0] == "input" && len(fields) > 2:
+				input, directive = swayUnquote(fields[1]), fields[2:]
+			case block == "":
+				continue
+			}
+
+			if directive[0] != "xkb_options" || len(directive) < 2 || rank[input] == 0 || rank[input] < best {
+				continue
+			}
+			best = rank[input]
+			options = splitList(swayUnquote(strings.Join(directive[1:], " ")))
+		}
+	}
+
+	return options
+}
+
+// swayUnquote removes the quotes around a config value
+func swayUnquote(value string) string {
+	return strings.Trim(value, `"'`)
+}
+
+// swayQuote quotes a command argument
+func swayQuote(value string) string {
+	return strconv.Quote(value)
+}
+
+// checkLinuxLayout rejects layouts meant for another OS
+func checkLinuxLayout(layout *domain.KeyboardLayout) error {
+	if layout.OS != domain.OSLinux {
+		return errors.WithDetails(
+			errors.New(errors.ErrCodeLayoutInvalidOS, "layout is not for Linux"),
+			map[string]any{
+				"layout": layout.Name,
+				"os":     layout.OS,
+			},
+		)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package layouts
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// fakeSway answers sway IPC requests on a unix socket, recording commands
+type fakeSway struct {
+	listener net.Listener
+	inputs   []swayInput
+	config   string
+	fail     string
+	commands []string
+	mu       sync.Mutex
+}
+
+func newFakeSway(t *testing.T, inputs []swayInput) *fakeSway {
+	t.Helper()
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "sway-ipc.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	sway := &fakeSway{listener: listener, inputs: inputs}
+	go sway.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return sway
+}
+
+func (f *fakeSway) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeSway) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		messageType, payload, err := readSwayMessage(conn)
+		if err != nil {
+			return
+		}
+
+		var reply any
+		switch messageType {
+		case swayRunCommand:
+			f.mu.Lock()
+			results := make([]swayCommandResult, 0)
+			for _, command := range strings.Split(string(payload), "; ") {
+				f.commands = append(f.commands, command)
+				if f.fail != "" && strings.Contains(command, f.fail) {
+					results = append(results, swayCommandResult{Error: "Failed to compile keymap"})
+				} else {
+					results = append(results, swayCommandResult{Success: true})
+				}
+			}
+			f.mu.Unlock()
+			reply = results
+		case swayGetConfig:
+			reply = swayConfig{Config: f.config}
+		case swayGetInputs:
+			reply = f.inputs
+		}
+
+		data, _ := json.Marshal(reply)
+		if err := writeSwayMessage(conn, messageType, data); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeSway) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeSway) path() string {
+	return f.listener.Addr().String()
+}
+
+var swayTestInputs = []swayInput{
+	{Identifier: "1:1:AT_Translated_Set_2_keyboard", Name: "AT Translated Set 2 keyboard", Vendor: 1, Product: 1, Type: "keyboard"},
+	{Identifier: "18003:4:foostan_Corne", Name: "foostan Corne", Vendor: 0x4653, Product: 0x0004, Type: "keyboard"},
+	{Identifier: "18003:4:foostan_Corne_Consumer_Control", Name: "foostan Corne Consumer Control", Vendor: 0x4653, Product: 0x0004, Type: "keyboard"},
+	{Identifier: "18003:4:foostan_Corne_Mouse", Name: "foostan Corne Mouse", Vendor: 0x4653, Product: 0x0004, Type: "pointer"},
+}
+
+func expectCommands(t *testing.T, got []string, expected ...string) {
+	t.Helper()
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected commands:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestSwayLayoutSwitcher_SwitchLayout(t *testing.T) {
+	sway := newFakeSway(t, swayTestInputs)
+	switcher := NewSwayLayoutSwitcher(sway.path())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutColemak, domain.OSLinux, "us -variant colemak")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	expectCommands(t, sway.received(),
+		`input "type:keyboard" xkb_variant ""`,
+		`input "type:keyboard" xkb_layout "us"`,
+		`input "type:keyboard" xkb_variant "colemak"`,
+	)
+}
+
+func TestSwayLayoutSwitcher_SwitchLayoutGroups(t *testing.T) {
+	sway := newFakeSway(t, swayTestInputs)
+	switcher := NewSwayLayoutSwitcher(sway.path())
+
+	layout := domain.NewXKBKeyboardLayout("us,ru(phonetic)",
+		[]domain.XKBGroup{{Layout: "us"}, {Layout: "ru", Variant: "phonetic"}},
+		"pc104", domain.LayoutOptions{Add: []string{"compose:ralt"}})
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	expectCommands(t, sway.received(),
+		`input "type:keyboard" xkb_variant ""`,
+		`input "type:keyboard" xkb_layout "us,ru"`,
+		`input "type:keyboard" xkb_variant ",phonetic"`,
+		`input "type:keyboard" xkb_model "pc104"`,
+		`input "type:keyboard" xkb_options "compose:ralt"`,
+	)
+}
+
+func TestSwayLayoutSwitcher_RestoresOptions(t *testing.T) {
+	sway := newFakeSway(t, swayTestInputs)
+	sway.config = "input type:keyboard {\n    xkb_layout fr\n    xkb_options ctrl:nocaps\n}\n"
+	switcher := NewSwayLayoutSwitcher(sway.path())
+
+	withOptions := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us").WithOptions(domain.LayoutOptions{
+		Add:    []string{"compose:ralt"},
+		Remove: []string{"ctrl:nocaps"},
+	})
+	withoutOptions := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+
+	for _, layout := range []*domain.KeyboardLayout{withOptions, withoutOptions, withoutOptions} {
+		if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+			t.Fatalf("Failed to switch to %s: %v", layout.Name, err)
+		}
+	}
+
+	// The configured options are set back once, then left alone
+	expectCommands(t, sway.received(),
+		`input "type:keyboard" xkb_variant ""`,
+		`input "type:keyboard" xkb_layout "us"`,
+		`input "type:keyboard" xkb_options "compose:ralt"`,
+		`input "type:keyboard" xkb_variant ""`,
+		`input "type:keyboard" xkb_layout "fr"`,
+		`input "type:keyboard" xkb_options "ctrl:nocaps"`,
+		`input "type:keyboard" xkb_variant ""`,
+		`input "type:keyboard" xkb_layout "fr"`,
+	)
+}
+
+func TestSwayConfigOptions(t *testing.T) {
+	config := `# input * xkb_options caps:none
+input * xkb_options grp:alt_shift_toggle
+input type:keyboard {
+    xkb_layout us
+    xkb_options "ctrl:nocaps,compose:ralt"
+}
+input "18003:4:foostan_Corne" xkb_options caps:escape
+input type:pointer xkb_options ignored:option
+`
+	included := "input type:keyboard xkb_options ctrl:swapcaps\n"
+
+	tests := []struct {
+		identifier string
+		configs    []string
+		expected   []string
+	}{
+		{"type:keyboard", []string{config}, []string{"ctrl:nocaps", "compose:ralt"}},
+		{"18003:4:foostan_Corne", []string{config}, []string{"caps:escape"}},
+		{"1:1:AT_Translated_Set_2_keyboard", []string{config}, []string{"ctrl:nocaps", "compose:ralt"}},
+		{"type:keyboard", []string{config, included}, []string{"ctrl:swapcaps"}},
+		{"type:keyboard", []string{"input * xkb_options grp:alt_shift_toggle\n"}, []string{"grp:alt_shift_toggle"}},
+		{"type:keyboard", []string{""}, nil},
+	}
+
+	for _, tt := range tests {
+		if options := swayConfigOptions(tt.configs, tt.identifier); !slices.Equal(options, tt.expected) {
+			t.Errorf("%s: expected %q, got %q", tt.identifier, tt.expected, options)
+		}
+	}
+}
+
+func TestSwayLayoutSwitcher_SwitchDeviceLayout(t *testing.T) {
+	sway := newFakeSway(t, swayTestInputs)
+	switcher := NewSwayLayoutSwitcher(sway.path())
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	// The pointer and the laptop keyboard are left alone
+	expectCommands(t, sway.received(),
+		`input "18003:4:foostan_Corne" xkb_variant ""`,
+		`input "18003:4:foostan_Corne" xkb_layout "fr"`,
+		`input "18003:4:foostan_Corne_Consumer_Control" xkb_variant ""`,
+		`input "18003:4:foostan_Corne_Consumer_Control" xkb_layout "fr"`,
+	)
+}
+
+func TestSwayLayoutSwitcher_UnknownDevice(t *testing.T) {
+	sway := newFakeSway(t, swayTestInputs)
+	switcher := NewSwayLayoutSwitcher(sway.path())
+
+	lily := domain.NewDevice("1209", "bb58", "Lily58")
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, lily); err == nil {
+		t.Error("Expected an error for a keyboard sway does not know")
+	}
+
+	if got := sway.received(); len(got) != 0 {
+		t.Errorf("Expected no command, got %v", got)
+	}
+}
+
+func TestSwayLayoutSwitcher_CommandFailure(t *testing.T) {
+	sway := newFakeSway(t, swayTestInputs)
+	sway.fail = "xkb_layout"
+	switcher := NewSwayLayoutSwitcher(sway.path())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	err := switcher.SwitchLayout(context.Background(), layout)
+	if err == nil {
+		t.Fatal("Expected the failed command to be reported")
+	}
+	if !strings.Contains(err.Error(), "Failed to compile keymap") {
+		t.Errorf("Expected sway's error in %q", err)
+	}
+}
+
+func TestSwayLayoutSwitcher_WrongOS(t *testing.T) {
+	switcher := NewSwayLayoutSwitcher(filepath.Join(t.TempDir(), "missing.sock"))
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSWindows, "0000040c")
+	if err := switcher.SwitchLayout(context.Background(), layout); err == nil {
+		t.Error("Expected a Windows layout to be rejected")
+	}
+}
+
+func TestXkbLayout(t *testing.T) {
+	tests := []struct {
+		identifier string
+		name       string
+		variant    string
+	}{
+		{"us", "us", ""},
+		{"us -variant intl", "us", "intl"},
+		{"gr,us -variant polytonic, -model pc104", "gr", "polytonic"},
+		{"", "us", ""},
+	}
+
+	for _, tt := range tests {
+		name, variant := xkbLayout(domain.NewKeyboardLayout("Test", domain.OSLinux, tt.identifier))
+		if name != tt.name || variant != tt.variant {
+			t.Errorf("%q: expected %s/%s, got %s/%s", tt.identifier, tt.name, tt.variant, name, variant)
+		}
+	}
+}
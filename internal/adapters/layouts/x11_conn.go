@@ -0,0 +1,488 @@
+package layouts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+)
+
+// X11 core requests used by polykeys
+const (
+	x11InternAtom     = 16
+	x11ChangeProperty = 18
+	x11GetProperty    = 20
+	x11GetInputFocus  = 43
+	x11QueryExtension = 98
+)
+
+// Predefined X11 atoms
+const (
+	x11AtomString = 31
+)
+
+// x11GenericEvent is the event type of extension events with a length, such
+// as XInput2 ones
+const x11GenericEvent = 35
+
+// x11Timeout bounds a request when the context has no deadline
+const x11Timeout = 5 * time.Second
+
+// x11Byte is the byte order polykeys speaks to the X server in
+var x11Byte = binary.LittleEndian
+
+// x11Error is an error reply of the X server
+type x11Error struct {
+	Code  uint8
+	Major uint8
+	Minor uint16
+}
+
+func (e *x11Error) Error() string {
+	return fmt.Sprintf("X error %d on request %d.%d", e.Code, e.Major, e.Minor)
+}
+
+// x11Extension is an X extension present on the server
+type x11Extension struct {
+	Major      uint8
+	FirstEvent uint8
+	FirstError uint8
+}
+
+// x11Response is a reply or an error of the X server
+type x11Response struct {
+	seq  uint16
+	data []byte
+	err  error
+}
+
+// x11Conn is a connection to an X server speaking just enough of the X11
+// protocol for keyboard layouts. Requests are sent one at a time, and events
+// are delivered on Events.
+type x11Conn struct {
+	conn      net.Conn
+	root      uint32
+	seq       uint16
+	responses chan x11Response
+	// Events receives the events of the server, and is closed with the
+	// connection
+	Events chan []byte
+	done   chan struct{}
+	err    error
+	mu     sync.Mutex
+}
+
+// dialX11 connects to the X server of display, such as ":0" or
+// "localhost:10.0"
+func dialX11(ctx context.Context, display string) (*x11Conn, error) {
+	network, address, number, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to X display %s: %w", display, err)
+	}
+
+	authName, authData := xauthority(network, address, number)
+
+	x, err := newX11Conn(ctx, conn, authName, authData)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set up X display %s: %w", display, err)
+	}
+
+	return x, nil
+}
+
+// parseDisplay returns where the X server of a display listens, and the
+// display number
+func parseDisplay(display string) (network, address, number string, err error) {
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return "", "", "", fmt.Errorf("invalid X display %q", display)
+	}
+
+	host := display[:colon]
+	number, _, _ = strings.Cut(display[colon+1:], ".")
+	if _, err := strconv.Atoi(number); err != nil {
+		return "", "", "", fmt.Errorf("invalid X display %q", display)
+	}
+
+	switch {
+	case host == "" || host == "unix":
+		return "unix", "/tmp/.X11-unix/X" + number, number, nil
+	case strings.HasPrefix(host, "/"):
+		// A socket path, as set by XQuartz
+		return "unix", host + ":" + number, number, nil
+	default:
+		port, _ := strconv.Atoi(number)
+		return "tcp", net.JoinHostPort(host, strconv.Itoa(6000+port)), number, nil
+	}
+}
+
+// Xauthority address families
+const (
+	xauthFamilyLocal = 256
+	xauthFamilyWild  = 65535
+)
+
+// xauthority returns the MIT-MAGIC-COOKIE-1 of a display from the
+// Xauthority file, or nothing if there is none
+func xauthority(network, address, number string) (string, []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(home, ".Xauthority")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+
+	host, _ := os.Hostname()
+	if network == "tcp" {
+		if h, _, err := net.SplitHostPort(address); err == nil && h != "localhost" && h != "127.0.0.1" {
+			host = h
+		}
+	}
+
+	reader := bytes.NewReader(data)
+	for reader.Len() > 0 {
+		var family uint16
+		if binary.Read(reader, binary.BigEndian, &family) != nil {
+			break
+		}
+		fields := make([][]byte, 4)
+		for i := range fields {
+			if fields[i], err = xauthField(reader); err != nil {
+				return "", nil
+			}
+		}
+
+		entryAddress, entryNumber, name, cookie := string(fields[0]), string(fields[1]), string(fields[2]), fields[3]
+		if family != xauthFamilyWild && (family != xauthFamilyLocal || entryAddress != host) {
+			continue
+		}
+		if entryNumber != "" && entryNumber != number {
+			continue
+		}
+		if name == "MIT-MAGIC-COOKIE-1" {
+			return name, cookie
+		}
+	}
+
+	return "", nil
+}
+
+func xauthField(reader io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	field := make([]byte, length)
+	_, err := io.ReadFull(reader, field)
+	return field, err
+}
+
+// newX11Conn sets up the X11 connection conn, and starts reading it
+func newX11Conn(ctx context.Context, conn net.Conn, authName string, authData []byte) (*x11Conn, error) {
+	ctx, cancel := x11Context(ctx)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+
+	setup := []byte{'l', 0}
+	setup = x11Byte.AppendUint16(setup, 11)
+	setup = x11Byte.AppendUint16(setup, 0)
+	setup = x11Byte.AppendUint16(setup, uint16(len(authName)))
+	setup = x11Byte.AppendUint16(setup, uint16(len(authData)))
+	setup = append(setup, 0, 0)
+	setup = x11Pad(append(setup, authName...))
+	setup = x11Pad(append(setup, authData...))
+
+	if _, err := conn.Write(setup); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	data := make([]byte, 4*int(x11Byte.Uint16(header[6:])))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	if header[0] != 1 {
+		reason := string(data[:min(int(header[1]), len(data))])
+		if header[0] == 2 {
+			reason = strings.TrimRight(string(data), "\x00")
+		}
+		return nil, fmt.Errorf("connection refused by the X server: %s", reason)
+	}
+
+	// Skip the vendor and the pixmap formats to reach the first screen
+	if len(data) < 32 {
+		return nil, fmt.Errorf("short X setup reply")
+	}
+	vendorLength := int(x11Byte.Uint16(data[16:]))
+	screens := 32 + x11PadLength(vendorLength) + 8*int(data[21])
+	if data[20] == 0 || len(data) < screens+4 {
+		return nil, fmt.Errorf("X server has no screen")
+	}
+
+	x := &x11Conn{
+		conn:      conn,
+		root:      x11Byte.Uint32(data[screens:]),
+		responses: make(chan x11Response, 1),
+		Events:    make(chan []byte, 64),
+		done:      make(chan struct{}),
+	}
+	go x.read()
+
+	return x, nil
+}
+
+// Close closes the connection
+func (x *x11Conn) Close() error {
+	return x.conn.Close()
+}
+
+// Done is closed when the connection is lost
+func (x *x11Conn) Done() <-chan struct{} {
+	return x.done
+}
+
+// read dispatches what the server sends until the connection is closed
+func (x *x11Conn) read() {
+	defer close(x.Events)
+	defer close(x.done)
+
+	for {
+		packet := make([]byte, 32)
+		if _, err := io.ReadFull(x.conn, packet); err != nil {
+			x.err = err
+			return
+		}
+
+		// Replies and generic events may be longer than 32 bytes
+		if packet[0] == 1 || packet[0]&0x7f == x11GenericEvent {
+			extra := make([]byte, 4*int(x11Byte.Uint32(packet[4:])))
+			if _, err := io.ReadFull(x.conn, extra); err != nil {
+				x.err = err
+				return
+			}
+			packet = append(packet, extra...)
+		}
+
+		switch packet[0] {
+		case 0:
+			x.respond(x11Response{
+				seq: x11Byte.Uint16(packet[2:]),
+				err: &x11Error{Code: packet[1], Major: packet[10], Minor: x11Byte.Uint16(packet[8:])},
+			})
+		case 1:
+			x.respond(x11Response{seq: x11Byte.Uint16(packet[2:]), data: packet})
+		default:
+			select {
+			case x.Events <- packet:
+			default:
+				logger.Debug("[X11] Dropping event %d, nobody reads them\n", packet[0])
+			}
+		}
+	}
+}
+
+// respond hands a reply or an error to the request waiting for it, dropping
+// those of requests that gave up
+func (x *x11Conn) respond(response x11Response) {
+	select {
+	case x.responses <- response:
+	case <-time.After(x11Timeout):
+		logger.Debug("[X11] Dropping the response to request %d\n", response.seq)
+	}
+}
+
+// request sends a request with a reply, and returns the reply
+func (x *x11Conn) request(ctx context.Context, opcode, data uint8, body []byte) ([]byte, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	seq, err := x.send(opcode, data, body)
+	if err != nil {
+		return nil, err
+	}
+	return x.wait(ctx, seq)
+}
+
+// call sends a request without a reply, and waits for the server to have
+// processed it so that its error is returned
+func (x *x11Conn) call(ctx context.Context, opcode, data uint8, body []byte) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	seq, err := x.send(opcode, data, body)
+	if err != nil {
+		return err
+	}
+
+	sync, err := x.send(x11GetInputFocus, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		response, err := x.next(ctx)
+		if err != nil {
+			return err
+		}
+		if response.seq == seq && response.err != nil {
+			return response.err
+		}
+		if response.seq == sync {
+			return response.err
+		}
+	}
+}
+
+// send writes a request, and returns its sequence number
+func (x *x11Conn) send(opcode, data uint8, body []byte) (uint16, error) {
+	request := []byte{opcode, data, 0, 0}
+	request = x11Pad(append(request, body...))
+	x11Byte.PutUint16(request[2:], uint16(len(request)/4))
+
+	if _, err := x.conn.Write(request); err != nil {
+		return 0, fmt.Errorf("failed to send X request: %w", err)
+	}
+
+	x.seq++
+	return x.seq, nil
+}
+
+// wait returns the reply of request seq
+func (x *x11Conn) wait(ctx context.Context, seq uint16) ([]byte, error) {
+	for {
+		response, err := x.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if response.seq == seq {
+			return response.data, response.err
+		}
+	}
+}
+
+// next returns the next reply or error
+func (x *x11Conn) next(ctx context.Context) (x11Response, error) {
+	ctx, cancel := x11Context(ctx)
+	defer cancel()
+
+	select {
+	case response := <-x.responses:
+		return response, nil
+	case <-x.done:
+		return x11Response{}, fmt.Errorf("X connection lost: %v", x.err)
+	case <-ctx.Done():
+		return x11Response{}, fmt.Errorf("X server did not answer: %w", ctx.Err())
+	}
+}
+
+// x11Context bounds ctx with x11Timeout unless it has a deadline
+func x11Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, x11Timeout)
+}
+
+// QueryExtension returns an extension of the server, or false if it is
+// missing
+func (x *x11Conn) QueryExtension(ctx context.Context, name string) (x11Extension, bool, error) {
+	reply, err := x.request(ctx, x11QueryExtension, 0, x11String16(name))
+	if err != nil {
+		return x11Extension{}, false, err
+	}
+
+	extension := x11Extension{Major: reply[9], FirstEvent: reply[10], FirstError: reply[11]}
+	return extension, reply[8] != 0, nil
+}
+
+// InternAtom returns the atom named name, creating it if needed
+func (x *x11Conn) InternAtom(ctx context.Context, name string) (uint32, error) {
+	reply, err := x.request(ctx, x11InternAtom, 0, x11String16(name))
+	if err != nil {
+		return 0, err
+	}
+	return x11Byte.Uint32(reply[8:]), nil
+}
+
+// GetProperty returns the value of a property of window, empty if it is not
+// set
+func (x *x11Conn) GetProperty(ctx context.Context, window, property uint32) ([]byte, error) {
+	body := x11Byte.AppendUint32(nil, window)
+	body = x11Byte.AppendUint32(body, property)
+	body = x11Byte.AppendUint32(body, 0) // AnyPropertyType
+	body = x11Byte.AppendUint32(body, 0)
+	body = x11Byte.AppendUint32(body, 1<<20)
+
+	reply, err := x.request(ctx, x11GetProperty, 0, body)
+	if err != nil {
+		return nil, err
+	}
+
+	format := int(reply[1])
+	length := int(x11Byte.Uint32(reply[16:])) * format / 8
+	if len(reply) < 32+length {
+		return nil, fmt.Errorf("short GetProperty reply")
+	}
+	return reply[32 : 32+length], nil
+}
+
+// ChangeProperty replaces a property of window with 8-bit data
+func (x *x11Conn) ChangeProperty(ctx context.Context, window, property, propertyType uint32, value []byte) error {
+	body := x11Byte.AppendUint32(nil, window)
+	body = x11Byte.AppendUint32(body, property)
+	body = x11Byte.AppendUint32(body, propertyType)
+	body = append(body, 8, 0, 0, 0)
+	body = x11Byte.AppendUint32(body, uint32(len(value)))
+	body = append(body, value...)
+
+	return x.call(ctx, x11ChangeProperty, 0, body)
+}
+
+// x11String16 encodes a string preceded by its 16-bit length, as in
+// InternAtom and QueryExtension
+func x11String16(value string) []byte {
+	body := x11Byte.AppendUint16(nil, uint16(len(value)))
+	body = append(body, 0, 0)
+	return append(body, value...)
+}
+
+// x11Pad pads data to a multiple of 4 bytes
+func x11Pad(data []byte) []byte {
+	return append(data, make([]byte, x11PadLength(len(data))-len(data))...)
+}
+
+// x11PadLength rounds length up to a multiple of 4
+func x11PadLength(length int) int {
+	return (length + 3) &^ 3
+}
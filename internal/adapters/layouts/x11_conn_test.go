@@ -0,0 +1,75 @@
+package layouts
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDisplay(t *testing.T) {
+	tests := []struct {
+		display string
+		network string
+		address string
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0"},
+		{":1.0", "unix", "/tmp/.X11-unix/X1"},
+		{"unix:2", "unix", "/tmp/.X11-unix/X2"},
+		{"localhost:10.0", "tcp", "localhost:6010"},
+		{"/private/tmp/com.apple.launchd.abc/org.xquartz:0", "unix", "/private/tmp/com.apple.launchd.abc/org.xquartz:0"},
+	}
+
+	for _, tt := range tests {
+		network, address, _, err := parseDisplay(tt.display)
+		if err != nil {
+			t.Errorf("%s: %v", tt.display, err)
+			continue
+		}
+		if network != tt.network || address != tt.address {
+			t.Errorf("%s: expected %s %s, got %s %s", tt.display, tt.network, tt.address, network, address)
+		}
+	}
+
+	for _, display := range []string{"", "localhost", ":x"} {
+		if _, _, _, err := parseDisplay(display); err == nil {
+			t.Errorf("Expected %q to be rejected", display)
+		}
+	}
+}
+
+func TestXauthority(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname")
+	}
+
+	entry := func(family uint16, fields ...string) []byte {
+		data := binary.BigEndian.AppendUint16(nil, family)
+		for _, field := range fields {
+			data = binary.BigEndian.AppendUint16(data, uint16(len(field)))
+			data = append(data, field...)
+		}
+		return data
+	}
+
+	var data []byte
+	data = append(data, entry(xauthFamilyLocal, "otherhost", "0", "MIT-MAGIC-COOKIE-1", "other")...)
+	data = append(data, entry(xauthFamilyLocal, host, "1", "MIT-MAGIC-COOKIE-1", "display1")...)
+	data = append(data, entry(xauthFamilyLocal, host, "0", "MIT-MAGIC-COOKIE-1", "display0")...)
+
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write Xauthority: %v", err)
+	}
+	t.Setenv("XAUTHORITY", path)
+
+	name, cookie := xauthority("unix", "/tmp/.X11-unix/X0", "0")
+	if name != "MIT-MAGIC-COOKIE-1" || string(cookie) != "display0" {
+		t.Errorf("Expected the cookie of display 0, got %s %q", name, cookie)
+	}
+
+	if name, _ := xauthority("unix", "/tmp/.X11-unix/X5", "5"); name != "" {
+		t.Errorf("Expected no cookie for display 5, got %s", name)
+	}
+}
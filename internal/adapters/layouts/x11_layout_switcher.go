@@ -0,0 +1,406 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
+)
+
+// X11LayoutSwitcher switches keyboard layouts through the XKB extension of
+// the X server, over a connection kept open between switches. Layouts
+// already in the keymap are selected by locking their group; others replace
+// the layout of the locked group in a new keymap, keeping the other groups,
+// the rules and the model. Keymaps have the options in use before the first
+// switch, changed as the layout asks. With XInput2, single keyboards get
+// their own keymap.
+type X11LayoutSwitcher struct {
+	display string
+	xkbRoot string
+	events  *domain.EventBus
+	conn    *x11Conn
+	xkb     *xkbClient
+	// xi is nil when the server lacks XInput2
+	xi *xiClient
+	// deviceKeymaps are the keymaps given to single keyboards, by device
+	// instance ID, to give them again when X recreates the keyboards
+	deviceKeymaps map[string]x11DeviceKeymap
+	// group is the locked group, as last reported by StateNotify
+	group uint8
+	watch context.Context
+	own   ownSwitches
+	// options are the options keymaps are loaded with
+	options xkbOptionBaseline
+	mu      sync.Mutex
+	// stateMu guards group and watch, which events update
+	stateMu sync.Mutex
+}
+
+// NewX11LayoutSwitcher creates a layout switcher for the X server of
+// display, resolving keymaps with the XKB data in xkbRoot and publishing
+// manual layout changes to events
+func NewX11LayoutSwitcher(display, xkbRoot string, events *domain.EventBus) *X11LayoutSwitcher {
+	return &X11LayoutSwitcher{
+		display:       display,
+		xkbRoot:       xkbRoot,
+		events:        events,
+		deviceKeymaps: make(map[string]x11DeviceKeymap),
+	}
+}
+
+// x11DeviceKeymap is the keymap given to a keyboard
+type x11DeviceKeymap struct {
+	device *domain.Device
+	names  xkbNames
+}
+
+// SwitchLayout changes the layout of every keyboard
+func (s *X11LayoutSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	xkb, err := s.connect(ctx)
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to connect to the X server", err),
+			map[string]any{"display": s.display},
+		)
+	}
+
+	if err := s.switchGroup(ctx, xkb, layout); err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+			map[string]any{"layout": layout.Name},
+		)
+	}
+
+	return nil
+}
+
+// SwitchDeviceLayout gives a single keyboard a keymap with the layout,
+// leaving the others untouched. The X server switches to the keymap of the
+// keyboard being typed on.
+func (s *X11LayoutSwitcher) SwitchDeviceLayout(ctx context.Context, layout *domain.KeyboardLayout, device *domain.Device) error {
+	if err := checkLinuxLayout(layout); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	xkb, err := s.connect(ctx)
+	if err != nil {
+		return errors.WithDetails(
+			errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to connect to the X server", err),
+			map[string]any{"display": s.display},
+		)
+	}
+	if s.xi == nil {
+		return errors.New(errors.ErrCodeLayoutSelectFailed, "X server lacks XInput2, needed for per-device layouts")
+	}
+
+	names, err := xkb.RulesNames(ctx)
+	if err != nil {
+		return errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to read the X keymap names", err)
+	}
+	// The keyboard only gets the groups of the layout so that it types the
+	// layout whatever group the other keyboards lock
+	names = xkbLayoutNames(names, layout, &s.options)
+	names.Layout, names.Variant = xkbGroupLists(xkbGroups(layout))
+	s.deviceKeymaps[device.InstanceID()] = x11DeviceKeymap{device: device, names: names}
+
+	devices, err := s.xi.Devices(ctx)
+	if err != nil {
+		return errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to list X input devices", err)
+	}
+
+	ids := xiKeyboardsForDevice(devices, device)
+	if len(ids) == 0 {
+		// X may add the keyboard after polykeys detected it
+		log.Printf("X has no keyboard matching %s yet, its layout will be set when it appears", device.Name)
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := s.loadKeymap(ctx, xkb, id, names); err != nil {
+			return errors.WithDetails(
+				errors.Wrap(errors.ErrCodeLayoutSelectFailed, "failed to switch layout", err),
+				map[string]any{
+					"layout":    layout.Name,
+					"device":    device.InstanceID(),
+					"x11Device": id,
+				},
+			)
+		}
+	}
+
+	return nil
+}
+
+// WatchLayouts publishes the group changes reported by the X server that
+// polykeys did not cause, until ctx is done
+func (s *X11LayoutSwitcher) WatchLayouts(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to the X server: %w", err)
+	}
+
+	s.stateMu.Lock()
+	s.watch = ctx
+	s.stateMu.Unlock()
+
+	return nil
+}
+
+// switchGroup locks the group of a layout. If no group has it, or the model,
+// options or groups of the layout change the keymap, a keymap is loaded
+// first, with the layout replacing the one of the locked group.
+func (s *X11LayoutSwitcher) switchGroup(ctx context.Context, xkb *xkbClient, layout *domain.KeyboardLayout) error {
+	names, err := xkb.RulesNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	name, variant := xkbLayout(layout)
+	locked := s.lockedGroup()
+	updated := xkbLayoutNames(names, layout, &s.options)
+	group := xkbGroupIndex(updated, name, variant)
+	if group < 0 {
+		updated, group = xkbSetGroup(updated, int(locked), name, variant)
+	}
+
+	if updated != names {
+		if err := s.loadKeymap(ctx, xkb, xkbUseCoreKbd, updated); err != nil {
+			return err
+		}
+		names = updated
+	} else if locked == uint8(group) {
+		logger.Debug("[X11] %s is already the locked group %d\n", xkbGroupName(names, group), group)
+		return nil
+	}
+
+	logger.Debug("[X11] Locking group %d (%s)\n", group, xkbGroupName(names, group))
+
+	s.own.mark()
+	return xkb.LockGroup(ctx, xkbUseCoreKbd, uint8(group))
+}
+
+// loadKeymap resolves names with the XKB rules and has the server compile
+// the keymap for device, recording the names of the core keyboard's keymap
+// on the root window
+func (s *X11LayoutSwitcher) loadKeymap(ctx context.Context, xkb *xkbClient, device uint16, names xkbNames) error {
+	if names.Rules == "" {
+		names.Rules = xkbDefaultRules
+	}
+
+	rules, err := loadXKBRules(s.xkbRoot, names.Rules)
+	if err != nil {
+		return err
+	}
+	components := rules.Components(names)
+
+	logger.Debug("[X11] Loading keymap %+v on device %#x\n", components, device)
+
+	s.own.mark()
+	if err := xkb.LoadKeymap(ctx, device, components); err != nil {
+		return err
+	}
+
+	if device != xkbUseCoreKbd {
+		return nil
+	}
+	return xkb.SetRulesNames(ctx, names)
+}
+
+// connect returns the XKB client of the connection to the X server, opening
+// the connection when there is none or it was lost
+func (s *X11LayoutSwitcher) connect(ctx context.Context) (*xkbClient, error) {
+	if s.conn != nil {
+		select {
+		case <-s.conn.Done():
+			logger.Debug("[X11] Connection to %s lost, reconnecting\n", s.display)
+			s.conn, s.xkb, s.xi = nil, nil, nil
+		default:
+			return s.xkb, nil
+		}
+	}
+
+	conn, err := dialX11(ctx, s.display)
+	if err != nil {
+		return nil, err
+	}
+
+	xkb, xi, err := s.setup(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s.conn, s.xkb, s.xi = conn, xkb, xi
+	go s.handleEvents(conn, xkb, xi)
+
+	return xkb, nil
+}
+
+// setup initializes XKB on a new connection and subscribes to state changes,
+// and to keyboards being added when XInput2 is available
+func (s *X11LayoutSwitcher) setup(ctx context.Context, conn *x11Conn) (*xkbClient, *xiClient, error) {
+	xkb, err := useXKB(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := xkb.SelectStateEvents(ctx, xkbUseCoreKbd); err != nil {
+		return nil, nil, err
+	}
+
+	state, err := xkb.State(ctx, xkbUseCoreKbd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.stateMu.Lock()
+	s.group = state.LockedGroup
+	s.stateMu.Unlock()
+
+	xi, err := useXInput2(ctx, conn)
+	if err == nil {
+		err = xi.SelectHierarchyEvents(ctx)
+	}
+	if err != nil {
+		logger.Debug("[X11] Per-device layouts are unavailable: %v\n", err)
+		xi = nil
+	}
+
+	return xkb, xi, nil
+}
+
+// handleEvents follows the state changes and the keyboards of a connection
+// until it closes
+func (s *X11LayoutSwitcher) handleEvents(conn *x11Conn, xkb *xkbClient, xi *xiClient) {
+	for event := range conn.Events {
+		if state, ok := xkb.StateEvent(event); ok {
+			s.stateChanged(xkb, state)
+		} else if xi != nil {
+			if changes, ok := xi.HierarchyEvent(event); ok {
+				s.keyboardsChanged(xkb, xi, changes)
+			}
+		}
+	}
+
+	if conn.err != nil {
+		log.Printf("Connection to X display %s closed: %v", s.display, conn.err)
+	}
+}
+
+// stateChanged records the locked group, publishing its layout when the
+// user switched it
+func (s *X11LayoutSwitcher) stateChanged(xkb *xkbClient, state xkbState) {
+	s.stateMu.Lock()
+	changed := s.group != state.LockedGroup
+	s.group = state.LockedGroup
+	watching := s.watch != nil && s.watch.Err() == nil
+	s.stateMu.Unlock()
+
+	if !changed || !watching {
+		return
+	}
+
+	if s.own.recent() {
+		logger.Debug("[X11] Group %d locked by polykeys\n", state.LockedGroup)
+		return
+	}
+
+	names, err := xkb.RulesNames(context.Background())
+	if err != nil {
+		logger.Debug("[X11] Failed to read the layouts: %v\n", err)
+		return
+	}
+
+	s.events.Publish(domain.LayoutChanged{Layout: xkbGroupName(names, int(state.LockedGroup))})
+}
+
+// keyboardsChanged gives keyboards added or enabled by X the keymap their
+// device was given, as X recreates keyboards with the default keymap when
+// they are plugged in again or resume
+func (s *X11LayoutSwitcher) keyboardsChanged(xkb *xkbClient, xi *xiClient, changes []xiHierarchyChange) {
+	added := make(map[uint16]bool)
+	for _, change := range changes {
+		if change.Use == xiSlaveKeyboard && change.Flags&(xiSlaveAdded|xiDeviceEnabled) != 0 {
+			added[change.Device] = true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(added) == 0 || len(s.deviceKeymaps) == 0 || s.xkb != xkb {
+		return
+	}
+
+	ctx, cancel := x11Context(context.Background())
+	defer cancel()
+
+	devices, err := xi.Devices(ctx)
+	if err != nil {
+		logger.Debug("[X11] Failed to list input devices: %v\n", err)
+		return
+	}
+
+	for _, keymap := range s.deviceKeymaps {
+		for _, id := range xiKeyboardsForDevice(devices, keymap.device) {
+			if !added[id] {
+				continue
+			}
+			if err := s.loadKeymap(ctx, xkb, id, keymap.names); err != nil {
+				log.Printf("Failed to set the layout of %s again: %v", keymap.device.Name, err)
+				continue
+			}
+			log.Printf("Set the layout of %s again after X added it", keymap.device.Name)
+		}
+	}
+}
+
+func (s *X11LayoutSwitcher) lockedGroup() uint8 {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.group
+}
+
+// xkbGroupIndex returns the group of a layout and variant in names, or -1
+func xkbGroupIndex(names xkbNames, name, variant string) int {
+	layouts := splitList(names.Layout)
+	variants := padList(splitList(names.Variant), len(layouts))
+
+	for i := range layouts {
+		if layouts[i] == name && variants[i] == variant {
+			return i
+		}
+	}
+	return -1
+}
+
+// xkbGroupName returns the layout of a group in the XKB syntax, such as
+// "us(intl)"
+func xkbGroupName(names xkbNames, group int) string {
+	layouts := splitList(names.Layout)
+	variants := padList(splitList(names.Variant), len(layouts))
+
+	if group >= len(layouts) {
+		return fmt.Sprintf("group %d", group+1)
+	}
+	if variants[group] == "" {
+		return layouts[group]
+	}
+	return layouts[group] + "(" + variants[group] + ")"
+}
@@ -0,0 +1,883 @@
+package layouts
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// Opcodes and numbers the fake X server gives to XKB and XInput
+const (
+	fakeXKBMajor      = 135
+	fakeXKBFirstEvent = 85
+	fakeXIMajor       = 131
+	fakeXRoot         = 0x1e7
+)
+
+// fakeX is an X server speaking the subset of the protocol polykeys uses.
+// Keymaps are only described by their components.
+type fakeX struct {
+	display  string
+	listener net.Listener
+	atoms    map[string]uint32
+	names    xkbNames
+	// keymaps are those of the core keyboard, deviceKeymaps the last one of
+	// each slave keyboard
+	keymaps       []xkbComponents
+	deviceKeymaps map[uint16]xkbComponents
+	devices       []xiDevice
+	group         uint8
+	// failSymbols makes keymaps with these symbols fail to compile
+	failSymbols string
+	clients     []*fakeXClient
+	mu          sync.Mutex
+}
+
+// fakeXClient is a connection to the fake X server
+type fakeXClient struct {
+	conn      net.Conn
+	seq       uint16
+	events    bool
+	hierarchy bool
+	mu        sync.Mutex
+}
+
+func newFakeX(t *testing.T, names xkbNames) *fakeX {
+	t.Helper()
+
+	// Socket paths are limited to about 100 bytes, which t.TempDir may exceed
+	dir, err := os.MkdirTemp("", "x11")
+	if err != nil {
+		t.Fatalf("Failed to create socket dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "X:0"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	x := &fakeX{
+		display:  filepath.Join(dir, "X") + ":0",
+		listener: listener,
+		atoms:    map[string]uint32{"STRING": x11AtomString},
+		names:    names,
+
+		deviceKeymaps: make(map[uint16]xkbComponents),
+		devices: []xiDevice{
+			{ID: 3, Use: 2, Name: "Virtual core keyboard", Enabled: true},
+			{ID: 5, Use: xiSlaveKeyboard, Name: "Virtual core XTEST keyboard", Enabled: true},
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			client := &fakeXClient{conn: conn}
+			x.mu.Lock()
+			x.clients = append(x.clients, client)
+			x.mu.Unlock()
+			go x.serve(client)
+		}
+	}()
+
+	return x
+}
+
+func (x *fakeX) serve(client *fakeXClient) {
+	defer client.conn.Close()
+
+	reader := bufio.NewReader(client.conn)
+	setup := make([]byte, 12)
+	if _, err := io.ReadFull(reader, setup); err != nil {
+		return
+	}
+	auth := x11PadLength(int(x11Byte.Uint16(setup[6:]))) + x11PadLength(int(x11Byte.Uint16(setup[8:])))
+	if _, err := io.ReadFull(reader, make([]byte, auth)); err != nil {
+		return
+	}
+
+	// Fixed setup data, no vendor and formats, then a screen
+	data := make([]byte, 32+40)
+	data[20] = 1   // screens
+	data[26] = 8   // min keycode
+	data[27] = 255 // max keycode
+	x11Byte.PutUint32(data[32:], fakeXRoot)
+	header := []byte{1, 0, 11, 0, 0, 0, 0, 0}
+	x11Byte.PutUint16(header[6:], uint16(len(data)/4))
+	client.write(append(header, data...))
+
+	for {
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+		body := make([]byte, 4*int(x11Byte.Uint16(request[2:]))-4)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+
+		client.mu.Lock()
+		client.seq++
+		seq := client.seq
+		client.mu.Unlock()
+
+		x.handle(client, seq, request[0], request[1], body)
+	}
+}
+
+// handle answers a request like an X server
+func (x *fakeX) handle(client *fakeXClient, seq uint16, opcode, minor uint8, body []byte) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	reply := make([]byte, 32)
+	switch {
+	case opcode == x11QueryExtension:
+		name := string(body[4 : 4+x11Byte.Uint16(body)])
+		switch name {
+		case "XKEYBOARD":
+			reply[8], reply[9], reply[10] = 1, fakeXKBMajor, fakeXKBFirstEvent
+		case "XInputExtension":
+			reply[8], reply[9] = 1, fakeXIMajor
+		}
+	case opcode == x11InternAtom:
+		name := string(body[4 : 4+x11Byte.Uint16(body)])
+		if _, ok := x.atoms[name]; !ok {
+			x.atoms[name] = uint32(100 + len(x.atoms))
+		}
+		x11Byte.PutUint32(reply[8:], x.atoms[name])
+	case opcode == x11GetProperty:
+		if x11Byte.Uint32(body[4:]) == x.atoms[xkbRulesNamesProperty] && x.names != (xkbNames{}) {
+			value := []byte(strings.Join([]string{x.names.Rules, x.names.Model, x.names.Layout, x.names.Variant, x.names.Options}, "\x00") + "\x00")
+			reply[1] = 8
+			x11Byte.PutUint32(reply[8:], x11AtomString)
+			x11Byte.PutUint32(reply[16:], uint32(len(value)))
+			reply = x11Pad(append(reply, value...))
+		}
+	case opcode == x11ChangeProperty:
+		if x11Byte.Uint32(body[4:]) == x.atoms[xkbRulesNamesProperty] {
+			value := body[20 : 20+x11Byte.Uint32(body[16:])]
+			fields := strings.Split(string(value), "\x00")
+			x.names = xkbNames{fields[0], fields[1], fields[2], fields[3], fields[4]}
+		}
+		return
+	case opcode == x11GetInputFocus:
+	case opcode == fakeXKBMajor && minor == xkbUseExtension:
+		reply[1] = 1
+		x11Byte.PutUint16(reply[8:], 1)
+	case opcode == fakeXKBMajor && minor == xkbSelectEvents:
+		client.events = x11Byte.Uint16(body[6:])&xkbStateNotifyMask != 0
+		return
+	case opcode == fakeXKBMajor && minor == xkbGetState:
+		reply[12], reply[13] = x.group, x.group
+	case opcode == fakeXKBMajor && minor == xkbLatchLockState:
+		if body[4] != 0 {
+			x.lockGroup(body[5])
+		}
+		return
+	case opcode == fakeXKBMajor && minor == xkbGetKbdByName:
+		names := make([]string, 0, 6)
+		for rest := body[8:]; len(names) < 6; {
+			names = append(names, string(rest[1:1+rest[0]]))
+			rest = rest[1+rest[0]:]
+		}
+		components := xkbComponents{names[1], names[2], names[3], names[4], names[5]}
+		if x.failSymbols == "" || components.Symbols != x.failSymbols {
+			if device := x11Byte.Uint16(body); device == xkbUseCoreKbd {
+				x.keymaps = append(x.keymaps, components)
+			} else {
+				x.deviceKeymaps[device] = components
+			}
+			reply[10] = 1
+		}
+	case opcode == fakeXIMajor && minor == xiQueryVersion:
+		x11Byte.PutUint16(reply[8:], 2)
+		x11Byte.PutUint16(reply[10:], 2)
+	case opcode == fakeXIMajor && minor == xiSelectEvents:
+		client.hierarchy = x11Byte.Uint32(body[12:])&(1<<xiHierarchyChanged) != 0
+		return
+	case opcode == fakeXIMajor && minor == xiQueryDevice:
+		x11Byte.PutUint16(reply[8:], uint16(len(x.devices)))
+		for _, device := range x.devices {
+			info := x11Byte.AppendUint16(nil, device.ID)
+			info = x11Byte.AppendUint16(info, device.Use)
+			info = x11Byte.AppendUint16(info, 3) // attachment
+			info = x11Byte.AppendUint16(info, 1) // num_classes
+			info = x11Byte.AppendUint16(info, uint16(len(device.Name)))
+			info = append(info, 0, 0)
+			if device.Enabled {
+				info[10] = 1
+			}
+			info = x11Pad(append(info, device.Name...))
+			// A key class without keys
+			info = append(info, 0, 0, 2, 0, 3, 0, 0, 0)
+			reply = append(reply, info...)
+		}
+	case opcode == fakeXIMajor && minor == xiGetProperty:
+		device := x.device(x11Byte.Uint16(body))
+		if device != nil && device.VendorID != 0 && x11Byte.Uint32(body[4:]) == x.atoms[xiProductIDProperty] {
+			x11Byte.PutUint32(reply[8:], 19) // INTEGER
+			x11Byte.PutUint32(reply[16:], 2)
+			reply[20] = 32
+			reply = x11Byte.AppendUint32(reply, device.VendorID)
+			reply = x11Byte.AppendUint32(reply, device.ProductID)
+		}
+		if device != nil && device.Node != "" && x11Byte.Uint32(body[4:]) == x.atoms[xiDeviceNodeProperty] {
+			x11Byte.PutUint32(reply[8:], x11AtomString)
+			x11Byte.PutUint32(reply[16:], uint32(len(device.Node)))
+			reply[20] = 8
+			reply = x11Pad(append(reply, device.Node...))
+		}
+	default:
+		client.writeError(seq, 1, opcode, minor)
+		return
+	}
+
+	reply[0] = 1
+	x11Byte.PutUint16(reply[2:], seq)
+	x11Byte.PutUint32(reply[4:], uint32((len(reply)-32)/4))
+	client.write(reply)
+}
+
+// lockGroup locks a group, notifying the clients selecting state events.
+// x.mu must be held.
+func (x *fakeX) lockGroup(group uint8) {
+	x.group = group
+	for _, client := range x.clients {
+		if !client.events {
+			continue
+		}
+		event := make([]byte, 32)
+		event[0], event[1] = fakeXKBFirstEvent, xkbStateNotify
+		event[8] = 3
+		event[13], event[18] = group, group
+		x11Byte.PutUint16(event[26:], 1<<7) // GroupLock changed
+		client.write(event)
+	}
+}
+
+func (x *fakeX) device(id uint16) *xiDevice {
+	for i := range x.devices {
+		if x.devices[i].ID == id {
+			return &x.devices[i]
+		}
+	}
+	return nil
+}
+
+// addKeyboard adds a slave keyboard, notifying the clients selecting
+// hierarchy events
+func (x *fakeX) addKeyboard(device xiDevice) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	device.Use, device.Enabled = xiSlaveKeyboard, true
+	x.devices = append(x.devices, device)
+
+	for _, client := range x.clients {
+		if !client.hierarchy {
+			continue
+		}
+		event := make([]byte, 32)
+		event[0], event[1] = x11GenericEvent, fakeXIMajor
+		x11Byte.PutUint32(event[4:], 3)
+		x11Byte.PutUint16(event[8:], xiHierarchyChanged)
+		x11Byte.PutUint32(event[16:], xiSlaveAdded|xiDeviceEnabled)
+		x11Byte.PutUint16(event[20:], 1)
+		info := x11Byte.AppendUint16(nil, device.ID)
+		info = x11Byte.AppendUint16(info, 3)
+		info = append(info, xiSlaveKeyboard, 1, 0, 0)
+		info = x11Byte.AppendUint32(info, xiSlaveAdded|xiDeviceEnabled)
+		client.write(append(event, info...))
+	}
+}
+
+// removeKeyboard removes a slave keyboard, without notifying clients
+func (x *fakeX) removeKeyboard(id uint16) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for i := range x.devices {
+		if x.devices[i].ID == id {
+			x.devices = append(x.devices[:i], x.devices[i+1:]...)
+			break
+		}
+	}
+	delete(x.deviceKeymaps, id)
+}
+
+func (x *fakeX) deviceKeymap(id uint16) (xkbComponents, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	keymap, ok := x.deviceKeymaps[id]
+	return keymap, ok
+}
+
+// userLocksGroup switches group as a keyboard shortcut would
+func (x *fakeX) userLocksGroup(group uint8) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.lockGroup(group)
+}
+
+// disconnect closes every client connection, as a restarting server would
+func (x *fakeX) disconnect() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, client := range x.clients {
+		client.conn.Close()
+	}
+	x.clients = nil
+}
+
+func (x *fakeX) state() (xkbNames, []xkbComponents, uint8) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.names, append([]xkbComponents(nil), x.keymaps...), x.group
+}
+
+func (c *fakeXClient) write(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(data)
+}
+
+func (c *fakeXClient) writeError(seq uint16, code, major, minor uint8) {
+	packet := make([]byte, 32)
+	packet[1], packet[10], packet[8] = code, major, minor
+	x11Byte.PutUint16(packet[2:], seq)
+	c.write(packet)
+}
+
+// testXKBRules is an excerpt of rules/evdev
+const testXKBRules = `// Excerpt of the evdev rules
+! $azerty = be fr
+
+! model		=	keycodes
+  *		=	evdev
+
+! layout[1]	=	keycodes
+  $azerty	=	+aliases(azerty)
+  *		=	+aliases(qwerty)
+
+! layout	=	keycodes
+  $azerty	=	+aliases(azerty)
+  *		=	+aliases(qwerty)
+
+! model		=	geometry
+  *		=	pc(pc104)
+
+! model		layout	=	symbols
+  *		*	=	pc+%l%(v)
+
+! model		layout[1]	=	symbols
+  *		*		=	pc+%l[1]%(v[1])
+
+! model		layout[2]	=	symbols
+  *		*		=	+%l[2]%(v[2]):2
+
+! model		=	symbols
+  *		=	+inet(evdev)
+
+! model		layout	=	compat
+  *		*	=	complete
+
+! model		layout[1]	=	compat
+  *		*		=	complete
+
+! model		=	types
+  *		=	complete
+
+! option	=	symbols
+  ctrl:nocaps	=	+ctrl(nocaps)
+  compose:ralt	=	+compose(ralt)
+`
+
+// testXKBRoot returns XKB data with the evdev rules excerpt
+func testXKBRoot(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "rules"), 0o755); err != nil {
+		t.Fatalf("Failed to create rules dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "rules", "evdev"), []byte(testXKBRules), 0o644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+	return root
+}
+
+func TestX11LayoutSwitcher_LocksExistingGroup(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,fr", ",", "ctrl:nocaps"})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	names, keymaps, group := x.state()
+	if group != 1 {
+		t.Errorf("Expected group 1 to be locked, got %d", group)
+	}
+	if len(keymaps) != 0 {
+		t.Errorf("Expected no keymap to be loaded, got %v", keymaps)
+	}
+	if names.Layout != "us,fr" {
+		t.Errorf("Expected the layouts to be unchanged, got %q", names.Layout)
+	}
+}
+
+func TestX11LayoutSwitcher_LoadsKeymap(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "fr", "", "ctrl:nocaps,compose:ralt"})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	names, keymaps, group := x.state()
+	expected := xkbComponents{
+		Keycodes: "evdev+aliases(qwerty)",
+		Types:    "complete",
+		Compat:   "complete",
+		Symbols:  "pc+us(intl)+inet(evdev)+ctrl(nocaps)+compose(ralt)",
+		Geometry: "pc(pc104)",
+	}
+	if len(keymaps) != 1 || keymaps[0] != expected {
+		t.Errorf("Expected keymap %+v, got %+v", expected, keymaps)
+	}
+
+	expectedNames := xkbNames{"evdev", "pc105", "us", "intl", "ctrl:nocaps,compose:ralt"}
+	if names != expectedNames {
+		t.Errorf("Expected names %+v, got %+v", expectedNames, names)
+	}
+	if group != 0 {
+		t.Errorf("Expected group 0 to be locked, got %d", group)
+	}
+}
+
+func TestX11LayoutSwitcher_ReplacesLockedGroup(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,ru", ",phonetic", "ctrl:nocaps"})
+	x.userLocksGroup(1)
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	names, keymaps, group := x.state()
+	expectedNames := xkbNames{"evdev", "pc105", "us,fr", "", "ctrl:nocaps"}
+	if names != expectedNames {
+		t.Errorf("Expected names %+v, got %+v", expectedNames, names)
+	}
+	if len(keymaps) != 1 || keymaps[0].Symbols != "pc+us+fr:2+inet(evdev)+ctrl(nocaps)" {
+		t.Errorf("Expected a keymap with both groups, got %+v", keymaps)
+	}
+	if group != 1 {
+		t.Errorf("Expected group 1 to stay locked, got %d", group)
+	}
+}
+
+func TestX11LayoutSwitcher_ChangesOptions(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,fr", ",", "ctrl:nocaps"})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr").WithOptions(domain.LayoutOptions{
+		Add:    []string{"compose:ralt"},
+		Remove: []string{"ctrl:nocaps"},
+	})
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	names, keymaps, group := x.state()
+	expectedNames := xkbNames{"evdev", "pc105", "us,fr", ",", "compose:ralt"}
+	if names != expectedNames {
+		t.Errorf("Expected names %+v, got %+v", expectedNames, names)
+	}
+	if len(keymaps) != 1 || keymaps[0].Symbols != "pc+us+fr:2+inet(evdev)+compose(ralt)" {
+		t.Errorf("Expected a keymap with the new options, got %+v", keymaps)
+	}
+	if group != 1 {
+		t.Errorf("Expected group 1 to be locked, got %d", group)
+	}
+
+	// Options already applied do not reload the keymap
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout again: %v", err)
+	}
+	if _, keymaps, _ := x.state(); len(keymaps) != 1 {
+		t.Errorf("Expected no other keymap to be loaded, got %+v", keymaps)
+	}
+}
+
+func TestX11LayoutSwitcher_RestoresOptions(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,fr", ",", "ctrl:nocaps"})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	withOptions := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr").WithOptions(domain.LayoutOptions{
+		Add:    []string{"compose:ralt"},
+		Remove: []string{"ctrl:nocaps"},
+	})
+	withoutOptions := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us")
+
+	for _, layout := range []*domain.KeyboardLayout{withOptions, withoutOptions} {
+		if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+			t.Fatalf("Failed to switch to %s: %v", layout.Name, err)
+		}
+	}
+
+	names, keymaps, group := x.state()
+	expectedNames := xkbNames{"evdev", "pc105", "us,fr", ",", "ctrl:nocaps"}
+	if names != expectedNames {
+		t.Errorf("Expected the options in use before to be restored, got %+v", names)
+	}
+	if len(keymaps) != 2 || keymaps[1].Symbols != "pc+us+fr:2+inet(evdev)+ctrl(nocaps)" {
+		t.Errorf("Expected a keymap with the options in use before, got %+v", keymaps)
+	}
+	if group != 0 {
+		t.Errorf("Expected group 0 to be locked, got %d", group)
+	}
+}
+
+func TestX11LayoutSwitcher_LoadsGroups(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "fr", "", "ctrl:nocaps"})
+	x.userLocksGroup(1)
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewXKBKeyboardLayout("us,ru(phonetic)",
+		[]domain.XKBGroup{{Layout: "us"}, {Layout: "ru", Variant: "phonetic"}},
+		"pc104", domain.LayoutOptions{Add: []string{"compose:ralt"}})
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	names, keymaps, group := x.state()
+	expectedNames := xkbNames{"evdev", "pc104", "us,ru", ",phonetic", "ctrl:nocaps,compose:ralt"}
+	if names != expectedNames {
+		t.Errorf("Expected names %+v, got %+v", expectedNames, names)
+	}
+	if len(keymaps) != 1 || keymaps[0].Symbols != "pc+us+ru(phonetic):2+inet(evdev)+ctrl(nocaps)+compose(ralt)" {
+		t.Errorf("Expected a keymap with both groups, got %+v", keymaps)
+	}
+	if group != 0 {
+		t.Errorf("Expected group 0 to be locked, got %d", group)
+	}
+}
+
+func TestX11LayoutSwitcher_KeymapFailure(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us", "", ""})
+	x.failSymbols = "pc+zz+inet(evdev)"
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout("Unknown", domain.OSLinux, "zz")
+	if err := switcher.SwitchLayout(context.Background(), layout); err == nil {
+		t.Fatal("Expected a keymap failing to compile to be reported")
+	}
+
+	if names, _, _ := x.state(); names.Layout != "us" {
+		t.Errorf("Expected the names to be kept, got %+v", names)
+	}
+}
+
+func TestX11LayoutSwitcher_NoServer(t *testing.T) {
+	switcher := NewX11LayoutSwitcher(filepath.Join(t.TempDir(), "X")+":0", testXKBRoot(t), domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err == nil {
+		t.Error("Expected an error without an X server")
+	}
+}
+
+func TestX11LayoutSwitcher_Reconnects(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,fr", "", ""})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	us := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us")
+	fr := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+
+	if err := switcher.SwitchLayout(context.Background(), fr); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	x.disconnect()
+	<-switcher.conn.Done()
+
+	if err := switcher.SwitchLayout(context.Background(), us); err != nil {
+		t.Fatalf("Expected the switcher to reconnect: %v", err)
+	}
+	if _, _, group := x.state(); group != 0 {
+		t.Errorf("Expected group 0 to be locked, got %d", group)
+	}
+}
+
+func TestX11LayoutSwitcher_WatchLayouts(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,us", ",intl", ""})
+	events := domain.NewEventBus()
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), events)
+
+	changes := make(chan domain.LayoutChanged, 10)
+	events.Subscribe(func(event domain.Event) {
+		changes <- event.(domain.LayoutChanged)
+	}, domain.EventLayoutChanged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := switcher.WatchLayouts(ctx); err != nil {
+		t.Fatalf("Failed to watch layouts: %v", err)
+	}
+
+	// A switch with the group toggle shortcut
+	x.userLocksGroup(1)
+
+	select {
+	case changed := <-changes:
+		if changed.Layout != "us(intl)" {
+			t.Errorf("Expected us(intl), got %+v", changed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the layout change to be published")
+	}
+
+	if group := switcher.lockedGroup(); group != 1 {
+		t.Errorf("Expected the switcher to know group 1 is locked, got %d", group)
+	}
+}
+
+func TestX11LayoutSwitcher_IgnoresOwnSwitches(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "us,fr", "", ""})
+	events := domain.NewEventBus()
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), events)
+
+	published := make(chan domain.Event, 10)
+	events.Subscribe(func(event domain.Event) { published <- event }, domain.EventLayoutChanged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := switcher.WatchLayouts(ctx); err != nil {
+		t.Fatalf("Failed to watch layouts: %v", err)
+	}
+
+	layout := domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, domain.OSLinux, "fr")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	select {
+	case event := <-published:
+		t.Errorf("Expected the change made by polykeys not to be published, got %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestX11LayoutSwitcher_SwitchDeviceLayout(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "fr", "", "compose:ralt"})
+	x.addKeyboard(xiDevice{ID: 10, Name: "AT Translated Set 2 keyboard"})
+	x.addKeyboard(xiDevice{ID: 11, Name: "foostan Corne", VendorID: 0x4653, ProductID: 0x0004})
+	x.addKeyboard(xiDevice{ID: 12, Name: "foostan Corne Consumer Control", VendorID: 0x4653, ProductID: 0x0004})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err != nil {
+		t.Fatalf("Failed to switch the device layout: %v", err)
+	}
+
+	for _, id := range []uint16{11, 12} {
+		keymap, ok := x.deviceKeymap(id)
+		if !ok || keymap.Symbols != "pc+us(intl)+inet(evdev)+compose(ralt)" {
+			t.Errorf("Expected device %d to get us(intl) with the options, got %+v", id, keymap)
+		}
+	}
+	if keymap, ok := x.deviceKeymap(10); ok {
+		t.Errorf("Expected the laptop keyboard to be left alone, got %+v", keymap)
+	}
+
+	names, keymaps, _ := x.state()
+	if len(keymaps) != 0 || names.Layout != "fr" {
+		t.Errorf("Expected the core keymap to be left alone, got %v and %+v", keymaps, names)
+	}
+}
+
+func TestX11LayoutSwitcher_SwitchIdenticalDeviceLayout(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "fr", "", ""})
+	x.addKeyboard(xiDevice{ID: 11, Name: "foostan Corne", VendorID: 0x4653, ProductID: 0x0004, Node: "/dev/input/event5"})
+	x.addKeyboard(xiDevice{ID: 12, Name: "foostan Corne", VendorID: 0x4653, ProductID: 0x0004, Node: "/dev/input/event7"})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	// The second of two identical Cornes
+	right := domain.NewDevice("4653", "0004", "foostan Corne")
+	right.Nodes = []domain.DeviceNode{{Name: "foostan Corne", Path: "/dev/input/event7"}}
+	layout := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, right); err != nil {
+		t.Fatalf("Failed to switch the device layout: %v", err)
+	}
+
+	if keymap, ok := x.deviceKeymap(12); !ok || keymap.Symbols != "pc+us+inet(evdev)" {
+		t.Errorf("Expected device 12 to get us, got %+v", keymap)
+	}
+	if keymap, ok := x.deviceKeymap(11); ok {
+		t.Errorf("Expected the other Corne to be left alone, got %+v", keymap)
+	}
+}
+
+func TestX11LayoutSwitcher_ReappliesDeviceLayout(t *testing.T) {
+	x := newFakeX(t, xkbNames{"evdev", "pc105", "fr", "", ""})
+	switcher := NewX11LayoutSwitcher(x.display, testXKBRoot(t), domain.NewEventBus())
+
+	// Detected by polykeys before X adds it
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	layout := domain.NewKeyboardLayout(domain.LayoutUSQwerty, domain.OSLinux, "us")
+	if err := switcher.SwitchDeviceLayout(context.Background(), layout, corne); err != nil {
+		t.Fatalf("Expected a keyboard unknown to X to be waited for: %v", err)
+	}
+
+	for _, id := range []uint16{11, 13} {
+		x.addKeyboard(xiDevice{ID: id, Name: "foostan Corne", VendorID: 0x4653, ProductID: 0x0004})
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if keymap, ok := x.deviceKeymap(id); ok {
+				if keymap.Symbols != "pc+us+inet(evdev)" {
+					t.Errorf("Expected device %d to get us, got %+v", id, keymap)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the layout to be set on device %d", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// Unplugged: X removes the device, and recreates it with a new ID
+		x.removeKeyboard(id)
+	}
+}
+
+func TestXIKeyboardsForDevice(t *testing.T) {
+	devices := []xiDevice{
+		{ID: 3, Use: 2, Name: "Virtual core keyboard", Enabled: true},
+		{ID: 8, Use: xiSlaveKeyboard, Name: "Lily58 Keyboard", Enabled: true},
+		{ID: 9, Use: xiSlaveKeyboard, Name: "Lily58 Consumer Control", Enabled: true},
+		{ID: 10, Use: xiSlaveKeyboard, Name: "Lily58 Pro Keyboard", Enabled: true},
+		{ID: 11, Use: xiSlaveKeyboard, Name: "Corne", VendorID: 0x4653, ProductID: 0x0004, Enabled: true},
+		{ID: 12, Use: xiSlaveKeyboard, Name: "Corne", VendorID: 0x4653, ProductID: 0x0005, Enabled: true},
+		{ID: 13, Use: xiSlaveKeyboard, Name: "Lily58", Enabled: false},
+		{ID: 14, Use: xiSlaveKeyboard, Name: "Planck", VendorID: 0xfeed, ProductID: 0x6060, Node: "/dev/input/event20", Enabled: true},
+		{ID: 15, Use: xiSlaveKeyboard, Name: "Planck", VendorID: 0xfeed, ProductID: 0x6060, Node: "/dev/input/event21", Enabled: true},
+	}
+
+	lily58 := domain.NewDevice("04d8", "eb2d", "Lily58")
+	lily58.Nodes = []domain.DeviceNode{{Name: "Lily58 Keyboard"}, {Name: "Lily58 Consumer Control"}}
+
+	planck := domain.NewDevice("feed", "6060", "Planck")
+	planck.Nodes = []domain.DeviceNode{{Name: "Planck", Path: "/dev/input/event21"}}
+	unknownNode := domain.NewDevice("feed", "6060", "Planck")
+	unknownNode.Nodes = []domain.DeviceNode{{Name: "Planck", Path: "/dev/input/event30"}}
+
+	tests := []struct {
+		name     string
+		device   *domain.Device
+		expected []uint16
+	}{
+		{"event node", planck, []uint16{15}},
+		{"no matching event node", unknownNode, []uint16{14, 15}},
+		{"vendor and product", domain.NewDevice("4653", "0004", "Corne"), []uint16{11}},
+		{"node names", lily58, []uint16{8, 9}},
+		{"name prefix", domain.NewDevice("04d8", "eb2d", "Lily58 Pro"), []uint16{10}},
+		{"unknown", domain.NewDevice("1234", "5678", "Planck"), []uint16{}},
+	}
+
+	for _, tt := range tests {
+		if got := xiKeyboardsForDevice(devices, tt.device); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestX11LayoutSwitcher_Xvfb switches layouts on a real X server when Xvfb
+// is installed
+func TestX11LayoutSwitcher_Xvfb(t *testing.T) {
+	if _, err := exec.LookPath("Xvfb"); err != nil {
+		t.Skip("Xvfb is not installed")
+	}
+	if _, err := os.Stat(filepath.Join(xkbDefaultConfigRoot, "rules", xkbDefaultRules)); err != nil {
+		t.Skip("XKB data is not installed")
+	}
+
+	display := startXvfb(t)
+	switcher := NewX11LayoutSwitcher(display, xkbDefaultConfigRoot, domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout(domain.LayoutUSInternational, domain.OSLinux, "us -variant intl")
+	if err := switcher.SwitchLayout(context.Background(), layout); err != nil {
+		t.Fatalf("Failed to switch layout: %v", err)
+	}
+
+	conn, err := dialX11(context.Background(), display)
+	if err != nil {
+		t.Fatalf("Failed to connect to Xvfb: %v", err)
+	}
+	defer conn.Close()
+
+	xkb, err := useXKB(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("Failed to use XKB: %v", err)
+	}
+
+	names, err := xkb.RulesNames(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read the names: %v", err)
+	}
+	if group := xkbGroupIndex(names, "us", "intl"); group < 0 {
+		t.Errorf("Expected us(intl) in the keymap, got %+v", names)
+	}
+}
+
+// startXvfb starts an X server, and returns its display
+func startXvfb(t *testing.T) string {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	defer reader.Close()
+
+	cmd := exec.Command("Xvfb", "-displayfd", "3", "-nolisten", "tcp")
+	cmd.ExtraFiles = []*os.File{writer}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start Xvfb: %v", err)
+	}
+	writer.Close()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	number, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Xvfb did not report its display: %v", err)
+	}
+	return ":" + strings.TrimSpace(number)
+}
@@ -0,0 +1,305 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// XInput2 requests used by polykeys
+const (
+	xiSelectEvents = 46
+	xiQueryVersion = 47
+	xiQueryDevice  = 48
+	xiGetProperty  = 59
+)
+
+// xiAllDevices selects every device in XInput2 requests
+const xiAllDevices = 0
+
+// xiSlaveKeyboard is the use of the devices behind the master keyboard
+const xiSlaveKeyboard = 4
+
+// XInput2 hierarchy events
+const (
+	xiHierarchyChanged = 11
+	xiSlaveAdded       = 1 << 2
+	xiDeviceEnabled    = 1 << 6
+)
+
+// Device properties set by the evdev and libinput drivers
+const (
+	// xiProductIDProperty holds the vendor and product IDs
+	xiProductIDProperty = "Device Product ID"
+	// xiDeviceNodeProperty holds the path of the event node, such as
+	// "/dev/input/event5"
+	xiDeviceNodeProperty = "Device Node"
+)
+
+// xiClient makes XInput2 requests on an X connection
+type xiClient struct {
+	conn      *x11Conn
+	extension x11Extension
+}
+
+// xiDevice is an input device of the X server
+type xiDevice struct {
+	ID      uint16
+	Use     uint16
+	Name    string
+	Enabled bool
+	// VendorID and ProductID are 0 when the driver does not report them
+	VendorID  uint32
+	ProductID uint32
+	// Node is the event node of the device, empty when the driver does not
+	// report it
+	Node string
+}
+
+// xiHierarchyChange is a device added, removed or changed, as reported by a
+// HierarchyChanged event
+type xiHierarchyChange struct {
+	Device uint16
+	Use    uint8
+	Flags  uint32
+}
+
+// useXInput2 initializes XInput2 on conn
+func useXInput2(ctx context.Context, conn *x11Conn) (*xiClient, error) {
+	extension, present, err := conn.QueryExtension(ctx, "XInputExtension")
+	if err != nil {
+		return nil, err
+	}
+	if !present {
+		return nil, fmt.Errorf("X server lacks the XInput extension")
+	}
+
+	body := x11Byte.AppendUint16(nil, 2)
+	body = x11Byte.AppendUint16(body, 0)
+	reply, err := conn.request(ctx, extension.Major, xiQueryVersion, body)
+	if err != nil {
+		return nil, err
+	}
+	if major := x11Byte.Uint16(reply[8:]); major < 2 {
+		return nil, fmt.Errorf("X server only supports XInput %d.%d", major, x11Byte.Uint16(reply[10:]))
+	}
+
+	return &xiClient{conn: conn, extension: extension}, nil
+}
+
+// SelectHierarchyEvents asks for the events of devices being added,
+// removed, enabled or disabled
+func (c *xiClient) SelectHierarchyEvents(ctx context.Context) error {
+	body := x11Byte.AppendUint32(nil, c.conn.root)
+	body = x11Byte.AppendUint16(body, 1) // num_mask
+	body = append(body, 0, 0)
+	body = x11Byte.AppendUint16(body, xiAllDevices)
+	body = x11Byte.AppendUint16(body, 1) // mask_len
+	body = x11Byte.AppendUint32(body, 1<<xiHierarchyChanged)
+
+	return c.conn.call(ctx, c.extension.Major, xiSelectEvents, body)
+}
+
+// Devices returns the input devices of the server, with the vendor and
+// product IDs and the event node of the slave keyboards
+func (c *xiClient) Devices(ctx context.Context) ([]xiDevice, error) {
+	body := x11Byte.AppendUint16(nil, xiAllDevices)
+	body = append(body, 0, 0)
+
+	reply, err := c.conn.request(ctx, c.extension.Major, xiQueryDevice, body)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int(x11Byte.Uint16(reply[8:]))
+	devices := make([]xiDevice, 0, count)
+	for offset := 32; len(devices) < count; {
+		if len(reply) < offset+12 {
+			return nil, fmt.Errorf("short XIQueryDevice reply")
+		}
+		info := reply[offset:]
+		nameLength := int(x11Byte.Uint16(info[8:]))
+		end := 12 + x11PadLength(nameLength)
+		if len(info) < end {
+			return nil, fmt.Errorf("short XIQueryDevice reply")
+		}
+
+		devices = append(devices, xiDevice{
+			ID:      x11Byte.Uint16(info),
+			Use:     x11Byte.Uint16(info[2:]),
+			Name:    string(info[12 : 12+nameLength]),
+			Enabled: info[10] != 0,
+		})
+
+		// Skip the classes
+		for i := 0; i < int(x11Byte.Uint16(info[6:])); i++ {
+			if len(info) < end+4 {
+				return nil, fmt.Errorf("short XIQueryDevice reply")
+			}
+			end += 4 * int(x11Byte.Uint16(info[end+2:]))
+		}
+		offset += end
+	}
+
+	productAtom, err := c.conn.InternAtom(ctx, xiProductIDProperty)
+	if err != nil {
+		return nil, err
+	}
+	nodeAtom, err := c.conn.InternAtom(ctx, xiDeviceNodeProperty)
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		if devices[i].Use != xiSlaveKeyboard {
+			continue
+		}
+		if devices[i].VendorID, devices[i].ProductID, err = c.productID(ctx, devices[i].ID, productAtom); err != nil {
+			return nil, err
+		}
+		if devices[i].Node, err = c.deviceNode(ctx, devices[i].ID, nodeAtom); err != nil {
+			return nil, err
+		}
+	}
+
+	return devices, nil
+}
+
+// productID returns the vendor and product IDs of a device, 0 if unknown
+func (c *xiClient) productID(ctx context.Context, device uint16, atom uint32) (uint32, uint32, error) {
+	reply, err := c.property(ctx, device, atom, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if reply[20] != 32 || x11Byte.Uint32(reply[16:]) < 2 || len(reply) < 40 {
+		return 0, 0, nil
+	}
+	return x11Byte.Uint32(reply[32:]), x11Byte.Uint32(reply[36:]), nil
+}
+
+// deviceNode returns the event node of a device, empty if unknown
+func (c *xiClient) deviceNode(ctx context.Context, device uint16, atom uint32) (string, error) {
+	reply, err := c.property(ctx, device, atom, 64)
+	if err != nil {
+		return "", err
+	}
+
+	length := int(x11Byte.Uint32(reply[16:]))
+	if reply[20] != 8 || len(reply) < 32+length {
+		return "", nil
+	}
+	return strings.TrimRight(string(reply[32:32+length]), "\x00"), nil
+}
+
+// property reads up to length 32-bit units of a device property
+func (c *xiClient) property(ctx context.Context, device uint16, atom, length uint32) ([]byte, error) {
+	body := x11Byte.AppendUint16(nil, device)
+	body = append(body, 0, 0)                 // delete, pad
+	body = x11Byte.AppendUint32(body, atom)   // property
+	body = x11Byte.AppendUint32(body, 0)      // AnyPropertyType
+	body = x11Byte.AppendUint32(body, 0)      // offset
+	body = x11Byte.AppendUint32(body, length) // len
+
+	return c.conn.request(ctx, c.extension.Major, xiGetProperty, body)
+}
+
+// HierarchyEvent returns the changes reported by a HierarchyChanged event,
+// or false for other events
+func (c *xiClient) HierarchyEvent(event []byte) ([]xiHierarchyChange, bool) {
+	if event[0]&0x7f != x11GenericEvent || event[1] != c.extension.Major || len(event) < 32 {
+		return nil, false
+	}
+	if x11Byte.Uint16(event[8:]) != xiHierarchyChanged {
+		return nil, false
+	}
+
+	count := int(x11Byte.Uint16(event[20:]))
+	changes := make([]xiHierarchyChange, 0, count)
+	for offset := 32; len(changes) < count && len(event) >= offset+12; offset += 12 {
+		changes = append(changes, xiHierarchyChange{
+			Device: x11Byte.Uint16(event[offset:]),
+			Use:    event[offset+4],
+			Flags:  x11Byte.Uint32(event[offset+8:]),
+		})
+	}
+
+	return changes, true
+}
+
+// xiKeyboardsForDevice returns the IDs of the slave keyboards of a device.
+// They are matched on the event nodes of the device, which tell identical
+// keyboards apart, and when none is found on vendor and product IDs when the
+// driver reports them, and on the names of its input nodes otherwise.
+func xiKeyboardsForDevice(devices []xiDevice, device *domain.Device) []uint16 {
+	if ids := xiKeyboardsForNodes(devices, device); len(ids) > 0 {
+		return ids
+	}
+
+	vendor, product, hasIDs := usbIDs(device)
+
+	ids := make([]uint16, 0)
+	for _, candidate := range devices {
+		if candidate.Use != xiSlaveKeyboard || !candidate.Enabled {
+			continue
+		}
+
+		var matched bool
+		if candidate.VendorID != 0 || candidate.ProductID != 0 {
+			matched = hasIDs && candidate.VendorID == vendor && candidate.ProductID == product
+		} else {
+			matched = xiNameMatches(candidate.Name, device)
+		}
+		if matched {
+			ids = append(ids, candidate.ID)
+		}
+	}
+
+	return ids
+}
+
+// xiKeyboardsForNodes returns the IDs of the slave keyboards whose event
+// node is one of the nodes of a device
+func xiKeyboardsForNodes(devices []xiDevice, device *domain.Device) []uint16 {
+	ids := make([]uint16, 0)
+	for _, candidate := range devices {
+		if candidate.Use != xiSlaveKeyboard || !candidate.Enabled || candidate.Node == "" {
+			continue
+		}
+		for _, node := range device.Nodes {
+			if node.Path == candidate.Node {
+				ids = append(ids, candidate.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// xiNameMatches returns true if an X device is one of the input nodes of a
+// device. Without known nodes, extra interfaces are recognized by a suffix
+// such as " Consumer Control".
+func xiNameMatches(name string, device *domain.Device) bool {
+	if name == device.Name {
+		return true
+	}
+	for _, node := range device.Nodes {
+		if name == node.Name {
+			return true
+		}
+	}
+	return len(device.Nodes) == 0 && device.Name != "" && strings.HasPrefix(name, device.Name+" ")
+}
+
+// usbIDs returns the vendor and product IDs of a device, or false if they
+// are not hexadecimal numbers
+func usbIDs(device *domain.Device) (uint32, uint32, bool) {
+	vendor, vendorErr := strconv.ParseUint(device.VendorID, 16, 16)
+	product, productErr := strconv.ParseUint(device.ProductID, 16, 16)
+	if vendorErr != nil || productErr != nil {
+		return 0, 0, false
+	}
+	return uint32(vendor), uint32(product), true
+}
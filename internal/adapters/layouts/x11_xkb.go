@@ -0,0 +1,187 @@
+package layouts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// XKB requests used by polykeys
+const (
+	xkbUseExtension   = 0
+	xkbSelectEvents   = 1
+	xkbGetState       = 4
+	xkbLatchLockState = 5
+	xkbGetKbdByName   = 23
+)
+
+// xkbUseCoreKbd is the device spec of the core keyboard
+const xkbUseCoreKbd = 0x100
+
+// XKB events, identified by the second byte of events of the extension
+const (
+	xkbStateNotify     = 2
+	xkbStateNotifyMask = 1 << xkbStateNotify
+)
+
+// GetKbdByName component masks
+const (
+	xkbGBNAllComponents = 0xff
+	xkbGBNGeometry      = 0x40
+)
+
+// xkbRulesNamesProperty is the root window property holding the rules,
+// model, layouts, variants and options of the keymap
+const xkbRulesNamesProperty = "_XKB_RULES_NAMES"
+
+// xkbClient makes XKB requests on an X connection
+type xkbClient struct {
+	conn      *x11Conn
+	extension x11Extension
+}
+
+// xkbState is the keyboard state reported by GetState and StateNotify
+type xkbState struct {
+	// Group is the effective group
+	Group uint8
+	// LockedGroup is the group selected until the next switch
+	LockedGroup uint8
+}
+
+// useXKB initializes the XKB extension on conn
+func useXKB(ctx context.Context, conn *x11Conn) (*xkbClient, error) {
+	extension, present, err := conn.QueryExtension(ctx, "XKEYBOARD")
+	if err != nil {
+		return nil, err
+	}
+	if !present {
+		return nil, fmt.Errorf("X server lacks the XKB extension")
+	}
+
+	body := x11Byte.AppendUint16(nil, 1)
+	body = x11Byte.AppendUint16(body, 0)
+	reply, err := conn.request(ctx, extension.Major, xkbUseExtension, body)
+	if err != nil {
+		return nil, err
+	}
+	if reply[1] == 0 {
+		return nil, fmt.Errorf("X server does not support XKB 1.0, only %d.%d",
+			x11Byte.Uint16(reply[8:]), x11Byte.Uint16(reply[10:]))
+	}
+
+	return &xkbClient{conn: conn, extension: extension}, nil
+}
+
+// SelectStateEvents asks for the StateNotify events of device
+func (k *xkbClient) SelectStateEvents(ctx context.Context, device uint16) error {
+	body := x11Byte.AppendUint16(nil, device)
+	body = x11Byte.AppendUint16(body, xkbStateNotifyMask) // affectWhich
+	body = x11Byte.AppendUint16(body, 0)                  // clear
+	body = x11Byte.AppendUint16(body, xkbStateNotifyMask) // selectAll
+	body = x11Byte.AppendUint16(body, 0)                  // affectMap
+	body = x11Byte.AppendUint16(body, 0)                  // map
+
+	return k.conn.call(ctx, k.extension.Major, xkbSelectEvents, body)
+}
+
+// State returns the keyboard state of device
+func (k *xkbClient) State(ctx context.Context, device uint16) (xkbState, error) {
+	body := x11Byte.AppendUint16(nil, device)
+	body = append(body, 0, 0)
+
+	reply, err := k.conn.request(ctx, k.extension.Major, xkbGetState, body)
+	if err != nil {
+		return xkbState{}, err
+	}
+
+	return xkbState{Group: reply[12], LockedGroup: reply[13]}, nil
+}
+
+// LockGroup selects the group of device until the next switch
+func (k *xkbClient) LockGroup(ctx context.Context, device uint16, group uint8) error {
+	body := x11Byte.AppendUint16(nil, device)
+	body = append(body,
+		0,     // affectModLocks
+		0,     // modLocks
+		1,     // lockGroup
+		group, // groupLock
+		0,     // affectModLatches
+		0,     // modLatches
+		0,     // pad
+		0,     // latchGroup
+		0, 0,  // groupLatch
+	)
+
+	return k.conn.call(ctx, k.extension.Major, xkbLatchLockState, body)
+}
+
+// LoadKeymap compiles a keymap from its components on the server, and gives
+// it to device
+func (k *xkbClient) LoadKeymap(ctx context.Context, device uint16, components xkbComponents) error {
+	body := x11Byte.AppendUint16(nil, device)
+	body = x11Byte.AppendUint16(body, xkbGBNAllComponents&^xkbGBNGeometry) // need
+	body = x11Byte.AppendUint16(body, xkbGBNAllComponents)                 // want
+	body = append(body, 1, 0)                                              // load
+	for _, name := range []string{"", components.Keycodes, components.Types, components.Compat, components.Symbols, components.Geometry} {
+		body = append(body, uint8(len(name)))
+		body = append(body, name...)
+	}
+
+	reply, err := k.conn.request(ctx, k.extension.Major, xkbGetKbdByName, body)
+	if err != nil {
+		return err
+	}
+	if reply[10] == 0 {
+		return fmt.Errorf("X server failed to compile the keymap %s", components.Symbols)
+	}
+
+	return nil
+}
+
+// StateEvent returns the state reported by a StateNotify event, or false for
+// other events
+func (k *xkbClient) StateEvent(event []byte) (xkbState, bool) {
+	if event[0]&0x7f != k.extension.FirstEvent || event[1] != xkbStateNotify {
+		return xkbState{}, false
+	}
+	return xkbState{Group: event[13], LockedGroup: event[18]}, true
+}
+
+// RulesNames returns the rules, model, layouts, variants and options the
+// keymap was compiled from, as recorded on the root window
+func (k *xkbClient) RulesNames(ctx context.Context) (xkbNames, error) {
+	atom, err := k.conn.InternAtom(ctx, xkbRulesNamesProperty)
+	if err != nil {
+		return xkbNames{}, err
+	}
+
+	value, err := k.conn.GetProperty(ctx, k.conn.root, atom)
+	if err != nil {
+		return xkbNames{}, err
+	}
+
+	fields := strings.Split(string(value), "\x00")
+	for len(fields) < 5 {
+		fields = append(fields, "")
+	}
+
+	return xkbNames{
+		Rules:   fields[0],
+		Model:   fields[1],
+		Layout:  fields[2],
+		Variant: fields[3],
+		Options: fields[4],
+	}, nil
+}
+
+// SetRulesNames records the names a keymap was compiled from on the root
+// window, as setxkbmap does, for other clients to read
+func (k *xkbClient) SetRulesNames(ctx context.Context, names xkbNames) error {
+	atom, err := k.conn.InternAtom(ctx, xkbRulesNamesProperty)
+	if err != nil {
+		return err
+	}
+
+	value := strings.Join([]string{names.Rules, names.Model, names.Layout, names.Variant, names.Options}, "\x00") + "\x00"
+	return k.conn.ChangeProperty(ctx, k.conn.root, atom, x11AtomString, []byte(value))
+}
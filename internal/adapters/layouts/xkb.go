@@ -0,0 +1,119 @@
+package layouts
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// xkbLayout returns the XKB layout and variant switched to by a Linux layout
+func xkbLayout(layout *domain.KeyboardLayout) (name, variant string) {
+	group := xkbGroups(layout)[0]
+	return group.Layout, group.Variant
+}
+
+// xkbGroups returns the XKB layouts of a Linux layout, the one switched to
+// first. Layouts without XKB fields fall back to "us".
+func xkbGroups(layout *domain.KeyboardLayout) []domain.XKBGroup {
+	if layout.Layout == "" {
+		return []domain.XKBGroup{{Layout: "us"}}
+	}
+	return layout.XKBGroups()
+}
+
+// xkbGroupLists returns groups as the comma-separated layout and variant
+// lists of XKB, the variants being empty if no group has one
+func xkbGroupLists(groups []domain.XKBGroup) (layouts, variants string) {
+	layoutList := make([]string, 0, len(groups))
+	variantList := make([]string, 0, len(groups))
+	for _, group := range groups {
+		layoutList = append(layoutList, group.Layout)
+		variantList = append(variantList, group.Variant)
+	}
+
+	layouts, variants = strings.Join(layoutList, ","), strings.Join(variantList, ",")
+	if strings.Trim(variants, ",") == "" {
+		variants = ""
+	}
+	return layouts, variants
+}
+
+// xkbLayoutNames returns names with the model and options of a layout, and
+// its groups in place of the current ones if it has several
+func xkbLayoutNames(names xkbNames, layout *domain.KeyboardLayout, baseline *xkbOptionBaseline) xkbNames {
+	names = xkbApplyOptions(names, baseline, layout.Options)
+	if layout.Model != "" {
+		names.Model = layout.Model
+	}
+	if len(layout.Groups) > 0 {
+		names.Layout, names.Variant = xkbGroupLists(xkbGroups(layout))
+	}
+	return names
+}
+
+// xkbSetGroup returns names with the layout and variant of a group replaced,
+// and the index of the group, which is appended if names have fewer groups
+func xkbSetGroup(names xkbNames, group int, name, variant string) (xkbNames, int) {
+	layouts := splitList(names.Layout)
+	variants := padList(splitList(names.Variant), len(layouts))
+
+	if group >= len(layouts) {
+		group = len(layouts)
+		layouts, variants = append(layouts, ""), append(variants, "")
+	}
+	layouts[group], variants[group] = name, variant
+
+	groups := make([]domain.XKBGroup, 0, len(layouts))
+	for i := range layouts {
+		groups = append(groups, domain.XKBGroup{Layout: layouts[i], Variant: variants[i]})
+	}
+	names.Layout, names.Variant = xkbGroupLists(groups)
+
+	return names, group
+}
+
+// xkbApplyOptions returns names with the options of the baseline, changed
+// as a layout asks. Options already matching are left as they are.
+func xkbApplyOptions(names xkbNames, baseline *xkbOptionBaseline, changes domain.LayoutOptions) xkbNames {
+	current := splitList(names.Options)
+	if options := baseline.apply(current, changes); !slices.Equal(options, current) {
+		names.Options = strings.Join(options, ",")
+	}
+	return names
+}
+
+// xkbOptionBaseline keeps the XKB options in use before the first switch.
+// The option changes of a layout are made to them rather than to the options
+// left by the previous layout, so that switching to a layout without
+// changes restores them. Options polykeys did not set, changed outside it
+// since, become the new baseline.
+type xkbOptionBaseline struct {
+	options []string
+	written map[string]bool // options set by polykeys since the baseline was taken
+	mu      sync.Mutex
+}
+
+// apply returns the baseline options with changes made, current being the
+// options in use, which become the baseline unless polykeys set them
+func (b *xkbOptionBaseline) apply(current []string, changes domain.LayoutOptions) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.written[xkbOptionsKey(current)] {
+		b.options = slices.Clone(current)
+		b.written = map[string]bool{xkbOptionsKey(current): true}
+	}
+
+	options := changes.Apply(b.options)
+	b.written[xkbOptionsKey(options)] = true
+	return options
+}
+
+// xkbOptionsKey identifies a set of options whatever their order
+func xkbOptionsKey(options []string) string {
+	sorted := slices.Clone(options)
+	slices.Sort(sorted)
+	return strings.Join(sorted, ",")
+}
@@ -0,0 +1,131 @@
+package layouts
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// xkbRegistry is the part of an XKB registry such as rules/evdev.xml
+// describing the layouts
+type xkbRegistry struct {
+	Layouts []struct {
+		Item     xkbConfigItem `xml:"configItem"`
+		Variants []struct {
+			Item xkbConfigItem `xml:"configItem"`
+		} `xml:"variantList>variant"`
+	} `xml:"layoutList>layout"`
+}
+
+// xkbConfigItem describes a layout or variant of the registry
+type xkbConfigItem struct {
+	Name        string   `xml:"name"`
+	Description string   `xml:"description"`
+	Countries   []string `xml:"countryList>iso3166Id"`
+	Languages   []string `xml:"languageList>iso639Id"`
+}
+
+// ReadXKBRegistry returns the layouts and variants installed on the system,
+// named after their description such as "English (Colemak-DH)". They are
+// read from rules/evdev.xml in the XKB data, or from rules/evdev.lst, which
+// lacks the languages and countries, when the former cannot be read.
+func ReadXKBRegistry(env *Environment) ([]*domain.KeyboardLayout, error) {
+	rules := filepath.Join(xkbConfigRoot(env), "rules", xkbDefaultRules)
+
+	data, xmlErr := os.ReadFile(rules + ".xml")
+	if xmlErr == nil {
+		var layouts []*domain.KeyboardLayout
+		if layouts, xmlErr = parseXKBRegistryXML(data); xmlErr == nil {
+			return layouts, nil
+		}
+	}
+
+	data, err := os.ReadFile(rules + ".lst")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the XKB registry: %w (and %v)", err, xmlErr)
+	}
+	return parseXKBRegistryList(string(data)), nil
+}
+
+// parseXKBRegistryXML reads the layouts of an XKB registry. Variants
+// without their own languages or countries have those of their layout.
+func parseXKBRegistryXML(data []byte) ([]*domain.KeyboardLayout, error) {
+	var registry xkbRegistry
+	if err := xml.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse the XKB registry: %w", err)
+	}
+
+	layouts := make([]*domain.KeyboardLayout, 0, len(registry.Layouts))
+	for _, layout := range registry.Layouts {
+		item := layout.Item
+		layouts = append(layouts, newXKBRegistryLayout(item, domain.XKBGroup{Layout: item.Name}))
+
+		for _, variant := range layout.Variants {
+			variantItem := variant.Item
+			if len(variantItem.Languages) == 0 {
+				variantItem.Languages = item.Languages
+			}
+			if len(variantItem.Countries) == 0 {
+				variantItem.Countries = item.Countries
+			}
+			layouts = append(layouts, newXKBRegistryLayout(variantItem, domain.XKBGroup{Layout: item.Name, Variant: variantItem.Name}))
+		}
+	}
+
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("no layout in the XKB registry")
+	}
+	return layouts, nil
+}
+
+// parseXKBRegistryList reads the "! layout" and "! variant" sections of an
+// XKB registry list such as evdev.lst, whose variant lines look like
+// "  intl            us: English (US, intl., with dead keys)"
+func parseXKBRegistryList(text string) []*domain.KeyboardLayout {
+	layouts := make([]*domain.KeyboardLayout, 0)
+
+	var section string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "!") {
+			section = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+			continue
+		}
+
+		name, description, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		description = strings.TrimSpace(description)
+
+		switch section {
+		case "layout":
+			layouts = append(layouts, newXKBRegistryLayout(xkbConfigItem{Name: name, Description: description}, domain.XKBGroup{Layout: name}))
+		case "variant":
+			layout, description, ok := strings.Cut(description, ": ")
+			if !ok {
+				continue
+			}
+			layouts = append(layouts, newXKBRegistryLayout(xkbConfigItem{Name: name, Description: description}, domain.XKBGroup{Layout: layout, Variant: name}))
+		}
+	}
+
+	return layouts
+}
+
+// newXKBRegistryLayout creates the layout described by a registry item
+func newXKBRegistryLayout(item xkbConfigItem, group domain.XKBGroup) *domain.KeyboardLayout {
+	name := strings.TrimSpace(item.Description)
+	if name == "" {
+		name = group.String()
+	}
+
+	layout := domain.NewXKBKeyboardLayout(name, []domain.XKBGroup{group}, "", domain.LayoutOptions{})
+	layout.Languages = item.Languages
+	layout.Countries = item.Countries
+	layout.Origin = domain.LayoutOriginSystem
+	return layout
+}
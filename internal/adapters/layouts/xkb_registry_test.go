@@ -0,0 +1,172 @@
+package layouts
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+const testXKBRegistry = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE xkbConfigRegistry SYSTEM "xkb.dtd">
+<xkbConfigRegistry version="1.1">
+  <modelList>
+    <model>
+      <configItem>
+        <name>pc105</name>
+        <description>Generic 105-key PC</description>
+      </configItem>
+    </model>
+  </modelList>
+  <layoutList>
+    <layout>
+      <configItem>
+        <name>us</name>
+        <shortDescription>en</shortDescription>
+        <description>English (US)</description>
+        <countryList>
+          <iso3166Id>US</iso3166Id>
+        </countryList>
+        <languageList>
+          <iso639Id>eng</iso639Id>
+        </languageList>
+      </configItem>
+      <variantList>
+        <variant>
+          <configItem>
+            <name>chr</name>
+            <description>Cherokee</description>
+            <languageList>
+              <iso639Id>chr</iso639Id>
+            </languageList>
+          </configItem>
+        </variant>
+        <variant>
+          <configItem>
+            <name>colemak_dh</name>
+            <description>English (Colemak-DH)</description>
+          </configItem>
+        </variant>
+      </variantList>
+    </layout>
+    <layout>
+      <configItem>
+        <name>be</name>
+        <description>Belgian</description>
+        <languageList>
+          <iso639Id>deu</iso639Id>
+          <iso639Id>nld</iso639Id>
+          <iso639Id>fra</iso639Id>
+        </languageList>
+      </configItem>
+    </layout>
+  </layoutList>
+  <optionList>
+    <group allowMultipleSelection="true">
+      <configItem>
+        <name>ctrl</name>
+        <description>Ctrl position</description>
+      </configItem>
+    </group>
+  </optionList>
+</xkbConfigRegistry>
+`
+
+const testXKBRegistryList = `! model
+  pc105           Generic 105-key PC
+
+! layout
+  us              English (US)
+  be              Belgian
+
+! variant
+  chr             us: Cherokee
+  colemak_dh      us: English (Colemak-DH)
+
+! option
+  ctrl                 Ctrl position
+`
+
+func TestParseXKBRegistryXML(t *testing.T) {
+	layouts, err := parseXKBRegistryXML([]byte(testXKBRegistry))
+	if err != nil {
+		t.Fatalf("Failed to parse the registry: %v", err)
+	}
+
+	expected := []struct {
+		name       string
+		identifier string
+		languages  []string
+		countries  []string
+	}{
+		{"English (US)", "us", []string{"eng"}, []string{"US"}},
+		{"Cherokee", "us -variant chr", []string{"chr"}, []string{"US"}},
+		{"English (Colemak-DH)", "us -variant colemak_dh", []string{"eng"}, []string{"US"}},
+		{"Belgian", "be", []string{"deu", "nld", "fra"}, nil},
+	}
+	if len(layouts) != len(expected) {
+		t.Fatalf("Expected %d layouts, got %d", len(expected), len(layouts))
+	}
+	for i, e := range expected {
+		layout := layouts[i]
+		if layout.Name != e.name || layout.SystemIdentifier != e.identifier || layout.OS != domain.OSLinux || layout.Origin != domain.LayoutOriginSystem {
+			t.Errorf("Expected %s as %q, got %+v", e.name, e.identifier, layout)
+		}
+		if !reflect.DeepEqual(layout.Languages, e.languages) || !reflect.DeepEqual(layout.Countries, e.countries) {
+			t.Errorf("%s: expected languages %v and countries %v, got %v and %v", e.name, e.languages, e.countries, layout.Languages, layout.Countries)
+		}
+	}
+
+	if _, err := parseXKBRegistryXML([]byte("<xkbConfigRegistry/>")); err == nil {
+		t.Error("Expected an error for a registry without layouts")
+	}
+}
+
+func TestParseXKBRegistryList(t *testing.T) {
+	layouts := parseXKBRegistryList(testXKBRegistryList)
+
+	expected := map[string]string{
+		"English (US)":         "us",
+		"Belgian":              "be",
+		"Cherokee":             "us -variant chr",
+		"English (Colemak-DH)": "us -variant colemak_dh",
+	}
+	if len(layouts) != len(expected) {
+		t.Fatalf("Expected %d layouts, got %d", len(expected), len(layouts))
+	}
+	for _, layout := range layouts {
+		if expected[layout.Name] != layout.SystemIdentifier {
+			t.Errorf("Unexpected layout %s as %q", layout.Name, layout.SystemIdentifier)
+		}
+	}
+}
+
+func TestReadXKBRegistry_FallsBackToList(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "rules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "rules", "evdev.lst"), []byte(testXKBRegistryList), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := &Environment{Getenv: func(key string) string {
+		if key == "XKB_CONFIG_ROOT" {
+			return root
+		}
+		return ""
+	}}
+
+	layouts, err := ReadXKBRegistry(env)
+	if err != nil || len(layouts) != 4 {
+		t.Fatalf("Expected the layouts of evdev.lst, got %d (%v)", len(layouts), err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "rules", "evdev.xml"), []byte(testXKBRegistry), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if layouts, err = ReadXKBRegistry(env); err != nil || len(layouts[0].Languages) == 0 {
+		t.Errorf("Expected the layouts of evdev.xml, got %v (%v)", layouts, err)
+	}
+}
@@ -0,0 +1,414 @@
+package layouts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// xkbDefaultConfigRoot is where the XKB data is installed, unless
+// $XKB_CONFIG_ROOT says otherwise
+const xkbDefaultConfigRoot = "/usr/share/X11/xkb"
+
+// Defaults of X servers for unset names
+const (
+	xkbDefaultRules = "evdev"
+	xkbDefaultModel = "pc105"
+)
+
+// xkbNames are the rules, model, layouts, variants and options a keymap is
+// built from. Layouts and variants are comma-separated lists, one item per
+// group.
+type xkbNames struct {
+	Rules   string
+	Model   string
+	Layout  string
+	Variant string
+	Options string
+}
+
+// xkbComponents are the keymap components the rules turn names into, as
+// printed by setxkbmap -print
+type xkbComponents struct {
+	Keycodes string
+	Types    string
+	Compat   string
+	Symbols  string
+	Geometry string
+}
+
+// xkbRules is a parsed XKB rules file, such as rules/evdev
+type xkbRules struct {
+	groups   map[string][]string
+	mappings []*xkbRuleMapping
+}
+
+// xkbRuleMapping is a "! model layout[2] = symbols" section of a rules file
+// with its rules
+type xkbRuleMapping struct {
+	names      []xkbRuleName
+	components []string
+	rules      []xkbRule
+}
+
+// xkbRuleName is a name a mapping matches on, with the group it is about
+// for layout[N] and variant[N]. Index is 0 without brackets.
+type xkbRuleName struct {
+	Kind  string
+	Index int
+}
+
+// xkbRule is a line of a mapping: the values to match, and the components
+// they add
+type xkbRule struct {
+	match  []string
+	values []string
+}
+
+// xkbConfigRoot returns the directory of the XKB data
+func xkbConfigRoot(env *Environment) string {
+	if root := env.Getenv("XKB_CONFIG_ROOT"); root != "" {
+		return root
+	}
+	return xkbDefaultConfigRoot
+}
+
+// loadXKBRules reads the rules file named rules in the XKB data at root
+func loadXKBRules(root, rules string) (*xkbRules, error) {
+	r := &xkbRules{groups: make(map[string][]string)}
+	if err := r.parseFile(root, filepath.Join(root, "rules", rules), 0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *xkbRules) parseFile(root, path string, depth int) error {
+	if depth > 8 {
+		return fmt.Errorf("too many nested includes in %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read XKB rules: %w", err)
+	}
+
+	return r.parse(string(data), func(include string) error {
+		home, _ := os.UserHomeDir()
+		include = strings.NewReplacer("%S", filepath.Join(root, "rules"), "%H", home, "%%", "%").Replace(include)
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		return r.parseFile(root, include, depth+1)
+	})
+}
+
+// parse reads the groups and mappings of a rules file, calling include for
+// "! include" lines
+func (r *xkbRules) parse(text string, include func(path string) error) error {
+	var mapping *xkbRuleMapping
+
+	text = strings.ReplaceAll(text, "\\\n", " ")
+	for number, line := range strings.Split(text, "\n") {
+		if comment := strings.Index(line, "//"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(fields[0], "!") {
+			fields = strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "!"))
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch {
+			case fields[0] == "include" && len(fields) == 2:
+				if err := include(fields[1]); err != nil {
+					return err
+				}
+				mapping = nil
+			case strings.HasPrefix(fields[0], "$") && len(fields) >= 2 && fields[1] == "=":
+				r.groups[fields[0]] = fields[2:]
+			default:
+				parsed, err := parseXKBRuleMapping(fields)
+				if err != nil {
+					return fmt.Errorf("line %d of XKB rules: %w", number+1, err)
+				}
+				mapping = parsed
+				r.mappings = append(r.mappings, mapping)
+			}
+			continue
+		}
+
+		if mapping == nil {
+			continue
+		}
+
+		equals := indexOf(fields, "=")
+		if equals != len(mapping.names) || len(fields)-equals-1 != len(mapping.components) {
+			continue
+		}
+		mapping.rules = append(mapping.rules, xkbRule{match: fields[:equals], values: fields[equals+1:]})
+	}
+
+	return nil
+}
+
+// parseXKBRuleMapping parses a mapping header such as
+// "model layout[2] = symbols"
+func parseXKBRuleMapping(fields []string) (*xkbRuleMapping, error) {
+	equals := indexOf(fields, "=")
+	if equals < 1 || equals == len(fields)-1 {
+		return nil, fmt.Errorf("invalid mapping %q", strings.Join(fields, " "))
+	}
+
+	mapping := &xkbRuleMapping{components: fields[equals+1:]}
+	for _, field := range fields[:equals] {
+		kind, index := field, 0
+		if open := strings.Index(field, "["); open > 0 && strings.HasSuffix(field, "]") {
+			n, err := strconv.Atoi(field[open+1 : len(field)-1])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid index in %q", field)
+			}
+			kind, index = field[:open], n
+		}
+
+		switch kind {
+		case "model", "layout", "variant", "option":
+		default:
+			return nil, fmt.Errorf("unknown name %q", field)
+		}
+		mapping.names = append(mapping.names, xkbRuleName{Kind: kind, Index: index})
+	}
+
+	return mapping, nil
+}
+
+// Components resolves names into keymap components, as setxkbmap and
+// libxkbcommon do
+func (r *xkbRules) Components(names xkbNames) xkbComponents {
+	model := names.Model
+	if model == "" {
+		model = xkbDefaultModel
+	}
+	layouts := splitList(names.Layout)
+	variants := padList(splitList(names.Variant), len(layouts))
+	options := splitList(names.Options)
+
+	values := make(map[string]string)
+	for _, mapping := range r.mappings {
+		if !mapping.applies(len(layouts)) {
+			continue
+		}
+
+		for _, rule := range mapping.rules {
+			if !r.matches(mapping, rule, model, layouts, variants, options) {
+				continue
+			}
+
+			group := mapping.group()
+			for i, component := range mapping.components {
+				value := expandXKBRuleValue(rule.values[i], model, layouts, variants, group)
+				values[component] = mergeXKBComponent(values[component], value)
+			}
+
+			// Every matching option applies, but only the first other rule
+			if !mapping.hasOption() {
+				break
+			}
+		}
+	}
+
+	return xkbComponents{
+		Keycodes: values["keycodes"],
+		Types:    values["types"],
+		Compat:   values["compat"],
+		Symbols:  values["symbols"],
+		Geometry: values["geometry"],
+	}
+}
+
+// applies returns true if a mapping is about the number of groups: layout
+// without an index is only for a single group, layout[N] only for several
+// groups, up to N
+func (m *xkbRuleMapping) applies(groups int) bool {
+	for _, name := range m.names {
+		if name.Kind != "layout" && name.Kind != "variant" {
+			continue
+		}
+		if name.Index == 0 && groups > 1 {
+			return false
+		}
+		if name.Index > 0 && (groups < 2 || name.Index > groups) {
+			return false
+		}
+	}
+	return true
+}
+
+// group returns the group index a mapping is about, 0 for the first
+func (m *xkbRuleMapping) group() int {
+	for _, name := range m.names {
+		if name.Index > 0 {
+			return name.Index - 1
+		}
+	}
+	return 0
+}
+
+func (m *xkbRuleMapping) hasOption() bool {
+	for _, name := range m.names {
+		if name.Kind == "option" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *xkbRules) matches(mapping *xkbRuleMapping, rule xkbRule, model string, layouts, variants, options []string) bool {
+	for i, name := range mapping.names {
+		group := max(name.Index-1, 0)
+
+		var matched bool
+		switch name.Kind {
+		case "model":
+			matched = r.matchValue(rule.match[i], model)
+		case "layout":
+			matched = group < len(layouts) && r.matchValue(rule.match[i], layouts[group])
+		case "variant":
+			matched = group < len(variants) && r.matchValue(rule.match[i], variants[group])
+		case "option":
+			for _, option := range options {
+				if r.matchValue(rule.match[i], option) {
+					matched = true
+					break
+				}
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchValue matches a value against a rule value: a wildcard, a $group or
+// a literal
+func (r *xkbRules) matchValue(pattern, value string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "$"):
+		return indexOf(r.groups[pattern], value) >= 0
+	default:
+		return pattern == value
+	}
+}
+
+// expandXKBRuleValue replaces %m, %l and %v in a rule value, with their
+// %l[N] index, +, |, _ or - prefix, or %(v) parentheses
+func expandXKBRuleValue(value, model string, layouts, variants []string, group int) string {
+	var expanded strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i+1 == len(value) {
+			expanded.WriteByte(value[i])
+			continue
+		}
+
+		j := i + 1
+		prefix, parens := byte(0), false
+		switch value[j] {
+		case '+', '|', '_', '-':
+			prefix = value[j]
+			j++
+		case '(':
+			parens = true
+			j++
+		case '%':
+			expanded.WriteByte('%')
+			i = j
+			continue
+		}
+		if j == len(value) {
+			expanded.WriteString(value[i:])
+			break
+		}
+
+		kind := value[j]
+		j++
+		index := group
+		if j < len(value) && value[j] == '[' {
+			if end := strings.IndexByte(value[j:], ']'); end > 0 {
+				if n, err := strconv.Atoi(value[j+1 : j+end]); err == nil && n > 0 {
+					index = n - 1
+				}
+				j += end + 1
+			}
+		}
+		if parens && j < len(value) && value[j] == ')' {
+			j++
+		}
+
+		var item string
+		switch kind {
+		case 'm':
+			item = model
+		case 'l':
+			if index < len(layouts) {
+				item = layouts[index]
+			}
+		case 'v':
+			if index < len(variants) {
+				item = variants[index]
+			}
+		default:
+			expanded.WriteString(value[i:j])
+			i = j - 1
+			continue
+		}
+
+		if item != "" {
+			switch {
+			case parens:
+				item = "(" + item + ")"
+			case prefix != 0:
+				item = string(prefix) + item
+			}
+			expanded.WriteString(item)
+		}
+		i = j - 1
+	}
+
+	return expanded.String()
+}
+
+// mergeXKBComponent adds a rule value to a component: values starting with
+// + or | are appended, others only set an empty component or one made of
+// such additions
+func mergeXKBComponent(component, value string) string {
+	switch {
+	case value == "":
+		return component
+	case component == "" || value[0] == '+' || value[0] == '|':
+		return component + value
+	case component[0] == '+' || component[0] == '|':
+		return value + component
+	default:
+		return component
+	}
+}
+
+func indexOf(items []string, item string) int {
+	for i := range items {
+		if items[i] == item {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,118 @@
+package layouts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestXKBRules_Components(t *testing.T) {
+	rules, err := loadXKBRules(testXKBRoot(t), "evdev")
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		names    xkbNames
+		keycodes string
+		symbols  string
+	}{
+		{
+			name:     "single layout",
+			names:    xkbNames{Layout: "us"},
+			keycodes: "evdev+aliases(qwerty)",
+			symbols:  "pc+us+inet(evdev)",
+		},
+		{
+			name:     "variant",
+			names:    xkbNames{Layout: "fr", Variant: "oss"},
+			keycodes: "evdev+aliases(azerty)",
+			symbols:  "pc+fr(oss)+inet(evdev)",
+		},
+		{
+			name:     "groups",
+			names:    xkbNames{Layout: "fr,us", Variant: ",intl"},
+			keycodes: "evdev+aliases(azerty)",
+			symbols:  "pc+fr+us(intl):2+inet(evdev)",
+		},
+		{
+			name:     "options",
+			names:    xkbNames{Layout: "us", Options: "compose:ralt,ctrl:nocaps,unknown:option"},
+			keycodes: "evdev+aliases(qwerty)",
+			symbols:  "pc+us+inet(evdev)+ctrl(nocaps)+compose(ralt)",
+		},
+	}
+
+	for _, tt := range tests {
+		components := rules.Components(tt.names)
+		if components.Keycodes != tt.keycodes {
+			t.Errorf("%s: expected keycodes %q, got %q", tt.name, tt.keycodes, components.Keycodes)
+		}
+		if components.Symbols != tt.symbols {
+			t.Errorf("%s: expected symbols %q, got %q", tt.name, tt.symbols, components.Symbols)
+		}
+		if components.Types != "complete" || components.Compat != "complete" || components.Geometry != "pc(pc104)" {
+			t.Errorf("%s: unexpected components %+v", tt.name, components)
+		}
+	}
+}
+
+func TestXKBRules_Include(t *testing.T) {
+	root := testXKBRoot(t)
+	custom := "! include %S/evdev\n\n! option = symbols\n  custom:swap = +custom(swap)\n"
+	if err := os.WriteFile(filepath.Join(root, "rules", "custom"), []byte(custom), 0o644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+
+	rules, err := loadXKBRules(root, "custom")
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+
+	components := rules.Components(xkbNames{Layout: "us", Options: "custom:swap"})
+	if components.Symbols != "pc+us+inet(evdev)+custom(swap)" {
+		t.Errorf("Expected the included rules and the custom option, got %q", components.Symbols)
+	}
+}
+
+func TestExpandXKBRuleValue(t *testing.T) {
+	layouts := []string{"us", "de"}
+	variants := []string{"", "neo"}
+
+	tests := []struct {
+		value    string
+		group    int
+		expected string
+	}{
+		{"pc+%l%(v)", 0, "pc+us"},
+		{"+%l[2]%(v[2]):2", 1, "+de(neo):2"},
+		{"pc(%m)", 0, "pc(pc105)"},
+		{"%+v[2]", 0, "+neo"},
+		{"%_v", 0, ""},
+		{"100%%", 0, "100%"},
+	}
+
+	for _, tt := range tests {
+		if got := expandXKBRuleValue(tt.value, "pc105", layouts, variants, tt.group); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestMergeXKBComponent(t *testing.T) {
+	tests := []struct {
+		component, value, expected string
+	}{
+		{"", "evdev", "evdev"},
+		{"evdev", "+aliases(qwerty)", "evdev+aliases(qwerty)"},
+		{"+aliases(qwerty)", "evdev", "evdev+aliases(qwerty)"},
+		{"evdev", "base", "evdev"},
+	}
+
+	for _, tt := range tests {
+		if got := mergeXKBComponent(tt.component, tt.value); got != tt.expected {
+			t.Errorf("merging %q into %q: expected %q, got %q", tt.value, tt.component, tt.expected, got)
+		}
+	}
+}
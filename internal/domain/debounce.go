@@ -0,0 +1,42 @@
+package domain
+
+import "time"
+
+// Debounce holds the timings used to absorb connect/disconnect bursts from
+// sleeping wireless keyboards, KVM switches and hubs resetting on resume
+type Debounce struct {
+	// SettleDelay is how long a device must stay connected before its layout
+	// is applied
+	SettleDelay time.Duration
+	// DisconnectGrace is how long to wait after a disconnection before
+	// reverting the layout; it is cancelled if the device returns in time
+	DisconnectGrace time.Duration
+}
+
+// IsZero returns true if connect and disconnect events are handled immediately
+func (d Debounce) IsZero() bool {
+	return d.SettleDelay == 0 && d.DisconnectGrace == 0
+}
+
+// DebounceOverride holds the debounce timings a mapping sets itself. Unset
+// fields follow the global debounce.
+type DebounceOverride struct {
+	SettleDelay     *time.Duration
+	DisconnectGrace *time.Duration
+}
+
+// IsZero returns true if the override sets no field
+func (o DebounceOverride) IsZero() bool {
+	return o.SettleDelay == nil && o.DisconnectGrace == nil
+}
+
+// Apply returns defaults with the fields set by the override replaced
+func (o DebounceOverride) Apply(defaults Debounce) Debounce {
+	if o.SettleDelay != nil {
+		defaults.SettleDelay = *o.SettleDelay
+	}
+	if o.DisconnectGrace != nil {
+		defaults.DisconnectGrace = *o.DisconnectGrace
+	}
+	return defaults
+}
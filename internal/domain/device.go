@@ -2,6 +2,32 @@ package domain
 
 import "time"
 
+// DeviceClass describes what kind of keys an input device can produce
+type DeviceClass string
+
+const (
+	// DeviceClassUnknown is used when the detector does not classify devices
+	DeviceClassUnknown DeviceClass = ""
+	// DeviceClassKeyboard is a full keyboard reporting alphanumeric keys
+	DeviceClassKeyboard DeviceClass = "keyboard"
+	// DeviceClassKeypad is a numeric keypad or macropad without letters
+	DeviceClassKeypad DeviceClass = "keypad"
+	// DeviceClassConsumerKeys only reports media, power or system keys
+	DeviceClassConsumerKeys DeviceClass = "consumer-keys"
+	// DeviceClassNonKeyboard cannot produce keyboard keys at all
+	DeviceClassNonKeyboard DeviceClass = "non-keyboard"
+)
+
+// DeviceNode is one input interface of a physical device
+type DeviceNode struct {
+	// Name is the name the interface reports (e.g. "Corne Consumer Control")
+	Name string
+	// Path is the OS device node (e.g. /dev/input/event5)
+	Path string
+	// Class is the kind of keys this interface reports
+	Class DeviceClass
+}
+
 // Device represents a physical keyboard device
 type Device struct {
 	// ID is a unique identifier for the device (e.g., VID:PID combination)
@@ -16,6 +42,14 @@ type Device struct {
 	Alias string
 	// LastSeen is the timestamp when the device was last detected
 	LastSeen time.Time
+	// Class is the kind of keys the device reports
+	Class DeviceClass
+	// Nodes lists the input interfaces grouped into this device, if known
+	Nodes []DeviceNode
+	// Serial is the USB serial number or Bluetooth MAC address, if known
+	Serial string
+	// Phys is the physical port path (e.g. usb-0000:00:14.0-3), if known
+	Phys string
 }
 
 // NewDevice creates a new Device with the given parameters
@@ -30,6 +64,38 @@ func NewDevice(vendorID, productID, name string) *Device {
 	}
 }
 
+// InstanceID identifies this particular device among identical ones,
+// using the same qualified form as mapping device IDs
+func (d *Device) InstanceID() string {
+	switch {
+	case d.Serial != "":
+		return d.ID + "#serial=" + d.Serial
+	case d.Phys != "":
+		return d.ID + "@" + d.Phys
+	default:
+		return d.ID
+	}
+}
+
+// MappingID returns the device ID new mappings for this device use. It is
+// qualified with the serial when known, and with the port only when another
+// connected device has the same ID, as a port path stops matching once the
+// keyboard is plugged in elsewhere.
+func (d *Device) MappingID(connected []*Device) string {
+	if d.Serial != "" {
+		return d.ID + "#serial=" + d.Serial
+	}
+	if d.Phys == "" {
+		return d.ID
+	}
+	for _, other := range connected {
+		if other.ID == d.ID && other.InstanceID() != d.InstanceID() {
+			return d.ID + "@" + d.Phys
+		}
+	}
+	return d.ID
+}
+
 // UpdateLastSeen updates the LastSeen timestamp to now
 func (d *Device) UpdateLastSeen() {
 	d.LastSeen = time.Now()
@@ -47,3 +113,9 @@ func (d *Device) DisplayName() string {
 	}
 	return d.Name
 }
+
+// IsFullKeyboard returns true if the device should drive layout switching.
+// Devices from detectors that do not classify them are assumed to be keyboards.
+func (d *Device) IsFullKeyboard() bool {
+	return d.Class == DeviceClassKeyboard || d.Class == DeviceClassUnknown
+}
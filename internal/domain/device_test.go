@@ -98,3 +98,65 @@ func TestDevice_DisplayName(t *testing.T) {
 		})
 	}
 }
+
+func TestDevice_IsFullKeyboard(t *testing.T) {
+	tests := []struct {
+		class    DeviceClass
+		expected bool
+	}{
+		{DeviceClassUnknown, true},
+		{DeviceClassKeyboard, true},
+		{DeviceClassKeypad, false},
+		{DeviceClassConsumerKeys, false},
+		{DeviceClassNonKeyboard, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.class), func(t *testing.T) {
+			device := NewDevice("1234", "5678", "Test Device")
+			device.Class = tt.class
+
+			if device.IsFullKeyboard() != tt.expected {
+				t.Errorf("Expected IsFullKeyboard to be %v for class '%s'", tt.expected, tt.class)
+			}
+		})
+	}
+}
+
+func TestDevice_InstanceID(t *testing.T) {
+	device := NewDevice("4653", "0004", "foostan Corne")
+	if device.InstanceID() != "4653:0004" {
+		t.Errorf("Expected plain ID, got '%s'", device.InstanceID())
+	}
+
+	device.Phys = "usb-0000:00:14.0-3"
+	if device.InstanceID() != "4653:0004@usb-0000:00:14.0-3" {
+		t.Errorf("Expected port qualified ID, got '%s'", device.InstanceID())
+	}
+
+	device.Serial = "ABC123"
+	if device.InstanceID() != "4653:0004#serial=ABC123" {
+		t.Errorf("Expected serial qualified ID, got '%s'", device.InstanceID())
+	}
+}
+
+func TestDevice_MappingID(t *testing.T) {
+	device := NewDevice("4653", "0004", "foostan Corne")
+	device.Phys = "usb-0000:00:14.0-3"
+	other := NewDevice("4653", "0004", "foostan Corne")
+	other.Phys = "usb-0000:00:14.0-1"
+	laptop := NewDevice("0001", "0001", "AT Translated Set 2 keyboard")
+
+	if id := device.MappingID([]*Device{device, laptop}); id != "4653:0004" {
+		t.Errorf("Expected plain ID without an identical keyboard, got '%s'", id)
+	}
+
+	if id := device.MappingID([]*Device{device, other, laptop}); id != "4653:0004@usb-0000:00:14.0-3" {
+		t.Errorf("Expected port qualified ID next to an identical keyboard, got '%s'", id)
+	}
+
+	device.Serial = "ABC123"
+	if id := device.MappingID(nil); id != "4653:0004#serial=ABC123" {
+		t.Errorf("Expected serial qualified ID, got '%s'", id)
+	}
+}
@@ -0,0 +1,199 @@
+package domain
+
+import (
+	"log"
+	"sync"
+)
+
+// EventType identifies the kind of an Event
+type EventType string
+
+const (
+	// EventDeviceConnected is published when a keyboard is connected
+	EventDeviceConnected EventType = "device_connected"
+	// EventDeviceDisconnected is published when a keyboard is disconnected
+	EventDeviceDisconnected EventType = "device_disconnected"
+	// EventLayoutSwitched is published after the layout was changed
+	EventLayoutSwitched EventType = "layout_switched"
+	// EventSwitchFailed is published when changing the layout failed
+	EventSwitchFailed EventType = "switch_failed"
+	// EventConfigReloaded is published after the configuration was loaded
+	EventConfigReloaded EventType = "config_reloaded"
+	// EventLayoutChanged is published when the layout was changed outside
+	// polykeys, for instance with a keyboard shortcut
+	EventLayoutChanged EventType = "layout_changed"
+	// EventDetectorFailed is published when a device detector stopped
+	// reporting devices
+	EventDetectorFailed EventType = "detector_failed"
+)
+
+// Event is something that happened in polykeys
+type Event interface {
+	// Type returns the kind of the event
+	Type() EventType
+}
+
+// DeviceConnected is published by detectors when a keyboard is connected
+type DeviceConnected struct {
+	Device *Device
+}
+
+// Type returns EventDeviceConnected
+func (DeviceConnected) Type() EventType { return EventDeviceConnected }
+
+// DeviceDisconnected is published by detectors when a keyboard is disconnected
+type DeviceDisconnected struct {
+	Device *Device
+}
+
+// Type returns EventDeviceDisconnected
+func (DeviceDisconnected) Type() EventType { return EventDeviceDisconnected }
+
+// LayoutSwitched is published after the layout of a mapping was applied
+type LayoutSwitched struct {
+	// Layout is the layout now active
+	Layout *KeyboardLayout
+	// Mapping is the mapping the layout was applied for
+	Mapping *Mapping
+	// Device is the only keyboard the layout was applied to, or nil if it
+	// was applied to every keyboard
+	Device *Device
+}
+
+// Type returns EventLayoutSwitched
+func (LayoutSwitched) Type() EventType { return EventLayoutSwitched }
+
+// SwitchFailed is published when the layout of a mapping could not be applied
+type SwitchFailed struct {
+	// Mapping is the mapping whose layout could not be applied
+	Mapping *Mapping
+	// Err is the reason of the failure
+	Err error
+}
+
+// Type returns EventSwitchFailed
+func (SwitchFailed) Type() EventType { return EventSwitchFailed }
+
+// ConfigReloaded is published after the configuration file was loaded
+type ConfigReloaded struct {
+	Config *Config
+}
+
+// Type returns EventConfigReloaded
+func (ConfigReloaded) Type() EventType { return EventConfigReloaded }
+
+// LayoutChanged is published by layout backends noticing that the active
+// layout was changed outside polykeys
+type LayoutChanged struct {
+	// Keyboard is the backend's name for the keyboard, empty if the layout
+	// changed for every keyboard
+	Keyboard string
+	// Layout is the backend's name for the now active layout
+	Layout string
+}
+
+// Type returns EventLayoutChanged
+func (LayoutChanged) Type() EventType { return EventLayoutChanged }
+
+// DetectorFailed is published by detectors that can no longer follow the
+// devices being connected and disconnected
+type DetectorFailed struct {
+	// Err is the reason of the failure
+	Err error
+}
+
+// Type returns EventDetectorFailed
+func (DetectorFailed) Type() EventType { return EventDetectorFailed }
+
+// EventBus delivers events to every subscriber interested in their type.
+// Handlers run synchronously in the publishing goroutine, in subscription
+// order, so they should return quickly.
+type EventBus struct {
+	subscribers []*Subscription
+	nextID      uint64
+	mu          sync.RWMutex
+}
+
+// Subscription is a handle on a subscriber, used to unsubscribe it
+type Subscription struct {
+	bus     *EventBus
+	id      uint64
+	types   map[EventType]bool
+	handler func(Event)
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe registers a handler for the given event types, or for every
+// event if no type is given
+func (b *EventBus) Subscribe(handler func(Event), types ...EventType) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	sub := &Subscription{
+		bus:     b,
+		id:      b.nextID,
+		handler: handler,
+	}
+
+	if len(types) > 0 {
+		sub.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.subscribers = append(b.subscribers, sub)
+	return sub
+}
+
+// Unsubscribe stops delivering events to the subscriber. It is safe to call
+// more than once, and from within a handler.
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	for i, sub := range s.bus.subscribers {
+		if sub.id == s.id {
+			s.bus.subscribers = append(s.bus.subscribers[:i:i], s.bus.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Publish delivers an event to the subscribers interested in its type
+func (b *EventBus) Publish(event Event) {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, sub := range subscribers {
+		if sub.types != nil && !sub.types[event.Type()] {
+			continue
+		}
+		sub.deliver(event)
+	}
+}
+
+// SubscriberCount returns the number of active subscriptions
+func (b *EventBus) SubscriberCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// deliver calls the handler, keeping a panicking subscriber from taking the
+// publisher down with it
+func (s *Subscription) deliver(event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in %s event handler: %v", event.Type(), r)
+		}
+	}()
+
+	s.handler(event)
+}
@@ -0,0 +1,80 @@
+package domain
+
+import "testing"
+
+func TestEventBus_SubscribeByType(t *testing.T) {
+	bus := NewEventBus()
+	device := NewDevice("4653", "0004", "foostan Corne")
+
+	var connected, all []EventType
+	bus.Subscribe(func(event Event) { connected = append(connected, event.Type()) }, EventDeviceConnected)
+	bus.Subscribe(func(event Event) { all = append(all, event.Type()) })
+
+	bus.Publish(DeviceConnected{Device: device})
+	bus.Publish(DeviceDisconnected{Device: device})
+
+	if len(connected) != 1 || connected[0] != EventDeviceConnected {
+		t.Errorf("Expected only device_connected, got %v", connected)
+	}
+	if len(all) != 2 {
+		t.Errorf("Expected both events, got %v", all)
+	}
+}
+
+func TestEventBus_Unsubscribe(t *testing.T) {
+	bus := NewEventBus()
+	device := NewDevice("4653", "0004", "foostan Corne")
+
+	first, second := 0, 0
+	sub := bus.Subscribe(func(Event) { first++ })
+	bus.Subscribe(func(Event) { second++ })
+
+	bus.Publish(DeviceConnected{Device: device})
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	bus.Publish(DeviceConnected{Device: device})
+
+	if first != 1 {
+		t.Errorf("Expected unsubscribed handler to run once, ran %d times", first)
+	}
+	if second != 2 {
+		t.Errorf("Expected remaining handler to run twice, ran %d times", second)
+	}
+}
+
+func TestEventBus_UnsubscribeFromHandler(t *testing.T) {
+	bus := NewEventBus()
+	device := NewDevice("4653", "0004", "foostan Corne")
+
+	calls, other := 0, 0
+	var sub *Subscription
+	sub = bus.Subscribe(func(Event) {
+		calls++
+		sub.Unsubscribe()
+	})
+	bus.Subscribe(func(Event) { other++ })
+
+	bus.Publish(DeviceConnected{Device: device})
+	bus.Publish(DeviceConnected{Device: device})
+
+	if calls != 1 {
+		t.Errorf("Expected handler to run once, ran %d times", calls)
+	}
+	if other != 2 {
+		t.Errorf("Expected other handler to keep receiving events, ran %d times", other)
+	}
+}
+
+func TestEventBus_PanickingHandler(t *testing.T) {
+	bus := NewEventBus()
+
+	delivered := false
+	bus.Subscribe(func(Event) { panic("boom") })
+	bus.Subscribe(func(Event) { delivered = true })
+
+	bus.Publish(ConfigReloaded{Config: &Config{}})
+
+	if !delivered {
+		t.Error("Expected event to reach the handler after the panicking one")
+	}
+}
@@ -1,5 +1,10 @@
 package domain
 
+import (
+	"slices"
+	"strings"
+)
+
 // OperatingSystem represents the target OS for a keyboard layout
 type OperatingSystem string
 
@@ -13,9 +18,9 @@ const (
 // These constants ensure consistency across all adapters
 const (
 	// US layouts
-	LayoutUSQwerty                = "US Qwerty"
-	LayoutUSInternational         = "US International"
-	LayoutUSInternationalDeadKeys = "US International Dead Keys"
+	LayoutUSQwerty             = "US Qwerty"
+	LayoutUSInternational      = "US International"
+	LayoutUSInternationalAltGr = "US International AltGr Dead Keys"
 
 	// French layouts
 	LayoutFrenchAzerty = "French AZERTY"
@@ -42,19 +47,215 @@ type KeyboardLayout struct {
 	ID string
 	// Name is the human-readable layout name
 	Name string
+	// Aliases are other names the layout can be referred to by
+	Aliases []string
 	// OS is the operating system this layout is for
 	OS OperatingSystem
 	// SystemIdentifier is the OS-specific identifier for the layout
 	// (e.g., "us" for Linux, "com.apple.keylayout.US" for macOS)
 	SystemIdentifier string
+	// Layout and Variant are the XKB layout of a Linux layout, such as "us"
+	// and "intl"
+	Layout  string
+	Variant string
+	// Groups are more XKB layouts loaded after Layout, reachable with the
+	// group switching shortcut
+	Groups []XKBGroup
+	// Model is the XKB keyboard model, empty to keep the one in use
+	Model string
+	// Options are the XKB option changes to make along with the switch
+	Options LayoutOptions
+	// Languages are the ISO 639 codes of the languages the layout is for,
+	// such as "fra", and Countries the ISO 3166 codes of the countries
+	// using it, when known
+	Languages []string
+	Countries []string
+	// Origin tells where the layout was declared
+	Origin LayoutOrigin
+}
+
+// LayoutOrigin tells where a layout comes from
+type LayoutOrigin string
+
+const (
+	// LayoutOriginBuiltIn layouts ship with polykeys
+	LayoutOriginBuiltIn LayoutOrigin = "built-in"
+	// LayoutOriginConfig layouts are declared in the config file
+	LayoutOriginConfig LayoutOrigin = "config"
+	// LayoutOriginSystem layouts are found among the ones installed on the
+	// system
+	LayoutOriginSystem LayoutOrigin = "system"
+)
+
+// XKBGroup is a layout of an XKB keymap with its variant
+type XKBGroup struct {
+	Layout  string
+	Variant string
+}
+
+// String returns the group in the XKB syntax, such as "us(intl)"
+func (g XKBGroup) String() string {
+	if g.Variant == "" {
+		return g.Layout
+	}
+	return g.Layout + "(" + g.Variant + ")"
+}
+
+// LayoutOptions are XKB options to add to or remove from the ones in use,
+// such as "ctrl:nocaps". Options not listed are kept.
+type LayoutOptions struct {
+	Add    []string
+	Remove []string
+}
+
+// IsZero returns true if the options change nothing
+func (o LayoutOptions) IsZero() bool {
+	return len(o.Add) == 0 && len(o.Remove) == 0
+}
+
+// Apply returns options without the removed ones and with the added ones
+// appended, unless already present
+func (o LayoutOptions) Apply(options []string) []string {
+	applied := make([]string, 0, len(options)+len(o.Add))
+	for _, option := range options {
+		if !slices.Contains(o.Remove, option) && !slices.Contains(applied, option) {
+			applied = append(applied, option)
+		}
+	}
+	for _, option := range o.Add {
+		if !slices.Contains(applied, option) {
+			applied = append(applied, option)
+		}
+	}
+	return applied
+}
+
+// Merge returns the changes of o followed by those of other, which win
+// when both change the same option
+func (o LayoutOptions) Merge(other LayoutOptions) LayoutOptions {
+	merged := LayoutOptions{}
+	for _, option := range o.Add {
+		if !slices.Contains(other.Remove, option) {
+			merged.Add = append(merged.Add, option)
+		}
+	}
+	for _, option := range o.Remove {
+		if !slices.Contains(other.Add, option) {
+			merged.Remove = append(merged.Remove, option)
+		}
+	}
+	merged.Add = append(merged.Add, other.Add...)
+	merged.Remove = append(merged.Remove, other.Remove...)
+	return merged
 }
 
-// NewKeyboardLayout creates a new KeyboardLayout
+// NewKeyboardLayout creates a new KeyboardLayout. Linux identifiers use the
+// setxkbmap syntax, such as "us,ru -variant ,phonetic -option ctrl:nocaps",
+// and fill the XKB fields.
 func NewKeyboardLayout(name string, os OperatingSystem, systemIdentifier string) *KeyboardLayout {
-	return &KeyboardLayout{
+	layout := &KeyboardLayout{
 		ID:               name + ":" + string(os),
 		Name:             name,
 		OS:               os,
 		SystemIdentifier: systemIdentifier,
 	}
+	if os == OSLinux {
+		layout.parseXKBIdentifier()
+	}
+	return layout
+}
+
+// NewXKBKeyboardLayout creates a Linux layout from its XKB groups, the first
+// one being switched to
+func NewXKBKeyboardLayout(name string, groups []XKBGroup, model string, options LayoutOptions) *KeyboardLayout {
+	layout := &KeyboardLayout{
+		ID:      name + ":" + string(OSLinux),
+		Name:    name,
+		OS:      OSLinux,
+		Model:   model,
+		Options: options,
+	}
+	if len(groups) > 0 {
+		layout.Layout, layout.Variant = groups[0].Layout, groups[0].Variant
+		layout.Groups = groups[1:]
+	}
+	layout.SystemIdentifier = layout.xkbIdentifier()
+	return layout
+}
+
+// XKBGroups returns the XKB layouts of a Linux layout, Layout first
+func (l *KeyboardLayout) XKBGroups() []XKBGroup {
+	groups := make([]XKBGroup, 0, len(l.Groups)+1)
+	groups = append(groups, XKBGroup{Layout: l.Layout, Variant: l.Variant})
+	return append(groups, l.Groups...)
+}
+
+// WithOptions returns a copy of the layout also making the given option
+// changes
+func (l *KeyboardLayout) WithOptions(options LayoutOptions) *KeyboardLayout {
+	layout := *l
+	layout.Options = l.Options.Merge(options)
+	return &layout
+}
+
+// parseXKBIdentifier fills the XKB fields from a setxkbmap identifier
+func (l *KeyboardLayout) parseXKBIdentifier() {
+	var layouts, variants []string
+
+	fields := strings.Fields(l.SystemIdentifier)
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "-") || i+1 == len(fields) {
+			if len(layouts) == 0 {
+				layouts = strings.Split(fields[i], ",")
+			}
+			continue
+		}
+
+		i++
+		switch value := fields[i]; fields[i-1] {
+		case "-layout":
+			layouts = strings.Split(value, ",")
+		case "-variant":
+			variants = strings.Split(value, ",")
+		case "-model":
+			l.Model = value
+		case "-option":
+			l.Options.Add = append(l.Options.Add, strings.Split(value, ",")...)
+		}
+	}
+
+	for i, layout := range layouts {
+		group := XKBGroup{Layout: layout}
+		if i < len(variants) {
+			group.Variant = variants[i]
+		}
+		if i == 0 {
+			l.Layout, l.Variant = group.Layout, group.Variant
+		} else {
+			l.Groups = append(l.Groups, group)
+		}
+	}
+}
+
+// xkbIdentifier returns the XKB fields in the setxkbmap syntax
+func (l *KeyboardLayout) xkbIdentifier() string {
+	groups := l.XKBGroups()
+	layouts := make([]string, 0, len(groups))
+	variants := make([]string, 0, len(groups))
+	for _, group := range groups {
+		layouts = append(layouts, group.Layout)
+		variants = append(variants, group.Variant)
+	}
+
+	identifier := strings.Join(layouts, ",")
+	if variant := strings.Join(variants, ","); strings.Trim(variant, ",") != "" {
+		identifier += " -variant " + variant
+	}
+	if l.Model != "" {
+		identifier += " -model " + l.Model
+	}
+	if len(l.Options.Add) > 0 {
+		identifier += " -option " + strings.Join(l.Options.Add, ",")
+	}
+	return identifier
 }
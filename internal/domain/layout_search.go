@@ -0,0 +1,265 @@
+package domain
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// iso639Alpha3 maps the two-letter ISO 639-1 codes of common languages to
+// their three-letter ISO 639-2 codes, terminology code first
+var iso639Alpha3 = map[string][]string{
+	"ar": {"ara"},
+	"be": {"bel"},
+	"bg": {"bul"},
+	"bn": {"ben"},
+	"bs": {"bos"},
+	"ca": {"cat"},
+	"cs": {"ces", "cze"},
+	"da": {"dan"},
+	"de": {"deu", "ger"},
+	"el": {"ell", "gre"},
+	"en": {"eng"},
+	"eo": {"epo"},
+	"es": {"spa"},
+	"et": {"est"},
+	"eu": {"eus", "baq"},
+	"fa": {"fas", "per"},
+	"fi": {"fin"},
+	"fr": {"fra", "fre"},
+	"ga": {"gle"},
+	"he": {"heb"},
+	"hi": {"hin"},
+	"hr": {"hrv"},
+	"hu": {"hun"},
+	"hy": {"hye", "arm"},
+	"is": {"isl", "ice"},
+	"it": {"ita"},
+	"ja": {"jpn"},
+	"ka": {"kat", "geo"},
+	"kk": {"kaz"},
+	"ko": {"kor"},
+	"lt": {"lit"},
+	"lv": {"lav"},
+	"mk": {"mkd", "mac"},
+	"mn": {"mon"},
+	"nb": {"nob"},
+	"nl": {"nld", "dut"},
+	"nn": {"nno"},
+	"no": {"nor"},
+	"pl": {"pol"},
+	"pt": {"por"},
+	"ro": {"ron", "rum"},
+	"ru": {"rus"},
+	"sk": {"slk", "slo"},
+	"sl": {"slv"},
+	"sq": {"sqi", "alb"},
+	"sr": {"srp"},
+	"sv": {"swe"},
+	"th": {"tha"},
+	"tr": {"tur"},
+	"uk": {"ukr"},
+	"ur": {"urd"},
+	"vi": {"vie"},
+	"zh": {"zho", "chi"},
+}
+
+// XKBCode returns the XKB groups of a Linux layout, such as "us(intl)" or
+// "us,ru(phonetic)", empty for other layouts
+func (l *KeyboardLayout) XKBCode() string {
+	if l.Layout == "" {
+		return ""
+	}
+
+	groups := l.XKBGroups()
+	codes := make([]string, 0, len(groups))
+	for _, group := range groups {
+		codes = append(codes, group.String())
+	}
+	return strings.Join(codes, ",")
+}
+
+// HasName returns true if name is the name or an alias of the layout,
+// ignoring case and spacing
+func (l *KeyboardLayout) HasName(name string) bool {
+	name = normalizeLayoutName(name)
+	if normalizeLayoutName(l.Name) == name {
+		return true
+	}
+	for _, alias := range l.Aliases {
+		if normalizeLayoutName(alias) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCode returns true if code is the system identifier of the layout or,
+// for Linux layouts, its XKB code such as "us(intl)", ignoring case and
+// spacing
+func (l *KeyboardLayout) HasCode(code string) bool {
+	code = normalizeLayoutName(code)
+	if code == "" {
+		return false
+	}
+	return normalizeLayoutName(l.SystemIdentifier) == code ||
+		(l.Layout != "" && strings.ToLower(l.XKBCode()) == code)
+}
+
+// ResolveLayout returns the first of layouts named name, by name or alias,
+// or else the first one whose code it is. Case and spacing are ignored.
+func ResolveLayout(layouts []*KeyboardLayout, name string) *KeyboardLayout {
+	for _, layout := range layouts {
+		if layout.HasName(name) {
+			return layout
+		}
+	}
+	for _, layout := range layouts {
+		if layout.HasCode(name) {
+			return layout
+		}
+	}
+	return nil
+}
+
+// ClosestLayouts returns up to count layouts whose name or alias is the
+// closest to name by edit distance, closest first. Punctuation and spacing
+// are ignored, and the details in parentheses of names such as
+// "English (Colemak-DH)" are compared on their own too.
+func ClosestLayouts(layouts []*KeyboardLayout, name string, count int) []*KeyboardLayout {
+	name = distanceKey(name)
+
+	type candidate struct {
+		layout   *KeyboardLayout
+		distance int
+	}
+	candidates := make([]candidate, 0, len(layouts))
+	for _, layout := range layouts {
+		names := append([]string{layout.Name}, layout.Aliases...)
+		if _, details, ok := strings.Cut(layout.Name, "("); ok {
+			names = append(names, strings.TrimSuffix(details, ")"))
+		}
+
+		distance := -1
+		for _, candidateName := range names {
+			if d := editDistance(name, distanceKey(candidateName)); distance < 0 || d < distance {
+				distance = d
+			}
+		}
+		candidates = append(candidates, candidate{layout, distance})
+	}
+
+	// Among equally close layouts, the first ones given win
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	closest := make([]*KeyboardLayout, 0, count)
+	for i := 0; i < len(candidates) && i < count; i++ {
+		closest = append(closest, candidates[i].layout)
+	}
+	return closest
+}
+
+// normalizeLayoutName lowercases a name and collapses its spacing
+func normalizeLayoutName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// distanceKey returns the lowercase letters and digits of a name
+func distanceKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// Matches returns true if query, ignoring case, is part of the layout name,
+// of an alias, of its system identifier or of its XKB code such as
+// "us(intl)"
+func (l *KeyboardLayout) Matches(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return true
+	}
+
+	if strings.Contains(strings.ToLower(l.Name), query) ||
+		strings.Contains(strings.ToLower(l.SystemIdentifier), query) {
+		return true
+	}
+	for _, alias := range l.Aliases {
+		if strings.Contains(strings.ToLower(alias), query) {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(l.XKBCode()), query)
+}
+
+// HasLanguage returns true if the layout is for a language, given as an
+// ISO 639-1 or 639-2 code such as "de", "deu" or "ger"
+func (l *KeyboardLayout) HasLanguage(code string) bool {
+	codes := languageCodes(strings.ToLower(strings.TrimSpace(code)))
+	for _, language := range l.Languages {
+		if slices.Contains(codes, strings.ToLower(language)) {
+			return true
+		}
+	}
+	return false
+}
+
+// languageCodes returns the codes of the language of an ISO 639 code
+func languageCodes(code string) []string {
+	if alpha3, ok := iso639Alpha3[code]; ok {
+		return append([]string{code}, alpha3...)
+	}
+	for alpha2, alpha3 := range iso639Alpha3 {
+		if slices.Contains(alpha3, code) {
+			return append([]string{alpha2}, alpha3...)
+		}
+	}
+	return []string{code}
+}
+
+// SearchLayouts returns the layouts matching query and, unless empty, for
+// language, sorted by name
+func SearchLayouts(layouts []*KeyboardLayout, query, language string) []*KeyboardLayout {
+	found := make([]*KeyboardLayout, 0, len(layouts))
+	for _, layout := range layouts {
+		if !layout.Matches(query) {
+			continue
+		}
+		if strings.TrimSpace(language) != "" && !layout.HasLanguage(language) {
+			continue
+		}
+		found = append(found, layout)
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+	return found
+}
@@ -0,0 +1,137 @@
+package domain
+
+import "testing"
+
+func TestKeyboardLayout_Matches(t *testing.T) {
+	layout := NewKeyboardLayout("English (Colemak-DH)", OSLinux, "us -variant colemak_dh")
+
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"", true},
+		{"colemak", true},
+		{"  Colemak-DH ", true},
+		{"us(colemak_dh)", true},
+		{"colemak_dh", true},
+		{"dvorak", false},
+		{"us(intl)", false},
+	}
+
+	for _, tt := range tests {
+		if got := layout.Matches(tt.query); got != tt.expected {
+			t.Errorf("Matches(%q) = %v, expected %v", tt.query, got, tt.expected)
+		}
+	}
+}
+
+func TestKeyboardLayout_HasLanguage(t *testing.T) {
+	layout := NewKeyboardLayout("German", OSLinux, "de")
+	layout.Languages = []string{"deu"}
+
+	for _, code := range []string{"de", "DE", "deu", "ger"} {
+		if !layout.HasLanguage(code) {
+			t.Errorf("Expected %q to match German", code)
+		}
+	}
+	for _, code := range []string{"fr", "fra", ""} {
+		if layout.HasLanguage(code) {
+			t.Errorf("Expected %q not to match German", code)
+		}
+	}
+}
+
+func TestSearchLayouts(t *testing.T) {
+	german := NewKeyboardLayout("German", OSLinux, "de")
+	german.Languages = []string{"deu"}
+	neo := NewKeyboardLayout("German (Neo 2)", OSLinux, "de -variant neo")
+	neo.Languages = []string{"deu"}
+	french := NewKeyboardLayout("French", OSLinux, "fr")
+	french.Languages = []string{"fra"}
+
+	layouts := []*KeyboardLayout{neo, french, german}
+
+	if found := SearchLayouts(layouts, "", ""); len(found) != 3 || found[0] != french || found[1] != german || found[2] != neo {
+		t.Errorf("Expected every layout sorted by name, got %v", found)
+	}
+	if found := SearchLayouts(layouts, "neo", ""); len(found) != 1 || found[0] != neo {
+		t.Errorf("Expected the Neo layout, got %v", found)
+	}
+	if found := SearchLayouts(layouts, "", "de"); len(found) != 2 {
+		t.Errorf("Expected the German layouts, got %v", found)
+	}
+	if found := SearchLayouts(layouts, "neo", "fr"); len(found) != 0 {
+		t.Errorf("Expected no layout, got %v", found)
+	}
+}
+
+func TestResolveLayout(t *testing.T) {
+	usQwerty := NewKeyboardLayout(LayoutUSQwerty, OSLinux, "us")
+	usQwerty.Aliases = []string{"US"}
+	usIntl := NewKeyboardLayout(LayoutUSInternational, OSLinux, "us -variant intl")
+	systemUS := NewKeyboardLayout("English (US)", OSLinux, "us")
+	windows := NewKeyboardLayout(LayoutUSQwerty, OSWindows, "00000409")
+
+	layouts := []*KeyboardLayout{usQwerty, usIntl, systemUS}
+
+	tests := []struct {
+		name     string
+		expected *KeyboardLayout
+	}{
+		{"US Qwerty", usQwerty},
+		{"  us   QWERTY ", usQwerty},
+		{"us", usQwerty},
+		{"US", usQwerty},
+		{"us(intl)", usIntl},
+		{"US -variant intl", usIntl},
+		{"english (us)", systemUS},
+		{"us(colemak)", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		if got := ResolveLayout(layouts, tt.name); got != tt.expected {
+			t.Errorf("ResolveLayout(%q) = %v, expected %v", tt.name, got, tt.expected)
+		}
+	}
+
+	if got := ResolveLayout([]*KeyboardLayout{windows}, "00000409"); got != windows {
+		t.Errorf("Expected the Windows layout by its KLID, got %v", got)
+	}
+}
+
+func TestClosestLayouts(t *testing.T) {
+	colemak := NewKeyboardLayout(LayoutColemak, OSLinux, "us -variant colemak")
+	dvorak := NewKeyboardLayout(LayoutDvorak, OSLinux, "us -variant dvorak")
+	french := NewKeyboardLayout(LayoutFrenchAzerty, OSLinux, "fr")
+	french.Aliases = []string{"French"}
+
+	layouts := []*KeyboardLayout{dvorak, french, colemak}
+
+	closest := ClosestLayouts(layouts, "Colmak", 2)
+	if len(closest) != 2 || closest[0] != colemak {
+		t.Errorf("Expected Colemak first, got %v", closest)
+	}
+	if closest := ClosestLayouts(layouts, "frnech", 1); len(closest) != 1 || closest[0] != french {
+		t.Errorf("Expected French AZERTY by its alias, got %v", closest)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"us", "", 2},
+		{"colemak", "colmak", 1},
+		{"kitten", "sitting", 3},
+		{"español", "espanol", 1},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.expected {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "testing"
+import (
+	"slices"
+	"testing"
+)
 
 func TestNewKeyboardLayout(t *testing.T) {
 	name := "US International"
@@ -84,3 +87,100 @@ func TestKeyboardLayout_DifferentOS(t *testing.T) {
 		})
 	}
 }
+
+func TestLayoutOptions_Apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  LayoutOptions
+		current  []string
+		expected []string
+	}{
+		{"no changes", LayoutOptions{}, []string{"ctrl:nocaps", "compose:ralt"}, []string{"ctrl:nocaps", "compose:ralt"}},
+		{"add", LayoutOptions{Add: []string{"compose:ralt"}}, []string{"ctrl:nocaps"}, []string{"ctrl:nocaps", "compose:ralt"}},
+		{"add present", LayoutOptions{Add: []string{"ctrl:nocaps"}}, []string{"ctrl:nocaps"}, []string{"ctrl:nocaps"}},
+		{"remove", LayoutOptions{Remove: []string{"ctrl:nocaps"}}, []string{"ctrl:nocaps", "compose:ralt"}, []string{"compose:ralt"}},
+		{"remove missing", LayoutOptions{Remove: []string{"grp:alt_shift_toggle"}}, []string{"ctrl:nocaps"}, []string{"ctrl:nocaps"}},
+		{"add and remove", LayoutOptions{Add: []string{"ctrl:swapcaps"}, Remove: []string{"ctrl:nocaps"}}, []string{"ctrl:nocaps"}, []string{"ctrl:swapcaps"}},
+		{"none in use", LayoutOptions{Add: []string{"ctrl:nocaps"}}, nil, []string{"ctrl:nocaps"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applied := tt.options.Apply(tt.current)
+			if !slices.Equal(applied, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, applied)
+			}
+		})
+	}
+}
+
+func TestKeyboardLayout_WithOptions(t *testing.T) {
+	layout := NewKeyboardLayout("US Qwerty", OSLinux, "us")
+	options := LayoutOptions{Add: []string{"ctrl:nocaps"}}
+
+	withOptions := layout.WithOptions(options)
+
+	if !slices.Equal(withOptions.Options.Add, options.Add) {
+		t.Errorf("Expected options %v, got %v", options, withOptions.Options)
+	}
+	if !layout.Options.IsZero() {
+		t.Errorf("Expected the original layout to be unchanged, got %v", layout.Options)
+	}
+}
+
+func TestNewKeyboardLayout_XKBFields(t *testing.T) {
+	tests := []struct {
+		identifier string
+		groups     []XKBGroup
+		model      string
+		options    []string
+	}{
+		{"us", []XKBGroup{{"us", ""}}, "", nil},
+		{"us -variant intl", []XKBGroup{{"us", "intl"}}, "", nil},
+		{"us,ru -variant ,phonetic", []XKBGroup{{"us", ""}, {"ru", "phonetic"}}, "", nil},
+		{"-layout fr -model pc105 -option ctrl:nocaps,compose:ralt", []XKBGroup{{"fr", ""}}, "pc105", []string{"ctrl:nocaps", "compose:ralt"}},
+	}
+
+	for _, tt := range tests {
+		layout := NewKeyboardLayout("Test", OSLinux, tt.identifier)
+		if !slices.Equal(layout.XKBGroups(), tt.groups) || layout.Model != tt.model || !slices.Equal(layout.Options.Add, tt.options) {
+			t.Errorf("%q: unexpected layout %+v", tt.identifier, layout)
+		}
+	}
+
+	if layout := NewKeyboardLayout(LayoutUSQwerty, OSWindows, "00000409"); layout.Layout != "" {
+		t.Errorf("Expected no XKB fields for Windows, got %+v", layout)
+	}
+}
+
+func TestNewXKBKeyboardLayout(t *testing.T) {
+	layout := NewXKBKeyboardLayout("us,ru(phonetic)",
+		[]XKBGroup{{Layout: "us"}, {Layout: "ru", Variant: "phonetic"}},
+		"pc104", LayoutOptions{Add: []string{"compose:ralt"}})
+
+	if layout.ID != "us,ru(phonetic):linux" || layout.OS != OSLinux {
+		t.Errorf("Unexpected ID %q or OS %q", layout.ID, layout.OS)
+	}
+	if layout.Layout != "us" || layout.Variant != "" || len(layout.Groups) != 1 {
+		t.Errorf("Unexpected groups %+v", layout.XKBGroups())
+	}
+
+	expected := "us,ru -variant ,phonetic -model pc104 -option compose:ralt"
+	if layout.SystemIdentifier != expected {
+		t.Errorf("Expected identifier %q, got %q", expected, layout.SystemIdentifier)
+	}
+}
+
+func TestLayoutOptions_Merge(t *testing.T) {
+	layout := LayoutOptions{Add: []string{"ctrl:nocaps", "compose:ralt"}, Remove: []string{"caps:escape"}}
+	mapping := LayoutOptions{Add: []string{"caps:escape"}, Remove: []string{"ctrl:nocaps"}}
+
+	merged := layout.Merge(mapping)
+
+	if !slices.Equal(merged.Add, []string{"compose:ralt", "caps:escape"}) {
+		t.Errorf("Unexpected added options %v", merged.Add)
+	}
+	if !slices.Equal(merged.Remove, []string{"ctrl:nocaps"}) {
+		t.Errorf("Unexpected removed options %v", merged.Remove)
+	}
+}
@@ -10,6 +10,18 @@ type Mapping struct {
 	LayoutName string
 	// LayoutOS is the operating system for this layout
 	LayoutOS OperatingSystem
+	// Layout is the layout of mappings describing it themselves, such as an
+	// XKB specification, used instead of looking LayoutName up
+	Layout *KeyboardLayout
+	// Debounce overrides the fields of the global debounce it sets for this
+	// device
+	Debounce DebounceOverride
+	// Priority decides which connected keyboard's layout wins; the most
+	// recently connected one wins among equal priorities
+	Priority int
+	// Options are the XKB options to add or remove when switching to the
+	// layout, keeping the others
+	Options LayoutOptions
 }
 
 // NewMapping creates a new Mapping
@@ -26,3 +38,8 @@ func NewMapping(deviceID, deviceDisplayName, layoutName string, layoutOS Operati
 func (m *Mapping) IsSystemDefault() bool {
 	return m.DeviceID == "system_default"
 }
+
+// Selector returns the device selector described by the mapping device ID
+func (m *Mapping) Selector() DeviceSelector {
+	return ParseDeviceSelector(m.DeviceID)
+}
@@ -24,6 +24,8 @@ type MappingRepository interface {
 	FindAll(ctx context.Context) ([]*Mapping, error)
 	// Delete removes a mapping by device ID
 	Delete(ctx context.Context, deviceID string) error
+	// ReplaceAll atomically replaces every mapping with the given ones
+	ReplaceAll(ctx context.Context, mappings []*Mapping) error
 	// GetSystemDefault retrieves the system default mapping
 	GetSystemDefault(ctx context.Context) (*Mapping, error)
 }
@@ -34,8 +36,15 @@ type LayoutRepository interface {
 	Save(ctx context.Context, layout *KeyboardLayout) error
 	// FindByName retrieves a layout by name and OS
 	FindByName(ctx context.Context, name string, os OperatingSystem) (*KeyboardLayout, error)
+	// Resolve retrieves a layout by name, alias or system identifier, such
+	// as "us(intl)" on Linux, ignoring case and spacing. The error lists
+	// the closest layout names when none matches.
+	Resolve(ctx context.Context, name string, os OperatingSystem) (*KeyboardLayout, error)
 	// FindByOS retrieves all layouts for a specific OS
 	FindByOS(ctx context.Context, os OperatingSystem) ([]*KeyboardLayout, error)
 	// FindAll retrieves all layouts
 	FindAll(ctx context.Context) ([]*KeyboardLayout, error)
+	// ReplaceConfigLayouts replaces the layouts declared in the config with
+	// the given ones, restoring the built-in layouts they no longer override
+	ReplaceConfigLayouts(ctx context.Context, layouts []*KeyboardLayout) error
 }
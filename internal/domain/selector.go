@@ -0,0 +1,101 @@
+package domain
+
+import "strings"
+
+// DeviceSelector selects devices from a mapping device ID. Besides the plain
+// "vendor:product" form, a selector can be qualified to tell identical
+// keyboards apart:
+//
+//	"4653:0004#serial=ABC123"       serial number or Bluetooth MAC
+//	"4653:0004@usb-0000:00:14.0-3"  physical port path
+type DeviceSelector struct {
+	// DeviceID is the vendor:product part of the selector
+	DeviceID string
+	// Serial is the required serial number, if any
+	Serial string
+	// Phys is the required port path, if any
+	Phys string
+}
+
+// ParseDeviceSelector parses a mapping device ID into a selector
+func ParseDeviceSelector(value string) DeviceSelector {
+	selector := DeviceSelector{}
+
+	if id, qualifier, found := strings.Cut(value, "#"); found {
+		selector.DeviceID = id
+		if serial, ok := strings.CutPrefix(qualifier, "serial="); ok {
+			selector.Serial = serial
+		} else {
+			selector.Serial = qualifier
+		}
+		return selector
+	}
+
+	if id, phys, found := strings.Cut(value, "@"); found {
+		selector.DeviceID = id
+		selector.Phys = phys
+		return selector
+	}
+
+	selector.DeviceID = value
+	return selector
+}
+
+// Matches returns true if the device satisfies every part of the selector
+func (s DeviceSelector) Matches(device *Device) bool {
+	if !strings.EqualFold(s.DeviceID, device.ID) {
+		return false
+	}
+
+	if s.Serial != "" && !strings.EqualFold(s.Serial, device.Serial) {
+		return false
+	}
+
+	// Phys values of individual interfaces end in "/inputN"
+	if s.Phys != "" && device.Phys != s.Phys && !strings.HasPrefix(device.Phys, s.Phys+"/") {
+		return false
+	}
+
+	return true
+}
+
+// Specificity ranks selectors so that qualified ones win over plain IDs
+func (s DeviceSelector) Specificity() int {
+	specificity := 0
+	if s.Serial != "" {
+		specificity += 2
+	}
+	if s.Phys != "" {
+		specificity++
+	}
+	return specificity
+}
+
+// FindMappingForDevice returns the most specific mapping matching the
+// device, or nil if none does. System default mappings are ignored.
+func FindMappingForDevice(mappings []*Mapping, device *Device) *Mapping {
+	var best *Mapping
+	bestSpecificity := -1
+
+	for _, mapping := range mappings {
+		if mapping.IsSystemDefault() {
+			continue
+		}
+
+		selector := mapping.Selector()
+		if !selector.Matches(device) {
+			continue
+		}
+
+		// Ties are broken on the device ID so the result does not depend on
+		// the repository's iteration order
+		specificity := selector.Specificity()
+		if specificity > bestSpecificity ||
+			(specificity == bestSpecificity && mapping.DeviceID < best.DeviceID) {
+			best = mapping
+			bestSpecificity = specificity
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,95 @@
+package domain
+
+import "testing"
+
+func TestParseDeviceSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected DeviceSelector
+	}{
+		{
+			name:     "Plain device ID",
+			value:    "4653:0004",
+			expected: DeviceSelector{DeviceID: "4653:0004"},
+		},
+		{
+			name:     "Serial qualifier",
+			value:    "4653:0004#serial=ABC123",
+			expected: DeviceSelector{DeviceID: "4653:0004", Serial: "ABC123"},
+		},
+		{
+			name:     "Bluetooth MAC qualifier",
+			value:    "046d:b35b#serial=c8:e2:65:a1:2b:3c",
+			expected: DeviceSelector{DeviceID: "046d:b35b", Serial: "c8:e2:65:a1:2b:3c"},
+		},
+		{
+			name:     "Port qualifier",
+			value:    "4653:0004@usb-0000:00:14.0-3",
+			expected: DeviceSelector{DeviceID: "4653:0004", Phys: "usb-0000:00:14.0-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseDeviceSelector(tt.value); got != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDeviceSelector_Matches(t *testing.T) {
+	device := NewDevice("4653", "0004", "foostan Corne")
+	device.Serial = "ABC123"
+	device.Phys = "usb-0000:00:14.0-3"
+
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"4653:0004", true},
+		{"4653:0004#serial=ABC123", true},
+		{"4653:0004#serial=abc123", true},
+		{"4653:0004#serial=XYZ789", false},
+		{"4653:0004@usb-0000:00:14.0-3", true},
+		{"4653:0004@usb-0000:00:14.0-4", false},
+		{"1209:bb58", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			if got := ParseDeviceSelector(tt.selector).Matches(device); got != tt.matches {
+				t.Errorf("Expected Matches to be %v, got %v", tt.matches, got)
+			}
+		})
+	}
+}
+
+func TestFindMappingForDevice(t *testing.T) {
+	mappings := []*Mapping{
+		NewMapping("system_default", "System Default", "French AZERTY", OSLinux),
+		NewMapping("4653:0004", "Corne", "US QWERTY", OSLinux),
+		NewMapping("4653:0004#serial=ABC123", "Corne Colemak", "Colemak-DH", OSLinux),
+		NewMapping("4653:0004@usb-0000:00:14.0-4", "Corne Left Port", "US International", OSLinux),
+	}
+
+	colemak := NewDevice("4653", "0004", "foostan Corne")
+	colemak.Serial = "ABC123"
+	colemak.Phys = "usb-0000:00:14.0-4"
+
+	qwerty := NewDevice("4653", "0004", "foostan Corne")
+	qwerty.Phys = "usb-0000:00:14.0-3"
+
+	if mapping := FindMappingForDevice(mappings, colemak); mapping == nil || mapping.LayoutName != "Colemak-DH" {
+		t.Errorf("Expected the serial mapping to win, got %v", mapping)
+	}
+
+	if mapping := FindMappingForDevice(mappings, qwerty); mapping == nil || mapping.LayoutName != "US QWERTY" {
+		t.Errorf("Expected the plain mapping, got %v", mapping)
+	}
+
+	if mapping := FindMappingForDevice(mappings, NewDevice("1209", "bb58", "Lily58")); mapping != nil {
+		t.Errorf("Expected no mapping for an unmapped device, got %v", mapping)
+	}
+}
@@ -2,7 +2,9 @@ package domain
 
 import "context"
 
-// DeviceDetector defines the interface for detecting USB/HID devices
+// DeviceDetector defines the interface for detecting USB/HID devices.
+// Detectors publish DeviceConnected and DeviceDisconnected events to the
+// EventBus they are created with.
 type DeviceDetector interface {
 	// StartMonitoring begins monitoring for device connection/disconnection events
 	StartMonitoring(ctx context.Context) error
@@ -10,10 +12,6 @@ type DeviceDetector interface {
 	StopMonitoring() error
 	// GetConnectedDevices returns all currently connected keyboard devices
 	GetConnectedDevices(ctx context.Context) ([]*Device, error)
-	// OnDeviceConnected registers a callback for device connection events
-	OnDeviceConnected(callback func(*Device))
-	// OnDeviceDisconnected registers a callback for device disconnection events
-	OnDeviceDisconnected(callback func(*Device))
 }
 
 // LayoutSwitcher defines the interface for switching keyboard layouts
@@ -22,6 +20,29 @@ type LayoutSwitcher interface {
 	SwitchLayout(ctx context.Context, layout *KeyboardLayout) error
 }
 
+// DeviceLayoutSwitcher is implemented by layout switchers able to give each
+// keyboard its own layout, used when the config enables per_device
+type DeviceLayoutSwitcher interface {
+	LayoutSwitcher
+	// SwitchDeviceLayout changes the layout of a single keyboard, leaving
+	// the others untouched
+	SwitchDeviceLayout(ctx context.Context, layout *KeyboardLayout, device *Device) error
+}
+
+// DeviceLayoutSupport is implemented by layout switchers whose support for
+// per-device layouts depends on the backend they currently use
+type DeviceLayoutSupport interface {
+	// SupportsDeviceLayouts returns true if SwitchDeviceLayout can be used
+	SupportsDeviceLayouts() bool
+}
+
+// LayoutWatcher is implemented by layout switchers able to notice layout
+// changes made outside polykeys, which they publish as LayoutChanged events
+type LayoutWatcher interface {
+	// WatchLayouts starts watching in the background until ctx is done
+	WatchLayouts(ctx context.Context) error
+}
+
 // ConfigLoader defines the interface for loading configuration
 type ConfigLoader interface {
 	// Load loads the configuration from the appropriate location
@@ -36,6 +57,17 @@ type ConfigLoader interface {
 type Config struct {
 	// Mappings contains all device-to-layout mappings
 	Mappings []*Mapping
+	// Layouts are the layouts declared in the config, overriding the
+	// built-in ones of the same name and OS
+	Layouts []*KeyboardLayout
 	// Enabled indicates if polykeys is currently active
 	Enabled bool
+	// Debounce is the default debounce applied to mappings without their own
+	Debounce Debounce
+	// PerDevice applies each keyboard's layout to that keyboard only instead
+	// of switching the layout of every keyboard
+	PerDevice bool
+	// Backend names the layout backend to use, empty to pick one
+	// automatically
+	Backend string
 }
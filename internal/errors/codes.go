@@ -34,4 +34,10 @@ const (
 	// Repository errors (500-599)
 	ErrCodeRepositoryFailed  ErrorCode = "PK_500"
 	ErrCodeRepositoryNotFound ErrorCode = "PK_501"
+
+	// Daemon control errors (600-699)
+	ErrCodeDaemonUnreachable       ErrorCode = "PK_600"
+	ErrCodeControlProtocolMismatch ErrorCode = "PK_601"
+	ErrCodeControlPermissionDenied ErrorCode = "PK_602"
+	ErrCodeControlRequestFailed    ErrorCode = "PK_603"
 )
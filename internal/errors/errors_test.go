@@ -127,6 +127,10 @@ func TestErrorCodes(t *testing.T) {
 		ErrCodeInvalidMapping,
 		ErrCodeRepositoryFailed,
 		ErrCodeRepositoryNotFound,
+		ErrCodeDaemonUnreachable,
+		ErrCodeControlProtocolMismatch,
+		ErrCodeControlPermissionDenied,
+		ErrCodeControlRequestFailed,
 	}
 
 	seen := make(map[ErrorCode]bool)
@@ -152,6 +156,7 @@ func TestErrorCodeRanges(t *testing.T) {
 		{"Config", ErrCodeConfigLoadFailed, "PK_3", 300, 399},
 		{"Mapping", ErrCodeMappingNotFound, "PK_4", 400, 499},
 		{"Repository", ErrCodeRepositoryFailed, "PK_5", 500, 599},
+		{"Control", ErrCodeDaemonUnreachable, "PK_6", 600, 699},
 	}
 
 	for _, tt := range tests {
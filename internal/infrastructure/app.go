@@ -1,36 +1,46 @@
 package infrastructure
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"sync"
 
 	"github.com/0xJohnnyboy/polykeys/internal/adapters/config"
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/layouts"
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 	"github.com/0xJohnnyboy/polykeys/internal/usecases"
 )
 
 // App holds all the application components
 type App struct {
+	EventBus           *domain.EventBus
 	ConfigLoader       domain.ConfigLoader
 	DeviceDetector     domain.DeviceDetector
 	LayoutSwitcher     domain.LayoutSwitcher
+	LayoutBackends     *layouts.BackendChain
 	LayoutRepo         domain.LayoutRepository
 	SwitchLayoutUC     *usecases.SwitchLayoutUseCase
 	ManageMappingsUC   *usecases.ManageMappingsUseCase
 	MonitorDevicesUC   *usecases.MonitorDevicesUseCase
+	configMu           sync.Mutex
 }
 
 // NewApp creates and initializes the application with all dependencies
 func NewApp() (*App, error) {
+	// Events are shared by the adapters, the use cases and the CLI
+	eventBus := domain.NewEventBus()
+
 	// Initialize config loader
 	configLoader := config.NewLuaConfigLoader()
 
 	// Initialize platform-specific adapters
-	deviceDetector, err := createDeviceDetector()
+	deviceDetector, err := createDeviceDetector(eventBus)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create device detector: %w", err)
 	}
 
-	layoutSwitcher, err := createLayoutSwitcher()
+	layoutBackends, err := createLayoutSwitcher(eventBus)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create layout switcher: %w", err)
 	}
@@ -38,17 +48,19 @@ func NewApp() (*App, error) {
 	// Create in-memory repositories (for now)
 	deviceRepo := NewInMemoryDeviceRepository()
 	mappingRepo := NewInMemoryMappingRepository()
-	layoutRepo := NewInMemoryLayoutRepository()
+	layoutRepo := createPlatformLayoutRepository()
 
 	// Initialize use cases
-	switchLayoutUC := usecases.NewSwitchLayoutUseCase(mappingRepo, layoutRepo, layoutSwitcher)
-	manageMappingsUC := usecases.NewManageMappingsUseCase(deviceRepo, mappingRepo, layoutRepo, configLoader)
-	monitorDevicesUC := usecases.NewMonitorDevicesUseCase(deviceRepo, deviceDetector, switchLayoutUC)
+	switchLayoutUC := usecases.NewSwitchLayoutUseCase(mappingRepo, layoutRepo, layoutBackends, eventBus)
+	manageMappingsUC := usecases.NewManageMappingsUseCase(deviceRepo, mappingRepo, layoutRepo, configLoader, eventBus)
+	monitorDevicesUC := usecases.NewMonitorDevicesUseCase(deviceRepo, deviceDetector, switchLayoutUC, eventBus)
 
 	return &App{
+		EventBus:           eventBus,
 		ConfigLoader:       configLoader,
 		DeviceDetector:     deviceDetector,
-		LayoutSwitcher:     layoutSwitcher,
+		LayoutSwitcher:     layoutBackends,
+		LayoutBackends:     layoutBackends,
 		LayoutRepo:         layoutRepo,
 		SwitchLayoutUC:     switchLayoutUC,
 		ManageMappingsUC:   manageMappingsUC,
@@ -57,11 +69,38 @@ func NewApp() (*App, error) {
 }
 
 // createDeviceDetector creates a platform-specific device detector
-func createDeviceDetector() (domain.DeviceDetector, error) {
-	return createPlatformDeviceDetector()
+func createDeviceDetector(events *domain.EventBus) (domain.DeviceDetector, error) {
+	return createPlatformDeviceDetector(events)
+}
+
+// createLayoutSwitcher creates the chain of platform-specific layout backends
+func createLayoutSwitcher(events *domain.EventBus) (*layouts.BackendChain, error) {
+	return createPlatformLayoutSwitcher(events)
 }
 
-// createLayoutSwitcher creates a platform-specific layout switcher
-func createLayoutSwitcher() (domain.LayoutSwitcher, error) {
-	return createPlatformLayoutSwitcher()
+// LoadConfig loads the mappings from the configuration file, applies its
+// global settings to the running use cases and re-evaluates the connected
+// keyboards. The previous mappings are kept if the file fails to load.
+func (a *App) LoadConfig(ctx context.Context) error {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+
+	config, err := a.ManageMappingsUC.LoadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.MonitorDevicesUC.SetDebounce(config.Debounce)
+
+	if err := a.LayoutBackends.Prefer(config.Backend); err != nil {
+		log.Printf("Warning: %v, picking the layout backend automatically", err)
+	}
+
+	if config.PerDevice && !a.SwitchLayoutUC.SupportsDeviceLayouts() {
+		log.Printf("Warning: per_device is not supported by this layout backend, switching every keyboard instead")
+	}
+	a.MonitorDevicesUC.SetPerDevice(config.PerDevice)
+
+	a.MonitorDevicesUC.Reevaluate()
+	return nil
 }
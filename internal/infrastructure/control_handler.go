@@ -0,0 +1,96 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/control"
+	"github.com/0xJohnnyboy/polykeys/internal/adapters/layouts"
+)
+
+// ControlHandler serves daemon control requests from the application components
+type ControlHandler struct {
+	app *App
+}
+
+// NewControlHandler creates a control handler backed by app
+func NewControlHandler(app *App) *ControlHandler {
+	return &ControlHandler{app: app}
+}
+
+// Status returns the connected devices, current layout and enabled state
+func (h *ControlHandler) Status(ctx context.Context) (*control.Status, error) {
+	devices, err := h.app.MonitorDevicesUC.GetConnectedDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &control.Status{
+		Enabled: h.app.MonitorDevicesUC.IsEnabled(),
+		Devices: make([]control.DeviceStatus, 0, len(devices)),
+	}
+
+	if layout := h.app.SwitchLayoutUC.CurrentLayout(); layout != nil {
+		status.CurrentLayout = layout.Name
+	}
+
+	for _, device := range devices {
+		nodes := make([]string, 0, len(device.Nodes))
+		for _, node := range device.Nodes {
+			nodes = append(nodes, node.Path)
+		}
+
+		status.Devices = append(status.Devices, control.DeviceStatus{
+			ID:    device.InstanceID(),
+			Name:  device.Name,
+			Alias: device.Alias,
+			Class: string(device.Class),
+			Nodes: nodes,
+		})
+	}
+
+	return status, nil
+}
+
+// Reload reloads the mappings from the configuration file
+func (h *ControlHandler) Reload(ctx context.Context) error {
+	if err := h.app.LoadConfig(ctx); err != nil {
+		return fmt.Errorf("failed to reload config: %w", err)
+	}
+	return nil
+}
+
+// SetEnabled enables or disables automatic layout switching
+func (h *ControlHandler) SetEnabled(ctx context.Context, enabled bool) error {
+	if enabled {
+		h.app.MonitorDevicesUC.Enable()
+	} else {
+		h.app.MonitorDevicesUC.Disable()
+	}
+	return nil
+}
+
+// Backend describes the layout backend in use and the other candidates
+func (h *ControlHandler) Backend(ctx context.Context) (*control.BackendStatus, error) {
+	return BackendStatus(h.app.LayoutBackends.Info()), nil
+}
+
+// BackendStatus converts the layout backends description for the control
+// protocol
+func BackendStatus(info layouts.BackendInfo) *control.BackendStatus {
+	status := &control.BackendStatus{
+		Name:       info.Active,
+		Reason:     info.Reason,
+		Candidates: make([]control.BackendCandidate, 0, len(info.Candidates)),
+	}
+
+	for _, candidate := range info.Candidates {
+		status.Candidates = append(status.Candidates, control.BackendCandidate{
+			Name:      candidate.Name,
+			Available: candidate.Available,
+			Reason:    candidate.Reason,
+		})
+	}
+
+	return status
+}
@@ -0,0 +1,181 @@
+package infrastructure
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// layoutCatalogData is the catalog of built-in layouts, keyed by canonical
+// layout ID
+//
+//go:embed layout_catalog.json
+var layoutCatalogData []byte
+
+// layoutCatalog holds the built-in layouts with their identifier on each OS
+type layoutCatalog map[string]layoutCatalogEntry
+
+// layoutCatalogEntry is a layout of the catalog. An OS without identifier
+// does not ship the layout.
+type layoutCatalogEntry struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	// XKB is a Linux identifier in the setxkbmap syntax, such as
+	// "us -variant intl"
+	XKB string `json:"xkb,omitempty"`
+	// Windows is a keyboard layout ID (KLID), such as "00020409"
+	Windows string `json:"windows,omitempty"`
+	// MacOS is an input source ID, such as "com.apple.keylayout.US"
+	MacOS string `json:"macos,omitempty"`
+}
+
+// loadLayoutCatalog parses and validates a layout catalog
+func loadLayoutCatalog(data []byte) (layoutCatalog, error) {
+	var catalog layoutCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse the layout catalog: %w", err)
+	}
+	if err := catalog.validate(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// validate checks that every layout has a name and an identifier, and that
+// no name, alias or identifier is shared by distinct layouts
+func (c layoutCatalog) validate() error {
+	names := make(map[string]string)
+	identifiers := make(map[string]string)
+
+	for _, id := range c.ids() {
+		entry := c[id]
+		if strings.TrimSpace(entry.Name) == "" {
+			return fmt.Errorf("layout %s has no name", id)
+		}
+		if entry.XKB == "" && entry.Windows == "" && entry.MacOS == "" {
+			return fmt.Errorf("layout %s has no identifier", id)
+		}
+
+		for _, name := range append([]string{id, entry.Name}, entry.Aliases...) {
+			key := layoutNameKey(name)
+			if other, ok := names[key]; ok && other != id {
+				return fmt.Errorf("layouts %s and %s are both named %q", other, id, name)
+			}
+			names[key] = id
+		}
+
+		for _, os := range []domain.OperatingSystem{domain.OSLinux, domain.OSWindows, domain.OSMacOS} {
+			identifier := entry.identifier(os)
+			if identifier == "" {
+				continue
+			}
+			key := string(os) + ":" + strings.ToLower(identifier)
+			if other, ok := identifiers[key]; ok {
+				return fmt.Errorf("layouts %s and %s share the %s identifier %q", other, id, os, identifier)
+			}
+			identifiers[key] = id
+		}
+	}
+
+	return nil
+}
+
+// layoutNameKey returns a layout name ignoring case and spacing
+func layoutNameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// ids returns the layout IDs of the catalog, sorted
+func (c layoutCatalog) ids() []string {
+	ids := make([]string, 0, len(c))
+	for id := range c {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Layouts returns the layouts of the catalog shipped on an OS. Their
+// catalog ID is one of their aliases.
+func (c layoutCatalog) Layouts(os domain.OperatingSystem) []*domain.KeyboardLayout {
+	layouts := make([]*domain.KeyboardLayout, 0, len(c))
+	for _, id := range c.ids() {
+		entry := c[id]
+		identifier := entry.identifier(os)
+		if identifier == "" {
+			continue
+		}
+
+		layout := domain.NewKeyboardLayout(entry.Name, os, identifier)
+		layout.Aliases = entry.aliases(id)
+		layout.Origin = domain.LayoutOriginBuiltIn
+		layouts = append(layouts, layout)
+	}
+	return layouts
+}
+
+// Extend returns a config layout named after a catalog layout, by name,
+// alias or ID, as a copy taking the canonical name and aliases of that
+// layout, so that it adds or replaces its identifier on one OS. Other
+// layouts are returned unchanged.
+func (c layoutCatalog) Extend(layout *domain.KeyboardLayout) *domain.KeyboardLayout {
+	for _, id := range c.ids() {
+		entry := c[id]
+		candidate := &domain.KeyboardLayout{Name: entry.Name, Aliases: entry.aliases(id)}
+		if !candidate.HasName(layout.Name) {
+			continue
+		}
+
+		extended := *layout
+		extended.ID = entry.Name + ":" + string(layout.OS)
+		extended.Name = entry.Name
+		extended.Aliases = append(candidate.Aliases, layout.Aliases...)
+		if layout.Name != entry.Name {
+			extended.Aliases = append(extended.Aliases, layout.Name)
+		}
+		return &extended
+	}
+	return layout
+}
+
+// Unavailable returns the name of the catalog layout having a name, by name,
+// alias or ID, if the OS does not ship it
+func (c layoutCatalog) Unavailable(name string, os domain.OperatingSystem) (string, bool) {
+	for _, id := range c.ids() {
+		entry := c[id]
+		if entry.identifier(os) != "" {
+			continue
+		}
+		candidate := &domain.KeyboardLayout{Name: entry.Name, Aliases: entry.aliases(id)}
+		if candidate.HasName(name) {
+			return entry.Name, true
+		}
+	}
+	return "", false
+}
+
+// identifier returns the identifier of the layout on an OS, empty if the
+// OS does not ship it
+func (e layoutCatalogEntry) identifier(os domain.OperatingSystem) string {
+	switch os {
+	case domain.OSLinux:
+		return e.XKB
+	case domain.OSWindows:
+		return e.Windows
+	case domain.OSMacOS:
+		return e.MacOS
+	default:
+		return ""
+	}
+}
+
+// aliases returns the aliases of the layout followed by its catalog ID
+func (e layoutCatalogEntry) aliases(id string) []string {
+	aliases := make([]string, 0, len(e.Aliases)+1)
+	aliases = append(aliases, e.Aliases...)
+	return append(aliases, id)
+}
@@ -0,0 +1,173 @@
+package infrastructure
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+)
+
+func TestLayoutCatalog_Embedded(t *testing.T) {
+	catalog, err := loadLayoutCatalog(layoutCatalogData)
+	if err != nil {
+		t.Fatalf("Invalid built-in layout catalog: %v", err)
+	}
+
+	for _, os := range []domain.OperatingSystem{domain.OSLinux, domain.OSWindows, domain.OSMacOS} {
+		layouts := catalog.Layouts(os)
+		if len(layouts) < 10 {
+			t.Errorf("Expected the common layouts on %s, got %d", os, len(layouts))
+		}
+		for _, layout := range layouts {
+			if layout.OS != os || layout.Origin != domain.LayoutOriginBuiltIn || layout.ID != layout.Name+":"+string(os) {
+				t.Errorf("Unexpected layout %+v", layout)
+			}
+		}
+	}
+
+	windows := make(map[string]string)
+	for _, layout := range catalog.Layouts(domain.OSWindows) {
+		windows[layout.Name] = layout.SystemIdentifier
+	}
+	// Windows does not ship Colemak, and 00000409 is plain US
+	if klid, ok := windows[domain.LayoutColemak]; ok {
+		t.Errorf("Expected no Windows KLID for Colemak, got %s", klid)
+	}
+	if windows[domain.LayoutUSQwerty] != "00000409" || windows[domain.LayoutUSInternational] != "00020409" {
+		t.Errorf("Unexpected Windows KLIDs %v", windows)
+	}
+}
+
+func TestLayoutCatalog_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		err     string
+	}{
+		{"valid", `{"us": {"name": "US", "xkb": "us"}, "fr": {"name": "French", "xkb": "fr"}}`, ""},
+		{"not JSON", `{"us": `, "parse"},
+		{"no name", `{"us": {"xkb": "us"}}`, "no name"},
+		{"no identifier", `{"us": {"name": "US"}}`, "no identifier"},
+		{"shared KLID", `{"us-intl": {"name": "US International", "windows": "00020409"}, "us-dead": {"name": "US Dead Keys", "windows": "00020409"}}`, "share the windows identifier"},
+		{"shared XKB identifier", `{"us": {"name": "US", "xkb": "us"}, "us-qwerty": {"name": "US Qwerty", "xkb": "US"}}`, "share the linux identifier"},
+		{"shared alias", `{"us": {"name": "US", "xkb": "us"}, "gb": {"name": "UK", "aliases": ["us"], "xkb": "gb"}}`, "both named"},
+		{"same identifier on distinct OSes", `{"x": {"name": "X", "xkb": "x", "macos": "x"}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadLayoutCatalog([]byte(tt.catalog))
+			if tt.err == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Expected an error about %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLayoutCatalog_DeadKeys(t *testing.T) {
+	catalog, err := loadLayoutCatalog(layoutCatalogData)
+	if err != nil {
+		t.Fatalf("Invalid built-in layout catalog: %v", err)
+	}
+
+	// US International is the dead keys layout on every OS, so a shared
+	// config gets the same keymap everywhere
+	for _, os := range []domain.OperatingSystem{domain.OSLinux, domain.OSWindows, domain.OSMacOS} {
+		layout := domain.ResolveLayout(catalog.Layouts(os), "US International Dead Keys")
+		if layout == nil || layout.Name != domain.LayoutUSInternational {
+			t.Errorf("Expected US International Dead Keys to resolve to %s on %s, got %+v", domain.LayoutUSInternational, os, layout)
+		}
+	}
+
+	// The AltGr variant only exists on Linux
+	if layout := domain.ResolveLayout(catalog.Layouts(domain.OSLinux), "US AltGr Intl"); layout == nil || layout.Name != domain.LayoutUSInternationalAltGr {
+		t.Errorf("Expected US AltGr Intl to resolve to %s on Linux, got %+v", domain.LayoutUSInternationalAltGr, layout)
+	}
+	if _, ok := catalog.Unavailable(domain.LayoutUSInternationalAltGr, domain.OSWindows); !ok {
+		t.Errorf("Expected %s to be unavailable on Windows", domain.LayoutUSInternationalAltGr)
+	}
+}
+
+func TestLayoutCatalog_Unavailable(t *testing.T) {
+	catalog, err := loadLayoutCatalog(layoutCatalogData)
+	if err != nil {
+		t.Fatalf("Invalid built-in layout catalog: %v", err)
+	}
+
+	if name, ok := catalog.Unavailable("colemak", domain.OSWindows); !ok || name != domain.LayoutColemak {
+		t.Errorf("Expected Colemak to be unavailable on Windows, got %q (%v)", name, ok)
+	}
+	if _, ok := catalog.Unavailable("colemak", domain.OSMacOS); ok {
+		t.Error("Expected Colemak to be available on macOS")
+	}
+	if _, ok := catalog.Unavailable("Workman", domain.OSWindows); ok {
+		t.Error("Expected a layout missing from the catalog not to be reported unavailable")
+	}
+}
+
+func TestInMemoryLayoutRepository_UnavailableLayout(t *testing.T) {
+	repo := NewInMemoryLayoutRepository()
+
+	_, err := repo.Resolve(t.Context(), "Colemak", domain.OSWindows)
+	if errors.GetCode(err) != errors.ErrCodeLayoutInvalidOS || !strings.Contains(err.Error(), "not available on windows") {
+		t.Errorf("Expected Colemak to be reported unavailable on Windows, got %v", err)
+	}
+
+	_, err = repo.Resolve(t.Context(), "Colemack-ish", domain.OSWindows)
+	if errors.GetCode(err) != errors.ErrCodeLayoutNotFound {
+		t.Errorf("Expected an unknown layout to be reported not found, got %v", err)
+	}
+}
+
+func TestLayoutCatalog_Extend(t *testing.T) {
+	catalog, err := loadLayoutCatalog(layoutCatalogData)
+	if err != nil {
+		t.Fatalf("Invalid built-in layout catalog: %v", err)
+	}
+
+	// The Colemak installer registers its own KLID
+	declared := domain.NewKeyboardLayout("colemak", domain.OSWindows, "a0000409")
+	declared.Origin = domain.LayoutOriginConfig
+
+	extended := catalog.Extend(declared)
+	if extended.Name != domain.LayoutColemak || extended.ID != domain.LayoutColemak+":windows" {
+		t.Errorf("Expected the catalog Colemak, got %+v", extended)
+	}
+	if extended.SystemIdentifier != "a0000409" || extended.Origin != domain.LayoutOriginConfig {
+		t.Errorf("Expected the declared identifier, got %+v", extended)
+	}
+	if declared.Name != "colemak" {
+		t.Errorf("Expected the declared layout to be left unchanged, got %+v", declared)
+	}
+
+	neo := domain.NewKeyboardLayout("Neo", domain.OSLinux, "de -variant neo")
+	if catalog.Extend(neo) != neo {
+		t.Error("Expected a layout missing from the catalog to be returned unchanged")
+	}
+}
+
+func TestInMemoryLayoutRepository_ExtendsCatalog(t *testing.T) {
+	repo := NewInMemoryLayoutRepository()
+
+	builtIn, _ := repo.FindAll(t.Context())
+	os := builtIn[0].OS
+
+	// Named after the catalog ID of UK Qwerty
+	declared := domain.NewKeyboardLayout("gb", os, "custom-uk")
+	if err := repo.ReplaceConfigLayouts(t.Context(), []*domain.KeyboardLayout{declared}); err != nil {
+		t.Fatalf("Failed to replace config layouts: %v", err)
+	}
+
+	layout, err := repo.Resolve(t.Context(), "British", os)
+	if err != nil || layout.Name != domain.LayoutUKQwerty || layout.SystemIdentifier != "custom-uk" {
+		t.Errorf("Expected the extended UK Qwerty, got %+v (%v)", layout, err)
+	}
+	if layouts, _ := repo.FindByOS(t.Context(), os); slices.ContainsFunc(layouts, func(l *domain.KeyboardLayout) bool { return l.Name == "gb" }) {
+		t.Error("Expected no separate layout named after the catalog ID")
+	}
+}
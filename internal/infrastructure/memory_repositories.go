@@ -3,10 +3,15 @@ package infrastructure
 import (
 	"context"
 	"fmt"
+	"log"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
 )
 
 // InMemoryDeviceRepository is a simple in-memory implementation
@@ -101,25 +106,57 @@ func (r *InMemoryMappingRepository) Delete(ctx context.Context, deviceID string)
 	return nil
 }
 
+func (r *InMemoryMappingRepository) ReplaceAll(ctx context.Context, mappings []*domain.Mapping) error {
+	replaced := make(map[string]*domain.Mapping, len(mappings))
+	for _, mapping := range mappings {
+		replaced[mapping.DeviceID] = mapping
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings = replaced
+	return nil
+}
+
 func (r *InMemoryMappingRepository) GetSystemDefault(ctx context.Context) (*domain.Mapping, error) {
 	return r.FindByDeviceID(ctx, "system_default")
 }
 
-// InMemoryLayoutRepository is a simple in-memory implementation
+// InMemoryLayoutRepository is a simple in-memory implementation. Layouts
+// declared in the config are kept apart from the built-in ones, which they
+// override while declared, and the built-in ones override the layouts
+// installed on the system.
 type InMemoryLayoutRepository struct {
 	layouts map[string]*domain.KeyboardLayout
+	config  map[string]*domain.KeyboardLayout
+	catalog layoutCatalog
 	mu      sync.RWMutex
+
+	// discover returns the layouts installed on the system, read once
+	// when a layout is not found otherwise
+	discover   func() ([]*domain.KeyboardLayout, error)
+	system     []*domain.KeyboardLayout
+	systemOnce sync.Once
 }
 
 func NewInMemoryLayoutRepository() *InMemoryLayoutRepository {
 	repo := &InMemoryLayoutRepository{
 		layouts: make(map[string]*domain.KeyboardLayout),
+		config:  make(map[string]*domain.KeyboardLayout),
 	}
 	// Pre-populate with common layouts
 	repo.populateDefaultLayouts()
 	return repo
 }
 
+// NewSystemLayoutRepository creates a repository also offering the layouts
+// installed on the system, which discover returns
+func NewSystemLayoutRepository(discover func() ([]*domain.KeyboardLayout, error)) *InMemoryLayoutRepository {
+	repo := NewInMemoryLayoutRepository()
+	repo.discover = discover
+	return repo
+}
+
 func (r *InMemoryLayoutRepository) populateDefaultLayouts() {
 	// Determine current OS
 	var os domain.OperatingSystem
@@ -134,9 +171,14 @@ func (r *InMemoryLayoutRepository) populateDefaultLayouts() {
 		os = domain.OSLinux
 	}
 
-	// Add common layouts based on OS
-	layouts := getDefaultLayoutsForOS(os)
-	for _, layout := range layouts {
+	// Add the catalog layouts shipped on this OS
+	catalog, err := loadLayoutCatalog(layoutCatalogData)
+	if err != nil {
+		log.Printf("Warning: built-in layouts unavailable: %v", err)
+		return
+	}
+	r.catalog = catalog
+	for _, layout := range catalog.Layouts(os) {
 		r.layouts[layout.ID] = layout
 	}
 }
@@ -155,55 +197,6 @@ func getCurrentOS() string {
 	}
 }
 
-func getDefaultLayoutsForOS(os domain.OperatingSystem) []*domain.KeyboardLayout {
-	switch os {
-	case domain.OSLinux:
-		return []*domain.KeyboardLayout{
-			domain.NewKeyboardLayout(domain.LayoutUSQwerty, os, "us"),
-			domain.NewKeyboardLayout(domain.LayoutUSInternational, os, "us -variant intl"),
-			domain.NewKeyboardLayout(domain.LayoutUSInternationalDeadKeys, os, "us -variant altgr-intl"),
-			domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, os, "fr"),
-			domain.NewKeyboardLayout(domain.LayoutUKQwerty, os, "gb"),
-			domain.NewKeyboardLayout(domain.LayoutColemak, os, "us -variant colemak"),
-			domain.NewKeyboardLayout(domain.LayoutDvorak, os, "us -variant dvorak"),
-		}
-	case domain.OSWindows:
-		return []*domain.KeyboardLayout{
-			domain.NewKeyboardLayout(domain.LayoutUSQwerty, os, "00000409"),
-			domain.NewKeyboardLayout(domain.LayoutUSInternational, os, "00020409"),
-			domain.NewKeyboardLayout(domain.LayoutUSInternationalDeadKeys, os, "00020409"),
-			domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, os, "0000040c"),
-			domain.NewKeyboardLayout(domain.LayoutUKQwerty, os, "00000809"),
-			domain.NewKeyboardLayout(domain.LayoutColemak, os, "00000409"),
-			domain.NewKeyboardLayout(domain.LayoutDvorak, os, "00010409"),
-			domain.NewKeyboardLayout(domain.LayoutGerman, os, "00000407"),
-			domain.NewKeyboardLayout(domain.LayoutSpanish, os, "0000040a"),
-			domain.NewKeyboardLayout(domain.LayoutItalian, os, "00000410"),
-			domain.NewKeyboardLayout(domain.LayoutPortuguese, os, "00000816"),
-			domain.NewKeyboardLayout(domain.LayoutRussian, os, "00000419"),
-			domain.NewKeyboardLayout(domain.LayoutJapanese, os, "00000411"),
-		}
-	case domain.OSMacOS:
-		return []*domain.KeyboardLayout{
-			domain.NewKeyboardLayout(domain.LayoutUSQwerty, os, "com.apple.keylayout.US"),
-			domain.NewKeyboardLayout(domain.LayoutUSInternational, os, "com.apple.keylayout.USInternational-PC"),
-			domain.NewKeyboardLayout(domain.LayoutUSInternationalDeadKeys, os, "com.apple.keylayout.USInternational-PC"),
-			domain.NewKeyboardLayout(domain.LayoutFrenchAzerty, os, "com.apple.keylayout.ABC-AZERTY"),
-			domain.NewKeyboardLayout(domain.LayoutUKQwerty, os, "com.apple.keylayout.British"),
-			domain.NewKeyboardLayout(domain.LayoutColemak, os, "com.apple.keylayout.Colemak"),
-			domain.NewKeyboardLayout(domain.LayoutDvorak, os, "com.apple.keylayout.Dvorak"),
-			domain.NewKeyboardLayout(domain.LayoutGerman, os, "com.apple.keylayout.German"),
-			domain.NewKeyboardLayout(domain.LayoutSpanish, os, "com.apple.keylayout.Spanish"),
-			domain.NewKeyboardLayout(domain.LayoutItalian, os, "com.apple.keylayout.Italian"),
-			domain.NewKeyboardLayout(domain.LayoutPortuguese, os, "com.apple.keylayout.Portuguese"),
-			domain.NewKeyboardLayout(domain.LayoutRussian, os, "com.apple.keylayout.Russian"),
-			domain.NewKeyboardLayout(domain.LayoutJapanese, os, "com.apple.inputmethod.Kotoeri.Japanese"),
-		}
-	default:
-		return []*domain.KeyboardLayout{}
-	}
-}
-
 func (r *InMemoryLayoutRepository) Save(ctx context.Context, layout *domain.KeyboardLayout) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -213,10 +206,19 @@ func (r *InMemoryLayoutRepository) Save(ctx context.Context, layout *domain.Keyb
 
 func (r *InMemoryLayoutRepository) FindByName(ctx context.Context, name string, os domain.OperatingSystem) (*domain.KeyboardLayout, error) {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
+	for _, layouts := range []map[string]*domain.KeyboardLayout{r.config, r.layouts} {
+		// Search by name and OS
+		for _, layout := range layouts {
+			if layout.Name == name && layout.OS == os {
+				r.mu.RUnlock()
+				return layout, nil
+			}
+		}
+	}
+	r.mu.RUnlock()
 
-	// Search by name and OS
-	for _, layout := range r.layouts {
+	// The system layouts are only read when needed
+	for _, layout := range r.systemLayouts() {
 		if layout.Name == name && layout.OS == os {
 			return layout, nil
 		}
@@ -225,12 +227,53 @@ func (r *InMemoryLayoutRepository) FindByName(ctx context.Context, name string,
 	return nil, fmt.Errorf("layout not found: %s for %s", name, os)
 }
 
+func (r *InMemoryLayoutRepository) Resolve(ctx context.Context, name string, os domain.OperatingSystem) (*domain.KeyboardLayout, error) {
+	if layout, err := r.FindByName(ctx, name, os); err == nil {
+		return layout, nil
+	}
+
+	layouts := r.ordered(os)
+	if layout := domain.ResolveLayout(layouts, name); layout != nil {
+		return layout, nil
+	}
+
+	// Catalog layouts this OS does not ship are not misspellings
+	if canonical, ok := r.catalog.Unavailable(name, os); ok {
+		return nil, errors.WithDetails(
+			errors.New(errors.ErrCodeLayoutInvalidOS, fmt.Sprintf(
+				"layout %q is not available on %s, declare its identifier in the layouts table once installed", canonical, os)),
+			map[string]interface{}{
+				"layout": name,
+				"os":     string(os),
+			},
+		)
+	}
+
+	closest := domain.ClosestLayouts(layouts, name, 3)
+	suggestions := make([]string, 0, len(closest))
+	for _, layout := range closest {
+		suggestions = append(suggestions, layout.Name)
+	}
+
+	message := fmt.Sprintf("layout %q not found for %s", name, os)
+	if len(suggestions) > 0 {
+		message += fmt.Sprintf(", closest matches: %s", strings.Join(suggestions, ", "))
+	}
+	return nil, errors.WithDetails(
+		errors.New(errors.ErrCodeLayoutNotFound, message),
+		map[string]interface{}{
+			"layout":      name,
+			"os":          string(os),
+			"suggestions": suggestions,
+		},
+	)
+}
+
 func (r *InMemoryLayoutRepository) FindByOS(ctx context.Context, os domain.OperatingSystem) ([]*domain.KeyboardLayout, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	all := r.all()
 
 	layouts := make([]*domain.KeyboardLayout, 0)
-	for _, layout := range r.layouts {
+	for _, layout := range all {
 		if layout.OS == os {
 			layouts = append(layouts, layout)
 		}
@@ -240,13 +283,100 @@ func (r *InMemoryLayoutRepository) FindByOS(ctx context.Context, os domain.Opera
 }
 
 func (r *InMemoryLayoutRepository) FindAll(ctx context.Context) ([]*domain.KeyboardLayout, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	all := r.all()
 
-	layouts := make([]*domain.KeyboardLayout, 0, len(r.layouts))
-	for _, layout := range r.layouts {
+	layouts := make([]*domain.KeyboardLayout, 0, len(all))
+	for _, layout := range all {
 		layouts = append(layouts, layout)
 	}
 
 	return layouts, nil
 }
+
+// ReplaceConfigLayouts replaces the config layouts. Those named after a
+// catalog layout extend it, taking its name and aliases.
+func (r *InMemoryLayoutRepository) ReplaceConfigLayouts(ctx context.Context, layouts []*domain.KeyboardLayout) error {
+	replaced := make(map[string]*domain.KeyboardLayout, len(layouts))
+	for _, layout := range layouts {
+		layout = r.catalog.Extend(layout)
+		replaced[layout.ID] = layout
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = replaced
+	return nil
+}
+
+// ordered returns the layouts of an OS in the order names are resolved in:
+// config layouts, built-in ones, then system ones, each skipping the
+// layouts overridden by the previous ones
+func (r *InMemoryLayoutRepository) ordered(os domain.OperatingSystem) []*domain.KeyboardLayout {
+	system := r.systemLayouts()
+
+	r.mu.RLock()
+	sources := [][]*domain.KeyboardLayout{sortedLayouts(r.config), sortedLayouts(r.layouts), system}
+	r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	layouts := make([]*domain.KeyboardLayout, 0, len(system)+len(sources[0])+len(sources[1]))
+	for _, source := range sources {
+		for _, layout := range source {
+			if layout.OS != os || seen[layout.ID] {
+				continue
+			}
+			seen[layout.ID] = true
+			layouts = append(layouts, layout)
+		}
+	}
+	return layouts
+}
+
+// sortedLayouts returns the layouts of a map sorted by name
+func sortedLayouts(layouts map[string]*domain.KeyboardLayout) []*domain.KeyboardLayout {
+	sorted := make([]*domain.KeyboardLayout, 0, len(layouts))
+	for _, layout := range layouts {
+		sorted = append(sorted, layout)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// all returns every layout by ID, config layouts overriding the built-in
+// ones, which override the system ones
+func (r *InMemoryLayoutRepository) all() map[string]*domain.KeyboardLayout {
+	system := r.systemLayouts()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make(map[string]*domain.KeyboardLayout, len(system)+len(r.layouts)+len(r.config))
+	for _, layout := range system {
+		all[layout.ID] = layout
+	}
+	for id, layout := range r.layouts {
+		all[id] = layout
+	}
+	for id, layout := range r.config {
+		all[id] = layout
+	}
+	return all
+}
+
+// systemLayouts returns the layouts installed on the system, reading them
+// the first time. Layouts stay available if they cannot be read.
+func (r *InMemoryLayoutRepository) systemLayouts() []*domain.KeyboardLayout {
+	if r.discover == nil {
+		return nil
+	}
+
+	r.systemOnce.Do(func() {
+		layouts, err := r.discover()
+		if err != nil {
+			logger.Debug("[Layouts] Installed layouts unavailable: %v\n", err)
+			return
+		}
+		r.system = layouts
+	})
+	return r.system
+}
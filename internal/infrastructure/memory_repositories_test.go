@@ -0,0 +1,125 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/errors"
+)
+
+func TestInMemoryLayoutRepository_ReplaceConfigLayouts(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryLayoutRepository()
+
+	builtIn, err := repo.FindAll(ctx)
+	if err != nil || len(builtIn) == 0 {
+		t.Fatalf("Expected built-in layouts, got %v (%v)", builtIn, err)
+	}
+	os := builtIn[0].OS
+
+	colemak := domain.NewKeyboardLayout(domain.LayoutColemak, os, "colemak-from-config")
+	colemak.Origin = domain.LayoutOriginConfig
+	neo := domain.NewKeyboardLayout("Neo", os, "neo")
+	neo.Origin = domain.LayoutOriginConfig
+
+	if err := repo.ReplaceConfigLayouts(ctx, []*domain.KeyboardLayout{colemak, neo}); err != nil {
+		t.Fatalf("Failed to replace config layouts: %v", err)
+	}
+
+	if layout, err := repo.FindByName(ctx, domain.LayoutColemak, os); err != nil || layout.SystemIdentifier != "colemak-from-config" || layout.Origin != domain.LayoutOriginConfig {
+		t.Errorf("Expected the config layout to override the built-in one, got %+v (%v)", layout, err)
+	}
+	if layout, err := repo.FindByName(ctx, "Neo", os); err != nil || layout != neo {
+		t.Errorf("Expected the config layout to be found, got %+v (%v)", layout, err)
+	}
+	if layouts, _ := repo.FindByOS(ctx, os); len(layouts) != len(builtIn)+1 {
+		t.Errorf("Expected %d layouts, got %d", len(builtIn)+1, len(layouts))
+	}
+
+	// Removed from the config
+	if err := repo.ReplaceConfigLayouts(ctx, nil); err != nil {
+		t.Fatalf("Failed to replace config layouts: %v", err)
+	}
+
+	layout, err := repo.FindByName(ctx, domain.LayoutColemak, os)
+	if err != nil || layout.Origin != domain.LayoutOriginBuiltIn {
+		t.Errorf("Expected the built-in layout back, got %+v (%v)", layout, err)
+	}
+	if _, err := repo.FindByName(ctx, "Neo", os); err == nil {
+		t.Error("Expected the removed layout to be gone")
+	}
+}
+
+func TestInMemoryLayoutRepository_SystemLayouts(t *testing.T) {
+	ctx := context.Background()
+
+	colemakDH := domain.NewXKBKeyboardLayout("English (Colemak-DH)", []domain.XKBGroup{{Layout: "us", Variant: "colemak_dh"}}, "", domain.LayoutOptions{})
+	colemakDH.Origin = domain.LayoutOriginSystem
+	shadowed := domain.NewKeyboardLayout(domain.LayoutColemak, domain.OSLinux, "us -variant colemak")
+	shadowed.Origin = domain.LayoutOriginSystem
+
+	reads := 0
+	repo := NewSystemLayoutRepository(func() ([]*domain.KeyboardLayout, error) {
+		reads++
+		return []*domain.KeyboardLayout{colemakDH, shadowed}, nil
+	})
+	// Whatever the OS running the test, a built-in Linux layout of the same name
+	builtIn := domain.NewKeyboardLayout(domain.LayoutColemak, domain.OSLinux, "us -variant colemak")
+	builtIn.Origin = domain.LayoutOriginBuiltIn
+	repo.layouts[builtIn.ID] = builtIn
+
+	if _, err := repo.FindByName(ctx, domain.LayoutColemak, domain.OSLinux); err != nil || reads != 0 {
+		t.Errorf("Expected built-in layouts to be found without reading the system ones, got %d reads (%v)", reads, err)
+	}
+
+	layout, err := repo.FindByName(ctx, "English (Colemak-DH)", domain.OSLinux)
+	if err != nil || layout != colemakDH {
+		t.Errorf("Expected the system layout, got %+v (%v)", layout, err)
+	}
+
+	layouts, _ := repo.FindByOS(ctx, domain.OSLinux)
+	for _, layout := range layouts {
+		if layout == shadowed {
+			t.Error("Expected the built-in layout to override the system one")
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Expected the system layouts to be read once, got %d reads", reads)
+	}
+}
+
+func TestInMemoryLayoutRepository_Resolve(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryLayoutRepository()
+
+	builtIn, _ := repo.FindAll(ctx)
+	os := builtIn[0].OS
+
+	neo := domain.NewKeyboardLayout("Neo", os, "neo")
+	neo.Aliases = []string{"Neo 2"}
+	if err := repo.ReplaceConfigLayouts(ctx, []*domain.KeyboardLayout{neo}); err != nil {
+		t.Fatalf("Failed to replace config layouts: %v", err)
+	}
+
+	for name, expected := range map[string]string{
+		"US":           domain.LayoutUSQwerty,
+		"french":       domain.LayoutFrenchAzerty,
+		" us  qwerty ": domain.LayoutUSQwerty,
+		"neo 2":        "Neo",
+	} {
+		layout, err := repo.Resolve(ctx, name, os)
+		if err != nil || layout.Name != expected {
+			t.Errorf("Resolve(%q) = %v (%v), expected %s", name, layout, err, expected)
+		}
+	}
+
+	_, err := repo.Resolve(ctx, "Colmak", os)
+	if errors.GetCode(err) != errors.ErrCodeLayoutNotFound {
+		t.Fatalf("Expected a PK_100 error, got %v", err)
+	}
+	suggestions := err.(*errors.PolykeysError).Details["suggestions"].([]string)
+	if len(suggestions) == 0 || suggestions[0] != domain.LayoutColemak {
+		t.Errorf("Expected Colemak to be suggested first, got %v", suggestions)
+	}
+}
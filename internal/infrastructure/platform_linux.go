@@ -3,15 +3,43 @@
 package infrastructure
 
 import (
+	"log"
+	"os"
+
 	"github.com/0xJohnnyboy/polykeys/internal/adapters/devices"
 	"github.com/0xJohnnyboy/polykeys/internal/adapters/layouts"
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 )
 
-func createPlatformDeviceDetector() (domain.DeviceDetector, error) {
-	return devices.NewLinuxDeviceDetector()
+// createPlatformDeviceDetector prefers kernel uevents and falls back to
+// watching /dev/input. POLYKEYS_DETECTOR=uevent|fsnotify forces a detector.
+func createPlatformDeviceDetector(events *domain.EventBus) (domain.DeviceDetector, error) {
+	switch os.Getenv("POLYKEYS_DETECTOR") {
+	case "uevent":
+		return devices.NewUeventDeviceDetector(events)
+	case "fsnotify":
+		return devices.NewLinuxDeviceDetector(events)
+	}
+
+	detector, err := devices.NewUeventDeviceDetector(events)
+	if err == nil {
+		return detector, nil
+	}
+
+	log.Printf("Uevent detector unavailable (%v), watching /dev/input instead", err)
+	return devices.NewLinuxDeviceDetector(events)
+}
+
+// createPlatformLayoutSwitcher picks among the compositor and desktop
+// backends and setxkbmap by probing the session
+func createPlatformLayoutSwitcher(events *domain.EventBus) (*layouts.BackendChain, error) {
+	return layouts.NewBackendChain(layouts.SystemEnvironment(), events, layouts.LinuxBackends()...), nil
 }
 
-func createPlatformLayoutSwitcher() (domain.LayoutSwitcher, error) {
-	return layouts.NewLinuxLayoutSwitcher(), nil
+// createPlatformLayoutRepository offers the layouts of the XKB registry
+// along with the built-in ones
+func createPlatformLayoutRepository() domain.LayoutRepository {
+	return NewSystemLayoutRepository(func() ([]*domain.KeyboardLayout, error) {
+		return layouts.ReadXKBRegistry(layouts.SystemEnvironment())
+	})
 }
@@ -8,10 +8,23 @@ import (
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 )
 
-func createPlatformDeviceDetector() (domain.DeviceDetector, error) {
-	return devices.NewWindowsDeviceDetector()
+func createPlatformDeviceDetector(events *domain.EventBus) (domain.DeviceDetector, error) {
+	return devices.NewWindowsDeviceDetector(events)
 }
 
-func createPlatformLayoutSwitcher() (domain.LayoutSwitcher, error) {
-	return layouts.NewWindowsLayoutSwitcher(), nil
+func createPlatformLayoutSwitcher(events *domain.EventBus) (*layouts.BackendChain, error) {
+	return layouts.NewBackendChain(layouts.SystemEnvironment(), events, layouts.Backend{
+		Name: "windows",
+		Probe: func(*layouts.Environment) (bool, string) {
+			return true, "the only backend on Windows"
+		},
+		New: func(*layouts.Environment, *domain.EventBus) (domain.LayoutSwitcher, error) {
+			return layouts.NewWindowsLayoutSwitcher(), nil
+		},
+	}), nil
+}
+
+// createPlatformLayoutRepository offers the built-in layouts
+func createPlatformLayoutRepository() domain.LayoutRepository {
+	return NewInMemoryLayoutRepository()
 }
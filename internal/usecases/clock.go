@@ -0,0 +1,24 @@
+package usecases
+
+import "time"
+
+// Timer is a pending call scheduled by a Clock
+type Timer interface {
+	// Stop cancels the call, returning false if it already ran or was stopped
+	Stop() bool
+}
+
+// Clock schedules delayed calls. It is injectable so debouncing can be
+// tested without sleeping.
+type Clock interface {
+	// AfterFunc calls f in its own goroutine once d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// realClock is the Clock backed by the time package
+type realClock struct{}
+
+// AfterFunc calls f after d using time.AfterFunc
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
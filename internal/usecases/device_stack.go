@@ -0,0 +1,54 @@
+package usecases
+
+import "github.com/0xJohnnyboy/polykeys/internal/domain"
+
+// deviceStack orders the connected keyboards from least to most recently
+// connected
+type deviceStack struct {
+	devices []*domain.Device
+}
+
+// push puts a device on top of the stack, moving it there if already present
+func (s *deviceStack) push(device *domain.Device) {
+	s.remove(device)
+	s.devices = append(s.devices, device)
+}
+
+// remove takes a device off the stack, returning false if it was not there
+func (s *deviceStack) remove(device *domain.Device) bool {
+	key := device.InstanceID()
+	for i, d := range s.devices {
+		if d.InstanceID() == key {
+			s.devices = append(s.devices[:i], s.devices[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns a copy of the stacked devices
+func (s *deviceStack) snapshot() []*domain.Device {
+	return append([]*domain.Device(nil), s.devices...)
+}
+
+// winner returns the mapped device whose layout should be active: the one
+// with the highest mapping priority, the most recently connected on ties.
+// It returns nil if no stacked device is mapped.
+func winner(devices []*domain.Device, mappingFor func(*domain.Device) *domain.Mapping) (*domain.Device, *domain.Mapping) {
+	var bestDevice *domain.Device
+	var bestMapping *domain.Mapping
+
+	for i := len(devices) - 1; i >= 0; i-- {
+		mapping := mappingFor(devices[i])
+		if mapping == nil {
+			continue
+		}
+
+		if bestMapping == nil || mapping.Priority > bestMapping.Priority {
+			bestDevice = devices[i]
+			bestMapping = mapping
+		}
+	}
+
+	return bestDevice, bestMapping
+}
@@ -0,0 +1,108 @@
+package usecases
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// eventQueueSize bounds the number of device events waiting to be handled
+const eventQueueSize = 64
+
+// eventQueue runs jobs one at a time on a single worker goroutine, in the
+// order they were submitted. Once a burst of jobs is drained the idle
+// function runs, so work requested by several jobs is done only once.
+type eventQueue struct {
+	jobs     chan func()
+	overflow atomic.Bool
+	running  atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	waiters  []chan struct{} // only touched by the worker
+}
+
+// newEventQueue creates a queue holding at most size pending jobs
+func newEventQueue(size int) *eventQueue {
+	return &eventQueue{
+		jobs: make(chan func(), size),
+		stop: make(chan struct{}),
+	}
+}
+
+// submit queues a job without blocking. If the queue is full the job is
+// dropped, false is returned and the overflow is reported to the next idle.
+func (q *eventQueue) submit(job func()) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		if !q.overflow.Swap(true) {
+			log.Printf("Event queue full (%d events), dropping events until it drains", cap(q.jobs))
+		}
+		return false
+	}
+}
+
+// start runs the worker until the queue is closed. idle is called after
+// each burst with whether jobs were dropped since the previous call.
+func (q *eventQueue) start(idle func(overflowed bool)) {
+	q.running.Store(true)
+	go q.run(idle)
+}
+
+// run handles jobs until the queue is closed
+func (q *eventQueue) run(idle func(overflowed bool)) {
+	defer q.running.Store(false)
+
+	for {
+		select {
+		case job := <-q.jobs:
+			job()
+		case <-q.stop:
+			return
+		}
+
+		// Drain the burst before doing the idle work
+		for drained := false; !drained; {
+			select {
+			case job := <-q.jobs:
+				job()
+			default:
+				drained = true
+			}
+		}
+
+		idle(q.overflow.Swap(false))
+
+		for _, waiter := range q.waiters {
+			close(waiter)
+		}
+		q.waiters = nil
+	}
+}
+
+// flush waits until the jobs submitted so far and the idle work following
+// them are done. It returns immediately if the worker is not running, and
+// must not be called from a job.
+func (q *eventQueue) flush() {
+	if !q.running.Load() {
+		return
+	}
+
+	done := make(chan struct{})
+	select {
+	case q.jobs <- func() { q.waiters = append(q.waiters, done) }:
+	case <-q.stop:
+		return
+	}
+
+	select {
+	case <-done:
+	case <-q.stop:
+	}
+}
+
+// close stops the worker, dropping the jobs still queued
+func (q *eventQueue) close() {
+	q.stopOnce.Do(func() { close(q.stop) })
+}
@@ -0,0 +1,64 @@
+package usecases
+
+import "testing"
+
+func TestEventQueue_RunsJobsInOrderThenIdle(t *testing.T) {
+	queue := newEventQueue(8)
+	defer queue.close()
+
+	var order []int
+	idles := 0
+	block := make(chan struct{})
+
+	queue.start(func(overflowed bool) {
+		idles++
+		if overflowed {
+			t.Error("Expected no overflow")
+		}
+	})
+
+	// Hold the worker so the following jobs form a single burst
+	queue.submit(func() { <-block })
+	for i := 1; i <= 3; i++ {
+		queue.submit(func() { order = append(order, i) })
+	}
+	close(block)
+	queue.flush()
+
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("Expected jobs to run in order, got %v", order)
+	}
+	if idles != 1 {
+		t.Errorf("Expected idle to run once after the burst, ran %d times", idles)
+	}
+}
+
+func TestEventQueue_ReportsOverflow(t *testing.T) {
+	queue := newEventQueue(2)
+	defer queue.close()
+
+	overflowed := false
+	block := make(chan struct{})
+	started := make(chan struct{})
+
+	queue.start(func(o bool) { overflowed = overflowed || o })
+
+	queue.submit(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	queue.submit(func() {})
+	queue.submit(func() {})
+	if queue.submit(func() {}) {
+		t.Error("Expected submit to fail once the queue is full")
+	}
+
+	close(block)
+	queue.flush()
+
+	if !overflowed {
+		t.Error("Expected idle to be told about the overflow")
+	}
+}
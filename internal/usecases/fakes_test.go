@@ -0,0 +1,297 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// fakeDetector lets tests fire connect/disconnect events by hand
+type fakeDetector struct {
+	events  *domain.EventBus
+	devices []*domain.Device
+	// settle runs after each event, to wait until it was handled
+	settle func()
+	// onStart runs at the end of StartMonitoring, like a detector's initial scan
+	onStart func()
+	// onList runs when the connected devices are listed
+	onList func()
+}
+
+func (d *fakeDetector) StartMonitoring(ctx context.Context) error {
+	if d.onStart != nil {
+		d.onStart()
+	}
+	return nil
+}
+func (d *fakeDetector) StopMonitoring() error { return nil }
+func (d *fakeDetector) GetConnectedDevices(ctx context.Context) ([]*domain.Device, error) {
+	devices := d.devices
+	if d.onList != nil {
+		d.onList()
+	}
+	return devices, nil
+}
+
+func (d *fakeDetector) connect(device *domain.Device) {
+	d.events.Publish(domain.DeviceConnected{Device: device})
+	d.settleEvents()
+}
+func (d *fakeDetector) disconnect(device *domain.Device) {
+	d.events.Publish(domain.DeviceDisconnected{Device: device})
+	d.settleEvents()
+}
+func (d *fakeDetector) settleEvents() {
+	if d.settle != nil {
+		d.settle()
+	}
+}
+
+// fakeDeviceRepository discards saved devices
+type fakeDeviceRepository struct{}
+
+func (fakeDeviceRepository) Save(ctx context.Context, device *domain.Device) error { return nil }
+func (fakeDeviceRepository) FindByID(ctx context.Context, id string) (*domain.Device, error) {
+	return nil, fmt.Errorf("device not found")
+}
+func (fakeDeviceRepository) FindAll(ctx context.Context) ([]*domain.Device, error) { return nil, nil }
+func (fakeDeviceRepository) Delete(ctx context.Context, id string) error           { return nil }
+
+// fakeMappingRepository holds mappings in a map
+type fakeMappingRepository struct {
+	mappings map[string]*domain.Mapping
+	mu       sync.RWMutex
+}
+
+func newFakeMappingRepository(mappings ...*domain.Mapping) *fakeMappingRepository {
+	repo := &fakeMappingRepository{mappings: make(map[string]*domain.Mapping)}
+	for _, mapping := range mappings {
+		repo.mappings[mapping.DeviceID] = mapping
+	}
+	return repo
+}
+
+func (r *fakeMappingRepository) Save(ctx context.Context, mapping *domain.Mapping) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings[mapping.DeviceID] = mapping
+	return nil
+}
+
+func (r *fakeMappingRepository) FindByDeviceID(ctx context.Context, deviceID string) (*domain.Mapping, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mapping, ok := r.mappings[deviceID]
+	if !ok {
+		return nil, fmt.Errorf("mapping not found")
+	}
+	return mapping, nil
+}
+
+func (r *fakeMappingRepository) FindAll(ctx context.Context) ([]*domain.Mapping, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	mappings := make([]*domain.Mapping, 0, len(r.mappings))
+	for _, mapping := range r.mappings {
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+func (r *fakeMappingRepository) Delete(ctx context.Context, deviceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.mappings, deviceID)
+	return nil
+}
+
+func (r *fakeMappingRepository) ReplaceAll(ctx context.Context, mappings []*domain.Mapping) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mappings = make(map[string]*domain.Mapping, len(mappings))
+	for _, mapping := range mappings {
+		r.mappings[mapping.DeviceID] = mapping
+	}
+	return nil
+}
+
+func (r *fakeMappingRepository) GetSystemDefault(ctx context.Context) (*domain.Mapping, error) {
+	return r.FindByDeviceID(ctx, "system_default")
+}
+
+// fakeLayoutRepository knows every layout name it is asked for, resolving
+// the aliases in fakeLayoutAliases to their canonical name
+type fakeLayoutRepository struct{}
+
+// fakeLayoutAliases maps layout aliases to their canonical name
+var fakeLayoutAliases = map[string]string{
+	"US": "US Qwerty",
+}
+
+func (fakeLayoutRepository) Save(ctx context.Context, layout *domain.KeyboardLayout) error {
+	return nil
+}
+func (fakeLayoutRepository) FindByName(ctx context.Context, name string, os domain.OperatingSystem) (*domain.KeyboardLayout, error) {
+	return domain.NewKeyboardLayout(name, os, name), nil
+}
+func (fakeLayoutRepository) Resolve(ctx context.Context, name string, os domain.OperatingSystem) (*domain.KeyboardLayout, error) {
+	if canonical, ok := fakeLayoutAliases[name]; ok {
+		name = canonical
+	}
+	return domain.NewKeyboardLayout(name, os, name), nil
+}
+func (fakeLayoutRepository) FindByOS(ctx context.Context, os domain.OperatingSystem) ([]*domain.KeyboardLayout, error) {
+	return nil, nil
+}
+func (fakeLayoutRepository) FindAll(ctx context.Context) ([]*domain.KeyboardLayout, error) {
+	return nil, nil
+}
+func (fakeLayoutRepository) ReplaceConfigLayouts(ctx context.Context, layouts []*domain.KeyboardLayout) error {
+	return nil
+}
+
+// recordingSwitcher records the layouts switched to
+type recordingSwitcher struct {
+	layouts []string
+	// gate, if set, holds every switch until it is closed. Each held switch
+	// is announced on switching first.
+	gate      chan struct{}
+	switching chan string
+	mu        sync.Mutex
+}
+
+func (s *recordingSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	if s.gate != nil {
+		s.switching <- layout.Name
+		<-s.gate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layouts = append(s.layouts, layout.Name)
+	return nil
+}
+
+// hold makes switches wait until release is called
+func (s *recordingSwitcher) hold() {
+	s.gate = make(chan struct{})
+	s.switching = make(chan string, 16)
+}
+
+func (s *recordingSwitcher) release() {
+	close(s.gate)
+}
+
+func (s *recordingSwitcher) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layouts = nil
+}
+
+func (s *recordingSwitcher) switched() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.layouts...)
+}
+
+// fakeClock fires timers when the test advances it
+type fakeClock struct {
+	now    time.Duration
+	timers []*fakeTimer
+	// settle runs after each advance, to wait until fired timers were handled
+	settle func()
+	mu     sync.Mutex
+}
+
+type fakeTimer struct {
+	deadline time.Duration
+	f        func()
+	stopped  bool
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{deadline: c.now + d, f: f}
+	c.timers = append(c.timers, timer)
+	return &fakeTimerHandle{clock: c, timer: timer}
+}
+
+// Advance moves the clock forward, running due timers in deadline order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now += d
+	due := make([]*fakeTimer, 0)
+	remaining := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.stopped {
+			continue
+		}
+		if timer.deadline <= c.now {
+			timer.stopped = true
+			due = append(due, timer)
+		} else {
+			remaining = append(remaining, timer)
+		}
+	}
+	c.timers = remaining
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].deadline < due[j].deadline })
+	for _, timer := range due {
+		timer.f()
+	}
+
+	if c.settle != nil {
+		c.settle()
+	}
+}
+
+type fakeTimerHandle struct {
+	clock *fakeClock
+	timer *fakeTimer
+}
+
+func (h *fakeTimerHandle) Stop() bool {
+	h.clock.mu.Lock()
+	defer h.clock.mu.Unlock()
+	wasActive := !h.timer.stopped
+	h.timer.stopped = true
+	return wasActive
+}
+
+// fakeConfigLoader returns a fixed config, or err if set, and records the
+// last saved one
+type fakeConfigLoader struct {
+	config *domain.Config
+	err    error
+	saved  *domain.Config
+}
+
+func (l *fakeConfigLoader) Load(ctx context.Context) (*domain.Config, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.config, nil
+}
+func (l *fakeConfigLoader) Save(ctx context.Context, config *domain.Config) error {
+	l.saved = config
+	return nil
+}
+func (l *fakeConfigLoader) GetConfigPath() (string, error) { return "polykeys.lua", nil }
+
+// recordingDeviceSwitcher also records per-device switches as "device=layout"
+type recordingDeviceSwitcher struct {
+	recordingSwitcher
+}
+
+func (s *recordingDeviceSwitcher) SwitchDeviceLayout(ctx context.Context, layout *domain.KeyboardLayout, device *domain.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layouts = append(s.layouts, device.DisplayName()+"="+layout.Name)
+	return nil
+}
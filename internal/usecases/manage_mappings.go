@@ -3,16 +3,19 @@ package usecases
 import (
 	"context"
 	"fmt"
+	"log"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 )
 
 // ManageMappingsUseCase handles the logic for managing device-to-layout mappings
 type ManageMappingsUseCase struct {
-	deviceRepo  domain.DeviceRepository
-	mappingRepo domain.MappingRepository
-	layoutRepo  domain.LayoutRepository
+	deviceRepo   domain.DeviceRepository
+	mappingRepo  domain.MappingRepository
+	layoutRepo   domain.LayoutRepository
 	configLoader domain.ConfigLoader
+	events       *domain.EventBus
+	settings     domain.Config // global settings of the last loaded config
 }
 
 // NewManageMappingsUseCase creates a new ManageMappingsUseCase
@@ -21,40 +24,46 @@ func NewManageMappingsUseCase(
 	mappingRepo domain.MappingRepository,
 	layoutRepo domain.LayoutRepository,
 	configLoader domain.ConfigLoader,
+	events *domain.EventBus,
 ) *ManageMappingsUseCase {
 	return &ManageMappingsUseCase{
 		deviceRepo:   deviceRepo,
 		mappingRepo:  mappingRepo,
 		layoutRepo:   layoutRepo,
 		configLoader: configLoader,
+		events:       events,
 	}
 }
 
-// AddMapping creates a new mapping between a device and a layout
+// AddMapping creates a new mapping between a device and a layout, returning
+// it with the canonical layout name. The mapping is for the device's plain
+// ID unless it has a serial, or an identical keyboard is among the connected
+// devices, so that identical keyboards get their own mappings.
 func (uc *ManageMappingsUseCase) AddMapping(
 	ctx context.Context,
 	device *domain.Device,
+	connected []*domain.Device,
 	layoutName string,
 	layoutOS domain.OperatingSystem,
-) error {
-	// Verify the layout exists
-	layout, err := uc.layoutRepo.FindByName(ctx, layoutName, layoutOS)
+) (*domain.Mapping, error) {
+	// Verify the layout exists, by any of its names
+	layout, err := uc.layoutRepo.Resolve(ctx, layoutName, layoutOS)
 	if err != nil {
-		return fmt.Errorf("layout %s not found: %w", layoutName, err)
+		return nil, err
 	}
 
 	// Save the device if not already saved
 	if err := uc.deviceRepo.Save(ctx, device); err != nil {
-		return fmt.Errorf("failed to save device: %w", err)
+		return nil, fmt.Errorf("failed to save device: %w", err)
 	}
 
 	// Create and save the mapping
-	mapping := domain.NewMapping(device.ID, device.DisplayName(), layout.Name, layout.OS)
+	mapping := domain.NewMapping(device.MappingID(connected), device.DisplayName(), layout.Name, layout.OS)
 	if err := uc.mappingRepo.Save(ctx, mapping); err != nil {
-		return fmt.Errorf("failed to save mapping: %w", err)
+		return nil, fmt.Errorf("failed to save mapping: %w", err)
 	}
 
-	return nil
+	return mapping, nil
 }
 
 // RemoveMapping removes a mapping for a device
@@ -89,10 +98,10 @@ func (uc *ManageMappingsUseCase) SetSystemDefault(
 	layoutName string,
 	layoutOS domain.OperatingSystem,
 ) error {
-	// Verify the layout exists
-	layout, err := uc.layoutRepo.FindByName(ctx, layoutName, layoutOS)
+	// Verify the layout exists, by any of its names
+	layout, err := uc.layoutRepo.Resolve(ctx, layoutName, layoutOS)
 	if err != nil {
-		return fmt.Errorf("layout %s not found: %w", layoutName, err)
+		return err
 	}
 
 	// Create system default mapping
@@ -106,19 +115,48 @@ func (uc *ManageMappingsUseCase) SetSystemDefault(
 
 // LoadFromConfig loads mappings from the configuration file
 func (uc *ManageMappingsUseCase) LoadFromConfig(ctx context.Context) error {
+	_, err := uc.LoadConfig(ctx)
+	return err
+}
+
+// LoadConfig loads the configuration file, replaces the mappings with its
+// own and returns it so that callers can apply the global settings. The
+// current mappings are kept if the file cannot be loaded.
+func (uc *ManageMappingsUseCase) LoadConfig(ctx context.Context) (*domain.Config, error) {
 	config, err := uc.configLoader.Load(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Save all mappings from config
+	// Layouts come first, as the mappings may use them
+	if err := uc.layoutRepo.ReplaceConfigLayouts(ctx, config.Layouts); err != nil {
+		return nil, fmt.Errorf("failed to save layouts from config: %w", err)
+	}
+
+	// Mappings keep the layout names as written, so that saving them back
+	// leaves the file alone; they are resolved when switching. A layout
+	// missing on this system only fails the mappings using it.
 	for _, mapping := range config.Mappings {
-		if err := uc.mappingRepo.Save(ctx, mapping); err != nil {
-			return fmt.Errorf("failed to save mapping from config: %w", err)
+		if mapping.Layout != nil {
+			continue
+		}
+		if _, err := uc.layoutRepo.Resolve(ctx, mapping.LayoutName, mapping.LayoutOS); err != nil {
+			log.Printf("Warning: mapping %s: %v", mapping.DeviceDisplayName, err)
 		}
 	}
 
-	return nil
+	// Mappings removed from the file must not survive a reload
+	if err := uc.mappingRepo.ReplaceAll(ctx, config.Mappings); err != nil {
+		return nil, fmt.Errorf("failed to save mappings from config: %w", err)
+	}
+
+	// Kept so that saving the mappings back does not drop them
+	uc.settings = *config
+	uc.settings.Mappings = nil
+
+	uc.events.Publish(domain.ConfigReloaded{Config: config})
+
+	return config, nil
 }
 
 // SaveToConfig saves current mappings to the configuration file
@@ -128,12 +166,11 @@ func (uc *ManageMappingsUseCase) SaveToConfig(ctx context.Context) error {
 		return fmt.Errorf("failed to retrieve mappings: %w", err)
 	}
 
-	config := &domain.Config{
-		Mappings: mappings,
-		Enabled:  true,
-	}
+	config := uc.settings
+	config.Mappings = mappings
+	config.Enabled = true
 
-	if err := uc.configLoader.Save(ctx, config); err != nil {
+	if err := uc.configLoader.Save(ctx, &config); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
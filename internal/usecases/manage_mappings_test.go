@@ -0,0 +1,141 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+func TestManageMappings_LoadConfigReplacesMappings(t *testing.T) {
+	ctx := context.Background()
+	mappingRepo := newFakeMappingRepository()
+	loader := &fakeConfigLoader{config: &domain.Config{Mappings: []*domain.Mapping{
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("feed:6060", "Planck", "US Qwerty", domain.OSLinux),
+	}}}
+	uc := NewManageMappingsUseCase(fakeDeviceRepository{}, mappingRepo, fakeLayoutRepository{}, loader, domain.NewEventBus())
+
+	if _, err := uc.LoadConfig(ctx); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// The Planck was removed from the file
+	loader.config = &domain.Config{Mappings: []*domain.Mapping{
+		domain.NewMapping("4653:0004", "Corne", "Dvorak", domain.OSLinux),
+	}}
+	if _, err := uc.LoadConfig(ctx); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	mappings, _ := mappingRepo.FindAll(ctx)
+	if len(mappings) != 1 || mappings[0].LayoutName != "Dvorak" {
+		t.Errorf("Expected only the Corne mapped to Dvorak, got %v", mappings)
+	}
+}
+
+func TestManageMappings_SaveKeepsLayoutNamesAsWritten(t *testing.T) {
+	ctx := context.Background()
+	loader := &fakeConfigLoader{config: &domain.Config{Mappings: []*domain.Mapping{
+		domain.NewMapping("4653:0004", "Corne", "US", domain.OSLinux),
+	}}}
+	uc := NewManageMappingsUseCase(fakeDeviceRepository{}, newFakeMappingRepository(), fakeLayoutRepository{}, loader, domain.NewEventBus())
+
+	if _, err := uc.LoadConfig(ctx); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := uc.SaveToConfig(ctx); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	if len(loader.saved.Mappings) != 1 || loader.saved.Mappings[0].LayoutName != "US" {
+		t.Errorf("Expected the Corne mapping to keep the layout name US, got %v", loader.saved.Mappings)
+	}
+}
+
+func TestManageMappings_LoadConfigErrorKeepsMappings(t *testing.T) {
+	ctx := context.Background()
+	mappingRepo := newFakeMappingRepository(domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	loader := &fakeConfigLoader{err: fmt.Errorf("error executing Lua config: <string> line:3 unexpected symbol")}
+	events := domain.NewEventBus()
+
+	reloaded := false
+	events.Subscribe(func(domain.Event) { reloaded = true }, domain.EventConfigReloaded)
+
+	uc := NewManageMappingsUseCase(fakeDeviceRepository{}, mappingRepo, fakeLayoutRepository{}, loader, events)
+
+	if _, err := uc.LoadConfig(ctx); err == nil {
+		t.Fatal("Expected the parse error to be reported")
+	}
+
+	if _, err := mappingRepo.FindByDeviceID(ctx, "4653:0004"); err != nil {
+		t.Error("Expected the previous mappings to be kept")
+	}
+	if reloaded {
+		t.Error("Expected no ConfigReloaded event for a failed load")
+	}
+}
+
+func TestManageMappings_AddMappingForIdenticalKeyboards(t *testing.T) {
+	ctx := context.Background()
+	mappingRepo := newFakeMappingRepository()
+	uc := NewManageMappingsUseCase(fakeDeviceRepository{}, mappingRepo, fakeLayoutRepository{}, &fakeConfigLoader{}, domain.NewEventBus())
+
+	left := domain.NewDevice("4653", "0004", "foostan Corne")
+	left.Serial = "ABC123"
+	right := domain.NewDevice("4653", "0004", "foostan Corne")
+	right.Phys = "usb-0000:00:14.0-3"
+	laptop := domain.NewDevice("0001", "0001", "AT Translated Set 2 keyboard")
+	laptop.Phys = "isa0060/serio0/input0"
+	connected := []*domain.Device{left, right, laptop}
+
+	for _, add := range []struct {
+		device *domain.Device
+		layout string
+	}{{left, "Colemak"}, {right, "Dvorak"}, {laptop, "French AZERTY"}} {
+		if _, err := uc.AddMapping(ctx, add.device, connected, add.layout, domain.OSLinux); err != nil {
+			t.Fatalf("Failed to add a mapping for %s: %v", add.device.InstanceID(), err)
+		}
+	}
+
+	expected := map[string]string{
+		"4653:0004#serial=ABC123":      "Colemak",
+		"4653:0004@usb-0000:00:14.0-3": "Dvorak",
+		"0001:0001":                    "French AZERTY",
+	}
+	for deviceID, layout := range expected {
+		mapping, err := mappingRepo.FindByDeviceID(ctx, deviceID)
+		if err != nil || mapping.LayoutName != layout {
+			t.Errorf("Expected %s to be mapped to %s, got %v (%v)", deviceID, layout, mapping, err)
+		}
+	}
+}
+
+func TestManageMappings_AddMappingMatchesOnAnyPort(t *testing.T) {
+	ctx := context.Background()
+	mappingRepo := newFakeMappingRepository()
+	uc := NewManageMappingsUseCase(fakeDeviceRepository{}, mappingRepo, fakeLayoutRepository{}, &fakeConfigLoader{}, domain.NewEventBus())
+
+	// A keyboard without a serial, alone of its kind
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	corne.Phys = "usb-0000:00:14.0-3/input0"
+	laptop := domain.NewDevice("0001", "0001", "AT Translated Set 2 keyboard")
+
+	mapping, err := uc.AddMapping(ctx, corne, []*domain.Device{laptop}, "Colemak", domain.OSLinux)
+	if err != nil {
+		t.Fatalf("Failed to add the mapping: %v", err)
+	}
+	if mapping.DeviceID != "4653:0004" {
+		t.Errorf("Expected a mapping for 4653:0004, got %s", mapping.DeviceID)
+	}
+
+	// The same keyboard plugged into another port
+	moved := domain.NewDevice("4653", "0004", "foostan Corne")
+	moved.Phys = "usb-0000:00:14.0-1/input0"
+
+	mappings, _ := mappingRepo.FindAll(ctx)
+	if found := domain.FindMappingForDevice(mappings, moved); found == nil || found.LayoutName != "Colemak" {
+		t.Errorf("Expected the mapping to match the keyboard on another port, got %v", found)
+	}
+}
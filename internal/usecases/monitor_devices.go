@@ -4,16 +4,46 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
+	"github.com/0xJohnnyboy/polykeys/internal/logger"
 )
 
-// MonitorDevicesUseCase handles the logic for monitoring device connections
+// MonitorDevicesUseCase handles the logic for monitoring device connections.
+// Device events are handled one at a time by a worker goroutine, so a slow
+// layout switch never blocks the detectors and switches cannot complete out
+// of order.
 type MonitorDevicesUseCase struct {
-	deviceRepo       domain.DeviceRepository
-	deviceDetector   domain.DeviceDetector
-	switchLayoutUC   *SwitchLayoutUseCase
-	enabled          bool
+	deviceRepo     domain.DeviceRepository
+	deviceDetector domain.DeviceDetector
+	switchLayoutUC *SwitchLayoutUseCase
+	events         *domain.EventBus
+	subscription   *domain.Subscription
+	queue          *eventQueue
+	enabled        atomic.Bool
+	perDevice      atomic.Bool
+	scanning       atomic.Bool // drop the events replayed by the initial scan
+	clock          Clock
+	debounce       domain.Debounce
+	pending        map[string]*pendingEvent // device instance ID -> debounced event
+	connected      deviceStack
+	mu             sync.Mutex
+
+	// Only touched by the worker
+	active  string            // key of the mapping whose layout was last applied
+	applied map[string]string // per device mode: device instance ID -> active key
+	dirty   bool              // the connected keyboards changed since the last reconcile
+}
+
+// pendingEvent is a connect or disconnect waiting for its debounce delay
+type pendingEvent struct {
+	timer      Timer
+	disconnect bool
 }
 
 // NewMonitorDevicesUseCase creates a new MonitorDevicesUseCase
@@ -21,70 +51,486 @@ func NewMonitorDevicesUseCase(
 	deviceRepo domain.DeviceRepository,
 	deviceDetector domain.DeviceDetector,
 	switchLayoutUC *SwitchLayoutUseCase,
+	events *domain.EventBus,
 ) *MonitorDevicesUseCase {
-	return &MonitorDevicesUseCase{
+	uc := &MonitorDevicesUseCase{
 		deviceRepo:     deviceRepo,
 		deviceDetector: deviceDetector,
 		switchLayoutUC: switchLayoutUC,
-		enabled:        true,
+		events:         events,
+		queue:          newEventQueue(eventQueueSize),
+		clock:          realClock{},
+		pending:        make(map[string]*pendingEvent),
 	}
+	uc.enabled.Store(true)
+	return uc
+}
+
+// SetDebounce sets the debounce used for devices whose mapping has none
+func (uc *MonitorDevicesUseCase) SetDebounce(debounce domain.Debounce) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.debounce = debounce
 }
 
-// StartMonitoring begins monitoring for device connection/disconnection events
+// SetPerDevice makes each keyboard use its own layout instead of switching
+// the layout of every keyboard, if the layout switcher supports it
+func (uc *MonitorDevicesUseCase) SetPerDevice(perDevice bool) {
+	uc.perDevice.Store(perDevice)
+}
+
+// perDeviceMode returns true if layouts are applied to each keyboard
+func (uc *MonitorDevicesUseCase) perDeviceMode() bool {
+	return uc.perDevice.Load() && uc.switchLayoutUC.SupportsDeviceLayouts()
+}
+
+// StartMonitoring reconciles the layout with the keyboards already connected,
+// then begins handling connection/disconnection events
 func (uc *MonitorDevicesUseCase) StartMonitoring(ctx context.Context) error {
-	// Register callbacks for device events
-	uc.deviceDetector.OnDeviceConnected(func(device *domain.Device) {
-		if !uc.enabled {
+	uc.scanning.Store(true)
+
+	// Subscribe to device events, queueing them for the worker
+	subscription := uc.events.Subscribe(func(event domain.Event) {
+		if uc.scanning.Load() {
 			return
 		}
 
-		log.Printf("Device connected: %s (%s)", device.DisplayName(), device.ID)
+		switch e := event.(type) {
+		case domain.DeviceConnected:
+			uc.queue.submit(func() { uc.handleConnected(ctx, e.Device) })
+		case domain.DeviceDisconnected:
+			uc.queue.submit(func() { uc.handleDisconnected(ctx, e.Device) })
+		}
+	}, domain.EventDeviceConnected, domain.EventDeviceDisconnected)
+
+	uc.mu.Lock()
+	uc.subscription = subscription
+	uc.mu.Unlock()
+
+	// Start monitoring
+	if err := uc.deviceDetector.StartMonitoring(ctx); err != nil {
+		subscription.Unsubscribe()
+		uc.scanning.Store(false)
+		return fmt.Errorf("failed to start device monitoring: %w", err)
+	}
+
+	// Events from now on may not be part of the snapshot, so they are queued
+	// and handled once the initial state is applied
+	uc.scanning.Store(false)
+
+	devices, err := uc.deviceDetector.GetConnectedDevices(ctx)
+	if err != nil {
+		log.Printf("Error listing connected devices: %v", err)
+	}
+	uc.reconcileInitial(ctx, devices)
+
+	uc.queue.start(func(overflowed bool) {
+		if overflowed {
+			uc.resync(ctx)
+		}
+		if uc.dirty {
+			uc.dirty = false
+			uc.reconcile(ctx)
+		}
+	})
+	uc.queue.flush()
+
+	log.Println("Device monitoring started")
+	return nil
+}
+
+// reconcileInitial stacks the keyboards connected at startup and applies
+// the single layout they resolve to
+func (uc *MonitorDevicesUseCase) reconcileInitial(ctx context.Context, devices []*domain.Device) {
+	keyboards := sortedKeyboards(devices)
 
-		// Update device last seen
-		device.UpdateLastSeen()
+	names := make([]string, 0, len(keyboards))
+	uc.mu.Lock()
+	for _, keyboard := range keyboards {
+		uc.connected.push(keyboard)
+		names = append(names, keyboard.DisplayName())
+	}
+	uc.mu.Unlock()
 
-		// Save device
-		if err := uc.deviceRepo.Save(ctx, device); err != nil {
+	for _, keyboard := range keyboards {
+		if err := uc.deviceRepo.Save(ctx, keyboard); err != nil {
 			log.Printf("Error saving device: %v", err)
-			return
 		}
+	}
+
+	state := fmt.Sprintf("Initial state: %d keyboard(s) connected [%s]", len(keyboards), strings.Join(names, ", "))
+
+	if !uc.enabled.Load() {
+		log.Printf("%s, switching disabled", state)
+		return
+	}
+
+	// Each keyboard gets its layout once the worker starts
+	if uc.perDeviceMode() {
+		log.Printf("%s, applying their layouts per device", state)
+		uc.dirty = true
+		return
+	}
+
+	device, mapping, err := uc.resolve(ctx, keyboards)
+	if err != nil {
+		log.Printf("%s, no layout applied: %v", state, err)
+		return
+	}
+
+	if err := uc.switchLayoutUC.SwitchToMapping(ctx, mapping); err != nil {
+		log.Printf("%s, failed to apply %s: %v", state, mapping.LayoutName, err)
+		return
+	}
+	uc.active = uc.activeKey(ctx, mapping)
+
+	if device != nil {
+		log.Printf("%s, applied %s for %s", state, mapping.LayoutName, device.DisplayName())
+	} else {
+		log.Printf("%s, applied default layout %s", state, mapping.LayoutName)
+	}
+}
+
+// resync rebuilds the connected keyboards from the detector after events
+// were dropped because the queue was full
+func (uc *MonitorDevicesUseCase) resync(ctx context.Context) {
+	devices, err := uc.deviceDetector.GetConnectedDevices(ctx)
+	if err != nil {
+		log.Printf("Error resyncing connected devices: %v", err)
+		return
+	}
+	keyboards := sortedKeyboards(devices)
+
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	// Debounced events may have been dropped, the detector is the reference
+	for key := range uc.pending {
+		uc.cancelPending(key)
+	}
+
+	present := make(map[string]bool, len(keyboards))
+	for _, keyboard := range keyboards {
+		present[keyboard.InstanceID()] = true
+	}
+
+	stacked := make(map[string]bool)
+	for _, device := range uc.connected.snapshot() {
+		if present[device.InstanceID()] {
+			stacked[device.InstanceID()] = true
+		} else {
+			uc.connected.remove(device)
+		}
+	}
+
+	for _, keyboard := range keyboards {
+		if !stacked[keyboard.InstanceID()] {
+			uc.connected.push(keyboard)
+		}
+	}
+
+	log.Printf("Resynced connected keyboards: %d connected", len(keyboards))
+	uc.dirty = true
+}
+
+// sortedKeyboards returns the full keyboards among devices, sorted by
+// instance ID since their connection order is unknown
+func sortedKeyboards(devices []*domain.Device) []*domain.Device {
+	keyboards := make([]*domain.Device, 0, len(devices))
+	for _, device := range devices {
+		if device.IsFullKeyboard() {
+			keyboards = append(keyboards, device)
+		}
+	}
+
+	sort.Slice(keyboards, func(i, j int) bool {
+		return keyboards[i].InstanceID() < keyboards[j].InstanceID()
+	})
+	return keyboards
+}
+
+// handleConnected stacks a newly connected device once it has settled
+func (uc *MonitorDevicesUseCase) handleConnected(ctx context.Context, device *domain.Device) {
+	log.Printf("Device connected: %s (%s)", device.DisplayName(), device.InstanceID())
+
+	// Keypads, media keys and power buttons must not change the layout
+	if !device.IsFullKeyboard() {
+		log.Printf("Ignoring %s: not a full keyboard (%s)", device.DisplayName(), device.Class)
+		return
+	}
+
+	// Update device last seen
+	device.UpdateLastSeen()
+
+	// Save device
+	if err := uc.deviceRepo.Save(ctx, device); err != nil {
+		log.Printf("Error saving device: %v", err)
+		return
+	}
+
+	debounce := uc.debounceFor(ctx, device)
+	key := device.InstanceID()
+
+	uc.mu.Lock()
+	if pending, ok := uc.cancelPending(key); ok && pending.disconnect {
+		uc.mu.Unlock()
+		log.Printf("%s reconnected within its grace period, keeping the current layout", device.DisplayName())
+		return
+	}
+
+	if debounce.SettleDelay > 0 {
+		uc.schedule(key, debounce.SettleDelay, false, func() {
+			uc.addConnected(device)
+		})
+		uc.mu.Unlock()
+		logger.Debug("[Monitor] Waiting %v for %s to settle\n", debounce.SettleDelay, device.DisplayName())
+		return
+	}
+	uc.mu.Unlock()
+
+	uc.addConnected(device)
+}
+
+// handleDisconnected unstacks a disconnected device once its grace period
+// has elapsed
+func (uc *MonitorDevicesUseCase) handleDisconnected(ctx context.Context, device *domain.Device) {
+	log.Printf("Device disconnected: %s (%s)", device.DisplayName(), device.InstanceID())
+
+	if !device.IsFullKeyboard() {
+		return
+	}
+
+	debounce := uc.debounceFor(ctx, device)
+	key := device.InstanceID()
+
+	uc.mu.Lock()
+	if pending, ok := uc.cancelPending(key); ok && !pending.disconnect {
+		uc.mu.Unlock()
+		log.Printf("%s disconnected before settling, layout unchanged", device.DisplayName())
+		return
+	}
+
+	if debounce.DisconnectGrace > 0 {
+		uc.schedule(key, debounce.DisconnectGrace, true, func() {
+			uc.removeConnected(device)
+		})
+		uc.mu.Unlock()
+		logger.Debug("[Monitor] Reverting layout in %v unless %s returns\n", debounce.DisconnectGrace, device.DisplayName())
+		return
+	}
+	uc.mu.Unlock()
+
+	uc.removeConnected(device)
+}
+
+// addConnected stacks a connected keyboard. The winning layout is applied
+// once the worker is idle.
+func (uc *MonitorDevicesUseCase) addConnected(device *domain.Device) {
+	uc.mu.Lock()
+	uc.connected.push(device)
+	uc.mu.Unlock()
+
+	uc.dirty = true
+}
+
+// removeConnected unstacks a disconnected keyboard. The winning layout is
+// applied once the worker is idle.
+func (uc *MonitorDevicesUseCase) removeConnected(device *domain.Device) {
+	uc.mu.Lock()
+	removed := uc.connected.remove(device)
+	uc.mu.Unlock()
+
+	if removed {
+		uc.dirty = true
+	}
+}
 
-		// Switch layout for this device
-		if err := uc.switchLayoutUC.SwitchForDevice(ctx, device); err != nil {
+// reconcile switches to the layout of the winning connected keyboard, or to
+// the system default once no mapped keyboard remains. Only the newest state
+// is applied when several events were handled since the last switch.
+func (uc *MonitorDevicesUseCase) reconcile(ctx context.Context) {
+	if !uc.enabled.Load() {
+		return
+	}
+
+	if uc.perDeviceMode() {
+		uc.reconcileDevices(ctx)
+		return
+	}
+	uc.applied = nil
+
+	uc.mu.Lock()
+	devices := uc.connected.snapshot()
+	uc.mu.Unlock()
+
+	device, mapping, err := uc.resolve(ctx, devices)
+	if err != nil {
+		log.Printf("Error switching to default layout: %v", err)
+		return
+	}
+
+	key := uc.activeKey(ctx, mapping)
+	if key == uc.active {
+		logger.Debug("[Monitor] %s is already active\n", mapping.LayoutName)
+		return
+	}
+
+	if err := uc.switchLayoutUC.SwitchToMapping(ctx, mapping); err != nil {
+		if device != nil {
 			log.Printf("Error switching layout for device %s: %v", device.DisplayName(), err)
-			return
+		} else {
+			log.Printf("Error switching to default layout: %v", err)
 		}
+		return
+	}
+	uc.active = key
 
+	if device != nil {
 		log.Printf("Successfully switched layout for device: %s", device.DisplayName())
-	})
+	} else {
+		log.Printf("Switched to default layout, no mapped keyboard connected")
+	}
+}
 
-	uc.deviceDetector.OnDeviceDisconnected(func(device *domain.Device) {
-		if !uc.enabled {
-			return
+// reconcileDevices applies the layout of each connected keyboard to that
+// keyboard only. Unmapped keyboards keep whatever layout they have.
+func (uc *MonitorDevicesUseCase) reconcileDevices(ctx context.Context) {
+	uc.mu.Lock()
+	devices := uc.connected.snapshot()
+	uc.mu.Unlock()
+
+	// The global layout is unknown once keyboards are switched one by one
+	uc.active = ""
+
+	applied := make(map[string]string, len(devices))
+	for _, device := range devices {
+		mapping, err := uc.switchLayoutUC.MappingForDevice(ctx, device)
+		if err != nil {
+			log.Printf("Error finding mapping for device %s: %v", device.DisplayName(), err)
+			continue
+		}
+		if mapping == nil {
+			continue
 		}
 
-		log.Printf("Device disconnected: %s (%s)", device.DisplayName(), device.ID)
+		key := device.InstanceID()
+		active := uc.activeKey(ctx, mapping)
+		if uc.applied[key] == active {
+			applied[key] = active
+			continue
+		}
 
-		// Switch to default layout when device is disconnected
-		if err := uc.switchLayoutUC.SwitchToDefault(ctx); err != nil {
-			log.Printf("Error switching to default layout: %v", err)
-			return
+		if err := uc.switchLayoutUC.SwitchDeviceToMapping(ctx, device, mapping); err != nil {
+			log.Printf("Error switching layout for device %s: %v", device.DisplayName(), err)
+			continue
 		}
+		applied[key] = active
+		log.Printf("Successfully switched layout of device: %s", device.DisplayName())
+	}
 
-		log.Printf("Switched to default layout after device disconnection")
+	uc.applied = applied
+}
+
+// resolve returns the winning device and its mapping, or a nil device and
+// the system default mapping when no connected keyboard is mapped
+func (uc *MonitorDevicesUseCase) resolve(ctx context.Context, devices []*domain.Device) (*domain.Device, *domain.Mapping, error) {
+	device, mapping := winner(devices, func(d *domain.Device) *domain.Mapping {
+		mapping, err := uc.switchLayoutUC.MappingForDevice(ctx, d)
+		if err != nil {
+			log.Printf("Error finding mapping for device %s: %v", d.DisplayName(), err)
+			return nil
+		}
+		return mapping
 	})
 
-	// Start monitoring
-	if err := uc.deviceDetector.StartMonitoring(ctx); err != nil {
-		return fmt.Errorf("failed to start device monitoring: %w", err)
+	if mapping != nil {
+		return device, mapping, nil
 	}
 
-	log.Println("Device monitoring started")
-	return nil
+	mapping, err := uc.switchLayoutUC.SystemDefault(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, mapping, nil
+}
+
+// activeKey identifies the layout applied for a mapping by what it applies,
+// so that a reloaded mapping with other options or another identifier
+// under the same layout name is applied again
+func (uc *MonitorDevicesUseCase) activeKey(ctx context.Context, mapping *domain.Mapping) string {
+	layout, err := uc.switchLayoutUC.layoutForMapping(ctx, mapping)
+	if err != nil {
+		return mapping.DeviceID + "=" + mapping.LayoutName
+	}
+	return fmt.Sprintf("%s=%s|%s|%v|%s|%v", mapping.DeviceID, layout.OS, layout.SystemIdentifier,
+		layout.XKBGroups(), layout.Model, layout.Options)
+}
+
+// debounceFor returns the global debounce with the fields set by the
+// device's mapping
+func (uc *MonitorDevicesUseCase) debounceFor(ctx context.Context, device *domain.Device) domain.Debounce {
+	uc.mu.Lock()
+	debounce := uc.debounce
+	uc.mu.Unlock()
+
+	if mapping, err := uc.switchLayoutUC.MappingForDevice(ctx, device); err == nil && mapping != nil {
+		return mapping.Debounce.Apply(debounce)
+	}
+	return debounce
+}
+
+// schedule queues action after delay unless the event is cancelled first.
+// uc.mu must be held.
+func (uc *MonitorDevicesUseCase) schedule(key string, delay time.Duration, disconnect bool, action func()) {
+	event := &pendingEvent{disconnect: disconnect}
+	uc.pending[key] = event
+
+	event.timer = uc.clock.AfterFunc(delay, func() {
+		uc.queue.submit(func() {
+			uc.mu.Lock()
+			current := uc.pending[key] == event
+			if current {
+				delete(uc.pending, key)
+			}
+			uc.mu.Unlock()
+
+			// A newer event for the same device replaced this one
+			if !current {
+				return
+			}
+
+			action()
+		})
+	})
+}
+
+// cancelPending cancels the debounced event of a device, if any.
+// uc.mu must be held.
+func (uc *MonitorDevicesUseCase) cancelPending(key string) (*pendingEvent, bool) {
+	event, ok := uc.pending[key]
+	if !ok {
+		return nil, false
+	}
+
+	event.timer.Stop()
+	delete(uc.pending, key)
+	return event, true
 }
 
 // StopMonitoring stops the monitoring process
 func (uc *MonitorDevicesUseCase) StopMonitoring() error {
+	uc.mu.Lock()
+	if uc.subscription != nil {
+		uc.subscription.Unsubscribe()
+		uc.subscription = nil
+	}
+	for key := range uc.pending {
+		uc.cancelPending(key)
+	}
+	uc.mu.Unlock()
+
+	uc.queue.close()
+
 	if err := uc.deviceDetector.StopMonitoring(); err != nil {
 		return fmt.Errorf("failed to stop device monitoring: %w", err)
 	}
@@ -93,21 +539,29 @@ func (uc *MonitorDevicesUseCase) StopMonitoring() error {
 	return nil
 }
 
-// Enable enables automatic layout switching
+// Reevaluate applies the layout of the connected keyboards again, for
+// instance after the mappings were reloaded
+func (uc *MonitorDevicesUseCase) Reevaluate() {
+	uc.queue.submit(func() { uc.dirty = true })
+}
+
+// Enable enables automatic layout switching, applying the layout of the
+// keyboards connected while it was disabled
 func (uc *MonitorDevicesUseCase) Enable() {
-	uc.enabled = true
+	uc.enabled.Store(true)
 	log.Println("Polykeys enabled")
+	uc.Reevaluate()
 }
 
 // Disable disables automatic layout switching
 func (uc *MonitorDevicesUseCase) Disable() {
-	uc.enabled = false
+	uc.enabled.Store(false)
 	log.Println("Polykeys disabled")
 }
 
 // IsEnabled returns whether automatic layout switching is enabled
 func (uc *MonitorDevicesUseCase) IsEnabled() bool {
-	return uc.enabled
+	return uc.enabled.Load()
 }
 
 // GetConnectedDevices returns all currently connected keyboard devices
@@ -124,22 +578,20 @@ func (uc *MonitorDevicesUseCase) GetConnectedDevices(ctx context.Context) ([]*do
 // This is useful for the "polykeys add --detect" command
 func (uc *MonitorDevicesUseCase) WaitForNextDevice(ctx context.Context) (*domain.Device, error) {
 	deviceChan := make(chan *domain.Device, 1)
-	errChan := make(chan error, 1)
 
-	// Temporarily register a callback
-	uc.deviceDetector.OnDeviceConnected(func(device *domain.Device) {
+	// Subscribe until a device shows up, alongside the other subscribers
+	subscription := uc.events.Subscribe(func(event domain.Event) {
 		select {
-		case deviceChan <- device:
+		case deviceChan <- event.(domain.DeviceConnected).Device:
 		default:
 		}
-	})
+	}, domain.EventDeviceConnected)
+	defer subscription.Unsubscribe()
 
 	// Wait for device or context cancellation
 	select {
 	case device := <-deviceChan:
 		return device, nil
-	case err := <-errChan:
-		return nil, err
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
@@ -0,0 +1,543 @@
+package usecases
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// newUnstartedMonitor wires a MonitorDevicesUseCase to fakes
+func newUnstartedMonitor(mappings ...*domain.Mapping) (*MonitorDevicesUseCase, *fakeDetector, *recordingSwitcher, *fakeClock) {
+	events := domain.NewEventBus()
+	detector := &fakeDetector{events: events}
+	switcher := &recordingSwitcher{}
+	clock := &fakeClock{}
+
+	mappings = append(mappings, domain.NewMapping("system_default", "System Default", "French AZERTY", domain.OSLinux))
+	switchUC := NewSwitchLayoutUseCase(newFakeMappingRepository(mappings...), fakeLayoutRepository{}, switcher, events)
+
+	monitor := NewMonitorDevicesUseCase(fakeDeviceRepository{}, detector, switchUC, events)
+	monitor.clock = clock
+	detector.settle = monitor.queue.flush
+	clock.settle = monitor.queue.flush
+
+	return monitor, detector, switcher, clock
+}
+
+// newTestMonitor starts a monitor with no keyboard connected and forgets the
+// initial switch to the default layout
+func newTestMonitor(t *testing.T, mappings ...*domain.Mapping) (*MonitorDevicesUseCase, *fakeDetector, *recordingSwitcher, *fakeClock) {
+	t.Helper()
+
+	monitor, detector, switcher, clock := newUnstartedMonitor(mappings...)
+	if err := monitor.StartMonitoring(context.Background()); err != nil {
+		t.Fatalf("Failed to start monitoring: %v", err)
+	}
+	switcher.reset()
+
+	return monitor, detector, switcher, clock
+}
+
+func expectSwitched(t *testing.T, switcher *recordingSwitcher, expected ...string) {
+	t.Helper()
+
+	if got := switcher.switched(); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected layouts %v, got %v", expected, got)
+	}
+}
+
+func TestMonitorDevices_NoDebounce(t *testing.T) {
+	_, detector, switcher, _ := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+	detector.disconnect(corne)
+
+	expectSwitched(t, switcher, "Colemak", "French AZERTY")
+}
+
+func TestMonitorDevices_SettleDelay(t *testing.T) {
+	monitor, detector, switcher, clock := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	monitor.SetDebounce(domain.Debounce{SettleDelay: 500 * time.Millisecond})
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+	clock.Advance(400 * time.Millisecond)
+	expectSwitched(t, switcher)
+
+	clock.Advance(100 * time.Millisecond)
+	expectSwitched(t, switcher, "Colemak")
+}
+
+func TestMonitorDevices_DisconnectBeforeSettling(t *testing.T) {
+	monitor, detector, switcher, clock := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	monitor.SetDebounce(domain.Debounce{SettleDelay: 500 * time.Millisecond})
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+	clock.Advance(100 * time.Millisecond)
+	detector.disconnect(corne)
+	clock.Advance(time.Second)
+
+	// The layout was never applied, so there is nothing to revert
+	expectSwitched(t, switcher)
+}
+
+func TestMonitorDevices_GracePeriodCancelledOnReturn(t *testing.T) {
+	monitor, detector, switcher, clock := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	monitor.SetDebounce(domain.Debounce{DisconnectGrace: 2 * time.Second})
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+	detector.disconnect(corne)
+	clock.Advance(time.Second)
+	detector.connect(corne)
+	clock.Advance(5 * time.Second)
+
+	expectSwitched(t, switcher, "Colemak")
+}
+
+func TestMonitorDevices_GracePeriodElapsed(t *testing.T) {
+	monitor, detector, switcher, clock := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	monitor.SetDebounce(domain.Debounce{DisconnectGrace: 2 * time.Second})
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+	detector.disconnect(corne)
+	clock.Advance(time.Second)
+	expectSwitched(t, switcher, "Colemak")
+
+	clock.Advance(time.Second)
+	expectSwitched(t, switcher, "Colemak", "French AZERTY")
+}
+
+func TestMonitorDevices_MappingDebounceOverridesGlobal(t *testing.T) {
+	k380 := domain.NewMapping("046d:b342", "K380", "US Qwerty", domain.OSLinux)
+	grace := 10 * time.Second
+	k380.Debounce = domain.DebounceOverride{DisconnectGrace: &grace}
+
+	monitor, detector, switcher, clock := newTestMonitor(t,
+		k380,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+	)
+	monitor.SetDebounce(domain.Debounce{DisconnectGrace: time.Second})
+
+	keyboard := domain.NewDevice("046d", "b342", "Logitech K380")
+	detector.connect(keyboard)
+	detector.disconnect(keyboard)
+	clock.Advance(5 * time.Second)
+	expectSwitched(t, switcher, "US Qwerty")
+
+	clock.Advance(5 * time.Second)
+	expectSwitched(t, switcher, "US Qwerty", "French AZERTY")
+}
+
+func TestMonitorDevices_RevertsToRemainingKeyboard(t *testing.T) {
+	_, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("1209:bb58", "Lily58", "US Qwerty", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	lily58 := domain.NewDevice("1209", "bb58", "Lily58")
+
+	detector.connect(corne)
+	detector.connect(lily58)
+	detector.disconnect(lily58)
+	expectSwitched(t, switcher, "Colemak", "US Qwerty", "Colemak")
+
+	detector.disconnect(corne)
+	expectSwitched(t, switcher, "Colemak", "US Qwerty", "Colemak", "French AZERTY")
+}
+
+func TestMonitorDevices_MappingDebounceInheritsUnsetFields(t *testing.T) {
+	grace := 10 * time.Second
+	k380 := domain.NewMapping("046d:b342", "K380", "US Qwerty", domain.OSLinux)
+	k380.Debounce = domain.DebounceOverride{DisconnectGrace: &grace}
+
+	monitor, detector, switcher, clock := newTestMonitor(t, k380)
+	monitor.SetDebounce(domain.Debounce{SettleDelay: 500 * time.Millisecond})
+
+	// The settle delay comes from the global debounce
+	detector.connect(domain.NewDevice("046d", "b342", "Logitech K380"))
+	clock.Advance(400 * time.Millisecond)
+	expectSwitched(t, switcher)
+
+	clock.Advance(100 * time.Millisecond)
+	expectSwitched(t, switcher, "US Qwerty")
+}
+
+func TestMonitorDevices_KeyboardInterfaceLossReverts(t *testing.T) {
+	_, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("1209:bb58", "Lily58", "US Qwerty", domain.OSLinux),
+	)
+	lily58 := domain.NewDevice("1209", "bb58", "Lily58")
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	consumer := domain.NewDevice("4653", "0004", "foostan Corne Consumer Control")
+	consumer.Class = domain.DeviceClassConsumerKeys
+
+	// The tracker's events when the Corne loses its keyboard interface but
+	// keeps its consumer control one
+	detector.connect(lily58)
+	detector.connect(corne)
+	detector.disconnect(corne)
+	expectSwitched(t, switcher, "US Qwerty", "Colemak", "US Qwerty")
+
+	// Its consumer control node going away later changes nothing
+	detector.disconnect(consumer)
+	expectSwitched(t, switcher, "US Qwerty", "Colemak", "US Qwerty")
+}
+
+func TestMonitorDevices_UnplugOlderKeyboardKeepsLayout(t *testing.T) {
+	_, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("1209:bb58", "Lily58", "US Qwerty", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	lily58 := domain.NewDevice("1209", "bb58", "Lily58")
+
+	detector.connect(corne)
+	detector.connect(lily58)
+	detector.disconnect(corne)
+
+	expectSwitched(t, switcher, "Colemak", "US Qwerty")
+}
+
+func TestMonitorDevices_PriorityWins(t *testing.T) {
+	corneMapping := domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux)
+	corneMapping.Priority = 10
+
+	_, detector, switcher, _ := newTestMonitor(t,
+		corneMapping,
+		domain.NewMapping("1209:bb58", "Lily58", "US Qwerty", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	lily58 := domain.NewDevice("1209", "bb58", "Lily58")
+
+	detector.connect(corne)
+	detector.connect(lily58)
+	expectSwitched(t, switcher, "Colemak")
+
+	detector.disconnect(corne)
+	expectSwitched(t, switcher, "Colemak", "US Qwerty")
+}
+
+func TestMonitorDevices_UnmappedKeyboardKeepsLayout(t *testing.T) {
+	_, detector, switcher, _ := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	laptop := domain.NewDevice("0001", "0001", "AT Translated Set 2 keyboard")
+
+	detector.connect(corne)
+	detector.connect(laptop)
+	detector.disconnect(laptop)
+
+	expectSwitched(t, switcher, "Colemak")
+}
+
+func TestMonitorDevices_InitialStateAppliedOnce(t *testing.T) {
+	monitor, detector, switcher, _ := newUnstartedMonitor(
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("1209:bb58", "Lily58", "US Qwerty", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	lily58 := domain.NewDevice("1209", "bb58", "Lily58")
+	detector.devices = []*domain.Device{corne, lily58}
+
+	// The detector's initial scan reports both keyboards as new
+	detector.onStart = func() {
+		detector.connect(corne)
+		detector.connect(lily58)
+	}
+
+	if err := monitor.StartMonitoring(context.Background()); err != nil {
+		t.Fatalf("Failed to start monitoring: %v", err)
+	}
+
+	// Ties are broken on the instance ID, whatever the scan order
+	expectSwitched(t, switcher, "Colemak")
+
+	detector.disconnect(corne)
+	expectSwitched(t, switcher, "Colemak", "US Qwerty")
+}
+
+func TestMonitorDevices_InitialStateWithoutKeyboards(t *testing.T) {
+	monitor, _, switcher, _ := newUnstartedMonitor(domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+
+	if err := monitor.StartMonitoring(context.Background()); err != nil {
+		t.Fatalf("Failed to start monitoring: %v", err)
+	}
+
+	expectSwitched(t, switcher, "French AZERTY")
+}
+
+func TestMonitorDevices_EventsDuringReconciliationAreReplayed(t *testing.T) {
+	monitor, detector, switcher, _ := newUnstartedMonitor(domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	// The Corne is plugged in right after the snapshot is taken
+	detector.onList = func() {
+		detector.connect(corne)
+	}
+
+	if err := monitor.StartMonitoring(context.Background()); err != nil {
+		t.Fatalf("Failed to start monitoring: %v", err)
+	}
+
+	expectSwitched(t, switcher, "French AZERTY", "Colemak")
+}
+
+func TestMonitorDevices_WaitForNextDeviceKeepsSwitching(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	waited := make(chan *domain.Device, 1)
+	go func() {
+		device, _ := monitor.WaitForNextDevice(ctx)
+		waited <- device
+	}()
+
+	// Wait until the waiter is subscribed before connecting
+	for monitor.events.SubscriberCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	detector.connect(corne)
+
+	if device := <-waited; device != corne {
+		t.Errorf("Expected WaitForNextDevice to return the Corne, got %v", device)
+	}
+	expectSwitched(t, switcher, "Colemak")
+
+	detector.disconnect(corne)
+	expectSwitched(t, switcher, "Colemak", "French AZERTY")
+
+	if count := monitor.events.SubscriberCount(); count != 1 {
+		t.Errorf("Expected WaitForNextDevice to unsubscribe, %d subscribers left", count)
+	}
+}
+
+func TestMonitorDevices_CoalescesSwitchesWhileBusy(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("feed:6060", "Planck", "US Qwerty", domain.OSLinux),
+		domain.NewMapping("1209:bb58", "Lily58", "Dvorak", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	planck := domain.NewDevice("feed", "6060", "OLKB Planck")
+	lily := domain.NewDevice("1209", "bb58", "Lily58")
+
+	// Let events pile up while the first switch is in progress
+	detector.settle = nil
+	switcher.hold()
+
+	detector.connect(corne)
+	<-switcher.switching
+
+	detector.connect(planck)
+	detector.connect(lily)
+	detector.disconnect(planck)
+
+	switcher.release()
+	monitor.queue.flush()
+
+	// US Qwerty was superseded before it could be applied
+	expectSwitched(t, switcher, "Colemak", "Dvorak")
+}
+
+func TestMonitorDevices_QueueOverflowResyncs(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("feed:6060", "Planck", "US Qwerty", domain.OSLinux),
+		domain.NewMapping("1209:bb58", "Lily58", "Dvorak", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	planck := domain.NewDevice("feed", "6060", "OLKB Planck")
+	lily := domain.NewDevice("1209", "bb58", "Lily58")
+
+	detector.settle = nil
+	switcher.hold()
+
+	detector.connect(corne)
+	<-switcher.switching
+
+	// Flood the queue, the events beyond its size are dropped
+	for i := 0; i < eventQueueSize*2; i++ {
+		if i%2 == 0 {
+			detector.connect(planck)
+		} else {
+			detector.disconnect(planck)
+		}
+	}
+	detector.connect(lily)
+
+	// The detector knows what is really connected
+	detector.devices = []*domain.Device{corne, lily}
+
+	switcher.release()
+	monitor.queue.flush()
+
+	expectSwitched(t, switcher, "Colemak", "Dvorak")
+
+	monitor.mu.Lock()
+	stacked := monitor.connected.snapshot()
+	monitor.mu.Unlock()
+	if len(stacked) != 2 {
+		t.Errorf("Expected the Corne and the Lily58 to be stacked, got %d devices", len(stacked))
+	}
+}
+
+func TestMonitorDevices_ConcurrentEvents(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("feed:6060", "Planck", "US Qwerty", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	planck := domain.NewDevice("feed", "6060", "OLKB Planck")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				device := corne
+				if (i+j)%2 == 0 {
+					device = planck
+				}
+				detector.connect(device)
+				if j%3 == 0 {
+					monitor.Disable()
+				} else {
+					monitor.Enable()
+				}
+				_ = monitor.IsEnabled()
+				detector.disconnect(device)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Whatever the interleaving, the monitor still follows new connections
+	monitor.Enable()
+	switcher.reset()
+	detector.connect(planck)
+	detector.connect(corne)
+
+	if got := switcher.switched(); len(got) == 0 || got[len(got)-1] != "Colemak" {
+		t.Errorf("Expected Colemak to be applied last, got %v", got)
+	}
+}
+
+func TestMonitorDevices_ReevaluateAfterMappingChange(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+
+	// The config now maps the Corne to another layout
+	monitor.switchLayoutUC.mappingRepo.Save(context.Background(), domain.NewMapping("4653:0004", "Corne", "Dvorak", domain.OSLinux))
+	monitor.Reevaluate()
+	monitor.queue.flush()
+
+	expectSwitched(t, switcher, "Colemak", "Dvorak")
+
+	// Nothing changed since
+	monitor.Reevaluate()
+	monitor.queue.flush()
+
+	expectSwitched(t, switcher, "Colemak", "Dvorak")
+}
+
+func TestMonitorDevices_ReevaluateAfterOptionsChange(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	detector.connect(corne)
+
+	// The config now gives the Corne mapping options, keeping its layout
+	reloaded := domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux)
+	reloaded.Options = domain.LayoutOptions{Add: []string{"ctrl:nocaps"}}
+	monitor.switchLayoutUC.mappingRepo.Save(context.Background(), reloaded)
+	monitor.Reevaluate()
+	monitor.queue.flush()
+
+	expectSwitched(t, switcher, "Colemak", "Colemak")
+}
+
+func TestMonitorDevices_EnableAppliesConnectedLayout(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t,
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("feed:6060", "Planck", "US Qwerty", domain.OSLinux),
+	)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	planck := domain.NewDevice("feed", "6060", "OLKB Planck")
+
+	detector.connect(corne)
+
+	// The Planck is plugged in while switching is disabled
+	monitor.Disable()
+	detector.connect(planck)
+
+	expectSwitched(t, switcher, "Colemak")
+
+	// Its layout is applied without waiting for another event
+	monitor.Enable()
+	monitor.queue.flush()
+
+	expectSwitched(t, switcher, "Colemak", "US Qwerty")
+}
+
+func TestMonitorDevices_PerDevice(t *testing.T) {
+	events := domain.NewEventBus()
+	detector := &fakeDetector{events: events}
+	switcher := &recordingDeviceSwitcher{}
+
+	mappings := newFakeMappingRepository(
+		domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux),
+		domain.NewMapping("feed:6060", "Planck", "US Qwerty", domain.OSLinux),
+		domain.NewMapping("system_default", "System Default", "French AZERTY", domain.OSLinux),
+	)
+	switchUC := NewSwitchLayoutUseCase(mappings, fakeLayoutRepository{}, switcher, events)
+	monitor := NewMonitorDevicesUseCase(fakeDeviceRepository{}, detector, switchUC, events)
+	monitor.SetPerDevice(true)
+	detector.settle = monitor.queue.flush
+
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+	planck := domain.NewDevice("feed", "6060", "OLKB Planck")
+	laptop := domain.NewDevice("0001", "0001", "AT Translated Set 2 keyboard")
+	detector.devices = []*domain.Device{corne, laptop}
+
+	if err := monitor.StartMonitoring(context.Background()); err != nil {
+		t.Fatalf("Failed to start monitoring: %v", err)
+	}
+
+	// Only mapped keyboards are switched, and never globally
+	expectSwitched(t, &switcher.recordingSwitcher, "foostan Corne=Colemak")
+
+	detector.connect(planck)
+	expectSwitched(t, &switcher.recordingSwitcher, "foostan Corne=Colemak", "OLKB Planck=US Qwerty")
+
+	detector.disconnect(planck)
+	detector.disconnect(corne)
+	expectSwitched(t, &switcher.recordingSwitcher, "foostan Corne=Colemak", "OLKB Planck=US Qwerty")
+
+	// A reconnected keyboard gets its layout again
+	detector.connect(corne)
+	expectSwitched(t, &switcher.recordingSwitcher, "foostan Corne=Colemak", "OLKB Planck=US Qwerty", "foostan Corne=Colemak")
+}
+
+func TestMonitorDevices_PerDeviceUnsupported(t *testing.T) {
+	monitor, detector, switcher, _ := newTestMonitor(t, domain.NewMapping("4653:0004", "Corne", "Colemak", domain.OSLinux))
+	monitor.SetPerDevice(true)
+	corne := domain.NewDevice("4653", "0004", "foostan Corne")
+
+	// The switcher cannot target a keyboard, so the layout is switched globally
+	detector.connect(corne)
+	expectSwitched(t, switcher, "Colemak")
+}
@@ -3,6 +3,7 @@ package usecases
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/0xJohnnyboy/polykeys/internal/domain"
 )
@@ -12,6 +13,9 @@ type SwitchLayoutUseCase struct {
 	mappingRepo    domain.MappingRepository
 	layoutRepo     domain.LayoutRepository
 	layoutSwitcher domain.LayoutSwitcher
+	events         *domain.EventBus
+	current        *domain.KeyboardLayout
+	mu             sync.RWMutex
 }
 
 // NewSwitchLayoutUseCase creates a new SwitchLayoutUseCase
@@ -19,35 +23,56 @@ func NewSwitchLayoutUseCase(
 	mappingRepo domain.MappingRepository,
 	layoutRepo domain.LayoutRepository,
 	layoutSwitcher domain.LayoutSwitcher,
+	events *domain.EventBus,
 ) *SwitchLayoutUseCase {
 	return &SwitchLayoutUseCase{
 		mappingRepo:    mappingRepo,
 		layoutRepo:     layoutRepo,
 		layoutSwitcher: layoutSwitcher,
+		events:         events,
 	}
 }
 
 // SwitchForDevice switches the keyboard layout based on the connected device
 func (uc *SwitchLayoutUseCase) SwitchForDevice(ctx context.Context, device *domain.Device) error {
-	// Find mapping for this device
-	mapping, err := uc.mappingRepo.FindByDeviceID(ctx, device.ID)
+	// Find the most specific mapping for this device
+	mapping, err := uc.MappingForDevice(ctx, device)
 	if err != nil {
+		return err
+	}
+
+	if mapping == nil {
 		// If no mapping found for this device, try system default
 		fmt.Printf("[Switch] ⚠ No mapping found for device %s (%s), using system default\n",
-			device.DisplayName(), device.ID)
+			device.DisplayName(), device.InstanceID())
 		mapping, err = uc.mappingRepo.GetSystemDefault(ctx)
 		if err != nil {
 			return fmt.Errorf("no mapping found for device %s and no system default: %w", device.DisplayName(), err)
 		}
 	} else {
 		fmt.Printf("[Switch] ✓ Found mapping for device %s (%s) → %s\n",
-			device.DisplayName(), device.ID, mapping.LayoutName)
+			device.DisplayName(), mapping.DeviceID, mapping.LayoutName)
 	}
 
+	return uc.SwitchToMapping(ctx, mapping)
+}
+
+// SwitchToMapping switches to the layout of a mapping, publishing
+// LayoutSwitched or SwitchFailed
+func (uc *SwitchLayoutUseCase) SwitchToMapping(ctx context.Context, mapping *domain.Mapping) error {
+	if err := uc.switchToMapping(ctx, mapping); err != nil {
+		uc.events.Publish(domain.SwitchFailed{Mapping: mapping, Err: err})
+		return err
+	}
+	return nil
+}
+
+// switchToMapping looks up and applies the layout of a mapping
+func (uc *SwitchLayoutUseCase) switchToMapping(ctx context.Context, mapping *domain.Mapping) error {
 	// Get the layout to switch to
-	layout, err := uc.layoutRepo.FindByName(ctx, mapping.LayoutName, mapping.LayoutOS)
+	layout, err := uc.layoutForMapping(ctx, mapping)
 	if err != nil {
-		return fmt.Errorf("layout %s not found: %w", mapping.LayoutName, err)
+		return err
 	}
 
 	fmt.Printf("[Switch] → Switching to layout: %s (OS: %s, ID: %s)\n",
@@ -58,36 +83,121 @@ func (uc *SwitchLayoutUseCase) SwitchForDevice(ctx context.Context, device *doma
 		return fmt.Errorf("failed to switch layout: %w", err)
 	}
 
+	uc.setCurrentLayout(layout)
+
 	fmt.Printf("[Switch] ✓ Successfully switched to %s\n", layout.Name)
 
+	uc.events.Publish(domain.LayoutSwitched{Layout: layout, Mapping: mapping})
+
 	return nil
 }
 
-// SwitchToDefault switches to the system default layout
-func (uc *SwitchLayoutUseCase) SwitchToDefault(ctx context.Context) error {
-	fmt.Printf("[Switch] → Switching to system default\n")
+// layoutForMapping returns the layout of a mapping with its option changes
+func (uc *SwitchLayoutUseCase) layoutForMapping(ctx context.Context, mapping *domain.Mapping) (*domain.KeyboardLayout, error) {
+	layout := mapping.Layout
+	if layout == nil {
+		var err error
+		if layout, err = uc.layoutRepo.Resolve(ctx, mapping.LayoutName, mapping.LayoutOS); err != nil {
+			return nil, err
+		}
+	}
 
-	// Get system default mapping
-	mapping, err := uc.mappingRepo.GetSystemDefault(ctx)
-	if err != nil {
-		return fmt.Errorf("no system default mapping configured: %w", err)
+	if !mapping.Options.IsZero() {
+		layout = layout.WithOptions(mapping.Options)
+	}
+	return layout, nil
+}
+
+// SupportsDeviceLayouts returns true if the layout switcher can give each
+// keyboard its own layout
+func (uc *SwitchLayoutUseCase) SupportsDeviceLayouts() bool {
+	if support, ok := uc.layoutSwitcher.(domain.DeviceLayoutSupport); ok {
+		return support.SupportsDeviceLayouts()
+	}
+
+	_, ok := uc.layoutSwitcher.(domain.DeviceLayoutSwitcher)
+	return ok
+}
+
+// SwitchDeviceToMapping applies the layout of a mapping to a single keyboard,
+// publishing LayoutSwitched or SwitchFailed
+func (uc *SwitchLayoutUseCase) SwitchDeviceToMapping(ctx context.Context, device *domain.Device, mapping *domain.Mapping) error {
+	if err := uc.switchDeviceToMapping(ctx, device, mapping); err != nil {
+		uc.events.Publish(domain.SwitchFailed{Mapping: mapping, Err: err})
+		return err
+	}
+	return nil
+}
+
+// switchDeviceToMapping looks up and applies the layout of a mapping to a
+// single keyboard
+func (uc *SwitchLayoutUseCase) switchDeviceToMapping(ctx context.Context, device *domain.Device, mapping *domain.Mapping) error {
+	switcher, ok := uc.layoutSwitcher.(domain.DeviceLayoutSwitcher)
+	if !ok {
+		return fmt.Errorf("layout switcher cannot switch the layout of a single keyboard")
 	}
 
-	// Get the layout
-	layout, err := uc.layoutRepo.FindByName(ctx, mapping.LayoutName, mapping.LayoutOS)
+	layout, err := uc.layoutForMapping(ctx, mapping)
 	if err != nil {
-		return fmt.Errorf("default layout %s not found: %w", mapping.LayoutName, err)
+		return err
 	}
 
-	fmt.Printf("[Switch] → Switching to default layout: %s (OS: %s, ID: %s)\n",
-		layout.Name, layout.OS, layout.SystemIdentifier)
+	fmt.Printf("[Switch] → Switching %s to layout: %s (OS: %s, ID: %s)\n",
+		device.DisplayName(), layout.Name, layout.OS, layout.SystemIdentifier)
 
-	// Switch to the layout
-	if err := uc.layoutSwitcher.SwitchLayout(ctx, layout); err != nil {
-		return fmt.Errorf("failed to switch to default layout: %w", err)
+	if err := switcher.SwitchDeviceLayout(ctx, layout, device); err != nil {
+		return fmt.Errorf("failed to switch layout of %s: %w", device.DisplayName(), err)
 	}
 
-	fmt.Printf("[Switch] ✓ Successfully switched to %s\n", layout.Name)
+	fmt.Printf("[Switch] ✓ Successfully switched %s to %s\n", device.DisplayName(), layout.Name)
+
+	uc.events.Publish(domain.LayoutSwitched{Layout: layout, Mapping: mapping, Device: device})
 
 	return nil
 }
+
+// MappingForDevice returns the most specific mapping for the device, or nil
+// if the device is not mapped
+func (uc *SwitchLayoutUseCase) MappingForDevice(ctx context.Context, device *domain.Device) (*domain.Mapping, error) {
+	mappings, err := uc.mappingRepo.FindAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve mappings: %w", err)
+	}
+
+	return domain.FindMappingForDevice(mappings, device), nil
+}
+
+// SwitchToDefault switches to the system default layout
+func (uc *SwitchLayoutUseCase) SwitchToDefault(ctx context.Context) error {
+	fmt.Printf("[Switch] → Switching to system default\n")
+
+	mapping, err := uc.SystemDefault(ctx)
+	if err != nil {
+		return err
+	}
+
+	return uc.SwitchToMapping(ctx, mapping)
+}
+
+// SystemDefault returns the system default mapping
+func (uc *SwitchLayoutUseCase) SystemDefault(ctx context.Context) (*domain.Mapping, error) {
+	mapping, err := uc.mappingRepo.GetSystemDefault(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no system default mapping configured: %w", err)
+	}
+	return mapping, nil
+}
+
+// CurrentLayout returns the last layout successfully switched to, or nil
+func (uc *SwitchLayoutUseCase) CurrentLayout() *domain.KeyboardLayout {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	return uc.current
+}
+
+// setCurrentLayout records the layout that is now active
+func (uc *SwitchLayoutUseCase) setCurrentLayout(layout *domain.KeyboardLayout) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.current = layout
+}
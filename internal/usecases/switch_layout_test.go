@@ -0,0 +1,63 @@
+package usecases
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/0xJohnnyboy/polykeys/internal/domain"
+)
+
+// capturingSwitcher keeps the last layout switched to
+type capturingSwitcher struct {
+	layout *domain.KeyboardLayout
+}
+
+func (s *capturingSwitcher) SwitchLayout(ctx context.Context, layout *domain.KeyboardLayout) error {
+	s.layout = layout
+	return nil
+}
+
+func TestSwitchLayout_InlineLayout(t *testing.T) {
+	switcher := &capturingSwitcher{}
+	uc := NewSwitchLayoutUseCase(newFakeMappingRepository(), fakeLayoutRepository{}, switcher, domain.NewEventBus())
+
+	layout := domain.NewXKBKeyboardLayout("us(altgr-intl)",
+		[]domain.XKBGroup{{Layout: "us", Variant: "altgr-intl"}},
+		"pc105", domain.LayoutOptions{Add: []string{"compose:ralt"}})
+	mapping := domain.NewMapping("4653:0004", "Corne", layout.Name, domain.OSLinux)
+	mapping.Layout = layout
+
+	if err := uc.SwitchToMapping(context.Background(), mapping); err != nil {
+		t.Fatalf("Failed to switch: %v", err)
+	}
+
+	if switcher.layout != layout {
+		t.Errorf("Expected the layout of the mapping, got %+v", switcher.layout)
+	}
+	if uc.CurrentLayout() != layout {
+		t.Errorf("Expected the current layout to be the one of the mapping, got %+v", uc.CurrentLayout())
+	}
+}
+
+func TestSwitchLayout_MappingOptions(t *testing.T) {
+	switcher := &capturingSwitcher{}
+	uc := NewSwitchLayoutUseCase(newFakeMappingRepository(), fakeLayoutRepository{}, switcher, domain.NewEventBus())
+
+	layout := domain.NewKeyboardLayout("Colemak", domain.OSLinux, "us -variant colemak -option ctrl:nocaps,compose:ralt")
+	mapping := domain.NewMapping("4653:0004", "Corne", layout.Name, domain.OSLinux)
+	mapping.Layout = layout
+	mapping.Options = domain.LayoutOptions{Add: []string{"ctrl:swapcaps"}, Remove: []string{"ctrl:nocaps"}}
+
+	if err := uc.SwitchToMapping(context.Background(), mapping); err != nil {
+		t.Fatalf("Failed to switch: %v", err)
+	}
+
+	expected := domain.LayoutOptions{Add: []string{"compose:ralt", "ctrl:swapcaps"}, Remove: []string{"ctrl:nocaps"}}
+	if !reflect.DeepEqual(switcher.layout.Options, expected) {
+		t.Errorf("Expected options %+v, got %+v", expected, switcher.layout.Options)
+	}
+	if !reflect.DeepEqual(layout.Options.Add, []string{"ctrl:nocaps", "compose:ralt"}) {
+		t.Errorf("Expected the layout of the mapping to be unchanged, got %+v", layout.Options)
+	}
+}